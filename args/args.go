@@ -30,6 +30,9 @@ import (
 
 const (
 	kernelCmdlineConf         = "clri.descriptor"
+	kernelCmdlineConfSHA256   = "clri.descriptor.sha256"
+	kernelCmdlineConfSigned   = "clri.descriptor.signed"
+	kernelCmdlineConfAttempts = "clri.descriptor.attempts"
 	kernelCmdlineDemo         = "clri.demo"
 	kernelCmdlineLog          = "clri.loglevel"
 	kernelCmdlineHighContrast = "clri.hc"
@@ -40,6 +43,12 @@ const (
 
 var (
 	kernelCmdlineFile = "/proc/cmdline"
+
+	// descriptorAttempts is the default number of attempts to download the configuration file
+	descriptorAttempts = 3
+
+	// descriptorRetryDelay is the time waited before downloading the configuration file again
+	descriptorRetryDelay = 5 * time.Second
 )
 
 // Args represents the user provided arguments
@@ -52,6 +61,10 @@ type Args struct {
 	LogFile                 string
 	ConfigFile              string
 	CfDownloaded            bool
+	ConfigURL               string
+	ConfigSHA256            string
+	TrustedKeys             []string
+	RequireSigned           bool
 	CfPurge                 bool
 	CfPurgeSet              bool
 	AllowInsecureHTTP       bool
@@ -62,6 +75,8 @@ type Args struct {
 	SwupdMirror             string
 	SwupdStateDir           string
 	SwupdCertPath           string
+	ClientCert              string
+	ClientKey               string
 	SwupdStateClean         bool
 	SwupdFormat             string
 	SwupdVersion            string
@@ -85,6 +100,9 @@ type Args struct {
 	BlockDevices            []string
 	StubImage               bool
 	ConvertConfigFile       string
+	ConvertFile             string
+	DiffConfigFiles         []string
+	NoMatch                 bool
 	TemplateConfigFile      string
 	MakeISO                 bool
 	MakeISOSet              bool
@@ -102,6 +120,11 @@ type Args struct {
 	SkipValidationAllSet    bool
 	SwapFileSize            string
 	ForceDestructive        bool
+	MakeMirror              string
+	VerifyMirror            string
+	Reproducible            bool
+	ContentCache            string
+	ContentCacheSize        string
 }
 
 func (args *Args) setKernelArgs() (err error) {
@@ -110,6 +133,8 @@ func (args *Args) setKernelArgs() (err error) {
 		url       string
 	)
 
+	attempts := descriptorAttempts
+
 	if kernelCmd, err = args.readKernelCmd(); err != nil {
 		return err
 	}
@@ -119,6 +144,17 @@ func (args *Args) setKernelArgs() (err error) {
 		curr = strings.TrimSpace(curr)
 		if strings.HasPrefix(curr, kernelCmdlineConf+"=") {
 			url = strings.Split(curr, "=")[1]
+		} else if strings.HasPrefix(curr, kernelCmdlineConfSHA256+"=") {
+			args.ConfigSHA256 = strings.Split(curr, "=")[1]
+		} else if strings.HasPrefix(curr, kernelCmdlineConfAttempts+"=") {
+			value := strings.Split(curr, "=")[1]
+			if n, cerr := strconv.Atoi(value); cerr != nil || n < 1 {
+				log.Warning("Ignoring invalid kernel parameter %s='%s'", kernelCmdlineConfAttempts, value)
+			} else {
+				attempts = n
+			}
+		} else if curr == kernelCmdlineConfSigned {
+			args.RequireSigned = true
 		} else if strings.HasPrefix(curr, kernelCmdlineDemo) {
 			args.DemoMode = true
 		} else if strings.HasPrefix(curr, kernelCmdlineHighContrast) {
@@ -134,56 +170,38 @@ func (args *Args) setKernelArgs() (err error) {
 	}
 
 	if url != "" {
-		networkGood := false
-		downFailCount := 1
-
 		var ffile string
-		msg := fmt.Sprintf("Downloading configuration file %q", url)
-
-		var downloadSleep time.Duration = 0
-		for { // Keep retrying to download configuration file
-			time.Sleep(downloadSleep * time.Second)
-			fmt.Println(msg)
-
-			if ffile, err = network.FetchRemoteConfigFile(url); err != nil {
-				downFailCount++
-				msg = fmt.Sprintf("Downloading configuration file %q [%d]", url, downFailCount)
-				// Try restarting the networking every 10th fail
-				if downFailCount%10 == 0 {
-					networkGood = false
-				}
-
-				failMsg := fmt.Sprintf("Failed to download: %s", err)
-				fmt.Println(failMsg)
-				downloadSleep = 5
-
-				var retrySleep time.Duration = 5
-				for { // Keep retrying to initialize the network
-					if networkGood {
-						break
-					}
-
-					time.Sleep(retrySleep * time.Second)
-
-					fmt.Println("Checking network....")
-
-					// Restart networking if we failed
-					// The likely gain is restarting pacdiscovery to fix autoproxy
-					if err := network.Restart(); err != nil {
-						log.Warning("Network restart failed")
-						fmt.Println("Warning: Network restart failed!")
-						retrySleep = 10
-					} else {
-						networkGood = true
-						break
-					}
-				}
-			} else {
+
+		// Give up after the attempts rather than waiting for a network which may
+		// never come up, the installer then reports the failure
+		for attempt := 1; ; attempt++ {
+			fmt.Printf("Downloading configuration file %q [%d/%d]\n", url, attempt, attempts)
+
+			// The network is restarted between the attempts, a pinned checksum
+			// rejects the content of a captive portal early
+			fetcher := &network.Fetcher{Attempts: 1, SHA256: args.ConfigSHA256}
+			if ffile, err = fetcher.FetchTemp(url, "clr-installer-yaml-"); err == nil {
 				break
 			}
+			fmt.Printf("Failed to download: %s\n", err)
+
+			if attempt >= attempts {
+				return fmt.Errorf("Failed to download the configuration file %q after %d attempts: %v",
+					url, attempts, err)
+			}
+
+			// Restart networking if we failed
+			// The likely gain is restarting pacdiscovery to fix autoproxy
+			if rerr := network.Restart(); rerr != nil {
+				log.Warning("Network restart failed")
+				fmt.Println("Warning: Network restart failed!")
+			}
+
+			time.Sleep(descriptorRetryDelay)
 		}
 
 		args.ConfigFile = ffile
+		args.ConfigURL = url
 		args.CfDownloaded = true
 	}
 
@@ -264,7 +282,16 @@ func (args *Args) setCommandLineArgs() (err error) {
 	)
 
 	flag.StringVar(
-		&args.SwupdCertPath, "swupd-cert", args.SwupdCertPath, "Swupd --certpath",
+		&args.SwupdCertPath, "swupd-cert", args.SwupdCertPath,
+		"Swupd --certpath; also trusted as a CA bundle by the downloads",
+	)
+
+	flag.StringVar(
+		&args.ClientCert, "client-cert", args.ClientCert, "Client certificate of the HTTPS downloads",
+	)
+
+	flag.StringVar(
+		&args.ClientKey, "client-key", args.ClientKey, "Private key of the client certificate",
 	)
 
 	flag.BoolVar(
@@ -314,6 +341,32 @@ func (args *Args) setCommandLineArgs() (err error) {
 		"Converts ister JSON config to clr-installer YAML config",
 	)
 
+	flag.StringVar(
+		&args.ConvertFile, "convert", args.ConvertFile,
+		"Converts a kickstart, cloud-config or ister JSON config to clr-installer YAML config",
+	)
+
+	flag.StringVar(
+		&args.ConfigSHA256, "config-sha256", args.ConfigSHA256,
+		"Expected sha256 checksum of the configuration file",
+	)
+
+	flag.StringSliceVar(
+		&args.TrustedKeys, "trusted-keys", args.TrustedKeys,
+		"File with the ssh-ed25519 public keys trusted to sign configuration files",
+	)
+
+	flag.BoolVar(
+		&args.RequireSigned, "require-signed", args.RequireSigned,
+		"Refuse configuration files without a valid signature or checksum",
+	)
+
+	var diffConfigFile string
+	flag.StringVar(
+		&diffConfigFile, "diff", "",
+		"Shows the semantic differences between two YAML config files: --diff <A> <B>",
+	)
+
 	flag.StringVarP(
 		&args.TemplateConfigFile, "template", "T", args.TemplateConfigFile,
 		"Generates a template clr-installer YAML config file",
@@ -423,6 +476,37 @@ func (args *Args) setCommandLineArgs() (err error) {
 	// We do not want this flag to be shown as part of the standard help message
 	makeFlagHidden(flag, "skip-validation-all")
 
+	flag.StringVar(
+		&args.MakeMirror, "make-mirror", args.MakeMirror,
+		"Downloads the swupd content of the configured version and bundles to a directory usable as mirror",
+	)
+
+	flag.StringVar(
+		&args.VerifyMirror, "verify-mirror", args.VerifyMirror,
+		"Checks the files of a mirror created with --make-mirror against its checksums and signed manifests",
+	)
+
+	flag.StringVar(
+		&args.ContentCache, "content-cache", args.ContentCache,
+		"Directory keeping the downloaded content of each version for the following installations",
+	)
+
+	flag.StringVar(
+		&args.ContentCacheSize, "content-cache-size", args.ContentCacheSize,
+		"Size limit of the content cache, the least recently used versions are removed past it; <size>[B|K|M|G]",
+	)
+
+	flag.BoolVar(
+		&args.Reproducible, "reproducible", false,
+		"Pins the version and the content to a lock file next to the configuration file, "+
+			"and creates the file systems with identifiers and timestamps derived from it",
+	)
+
+	flag.BoolVar(
+		&args.NoMatch, "no-match", false,
+		"Do not apply the hardware match sections of the configuration file",
+	)
+
 	flag.StringVar(
 		&args.SwapFileSize, "swap-file-size", args.SwapFileSize, "Size of the swapfile; <size>[B|K|M|G]",
 	)
@@ -444,11 +528,21 @@ func (args *Args) setCommandLineArgs() (err error) {
 	// If we have a downloaded file, but it is overridden by command line, remove the tempfile
 	if args.CfDownloaded && args.ConfigFile != saveConfigFile {
 		_ = os.Remove(saveConfigFile)
+		args.ConfigURL = ""
 	}
 
 	// Determine whether boolean command line arguments were set or not
 	args.setBoolFlagCheck(flag)
 
+	if diffConfigFile != "" {
+		// The second config file is the last positional argument
+		if flag.NArg() < 2 {
+			return errors.New("--diff requires two config files: --diff <A> <B>")
+		}
+
+		args.DiffConfigFiles = []string{diffConfigFile, flag.Arg(flag.NArg() - 1)}
+	}
+
 	if (args.TelemetryURL != "" && args.TelemetryTID == "") ||
 		(args.TelemetryURL == "" && args.TelemetryTID != "") {
 		return errors.New("Telemetry requires both --telemetry-url and --telemetry-tid")
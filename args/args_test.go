@@ -146,6 +146,43 @@ func TestKernelCmdDemoTrue(t *testing.T) {
 	}
 }
 
+func TestKernelCmdConfVerification(t *testing.T) {
+	var testArgs Args
+	var kernelCmd string
+	var err error
+
+	kernelCmd = "root=PARTUUID=694da991-29f6-4cbd-ab72-6da064a799c0 quiet" +
+		" " + kernelCmdlineConfSHA256 + "=5d2281d97bd3a7aa7bad0faccf700442ad5e605b757b7c59e2d4d301b1ab4531" +
+		" " + kernelCmdlineConfSigned
+
+	kernelCmdlineFile, err = makeTestKernelCmd(kernelCmd)
+	defer func() {
+		_ = os.Remove(kernelCmdlineFile)
+	}()
+	if err != nil {
+		t.Errorf("Failed to makeTestKernelCmd with error %q", err)
+		return
+	}
+
+	err = testArgs.setKernelArgs()
+	if err != nil {
+		t.Errorf("Failed to setKernelArgs with error %q", err)
+		return
+	}
+
+	if testArgs.ConfigSHA256 != "5d2281d97bd3a7aa7bad0faccf700442ad5e605b757b7c59e2d4d301b1ab4531" {
+		t.Errorf("Failed to detect the config checksum with kernel command %q", kernelCmd)
+	}
+
+	if !testArgs.RequireSigned {
+		t.Errorf("Failed to detect the signed config policy with kernel command %q", kernelCmd)
+	}
+
+	if testArgs.ConfigFile != "" {
+		t.Errorf("Checksum option should not be mistaken for a config file: %q", testArgs.ConfigFile)
+	}
+}
+
 func TestKernelCmdDemoFalse(t *testing.T) {
 	var testArgs Args
 	var kernelCmd string
@@ -419,6 +456,49 @@ func TestConvertArg(t *testing.T) {
 	}
 }
 
+func TestConvertKickstartArg(t *testing.T) {
+	var testArgs Args
+
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+
+	os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--convert", "anaconda-ks.cfg"}
+	t.Logf("Current os.Args: %v", os.Args)
+
+	err := testArgs.setCommandLineArgs()
+
+	os.Args = currArgs
+	if err != nil {
+		t.Fatal("Failed to parse arguments")
+	}
+	t.Logf("testArgs.ConvertFile: %s", testArgs.ConvertFile)
+	if testArgs.ConvertFile != "anaconda-ks.cfg" {
+		t.Fatal("Failed to parse config file for --convert")
+	}
+}
+
+func TestDiffArg(t *testing.T) {
+	var testArgs Args
+
+	currArgs := make([]string, len(os.Args))
+	copy(currArgs, os.Args)
+
+	os.Args = []string{currArgs[0], currArgs[1], currArgs[2], "--diff", "a.yaml", "b.yaml"}
+	t.Logf("Current os.Args: %v", os.Args)
+
+	err := testArgs.setCommandLineArgs()
+
+	os.Args = currArgs
+	if err != nil {
+		t.Fatal("Failed to parse arguments")
+	}
+	t.Logf("testArgs.DiffConfigFiles: %v", testArgs.DiffConfigFiles)
+	if len(testArgs.DiffConfigFiles) != 2 || testArgs.DiffConfigFiles[0] != "a.yaml" ||
+		testArgs.DiffConfigFiles[1] != "b.yaml" {
+		t.Fatal("Failed to parse config files for --diff")
+	}
+}
+
 func TestBundleArg(t *testing.T) {
 	var testArgs Args
 
@@ -30,6 +30,7 @@ import (
 	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/signature"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/syscheck"
@@ -116,6 +117,11 @@ func main() {
 	}
 	log.SetLogLevel(options.LogLevel)
 
+	if err := network.SetTLSFiles(options.SwupdCertPath, options.ClientCert, options.ClientKey); err != nil {
+		fmt.Println("TLS Configuration Error: " + err.Error())
+		os.Exit(1)
+	}
+
 	// Begin installer execution
 	if err := execute(options); err != nil {
 		// Print and log errors with stack traces. To include stack traces, the
@@ -174,6 +180,12 @@ func checkAndLoadConfigFile(options args.Args, md **model.SystemInstall) (string
 	var err error
 
 	cf := options.ConfigFile
+	source := options.ConfigFile
+	remote := options.CfDownloaded && options.ConfigURL != ""
+	if remote {
+		source = options.ConfigURL
+	}
+
 	if options.ConfigFile == "" {
 		if cf, err = conf.LookupDefaultConfig(); err != nil {
 			return "", err
@@ -184,10 +196,18 @@ func checkAndLoadConfigFile(options args.Args, md **model.SystemInstall) (string
 			return "", err
 		}
 		options.CfDownloaded = true
+		remote = true
 	} else if ok, err := utils.FileExists(options.ConfigFile); !ok || err != nil {
 		return "", errors.Errorf("Cannot access configuration file %q", options.ConfigFile)
 	}
 
+	// The default configuration file is part of the installer image
+	if options.ConfigFile != "" {
+		if err = verifyConfigFile(options, cf, source, remote); err != nil {
+			return "", err
+		}
+	}
+
 	if filepath.Ext(cf) == ".json" {
 		_, err = model.JSONtoYAMLConfig(cf)
 		if err != nil {
@@ -207,6 +227,67 @@ func checkAndLoadConfigFile(options args.Args, md **model.SystemInstall) (string
 	return cf, nil
 }
 
+// verifyConfigFile enforces the checksum pinning and signature policy on the
+// configuration file cf loaded from source
+func verifyConfigFile(options args.Args, cf string, source string, remote bool) error {
+	if options.ConfigSHA256 != "" {
+		if err := signature.VerifySHA256(cf, options.ConfigSHA256); err != nil {
+			return err
+		}
+
+		log.Info("Configuration file %q matches the pinned sha256 checksum", source)
+		return nil
+	}
+
+	keyFiles := append([]string{}, options.TrustedKeys...)
+	if baked, err := conf.LookupTrustedKeysFile(); err == nil {
+		keyFiles = append(keyFiles, baked)
+	}
+
+	keys, err := signature.LoadTrustedKeys(keyFiles...)
+	if err != nil {
+		return err
+	}
+
+	// Remote configurations must be signed once trusted keys are available
+	required := options.RequireSigned || (remote && len(keys) > 0)
+
+	sigFile := cf + signature.Extension
+	if remote {
+		if sigFile, err = network.FetchRemoteConfigFile(source + signature.Extension); err != nil {
+			log.Debug("No signature found for %q: %v", source, err)
+			sigFile = ""
+		} else {
+			defer func() { _ = os.Remove(sigFile) }()
+		}
+	} else if ok, _ := utils.FileExists(sigFile); !ok {
+		sigFile = ""
+	}
+
+	if sigFile == "" || len(keys) == 0 {
+		if required {
+			return errors.Errorf("Refusing configuration file %q: no valid signature or checksum", source)
+		}
+
+		if remote {
+			msg := fmt.Sprintf("Configuration file %q is not verified; use trusted keys or a sha256 checksum", source)
+			fmt.Println("WARNING: " + msg)
+			log.Warning(msg)
+		}
+
+		return nil
+	}
+
+	signer, err := signature.Verify(cf, sigFile, keys)
+	if err != nil {
+		return errors.Errorf("Refusing configuration file %q: %v", source, err)
+	}
+
+	log.Info("Configuration file %q signed by trusted key %q", source, signer.Comment)
+
+	return nil
+}
+
 func processSwupdOptions(options args.Args, md *model.SystemInstall) {
 	// Command line overrides the configuration file
 	if options.SwupdMirror != "" {
@@ -425,6 +506,10 @@ func processConvertConfigFileOption(options args.Args, md *model.SystemInstall)
 		return copyModel, errors.Errorf("Options --json-yaml and --template are mutually exclusive.")
 	}
 
+	if options.ConvertFile != "" && (options.ConvertConfigFile != "" || options.TemplateConfigFile != "") {
+		return copyModel, errors.Errorf("Option --convert is mutually exclusive with --json-yaml and --template.")
+	}
+
 	if options.ConvertConfigFile != "" {
 		if filepath.Ext(options.ConvertConfigFile) == ".json" {
 			copyModel, err = model.JSONtoYAMLConfig(options.ConvertConfigFile)
@@ -433,9 +518,120 @@ func processConvertConfigFileOption(options args.Args, md *model.SystemInstall)
 		}
 	}
 
+	if options.ConvertFile != "" {
+		copyModel, err = convertConfigFile(options.ConvertFile)
+	}
+
 	return copyModel, err
 }
 
+// convertConfigFile detects the format of a foreign config file and converts it
+func convertConfigFile(cf string) (*model.SystemInstall, error) {
+	if filepath.Ext(cf) == ".json" {
+		return model.JSONtoYAMLConfig(cf)
+	}
+
+	if model.IsCloudConfig(cf) {
+		md, err := model.CloudInitToYAMLConfig(cf)
+		if err == nil {
+			md.CloudInitSeed = true
+		}
+		return md, err
+	}
+
+	md, report, err := model.KickstartToYAMLConfig(cf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, curr := range report.Unsupported {
+		msg := fmt.Sprintf("Kickstart %s", curr)
+		fmt.Println("WARNING: " + msg)
+		log.Warning(msg)
+	}
+
+	return md, nil
+}
+
+// processDiffOption prints the semantic differences between two config files
+func processDiffOption(options args.Args) error {
+	var models []*model.SystemInstall
+
+	for _, cf := range options.DiffConfigFiles {
+		if _, err := os.Stat(cf); err != nil {
+			return errors.Wrap(err)
+		}
+
+		// ConfigFile is set so LoadFile does not apply the running system defaults
+		md, err := model.LoadFile(cf, args.Args{ConfigFile: cf, NoMatch: true})
+		if err != nil {
+			return errors.Errorf("Failed to load config file %q: %v", cf, err)
+		}
+		models = append(models, md)
+	}
+
+	sections := model.Diff(models[0], models[1])
+	if len(sections) == 0 {
+		fmt.Println("No semantic differences found")
+		return nil
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n", options.DiffConfigFiles[0], options.DiffConfigFiles[1])
+	for _, section := range sections {
+		fmt.Printf("\n[%s]\n", section.Name)
+		for _, change := range section.Changes {
+			fmt.Println("  " + change)
+		}
+	}
+
+	return nil
+}
+
+// processMakeMirrorOption downloads the swupd content of the configured version
+// and bundles to the directory given with --make-mirror
+func processMakeMirrorOption(options args.Args, md *model.SystemInstall) error {
+	bundles := append(swupd.InstallBundles(md), md.UserBundles...)
+	contentURL := swupd.ContentURL(md, options)
+
+	dir, err := filepath.Abs(options.MakeMirror)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	fmt.Printf("Building the swupd mirror %s from %s\n", dir, contentURL)
+
+	version, err := swupd.BuildMirror(dir, contentURL, utils.VersionUintString(md.Version),
+		bundles, md.SwupdSkipOptional, options.SwupdCertPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Mirror of version %s is complete, install from it with: --swupd-url=file://%s\n", version, dir)
+
+	return nil
+}
+
+// processVerifyMirrorOption checks a mirror created with --make-mirror is complete
+func processVerifyMirrorOption(options args.Args) error {
+	problems, err := swupd.VerifyMirror(options.VerifyMirror, options.SwupdCertPath)
+	if err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		for _, curr := range problems {
+			fmt.Println(curr)
+		}
+
+		return errors.Errorf("Mirror %s failed the verification: %d problems found",
+			options.VerifyMirror, len(problems))
+	}
+
+	fmt.Printf("Mirror %s verified\n", options.VerifyMirror)
+
+	return nil
+}
+
 func processOptionsSaveIfSet(options args.Args, md *model.SystemInstall) {
 	if options.RebootSet {
 		md.PostReboot = options.Reboot
@@ -498,6 +694,14 @@ func execute(options args.Args) error {
 		return nil
 	}
 
+	if len(options.DiffConfigFiles) == 2 {
+		return processDiffOption(options)
+	}
+
+	if options.VerifyMirror != "" {
+		return processVerifyMirrorOption(options)
+	}
+
 	var md *model.SystemInstall
 
 	cf := options.ConfigFile
@@ -532,8 +736,14 @@ func execute(options args.Args) error {
 		log.Info("Overriding bundle list from command line: %s", strings.Join(md.Bundles, ", "))
 	}
 
-	if options.ConvertConfigFile != "" {
-		_, err := md.WriteYAMLConfig(options.ConvertConfigFile)
+	if options.ConvertConfigFile != "" || options.ConvertFile != "" {
+		cf := options.ConvertConfigFile
+		// the converted foreign config is written next to it as YAML
+		if options.ConvertFile != "" {
+			cf = strings.TrimSuffix(options.ConvertFile, filepath.Ext(options.ConvertFile)) + ".yaml"
+		}
+
+		_, err := md.WriteYAMLConfig(cf)
 		if err != nil {
 			return err
 		}
@@ -545,6 +755,10 @@ func execute(options args.Args) error {
 		return processTemplateConfigFileOption(options, md)
 	}
 
+	if options.MakeMirror != "" {
+		return processMakeMirrorOption(options, md)
+	}
+
 	// exit if certain conditions fail for certain options
 	osExitForOptions(options)
 
@@ -105,7 +105,7 @@ func run(sw func(cmd *exec.Cmd) error, writer io.Writer, env map[string]string,
 	for _, pvar := range proxy.GetProxyValues() {
 		cmd.Env = append(cmd.Env, pvar)
 	}
-	log.Debug("cmd.Env: %+v", cmd.Env)
+	log.Debug("cmd.Env: %s", proxy.Redact(fmt.Sprintf("%+v", cmd.Env)))
 
 	if sw != nil {
 		if err := sw(cmd); err != nil {
@@ -158,7 +158,7 @@ func RunAndProcessOutput(printPrefix string, output Output, args ...string) erro
 	for _, pvar := range proxy.GetProxyValues() {
 		cmd.Env = append(cmd.Env, pvar)
 	}
-	log.Debug("cmd.Env: %+v", cmd.Env)
+	log.Debug("cmd.Env: %s", proxy.Redact(fmt.Sprintf("%+v", cmd.Env)))
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
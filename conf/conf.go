@@ -37,6 +37,9 @@ const (
 
 	// KernelListFile is the file describing the available kernel bundles
 	KernelListFile = "kernels.json"
+
+	// TrustedKeysFile lists the public keys trusted to sign configuration files
+	TrustedKeysFile = "trusted-keys"
 )
 
 func isRunningFromSourceTree() (bool, string, error) {
@@ -91,6 +94,12 @@ func LookupDefaultConfig() (string, error) {
 	return lookupDefaultFile(ConfigFile, "")
 }
 
+// LookupTrustedKeysFile looks up the public keys baked in the image and trusted
+// to sign configuration files
+func LookupTrustedKeysFile() (string, error) {
+	return lookupDefaultFile(TrustedKeysFile, "")
+}
+
 // LookupChpasswdConfig looks up the chpasswd pam file used in the post install
 func LookupChpasswdConfig() (string, error) {
 	return lookupDefaultFile(ChpasswdPAMFile, "")
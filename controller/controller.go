@@ -64,7 +64,7 @@ func sortMountPoint(bds []*storage.BlockDevice) []*storage.BlockDevice {
 func Install(rootDir string, model *model.SystemInstall, options args.Args) error {
 	var err error
 	var prg progress.Progress
-	var encryptedUsed, softRaidUsed, lvmRootUsed, lvmOtherUsed bool
+	var encryptedUsed bool
 
 	vars := map[string]string{
 		"chrootDir": rootDir,
@@ -75,6 +75,10 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		vars[k] = v
 	}
 
+	if err = configureProxy(model); err != nil {
+		return err
+	}
+
 	preConfFile := log.GetPreConfFile()
 
 	if err = model.WriteFile(preConfFile); err != nil {
@@ -99,6 +103,17 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	if options.Reproducible {
+		msg := utils.Locale.Get("Verifying the content lock file")
+		prg = progress.NewLoop(msg)
+		log.Info(msg)
+		if _, err = swupd.ApplyLock(model, options); err != nil {
+			prg.Failure()
+			return err
+		}
+		prg.Success()
+	}
+
 	version := utils.VersionUintString(model.Version)
 
 	log.Debug("Clear Linux OS version: %s", version)
@@ -108,16 +123,40 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
+	contentIsLocal := swupd.ContentIsLocal(version, options, model)
+
 	// Using MassInstaller (non-UI) the network will not have been checked yet
 	if !NetworkPassing &&
 		!options.StubImage &&
-		!swupd.OfflineIsUsable(version, options) &&
+		!contentIsLocal &&
 		len(model.UserBundles) != 0 {
 		if err = ConfigureNetwork(model); err != nil {
 			return err
 		}
 	}
 
+	// check the bundle names and account for the size of the selected bundles
+	// in the partition validation, the bundle manifests are only read once the
+	// content is reachable
+	if !options.StubImage && (contentIsLocal || NetworkPassing) {
+		if resolver, err := swupd.LoadResolver(model, options); err != nil {
+			log.Warning("Could not check the bundle names: %v", err)
+		} else {
+			model.BundleResolver = resolver
+		}
+
+		if estimate := swupd.UpdateEstimatedSize(model, options); estimate != nil {
+			log.Info(estimate.String())
+		}
+
+		if err = model.Validate(); err != nil {
+			return err
+		}
+	}
+
+	progress.BeginInstall()
+	progress.StartPhase(progress.PhasePartition)
+
 	expandMe := []*storage.BlockDevice{}
 	detachMe := []string{}
 	removeMe := []string{}
@@ -251,14 +290,15 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 	var childrenToCheck []*storage.BlockDevice
 
 	for _, curr := range model.TargetMedias {
-		// Are we using software RAID
-		softRaidUsed = softRaidUsed || curr.UsesRaid()
-
 		childrenToCheck = append(childrenToCheck, curr.FindAllChildren()...)
 	}
 
+	progress.StartPhase(progress.PhaseFormat)
+
 	// prepare the blockdevice's partitions filesystem
-	for _, ch := range childrenToCheck {
+	for i, ch := range childrenToCheck {
+		progress.PhaseProgress(progress.PhaseFormat, float64(i)/float64(len(childrenToCheck)))
+
 		if ch.Type == storage.BlockDeviceTypeCrypt {
 			encryptedUsed = true
 
@@ -274,14 +314,6 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 			}
 		}
 
-		if ch.Type == storage.BlockDeviceTypeLVM2Volume {
-			if ch.MountPoint == "/" {
-				lvmRootUsed = true
-			} else {
-				lvmOtherUsed = true
-			}
-		}
-
 		// if we have a mount point set it for future mounting
 		if ch.MountPoint != "" {
 			mountPoints = append(mountPoints, ch)
@@ -313,6 +345,7 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 	}
 
 	if options.StubImage {
+		progress.EndInstall()
 		return nil
 	}
 
@@ -343,54 +376,13 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
-	// If we are using NetworkManager add the basic bundle
-	if network.IsNetworkManagerActive() {
-		log.Info("Adding bundle '%s' to enable networking", network.RequiredBundle)
-		model.AddBundle(network.RequiredBundle)
+	// Add the bundles required by the configuration, the same list the
+	// bundle pages and the size estimate use
+	for _, curr := range swupd.ImplicitBundles(model) {
+		log.Info("Adding bundle '%s': %s", curr.Name, curr.Reason)
+		model.AddBundle(curr.Name)
 	}
 
-	// Add in the User Defined bundles
-	for _, curr := range model.UserBundles {
-		log.Info("Adding bundle '%s' from user selection", curr)
-		model.AddBundle(curr)
-	}
-
-	if model.Telemetry.Enabled {
-		log.Info("Adding bundle '%s' to enable telemetry", telemetry.RequiredBundle)
-		model.AddBundle(telemetry.RequiredBundle)
-	}
-
-	if len(model.Users) > 0 {
-		log.Info("Adding bundle '%s' to support non-root users", cuser.RequiredBundle)
-		model.AddBundle(cuser.RequiredBundle)
-	}
-
-	if model.Timezone.Code != timezone.DefaultTimezone {
-		log.Info("Adding bundle '%s' due to non-default timezone '%s'",
-			timezone.RequiredBundle, model.Timezone.Code)
-		model.AddBundle(timezone.RequiredBundle)
-	}
-
-	if model.Keyboard.Code != keyboard.DefaultKeyboard {
-		log.Info("Adding bundle '%s' due to non-default keyboard '%s'",
-			keyboard.RequiredBundle, model.Keyboard.Code)
-		model.AddBundle(keyboard.RequiredBundle)
-	}
-
-	if model.Language.Code != language.DefaultLanguage {
-		log.Info("Adding bundle '%s' due to non-default language '%s'",
-			language.RequiredBundle, model.Language.Code)
-		model.AddBundle(language.RequiredBundle)
-	}
-
-	if encryptedUsed || softRaidUsed || lvmRootUsed {
-		log.Info("Adding bundle '%s' to enable encryption, sw RAID, or LVM root", storage.RequiredBundle)
-		model.AddBundle(storage.RequiredBundle)
-	}
-	if lvmOtherUsed {
-		log.Info("Adding bundle '%s' to enable LVM", storage.RequiredBundleLVM)
-		model.AddBundle(storage.RequiredBundleLVM)
-	}
 	if encryptedUsed {
 		kernelArgs := []string{storage.KernelArgument}
 		model.AddExtraKernelArguments(kernelArgs)
@@ -433,6 +425,8 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	progress.StartPhase(progress.PhaseDownload)
+
 	if prg, err = contentInstall(rootDir, version, model, options); err != nil {
 		prg.Failure()
 		return err
@@ -449,6 +443,8 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		prg.Success()
 	}
 
+	progress.StartPhase(progress.PhaseUsers)
+
 	if err = configureTimezone(rootDir, model); err != nil {
 		// Just log the error, not setting the timezone is not reason to fail the install
 		log.Error("Error setting timezone: %v", err)
@@ -468,6 +464,10 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		return err
 	}
 
+	if err = cuser.ApplySudo(rootDir, model.Sudo); err != nil {
+		return err
+	}
+
 	if model.Hostname != "" {
 		if err = hostname.SetTargetHostname(rootDir, model.Hostname); err != nil {
 			return err
@@ -480,11 +480,42 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	if model.Resolved != nil {
+		if err = model.Resolved.Apply(rootDir); err != nil {
+			return err
+		}
+	}
+
+	if err = network.WriteWirelessConnections(rootDir, model.NetworkInterfaces); err != nil {
+		return err
+	}
+
+	if err = network.WriteLinkFiles(rootDir, model.NetworkInterfaces); err != nil {
+		return err
+	}
+
+	if model.Proxy != nil {
+		if err = model.Proxy.Apply(rootDir); err != nil {
+			return err
+		}
+	}
+
 	if model.CopySwupd {
 		swupd.CopyConfigurations(rootDir)
 	}
 
-	if model.AllowInsecureHTTP {
+	if model.CloudInitSeed {
+		if err = model.WriteCloudInitSeed(rootDir); err != nil {
+			return err
+		}
+	}
+
+	if model.Updates != nil {
+		// the swupd configuration of the updates includes allow_insecure_http
+		if err = model.Updates.Apply(rootDir, model.AllowInsecureHTTP); err != nil {
+			return err
+		}
+	} else if model.AllowInsecureHTTP {
 		swupd.CreateConfig(rootDir)
 	}
 
@@ -494,6 +525,8 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	progress.StartPhase(progress.PhaseHooks)
+
 	if err = applyHooks("post-install", vars, model.PostInstall); err != nil {
 		return err
 	}
@@ -513,6 +546,8 @@ func Install(rootDir string, model *model.SystemInstall, options args.Args) erro
 		}
 	}
 
+	progress.EndInstall()
+
 	msg = utils.Locale.Get("Installation completed")
 	prg = progress.NewLoop(msg)
 	log.Info(msg)
@@ -622,6 +657,15 @@ func contentInstall(rootDir string, version string,
 		}
 	}
 
+	// The content cache of the version is shared with the other installations
+	cache, cacheErr := swupd.AcquireContentCache(md, options)
+	if cacheErr != nil {
+		log.Warning("Not using the content cache: %v", cacheErr)
+	} else if cache != nil {
+		defer cache.Release()
+		sw.UseStateDirCache(cache.Dir())
+	}
+
 	msg := utils.Locale.Get("Installing base OS and configured bundles")
 	log.Info(msg)
 
@@ -633,6 +677,24 @@ func contentInstall(rootDir string, version string,
 		return prg, err
 	}
 
+	if cache != nil {
+		cacheMsg := utils.Locale.Get("Updating the content cache")
+		prg = progress.NewLoop(cacheMsg)
+		log.Info(cacheMsg)
+		if err := cache.Store(sw.GetStateDir()); err != nil {
+			log.Warning("Could not update the content cache: %v", err)
+		}
+		prg.Success()
+	}
+
+	// Register the third-party repositories and install their bundles
+	if len(md.ThirdParty) > 0 {
+		if err := sw.InstallThirdParty(md.ThirdParty); err != nil {
+			prg = progress.NewLoop(msg)
+			return prg, err
+		}
+	}
+
 	// Create custom config in the installer image to override default bundle list
 	if md.TargetBundles != nil {
 		if err := writeCustomConfig(rootDir, md); err != nil {
@@ -684,6 +746,14 @@ func contentInstall(rootDir string, version string,
 			prg = progress.NewLoop(msg)
 			return prg, err
 		}
+
+		if len(md.ThirdParty) > 0 {
+			log.Info("Downloading third-party content to the target")
+			if err := sw.DownloadThirdParty(md.ThirdParty); err != nil {
+				prg = progress.NewLoop(msg)
+				return prg, err
+			}
+		}
 	}
 
 	if !md.AutoUpdate.Value() {
@@ -698,6 +768,8 @@ func contentInstall(rootDir string, version string,
 		prg.Success()
 	}
 
+	progress.StartPhase(progress.PhaseBootloader)
+
 	msg = utils.Locale.Get("Installing boot loader")
 	prg = progress.NewLoop(msg)
 	log.Info(msg)
@@ -789,6 +861,11 @@ func copyOfflineToStatedir(rootDir, stateDir string) error {
 
 // ConfigureNetwork applies the model/configured network interfaces
 func ConfigureNetwork(model *model.SystemInstall) error {
+	if err := configureProxy(model); err != nil {
+		NetworkPassing = false
+		return err
+	}
+
 	prg, err := configureNetwork(model)
 	if err != nil {
 		prg.Failure()
@@ -801,17 +878,41 @@ func ConfigureNetwork(model *model.SystemInstall) error {
 	return nil
 }
 
-func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
+// configureProxy sets the proxies used by the installer commands and requests
+func configureProxy(model *model.SystemInstall) error {
 	proxy.SetHTTPSProxy(model.HTTPSProxy)
 
-	if len(model.NetworkInterfaces) > 0 {
+	if model.Proxy != nil {
+		if err := model.Proxy.Load(); err != nil {
+			return err
+		}
+	}
+	proxy.SetConfig(model.Proxy)
+
+	return nil
+}
+
+func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
+	if len(model.NetworkInterfaces) > 0 || model.Resolved != nil {
 		msg := "Applying network settings"
 		prg := progress.NewLoop(msg)
 		log.Info(msg)
+		if err := network.ResolveNames(model.NetworkInterfaces); err != nil {
+			prg.Failure()
+			return prg, err
+		}
+
 		if err := network.Apply("/", model.NetworkInterfaces); err != nil {
 			prg.Failure()
 			return prg, err
 		}
+
+		if model.Resolved != nil {
+			if err := model.Resolved.Apply("/"); err != nil {
+				prg.Failure()
+				return prg, err
+			}
+		}
 		prg.Success()
 
 		msg = utils.Locale.Get("Restarting network interfaces")
@@ -822,33 +923,37 @@ func configureNetwork(model *model.SystemInstall) (progress.Progress, error) {
 			return prg, err
 		}
 		prg.Success()
-	}
 
-	msg := utils.Locale.Get("Testing connectivity")
-	attempts := 3
-	prg := progress.NewLoop(msg)
-	ok := false
-	// 3 attempts to test connectivity
-	for i := 0; i < attempts; i++ {
-		time.Sleep(2 * time.Second)
+		for _, curr := range model.NetworkInterfaces {
+			if !curr.IsVirtual() {
+				continue
+			}
 
-		log.Info(msg)
-		if err := network.VerifyConnectivity(); err == nil {
-			ok = true
+			msg = utils.Locale.Get("Waiting for virtual network devices")
+			prg = progress.NewLoop(msg)
+			log.Info(msg)
+			if err := network.WaitForVirtualDevices(model.NetworkInterfaces); err != nil {
+				// The connectivity test decides if the network is usable
+				log.Warning("%v", err)
+			}
+			prg.Success()
 			break
 		}
-		log.Warning("Attempt to verify connectivity failed")
+	}
 
-		// Restart networking if we failed
-		// The likely gain is restarting pacdiscovery to fix autoproxy
-		if err := network.Restart(); err != nil {
-			log.Warning("Network restart failed")
-			ok = false
-			break
+	msg := utils.Locale.Get("Testing connectivity")
+	prg := progress.NewLoop(msg)
+	log.Info(msg)
+
+	// Restart networking if we failed
+	// The likely gain is restarting pacdiscovery to fix autoproxy
+	if err := model.Connectivity.Verify(network.Restart); err != nil {
+		if !model.Connectivity.Fatal() {
+			log.Warning("Continuing without network connectivity: %v", err)
+			prg.Success()
+			return nil, nil
 		}
-	}
 
-	if !ok {
 		msg = utils.Locale.Get("Network check failed.")
 		msg += " " + utils.Locale.Get("Use %s to configure network.", NetWorkManager)
 		return prg, errors.Errorf(msg)
@@ -945,6 +1050,7 @@ func saveInstallResults(rootDir string, md *model.SystemInstall) error {
 	cleanModel.Users = nil             // Remove User Info
 	cleanModel.Hostname = ""           // Remove user defined hostname
 	cleanModel.HTTPSProxy = ""         // Remove user defined Proxy
+	cleanModel.Proxy = nil             // Remove user defined Proxies
 	cleanModel.SwupdMirror = ""        // Remove user defined Swupd Mirror
 	cleanModel.NetworkInterfaces = nil // Remove Network information
 
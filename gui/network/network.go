@@ -192,6 +192,9 @@ func (netDialog *networkTestDialog) Partial(total int, step int) {
 	return
 }
 
+// Overall is not used, the network check is not part of the installation progress
+func (netDialog *networkTestDialog) Overall(percent int, eta time.Duration) {}
+
 // Step will step the progressbar in indeterminate mode
 func (netDialog *networkTestDialog) Step() {
 	_, err := glib.IdleAdd(func() {
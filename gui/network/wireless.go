@@ -0,0 +1,256 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"github.com/clearlinux/clr-installer/gui/common"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/utils"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// wirelessDialog is the Wi-Fi network selection pop-up box
+type wirelessDialog struct {
+	box       *gtk.Box
+	grid      *gtk.Grid
+	device    *gtk.ComboBoxText
+	ssid      *gtk.Entry
+	hidden    *gtk.CheckButton
+	security  *gtk.ComboBoxText
+	psk       *gtk.Entry
+	eapMethod *gtk.ComboBoxText
+	identity  *gtk.Entry
+	password  *gtk.Entry
+	caCert    *gtk.Entry
+	warning   *gtk.Label
+	dialog    *gtk.Dialog
+	row       int
+}
+
+// addRow adds a labeled widget to the dialog grid
+func (wd *wirelessDialog) addRow(text string, widget gtk.IWidget) error {
+	label, err := common.SetLabel(text, "label-entry", 0.0)
+	if err != nil {
+		return err
+	}
+
+	wd.grid.Attach(label, 0, wd.row, 1, 1)
+	wd.grid.Attach(widget, 1, wd.row, 1, 1)
+	wd.row++
+
+	return nil
+}
+
+func newEntry(visible bool) (*gtk.Entry, error) {
+	widget, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+
+	widget.SetVisibility(visible)
+	widget.SetHExpand(true)
+
+	return widget, nil
+}
+
+func getText(entry *gtk.Entry) string {
+	text, err := entry.GetText()
+	if err != nil {
+		log.Warning("Error getting entry text: %v", err)
+	}
+
+	return text
+}
+
+// createWirelessDialog creates the pop-up window to select the Wi-Fi network
+func createWirelessDialog(devices []string) (*wirelessDialog, error) {
+	var err error
+	wd := &wirelessDialog{}
+
+	if wd.box, err = gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0); err != nil {
+		return nil, err
+	}
+
+	if wd.grid, err = gtk.GridNew(); err != nil {
+		return nil, err
+	}
+	wd.grid.SetRowSpacing(6)
+	wd.grid.SetColumnSpacing(12)
+	wd.box.PackStart(wd.grid, true, true, 0)
+
+	if wd.device, err = gtk.ComboBoxTextNew(); err != nil {
+		return nil, err
+	}
+	for _, curr := range devices {
+		wd.device.Append(curr, curr)
+	}
+	wd.device.SetActive(0)
+
+	if wd.ssid, err = newEntry(true); err != nil {
+		return nil, err
+	}
+
+	if wd.hidden, err = gtk.CheckButtonNewWithLabel(utils.Locale.Get("Hidden network")); err != nil {
+		return nil, err
+	}
+
+	if wd.security, err = gtk.ComboBoxTextNew(); err != nil {
+		return nil, err
+	}
+	wd.security.Append(network.WirelessOpen, utils.Locale.Get("None"))
+	wd.security.Append(network.WirelessWPA2PSK, utils.Locale.Get("WPA2 Personal"))
+	wd.security.Append(network.WirelessWPA3PSK, utils.Locale.Get("WPA3 Personal"))
+	wd.security.Append(network.WirelessEAP, utils.Locale.Get("WPA2/WPA3 Enterprise"))
+	wd.security.SetActiveID(network.WirelessWPA2PSK)
+
+	if wd.psk, err = newEntry(false); err != nil {
+		return nil, err
+	}
+
+	if wd.eapMethod, err = gtk.ComboBoxTextNew(); err != nil {
+		return nil, err
+	}
+	wd.eapMethod.Append("peap", "PEAP")
+	wd.eapMethod.Append("ttls", "TTLS")
+	wd.eapMethod.SetActiveID("peap")
+
+	if wd.identity, err = newEntry(true); err != nil {
+		return nil, err
+	}
+
+	if wd.password, err = newEntry(false); err != nil {
+		return nil, err
+	}
+
+	if wd.caCert, err = newEntry(true); err != nil {
+		return nil, err
+	}
+	wd.caCert.SetPlaceholderText(utils.Locale.Get("Path of the CA certificate, optional"))
+
+	rows := []struct {
+		text   string
+		widget gtk.IWidget
+	}{
+		{utils.Locale.Get("Interface"), wd.device},
+		{utils.Locale.Get("Network name (SSID)"), wd.ssid},
+		{"", wd.hidden},
+		{utils.Locale.Get("Security"), wd.security},
+		{utils.Locale.Get("Passphrase"), wd.psk},
+		{utils.Locale.Get("Authentication"), wd.eapMethod},
+		{utils.Locale.Get("Identity"), wd.identity},
+		{utils.Locale.Get("Password"), wd.password},
+		{utils.Locale.Get("CA certificate"), wd.caCert},
+	}
+
+	for _, curr := range rows {
+		if err = wd.addRow(curr.text, curr.widget); err != nil {
+			return nil, err
+		}
+	}
+
+	if wd.warning, err = common.SetLabel("", "label-warning", 0.0); err != nil {
+		return nil, err
+	}
+	wd.warning.SetLineWrap(true)
+	wd.box.PackStart(wd.warning, false, false, 6)
+
+	if _, err = wd.security.Connect("changed", wd.onSecurityChanged); err != nil {
+		return nil, err
+	}
+	wd.onSecurityChanged()
+
+	title := utils.Locale.Get("Connect to a Wi-Fi network")
+	wd.dialog, err = common.CreateDialogOkCancel(wd.box, title, utils.Locale.Get("CONNECT"),
+		utils.Locale.Get("SKIP"))
+	if err != nil {
+		return nil, err
+	}
+
+	wd.dialog.ShowAll()
+
+	return wd, nil
+}
+
+// onSecurityChanged enables the widgets relevant to the selected security
+func (wd *wirelessDialog) onSecurityChanged() {
+	security := wd.security.GetActiveID()
+	isEAP := security == network.WirelessEAP
+
+	wd.psk.SetSensitive(security == network.WirelessWPA2PSK || security == network.WirelessWPA3PSK)
+	wd.eapMethod.SetSensitive(isEAP)
+	wd.identity.SetSensitive(isEAP)
+	wd.password.SetSensitive(isEAP)
+	wd.caCert.SetSensitive(isEAP)
+}
+
+// getInterface returns the interface connecting to the Wi-Fi network as entered
+func (wd *wirelessDialog) getInterface() *network.Interface {
+	w := &network.Wireless{
+		SSID:     getText(wd.ssid),
+		Hidden:   wd.hidden.GetActive(),
+		Security: wd.security.GetActiveID(),
+	}
+
+	switch w.Security {
+	case network.WirelessWPA2PSK, network.WirelessWPA3PSK:
+		w.PSK = getText(wd.psk)
+	case network.WirelessEAP:
+		w.EAP = &network.EAP{
+			Method:   wd.eapMethod.GetActiveID(),
+			Identity: getText(wd.identity),
+			Password: getText(wd.password),
+			CACert:   getText(wd.caCert),
+		}
+	}
+
+	return &network.Interface{
+		Name:        wd.device.GetActiveID(),
+		DHCP:        true,
+		Wireless:    w,
+		UserDefined: true,
+	}
+}
+
+// RunWirelessDialog creates the pop-up window to connect to a Wi-Fi network when the
+// system has Wi-Fi devices, the network is added to the model's interfaces
+func RunWirelessDialog(md *model.SystemInstall) (bool, error) {
+	devices, err := network.WirelessDevices()
+	if err != nil || len(devices) == 0 {
+		return false, err
+	}
+
+	wd, err := createWirelessDialog(devices)
+	if err != nil {
+		return false, err
+	}
+	defer wd.dialog.Destroy()
+
+	for wd.dialog.Run() == gtk.RESPONSE_OK {
+		iface := wd.getInterface()
+
+		if err = iface.Validate(); err != nil {
+			wd.warning.SetText(err.Error())
+			continue
+		}
+
+		replaced := false
+		for idx, curr := range md.NetworkInterfaces {
+			if curr.Name == iface.Name {
+				md.NetworkInterfaces[idx] = iface
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			md.AddNetworkInterface(iface)
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
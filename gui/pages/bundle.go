@@ -11,11 +11,13 @@ import (
 	"strings"
 
 	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
 
 	"github.com/clearlinux/clr-installer/controller"
 	"github.com/clearlinux/clr-installer/gui/common"
 	"github.com/clearlinux/clr-installer/gui/network"
+	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/model"
 	"github.com/clearlinux/clr-installer/swupd"
 	"github.com/clearlinux/clr-installer/utils"
@@ -41,11 +43,17 @@ type Bundle struct {
 	windowController Controller
 	bundles          []*swupd.Bundle     // Known bundles
 	box              *gtk.Box            // Main layout
+	searchEntry      *gtk.SearchEntry    // Filter the bundles by name or description
+	category         *gtk.ComboBoxText   // Filter the bundles by category
+	note             *gtk.Label          // Explain the effect of the bundle selection
+	resolver         *swupd.Resolver     // Resolve the bundle includes
 	checks           *gtk.FlowBox        // Where to store checks
 	scroll           *gtk.ScrolledWindow // Scroll the checks
 
 	selections []*gtk.CheckButton
 	clearPage  bool
+	loading    bool
+	noteSeq    int
 }
 
 type decisionDialog struct {
@@ -111,6 +119,9 @@ func createBundleWidget(bundle *swupd.Bundle) (*gtk.CheckButton, error) {
 	root.PackStart(img, false, false, 0)
 
 	txt := fmt.Sprintf("<b>%s</b>\n%s", bundle.Name, utils.Locale.Get(bundle.Desc))
+	if summary := bundle.Summary(); summary != "" {
+		txt = fmt.Sprintf("%s\n<small>%s</small>", txt, summary)
+	}
 	label, err := gtk.LabelNew(txt)
 	if err != nil {
 		return nil, err
@@ -206,18 +217,41 @@ func NewBundlePage(windowController Controller, model *model.SystemInstall) (Pag
 		model:            model,
 	}
 
-	// Load our bundles
-	bundle.bundles, err = swupd.LoadBundleList(model)
+	// main layout
+	bundle.box, err = gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
 	if err != nil {
 		return nil, err
 	}
+	bundle.box.SetBorderWidth(8)
 
-	// main layout
-	bundle.box, err = gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 0)
+	// search and category filters
+	filters, err := setBox(gtk.ORIENTATION_HORIZONTAL, 6, "box-page")
 	if err != nil {
 		return nil, err
 	}
-	bundle.box.SetBorderWidth(8)
+	bundle.box.PackStart(filters, false, false, 0)
+
+	bundle.searchEntry, err = setSearchEntry("search-entry")
+	if err != nil {
+		return nil, err
+	}
+	filters.PackStart(bundle.searchEntry, true, true, 0)
+	if _, err := bundle.searchEntry.Connect("search-changed", bundle.onFilterChange); err != nil {
+		return nil, err
+	}
+
+	bundle.category, err = gtk.ComboBoxTextNew()
+	if err != nil {
+		return nil, err
+	}
+	for _, curr := range swupd.BundleCategories {
+		bundle.category.Append(curr, utils.Locale.Get(curr))
+	}
+	bundle.category.SetActiveID(swupd.BundleCategoryAll)
+	filters.PackStart(bundle.category, false, false, 0)
+	if _, err := bundle.category.Connect("changed", bundle.onFilterChange); err != nil {
+		return nil, err
+	}
 
 	// check list
 	bundle.checks, err = gtk.FlowBoxNew()
@@ -234,15 +268,84 @@ func NewBundlePage(windowController Controller, model *model.SystemInstall) (Pag
 	bundle.scroll.Add(bundle.checks)
 	bundle.box.PackStart(bundle.scroll, true, true, 0)
 
-	// Match the bundle set to our ticks
-	for _, b := range bundle.bundles {
+	// selection note
+	bundle.note, err = common.SetLabel("", "label-info", 0.0)
+	if err != nil {
+		return nil, err
+	}
+	bundle.note.SetLineWrap(true)
+	bundle.box.PackStart(bundle.note, false, false, 0)
+
+	return bundle, nil
+}
+
+// loadBundles loads the bundle catalog in the background and creates the
+// bundle check boxes once it is available
+func (bundle *Bundle) loadBundles() {
+	if bundle.loading {
+		return
+	}
+
+	bundle.loading = true
+	bundle.note.SetText(utils.Locale.Get("Loading the bundle catalog..."))
+
+	go func() {
+		options := bundle.windowController.GetOptions()
+
+		bundles, err := swupd.LoadBundleList(bundle.model, options)
+
+		resolver, rerr := swupd.LoadResolver(bundle.model, options)
+		if err == nil && rerr != nil {
+			log.Warning("Could not resolve the bundle includes: %v", rerr)
+		}
+
+		_, ierr := glib.IdleAdd(func() {
+			bundle.loading = false
+
+			if err != nil {
+				log.Warning("Could not load the bundle list: %v", err)
+				bundle.note.SetText(utils.Locale.Get("Could not load the bundle list: %v", err))
+				return
+			}
+
+			bundle.resolver = resolver
+
+			if err := bundle.addBundles(bundles); err != nil {
+				log.ErrorError(err)
+				return
+			}
+
+			bundle.ResetChanges()
+		})
+		if ierr != nil {
+			log.ErrorError(ierr)
+		}
+	}()
+}
+
+// addBundles creates the check boxes of the bundles, the model selection is
+// applied before the signals are connected
+func (bundle *Bundle) addBundles(bundles []*swupd.Bundle) error {
+	for _, b := range bundles {
 		wid, err := createBundleWidget(b)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		wid.SetActive(bundle.model.ContainsUserBundle(b.Name))
 		bundle.checks.Add(wid)
 		bundle.selections = append(bundle.selections, wid)
 	}
+	bundle.bundles = bundles
+
+	for i := range bundle.selections {
+		b := bundle.bundles[i]
+		wid := bundle.selections[i]
+		if _, err := wid.Connect("toggled", func() {
+			bundle.updateNote(b, wid.GetActive())
+		}); err != nil {
+			return err
+		}
+	}
 
 	for i := range bundle.selections {
 		if !controller.NetworkPassing {
@@ -253,7 +356,7 @@ func NewBundlePage(windowController Controller, model *model.SystemInstall) (Pag
 					// clearPage set to true, we set to
 					// false so that it fire nexttime
 					// onwards
-					_, err := createDecisionBox(model, bundle)
+					_, err := createDecisionBox(bundle.model, bundle)
 					if err != nil {
 						return
 					}
@@ -263,12 +366,70 @@ func NewBundlePage(windowController Controller, model *model.SystemInstall) (Pag
 					bundle.clearPage = false
 				}
 			}); err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 
-	return bundle, nil
+	bundle.checks.ShowAll()
+
+	return nil
+}
+
+// updateNote explains the effect of the last checked or unchecked bundle, or
+// the bundles always installed if there is nothing to note; the includes may
+// have to be downloaded so the note is resolved in the background
+func (bundle *Bundle) updateNote(b *swupd.Bundle, checked bool) {
+	bundle.noteSeq++
+	seq := bundle.noteSeq
+
+	if bundle.resolver == nil || b == nil {
+		bundle.note.SetText(swupd.ImplicitBundlesNote(bundle.model))
+		return
+	}
+
+	selected := []string{}
+	for n, curr := range bundle.bundles {
+		if bundle.selections[n].GetActive() {
+			selected = append(selected, curr.Name)
+		}
+	}
+
+	go func() {
+		note := bundle.resolver.SelectionNote(bundle.model, b.Name, checked, selected)
+		if note == "" {
+			note = swupd.ImplicitBundlesNote(bundle.model)
+		}
+
+		_, err := glib.IdleAdd(func() {
+			// a later selection replaced this note
+			if seq == bundle.noteSeq {
+				bundle.note.SetText(note)
+			}
+		})
+		if err != nil {
+			log.ErrorError(err)
+		}
+	}()
+}
+
+// onFilterChange shows only the bundles matching the search text and category
+func (bundle *Bundle) onFilterChange() {
+	search := getTextFromSearchEntry(bundle.searchEntry)
+	category := bundle.category.GetActiveID()
+
+	for n, b := range bundle.bundles {
+		child := bundle.checks.GetChildAtIndex(n)
+		if child == nil {
+			continue
+		}
+
+		if b.Matches(search, category) {
+			child.Show()
+		} else {
+			child.Hide()
+		}
+	}
 }
 
 // IsDone checks if all the steps are completed
@@ -317,14 +478,34 @@ func (bundle *Bundle) StoreChanges() {
 			bundle.model.RemoveUserBundle(b.Name)
 		}
 	}
+
+	// the bundle manifests may have to be downloaded for the estimate
+	swupd.EstimateInBackground(bundle.model, bundle.windowController.GetOptions(),
+		func(estimate *swupd.InstallEstimate) {
+			_, err := glib.IdleAdd(func() {
+				swupd.SetEstimatedSize(bundle.model, estimate)
+			})
+			if err != nil {
+				log.ErrorError(err)
+			}
+		})
 }
 
 // ResetChanges will reset this page to match the model
 func (bundle *Bundle) ResetChanges() {
+	// the bundle catalog is loaded the first time the page is shown
+	if bundle.bundles == nil {
+		bundle.loadBundles()
+		bundle.windowController.SetButtonState(ButtonConfirm, controller.NetworkPassing)
+		return
+	}
+
 	// Match selection to what's in the model
 	for n, b := range bundle.bundles {
 		bundle.selections[n].SetActive(bundle.model.ContainsUserBundle(b.Name))
 	}
+	bundle.onFilterChange()
+	bundle.updateNote(nil, false)
 	bundle.windowController.SetButtonState(ButtonConfirm, controller.NetworkPassing)
 }
 
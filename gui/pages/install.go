@@ -30,6 +30,7 @@ type InstallPage struct {
 	layout     *gtk.Box
 
 	pbar      *gtk.ProgressBar    // Progress bar
+	overall   *gtk.ProgressBar    // Overall installation progress bar
 	list      *gtk.ListBox        // Scrolling list for messages
 	selection int                 // Current progress selection
 	scroll    *gtk.ScrolledWindow // Hold the list
@@ -108,6 +109,19 @@ func NewInstallPage(controller Controller, model *model.SystemInstall) (Page, er
 	// Throw it on the bottom of the page
 	page.layout.PackEnd(page.pbar, false, false, 0)
 
+	// Create the overall progress bar, shown above the task progress bar
+	page.overall, err = gtk.ProgressBarNew()
+	if err != nil {
+		return nil, err
+	}
+
+	page.overall.SetHAlign(gtk.ALIGN_FILL)
+	page.overall.SetMarginStart(24)
+	page.overall.SetMarginEnd(24)
+	page.overall.SetShowText(true)
+	page.overall.SetText(utils.Locale.Get("Overall progress: %s", progress.OverallString(0, 0)))
+	page.layout.PackEnd(page.overall, false, false, 0)
+
 	return page, nil
 }
 
@@ -187,6 +201,9 @@ func (page *InstallPage) ResetChanges() {
 		// Temporary handling of errors
 		if err != nil {
 			text := utils.Locale.Get("Installation failed.")
+			if hint := swupd.FailureHint(err); hint != "" {
+				text = text + " " + hint
+			}
 			text = text + " " + utils.Locale.Get("See %s for details.", page.controller.GetOptions().LogFile)
 			page.info.SetText(text)
 			sc, err := page.info.GetStyleContext()
@@ -297,6 +314,18 @@ func (page *InstallPage) Partial(total int, step int) {
 	}
 }
 
+// Overall shows the overall installation progress and the remaining time
+func (page *InstallPage) Overall(percent int, eta time.Duration) {
+	_, err := glib.IdleAdd(func() {
+		page.overall.SetFraction(float64(percent) / 100)
+		page.overall.SetText(utils.Locale.Get("Overall progress: %s", progress.OverallString(percent, eta)))
+	})
+	if err != nil {
+		log.ErrorError(err) // TODO: Handle error in a better way
+		return
+	}
+}
+
 // Step will step the progressbar in indeterminate mode
 func (page *InstallPage) Step() {
 	_, err := glib.IdleAdd(func() {
@@ -5,6 +5,7 @@
 package pages
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/gotk3/gotk3/gtk"
@@ -46,11 +47,28 @@ type UserAddPage struct {
 	adminCheck   *gtk.CheckButton
 	adminChanged bool
 
+	accountFields  []*accountField
+	uid            *gtk.Entry
+	gid            *gtk.Entry
+	groups         *gtk.Entry
+	shell          *gtk.Entry
+	home           *gtk.Entry
+	expire         *gtk.Entry
+	forceCheck     *gtk.CheckButton
+	accountChanged bool
+
 	justLoaded bool
 
 	addMode bool
 }
 
+// accountField is an optional account attribute entry
+type accountField struct {
+	entry    *gtk.Entry
+	warning  *gtk.Label
+	validate func(string) (bool, string)
+}
+
 // NewUserAddPage returns a new User Add page
 func NewUserAddPage(controller Controller, model *model.SystemInstall) (Page, error) {
 	page := &UserAddPage{
@@ -117,6 +135,60 @@ func NewUserAddPage(controller Controller, model *model.SystemInstall) (Page, er
 	page.adminCheck.SetSensitive(false) // MUST have an admin user
 	page.box.PackStart(page.adminCheck, false, false, 0)
 
+	// Account details
+	idRules := utils.Locale.Get("Optional, assigned automatically when empty.")
+	pathRules := utils.Locale.Get("Optional absolute path, the system default when empty.")
+
+	for _, curr := range []struct {
+		entry    **gtk.Entry
+		label    string
+		rules    string
+		maxSize  int
+		validate func(string) (bool, string)
+	}{
+		{&page.uid, utils.Locale.Get("UID"), idRules, 10, user.IsValidID},
+		{&page.gid, utils.Locale.Get("GID"), idRules, 10, user.IsValidID},
+		{&page.groups, utils.Locale.Get("Groups"),
+			utils.Locale.Get("Optional comma separated supplementary groups, created if missing."), 255,
+			user.IsValidGroups},
+		{&page.shell, utils.Locale.Get("Shell"), pathRules, 255, user.IsValidPath},
+		{&page.home, utils.Locale.Get("Home"), pathRules, 255, user.IsValidPath},
+		{&page.expire, utils.Locale.Get("Expires"),
+			utils.Locale.Get("Optional account expiry date as YYYY-MM-DD."), 10, user.IsValidExpire},
+	} {
+		field := &accountField{validate: curr.validate}
+		field.entry, field.warning, err = page.setSimilarWidgets(curr.label, curr.rules, curr.maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := field.entry.Connect("changed", page.onAccountChange); err != nil {
+			return nil, err
+		}
+
+		*curr.entry = field.entry
+		page.accountFields = append(page.accountFields, field)
+	}
+
+	page.forceCheck, err = gtk.CheckButtonNew()
+	if err != nil {
+		return nil, err
+	}
+	page.forceCheck.SetLabel("   " + utils.Locale.Get("Change password at first login"))
+	sc, err = page.forceCheck.GetStyleContext()
+	if err != nil {
+		log.Warning("Error getting style context: ", err) // Just log trivial error
+	} else {
+		sc.AddClass("label-entry")
+	}
+	page.forceCheck.SetMarginStart(CommonSetting + common.StartEndMargin)
+	page.forceCheck.SetMarginEnd(common.StartEndMargin)
+	page.box.PackStart(page.forceCheck, false, false, 0)
+
+	if _, err := page.forceCheck.Connect("clicked", page.onAccountChange); err != nil {
+		return nil, err
+	}
+
 	// Generate signal on Name change
 	if _, err := page.name.Connect("changed", page.onNameChange); err != nil {
 		return nil, err
@@ -235,6 +307,39 @@ func (page *UserAddPage) onAdminClick(button *gtk.CheckButton) {
 	page.setConfirmButton()
 }
 
+// storeAccount copies the account details into the user
+func (page *UserAddPage) storeAccount(u *user.User) {
+	uid, _ := strconv.ParseUint(getTextFromEntry(page.uid), 10, 32)
+	u.UID = uint(uid)
+	gid, _ := strconv.ParseUint(getTextFromEntry(page.gid), 10, 32)
+	u.GID = uint(gid)
+	u.Groups = user.ParseGroups(getTextFromEntry(page.groups))
+	u.Shell = getTextFromEntry(page.shell)
+	u.Home = getTextFromEntry(page.home)
+	u.Expire = getTextFromEntry(page.expire)
+	u.ForcePasswordChange = page.forceCheck.GetActive()
+}
+
+func (page *UserAddPage) onAccountChange() {
+	for _, curr := range page.accountFields {
+		if ok, msg := curr.validate(getTextFromEntry(curr.entry)); !ok {
+			curr.warning.SetText(msg)
+		} else {
+			curr.warning.SetText("")
+		}
+	}
+
+	account := &user.User{}
+	page.storeAccount(account)
+
+	page.accountChanged = account.UID != page.user.UID || account.GID != page.user.GID ||
+		strings.Join(account.Groups, ",") != strings.Join(page.user.Groups, ",") ||
+		account.Shell != page.user.Shell || account.Home != page.user.Home ||
+		account.Expire != page.user.Expire || account.ForcePasswordChange != page.user.ForcePasswordChange
+
+	page.setConfirmButton()
+}
+
 // IsRequired will return false as we have default values
 func (page *UserAddPage) IsRequired() bool {
 	return true
@@ -280,6 +385,7 @@ func (page *UserAddPage) StoreChanges() {
 			Login:    getTextFromEntry(page.login),
 			Admin:    page.adminCheck.GetActive(),
 		}
+		page.storeAccount(newUser)
 
 		page.model.AddUser(newUser)
 	} else {
@@ -291,6 +397,7 @@ func (page *UserAddPage) StoreChanges() {
 		page.model.Users[0].UserName = getTextFromEntry(page.name)
 		page.model.Users[0].Login = getTextFromEntry(page.login)
 		page.model.Users[0].Admin = page.adminCheck.GetActive()
+		page.storeAccount(page.model.Users[0])
 	}
 
 	log.Debug("page.model.Users[0]: %+v", page.model.Users[0]) // RemoveMe
@@ -328,6 +435,13 @@ func (page *UserAddPage) ResetChanges() {
 
 	setTextInEntry(page.name, page.user.UserName)
 	setTextInEntry(page.login, page.user.Login)
+	setTextInEntry(page.uid, formatID(page.user.UID))
+	setTextInEntry(page.gid, formatID(page.user.GID))
+	setTextInEntry(page.groups, strings.Join(page.user.Groups, ","))
+	setTextInEntry(page.shell, page.user.Shell)
+	setTextInEntry(page.home, page.user.Home)
+	setTextInEntry(page.expire, page.user.Expire)
+	page.forceCheck.SetActive(page.user.ForcePasswordChange)
 
 	if page.addMode {
 		log.Debug("Starting in addMode")
@@ -372,7 +486,13 @@ func (page *UserAddPage) GetConfiguredValue() string {
 func (page *UserAddPage) setConfirmButton() {
 	page.controller.SetButtonState(ButtonConfirm, false)
 
-	if page.nameChanged || page.loginChanged || page.passwordChanged || page.adminChanged {
+	if page.nameChanged || page.loginChanged || page.passwordChanged || page.adminChanged || page.accountChanged {
+		for _, curr := range page.accountFields {
+			if warning, _ := curr.warning.GetText(); warning != "" {
+				return
+			}
+		}
+
 		userWarning, _ := page.nameWarning.GetText()
 		loginWarning, _ := page.loginWarning.GetText()
 		passwordWarning, _ := page.passwordWarning.GetText()
@@ -393,15 +513,29 @@ func (page *UserAddPage) clearForm() {
 	setTextInEntry(page.password, "")
 	setTextInEntry(page.passwordConfirm, "")
 	page.adminCheck.SetActive(true)
+	for _, curr := range page.accountFields {
+		setTextInEntry(curr.entry, "")
+	}
+	page.forceCheck.SetActive(false)
 
 	page.nameChanged = false
 	page.loginChanged = false
 	page.passwordChanged = false
 	page.fakePassword = false
 	page.adminChanged = false
+	page.accountChanged = false
 	page.addMode = false
 }
 
+// formatID returns the text of a uid or gid, empty when unset
+func formatID(id uint) string {
+	if id == 0 {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(id), 10)
+}
+
 func setLabelAndEntry(entryText string, maxSize int) (*gtk.Box, *gtk.Entry, error) {
 	// Box
 	boxEntry, err := setBox(gtk.ORIENTATION_HORIZONTAL, 0, "")
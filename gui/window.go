@@ -654,6 +654,11 @@ func (window *Window) ActivatePage(page pages.Page) {
 		window.buttons.confirm.SetLabel(utils.Locale.Get("YES"))
 		window.buttons.cancel.SetLabel(utils.Locale.Get("NO"))
 	case pages.PageIDNetwork:
+		// Offers to connect to a Wi-Fi network first if there is a Wi-Fi device
+		if _, err := network.RunWirelessDialog(window.model); err != nil {
+			log.Warning("Error running Wi-Fi dialog: ", err)
+		}
+
 		// Launches network check pop-up without changing page
 		if _, err := network.RunNetworkTest(window.model); err != nil {
 			log.Warning("Error running network test: ", err)
@@ -914,6 +919,9 @@ func (window *Window) confirmInstall() {
 	dryRunResults := storage.GetPlannedMediaChanges(window.model.InstallSelected, window.model.TargetMedias,
 		window.model.MediaOpts)
 
+	*dryRunResults.TargetResults = append(*dryRunResults.TargetResults,
+		swupd.EstimateSummary(window.model, window.options)...)
+
 	writeToConfirmInstallDialog(buffer, dryRunResults)
 
 	if err = setConfirmButtonState(dialog, window); err != nil {
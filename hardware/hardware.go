@@ -0,0 +1,309 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hardware
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/telemetry"
+)
+
+const (
+	// BareMetal is the hypervisor value when not running in a virtual machine
+	BareMetal = "none"
+)
+
+var (
+	dmiDir      = "/sys/class/dmi/id"
+	cpuInfoFile = "/proc/cpuinfo"
+	memInfoFile = "/proc/meminfo"
+	sysBlockDir = "/sys/block"
+
+	// virtual block devices which are never install targets
+	ignoredDiskPrefixes = []string{"loop", "ram", "zram", "sr", "fd", "dm-", "md", "nbd"}
+)
+
+// Facts describes the detected hardware of the running system
+type Facts struct {
+	DMIVendor  string
+	DMIProduct string
+	CPUFlags   []string
+	Memory     uint64
+	DiskSizes  []uint64
+	MACs       []string
+	Hypervisor string
+}
+
+// Criteria is the set of conditions the Facts have to satisfy, empty
+// conditions are ignored and all given conditions must be met
+type Criteria struct {
+	DMIVendor   string   `yaml:"dmiVendor,omitempty,flow"`
+	DMIProduct  string   `yaml:"dmiProduct,omitempty,flow"`
+	CPUFlags    []string `yaml:"cpuFlags,omitempty,flow"`
+	MinMemory   string   `yaml:"minMemory,omitempty,flow"`
+	MaxMemory   string   `yaml:"maxMemory,omitempty,flow"`
+	MinDisks    int      `yaml:"minDisks,omitempty,flow"`
+	MaxDisks    int      `yaml:"maxDisks,omitempty,flow"`
+	MinDiskSize string   `yaml:"minDiskSize,omitempty,flow"`
+	MACs        []string `yaml:"macAddress,omitempty,flow"`
+	Hypervisor  string   `yaml:"hypervisor,omitempty,flow"`
+}
+
+func readSysValue(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Debug("Could not read %s: %v", path, err)
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+func detectCPUFlags() ([]string, error) {
+	fp, err := os.Open(cpuInfoFile)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		tks := strings.SplitN(scanner.Text(), ":", 2)
+		if len(tks) == 2 && strings.TrimSpace(tks[0]) == "flags" {
+			return strings.Fields(tks[1]), nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return []string{}, nil
+}
+
+func detectMemory() (uint64, error) {
+	fp, err := os.Open(memInfoFile)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		return kb * 1024, nil
+	}
+
+	return 0, errors.Errorf("MemTotal not found in %s", memInfoFile)
+}
+
+func detectDiskSizes() ([]uint64, error) {
+	entries, err := ioutil.ReadDir(sysBlockDir)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	sizes := []uint64{}
+	for _, curr := range entries {
+		ignored := false
+		for _, prefix := range ignoredDiskPrefixes {
+			ignored = ignored || strings.HasPrefix(curr.Name(), prefix)
+		}
+
+		if ignored {
+			continue
+		}
+
+		// sysfs always reports the size in 512 bytes sectors
+		sectors, err := strconv.ParseUint(readSysValue(filepath.Join(sysBlockDir, curr.Name(), "size")), 10, 64)
+		if err != nil || sectors == 0 {
+			continue
+		}
+
+		sizes = append(sizes, sectors*512)
+	}
+
+	return sizes, nil
+}
+
+func detectMACs() []string {
+	macs := []string{}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Warning("Could not list network interfaces: %v", err)
+		return macs
+	}
+
+	for _, curr := range ifaces {
+		if curr.Flags&net.FlagLoopback != 0 || len(curr.HardwareAddr) == 0 {
+			continue
+		}
+
+		macs = append(macs, strings.ToLower(curr.HardwareAddr.String()))
+	}
+
+	return macs
+}
+
+// Detect collects the facts of the running system
+func Detect() (*Facts, error) {
+	var err error
+
+	facts := &Facts{
+		DMIVendor:  readSysValue(filepath.Join(dmiDir, "sys_vendor")),
+		DMIProduct: readSysValue(filepath.Join(dmiDir, "product_name")),
+		MACs:       detectMACs(),
+		Hypervisor: (&telemetry.Telemetry{}).RunningEnvironment(),
+	}
+
+	if facts.CPUFlags, err = detectCPUFlags(); err != nil {
+		return nil, err
+	}
+
+	if facts.Memory, err = detectMemory(); err != nil {
+		return nil, err
+	}
+
+	if facts.DiskSizes, err = detectDiskSizes(); err != nil {
+		return nil, err
+	}
+
+	log.Debug("Detected hardware facts: %+v", *facts)
+
+	return facts, nil
+}
+
+// globMatch matches value against a shell pattern, case insensitive
+func globMatch(pattern string, value string) (bool, error) {
+	ok, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(value))
+	if err != nil {
+		return false, errors.Errorf("Invalid match pattern %q: %v", pattern, err)
+	}
+
+	return ok, nil
+}
+
+func parseSize(field string, value string) (uint64, error) {
+	size, err := storage.ParseVolumeSize(value)
+	if err != nil {
+		return 0, errors.Errorf("Invalid %s %q: %v", field, value, err)
+	}
+
+	return size, nil
+}
+
+// Validate checks the criteria are well formed
+func (c *Criteria) Validate() error {
+	// matching against a system with a single unnamed interface exercises every field
+	_, err := c.Matches(&Facts{MACs: []string{""}})
+	return err
+}
+
+// Matches returns true if the facts satisfy all of the criteria
+func (c *Criteria) Matches(f *Facts) (bool, error) {
+	result := true
+
+	globs := []struct{ pattern, value string }{
+		{c.DMIVendor, f.DMIVendor},
+		{c.DMIProduct, f.DMIProduct},
+		{c.Hypervisor, f.Hypervisor},
+	}
+
+	for _, curr := range globs {
+		if curr.pattern == "" {
+			continue
+		}
+
+		ok, err := globMatch(curr.pattern, curr.value)
+		if err != nil {
+			return false, err
+		}
+		result = result && ok
+	}
+
+	for _, flag := range c.CPUFlags {
+		found := false
+		for _, curr := range f.CPUFlags {
+			found = found || curr == flag
+		}
+		result = result && found
+	}
+
+	if c.MinMemory != "" {
+		min, err := parseSize("minMemory", c.MinMemory)
+		if err != nil {
+			return false, err
+		}
+		result = result && f.Memory >= min
+	}
+
+	if c.MaxMemory != "" {
+		max, err := parseSize("maxMemory", c.MaxMemory)
+		if err != nil {
+			return false, err
+		}
+		result = result && f.Memory <= max
+	}
+
+	if c.MinDisks > 0 {
+		result = result && len(f.DiskSizes) >= c.MinDisks
+	}
+
+	if c.MaxDisks > 0 {
+		result = result && len(f.DiskSizes) <= c.MaxDisks
+	}
+
+	if c.MinDiskSize != "" {
+		min, err := parseSize("minDiskSize", c.MinDiskSize)
+		if err != nil {
+			return false, err
+		}
+
+		found := false
+		for _, curr := range f.DiskSizes {
+			found = found || curr >= min
+		}
+		result = result && found
+	}
+
+	if len(c.MACs) > 0 {
+		found := false
+		for _, pattern := range c.MACs {
+			for _, curr := range f.MACs {
+				ok, err := globMatch(pattern, curr)
+				if err != nil {
+					return false, err
+				}
+				found = found || ok
+			}
+		}
+		result = result && found
+	}
+
+	return result, nil
+}
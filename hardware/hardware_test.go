@@ -0,0 +1,119 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package hardware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFixture(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir for %s: %v", path, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-hardware-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	saveDmi, saveCPU, saveMem, saveBlock := dmiDir, cpuInfoFile, memInfoFile, sysBlockDir
+	defer func() {
+		dmiDir, cpuInfoFile, memInfoFile, sysBlockDir = saveDmi, saveCPU, saveMem, saveBlock
+	}()
+
+	dmiDir = filepath.Join(dir, "dmi")
+	cpuInfoFile = filepath.Join(dir, "cpuinfo")
+	memInfoFile = filepath.Join(dir, "meminfo")
+	sysBlockDir = filepath.Join(dir, "block")
+
+	writeFixture(t, filepath.Join(dmiDir, "sys_vendor"), "Intel Corporation\n")
+	writeFixture(t, filepath.Join(dmiDir, "product_name"), "NUC8i5BEH\n")
+	writeFixture(t, cpuInfoFile, "processor\t: 0\nflags\t\t: fpu lm sse4_2 avx2\n")
+	writeFixture(t, memInfoFile, "MemTotal:       16318148 kB\nMemFree:         1000 kB\n")
+	writeFixture(t, filepath.Join(sysBlockDir, "sda", "size"), "2097152\n")
+	writeFixture(t, filepath.Join(sysBlockDir, "loop0", "size"), "2048\n")
+
+	facts, err := Detect()
+	if err != nil {
+		t.Fatalf("Detect shouldn't return an error: %v", err)
+	}
+
+	if facts.DMIVendor != "Intel Corporation" || facts.DMIProduct != "NUC8i5BEH" {
+		t.Fatalf("Unexpected DMI facts: %+v", facts)
+	}
+
+	if len(facts.CPUFlags) != 4 || facts.Memory != 16318148*1024 {
+		t.Fatalf("Unexpected CPU or memory facts: %+v", facts)
+	}
+
+	if len(facts.DiskSizes) != 1 || facts.DiskSizes[0] != 1024*1024*1024 {
+		t.Fatalf("Expected a single 1GiB disk: %v", facts.DiskSizes)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	facts := &Facts{
+		DMIVendor:  "Dell Inc.",
+		DMIProduct: "PowerEdge R740",
+		CPUFlags:   []string{"lm", "avx2", "avx512f"},
+		Memory:     64 * 1024 * 1024 * 1024,
+		DiskSizes:  []uint64{480 * 1000 * 1000 * 1000, 4 * 1000 * 1000 * 1000 * 1000},
+		MACs:       []string{"3c:fd:fe:00:11:22"},
+		Hypervisor: BareMetal,
+	}
+
+	tests := []struct {
+		criteria Criteria
+		match    bool
+	}{
+		{Criteria{}, true},
+		{Criteria{DMIVendor: "dell*", DMIProduct: "PowerEdge*"}, true},
+		{Criteria{DMIVendor: "Lenovo*"}, false},
+		{Criteria{CPUFlags: []string{"avx512f", "lm"}}, true},
+		{Criteria{CPUFlags: []string{"sgx"}}, false},
+		{Criteria{MinMemory: "32G", MaxMemory: "128G"}, true},
+		{Criteria{MinMemory: "128G"}, false},
+		{Criteria{MinDisks: 2, MinDiskSize: "2T"}, true},
+		{Criteria{MaxDisks: 1}, false},
+		{Criteria{MACs: []string{"aa:*", "3C:FD:FE:*"}}, true},
+		{Criteria{Hypervisor: "kvm"}, false},
+		{Criteria{Hypervisor: BareMetal}, true},
+	}
+
+	for _, curr := range tests {
+		ok, err := curr.criteria.Matches(facts)
+		if err != nil {
+			t.Fatalf("%+v shouldn't return an error: %v", curr.criteria, err)
+		}
+
+		if ok != curr.match {
+			t.Fatalf("%+v expected match %v, got %v", curr.criteria, curr.match, ok)
+		}
+	}
+}
+
+func TestInvalidCriteria(t *testing.T) {
+	invalid := []Criteria{
+		{MinMemory: "lots"},
+		{DMIVendor: "[Dell"},
+		{MACs: []string{"[00"}},
+	}
+
+	for _, curr := range invalid {
+		if err := curr.Validate(); err == nil {
+			t.Fatalf("%+v should fail validation", curr)
+		}
+	}
+}
@@ -29,6 +29,8 @@ type MassInstall struct {
 	prgDesc  string
 	prgIndex int
 	step     int
+	overall  string
+	decile   int
 }
 
 // New creates a new instance of MassInstall frontend implementation
@@ -58,7 +60,7 @@ func (mi *MassInstall) Step() {
 
 	elms := []string{"|", "-", "\\", "|", "/", "-", "\\"}
 
-	fmt.Printf("%s [%s]\r", mi.prgDesc, elms[mi.prgIndex])
+	fmt.Printf("%s%s [%s]\r", mi.overall, mi.prgDesc, elms[mi.prgIndex])
 
 	if mi.prgIndex+1 == len(elms) {
 		mi.prgIndex = 0
@@ -86,7 +88,7 @@ func (mi *MassInstall) Partial(total int, step int) {
 		return
 	}
 
-	line := fmt.Sprintf("%s %.0f%%\r", mi.prgDesc, (float64(step)/float64(total))*100)
+	line := fmt.Sprintf("%s%s %.0f%%\r", mi.overall, mi.prgDesc, (float64(step)/float64(total))*100)
 	fmt.Printf("%s", line)
 }
 
@@ -114,6 +116,21 @@ func (mi *MassInstall) Failure() {
 	fmt.Printf("%s [*failed*]\n", mi.prgDesc)
 }
 
+// Overall is part of the progress.Client implementation, the overall progress
+// prefixes the status line of the current task; when the output is not a
+// terminal it is printed at every 10%
+func (mi *MassInstall) Overall(percent int, eta time.Duration) {
+	if !utils.IsStdoutTTY() {
+		if percent/10 != mi.decile {
+			mi.decile = percent / 10
+			fmt.Printf("Overall progress: %s\n", progress.OverallString(percent, eta))
+		}
+		return
+	}
+
+	mi.overall = fmt.Sprintf("[%s] ", progress.OverallString(percent, eta))
+}
+
 // MustRun is part of the Frontend implementation and tells the core implementation that this
 // frontend wants or should be executed
 func (mi *MassInstall) MustRun(args *args.Args) bool {
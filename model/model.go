@@ -22,9 +22,11 @@ import (
 	"github.com/clearlinux/clr-installer/keyboard"
 	"github.com/clearlinux/clr-installer/language"
 	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/storage"
 	"github.com/clearlinux/clr-installer/telemetry"
 	"github.com/clearlinux/clr-installer/timezone"
+	"github.com/clearlinux/clr-installer/updates"
 	"github.com/clearlinux/clr-installer/user"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -53,6 +55,8 @@ type SystemInstall struct {
 	InstallSelected   map[string]storage.InstallTarget `yaml:"-"`
 	TargetMedias      []*storage.BlockDevice           `yaml:"targetMedia"`
 	NetworkInterfaces []*network.Interface             `yaml:"networkInterfaces,omitempty,flow"`
+	Resolved          *network.Resolved                `yaml:"resolved,omitempty"`
+	Connectivity      *network.Connectivity            `yaml:"connectivity,omitempty"`
 	Keyboard          *keyboard.Keymap                 `yaml:"keyboard,omitempty,flow"`
 	Language          *language.Language               `yaml:"language,omitempty,flow"`
 	Bundles           []string                         `yaml:"bundles,omitempty,flow"`
@@ -60,9 +64,11 @@ type SystemInstall struct {
 	UserBundles       []string                         `yaml:"userBundles,omitempty,flow"`
 	Offline           bool                             `yaml:"offline,omitempty,flow"`
 	HTTPSProxy        string                           `yaml:"httpsProxy,omitempty,flow"`
+	Proxy             *proxy.Config                    `yaml:"proxy,omitempty"`
 	Telemetry         *telemetry.Telemetry             `yaml:"telemetry,omitempty,flow"`
 	Timezone          *timezone.TimeZone               `yaml:"timezone,omitempty,flow"`
 	Users             []*user.User                     `yaml:"users,omitempty,flow"`
+	Sudo              []*user.SudoPolicy               `yaml:"sudo,omitempty"`
 	KernelArguments   *kernel.Arguments                `yaml:"kernel-arguments,omitempty,flow"`
 	Kernel            *kernel.Kernel                   `yaml:"kernel,omitempty,flow"`
 	PostReboot        bool                             `yaml:"postReboot,omitempty,flow"`
@@ -72,6 +78,7 @@ type SystemInstall struct {
 	PostArchive       *boolset.BoolSet                 `yaml:"postArchive,omitempty,flow"`
 	Hostname          string                           `yaml:"hostname,omitempty,flow"`
 	AutoUpdate        *boolset.BoolSet                 `yaml:"autoUpdate,flow"`
+	Updates           *updates.Policy                  `yaml:"updates,omitempty"`
 	TelemetryURL      string                           `yaml:"telemetryURL,omitempty,flow"`
 	TelemetryTID      string                           `yaml:"telemetryTID,omitempty,flow"`
 	TelemetryPolicy   string                           `yaml:"telemetryPolicy,omitempty,flow"`
@@ -83,8 +90,11 @@ type SystemInstall struct {
 	StorageAlias      []*StorageAlias                  `yaml:"block-devices,omitempty,flow"`
 	CopyNetwork       bool                             `yaml:"copyNetwork,omitempty,flow"`
 	CopySwupd         bool                             `yaml:"copySwupd,omitempty,flow"`
+	CloudInitSeed     bool                             `yaml:"cloudInitSeed,omitempty,flow"`
 	Environment       map[string]string                `yaml:"env,omitempty,flow"`
 	CryptPass         string                           `yaml:"-"`
+	BundleResolver    BundleResolver                   `yaml:"-"`
+	HostBundles       []string                         `yaml:"-"`
 	MakeISO           bool                             `yaml:"iso,omitempty,flow"`
 	ISOPublisher      string                           `yaml:"isoPublisher,omitempty,flow"`
 	ISOApplicationID  string                           `yaml:"isoApplicationId,omitempty,flow"`
@@ -92,7 +102,10 @@ type SystemInstall struct {
 	LockFile          string                           `yaml:"-"`
 	ClearCfFile       string                           `yaml:"-"`
 	PreCheckDone      bool                             `yaml:"preCheckDone,omitempty,flow"`
+	Match             []*Match                         `yaml:"match,omitempty"`
+	ThirdParty        []*ThirdPartyRepo                `yaml:"thirdParty,omitempty"`
 	MediaOpts         storage.MediaOpts                `yaml:",inline"`
+	matchOverrides    []matchOverride
 }
 
 // SystemUsage is used to include additional information into the telemetry payload
@@ -296,6 +309,158 @@ func (si *SystemInstall) OverrideBundles(overrideBundles []string) {
 	}
 }
 
+// ImplicitBundle is a bundle added to the installation due to other
+// configuration settings
+type ImplicitBundle struct {
+	Name   string
+	Reason string
+}
+
+// ImplicitBundles returns the bundles the installer adds based on the
+// configuration; bundles depending on the running system are not included
+func (si *SystemInstall) ImplicitBundles() []*ImplicitBundle {
+	result := []*ImplicitBundle{}
+
+	for _, curr := range si.UserBundles {
+		result = append(result, &ImplicitBundle{Name: curr, Reason: "user selection"})
+	}
+
+	if si.Telemetry != nil && si.Telemetry.Enabled {
+		result = append(result, &ImplicitBundle{Name: telemetry.RequiredBundle, Reason: "telemetry is enabled"})
+	}
+
+	if len(si.Users) > 0 {
+		result = append(result, &ImplicitBundle{Name: user.RequiredBundle, Reason: "non-root users are defined"})
+	} else if len(si.Sudo) > 0 {
+		result = append(result, &ImplicitBundle{Name: user.RequiredBundle, Reason: "sudo policies are defined"})
+	}
+
+	if si.CloudInitSeed {
+		result = append(result, &ImplicitBundle{Name: CloudInitRequiredBundle,
+			Reason: "a cloud-init NoCloud seed is written"})
+	}
+
+	if si.Timezone != nil && si.Timezone.Code != timezone.DefaultTimezone {
+		result = append(result, &ImplicitBundle{Name: timezone.RequiredBundle,
+			Reason: fmt.Sprintf("non-default timezone '%s'", si.Timezone.Code)})
+	}
+
+	if si.Keyboard != nil && si.Keyboard.Code != keyboard.DefaultKeyboard {
+		result = append(result, &ImplicitBundle{Name: keyboard.RequiredBundle,
+			Reason: fmt.Sprintf("non-default keyboard '%s'", si.Keyboard.Code)})
+	}
+
+	if si.Language != nil && si.Language.Code != language.DefaultLanguage {
+		result = append(result, &ImplicitBundle{Name: language.RequiredBundle,
+			Reason: fmt.Sprintf("non-default language '%s'", si.Language.Code)})
+	}
+
+	for _, curr := range si.NetworkInterfaces {
+		if curr.IsWireless() {
+			result = append(result, &ImplicitBundle{Name: network.RequiredBundle,
+				Reason: "Wi-Fi interfaces are defined"})
+			break
+		}
+	}
+
+	encrypted, raid, lvmRoot, lvmOther := false, false, false, false
+	for _, curr := range si.TargetMedias {
+		raid = raid || curr.UsesRaid()
+
+		for _, ch := range curr.FindAllChildren() {
+			encrypted = encrypted || ch.Type == storage.BlockDeviceTypeCrypt
+
+			if ch.Type == storage.BlockDeviceTypeLVM2Volume {
+				lvmRoot = lvmRoot || ch.MountPoint == "/"
+				lvmOther = lvmOther || ch.MountPoint != "/"
+			}
+		}
+	}
+
+	if encrypted {
+		result = append(result, &ImplicitBundle{Name: storage.RequiredBundle, Reason: "encrypted partitions"})
+	} else if raid {
+		result = append(result, &ImplicitBundle{Name: storage.RequiredBundle, Reason: "software RAID"})
+	} else if lvmRoot {
+		result = append(result, &ImplicitBundle{Name: storage.RequiredBundle, Reason: "LVM root partition"})
+	}
+
+	if lvmOther {
+		result = append(result, &ImplicitBundle{Name: storage.RequiredBundleLVM, Reason: "LVM partitions"})
+	}
+
+	return result
+}
+
+// BundleResolver checks bundle names against the bundles available for
+// the installation
+type BundleResolver interface {
+	Contains(name string) bool
+	Suggest(name string) string
+}
+
+// validateUsers checks the user accounts and the sudo policies, an explicit uid
+// may only be used once
+func (si *SystemInstall) validateUsers() error {
+	uids := map[uint]string{}
+
+	for _, curr := range si.Users {
+		if err := curr.Validate(); err != nil {
+			return err
+		}
+
+		if curr.UID == 0 {
+			continue
+		}
+
+		if login, ok := uids[curr.UID]; ok {
+			return errors.ValidationErrorf("Users %s and %s share the uid %d", login, curr.Login, curr.UID)
+		}
+		uids[curr.UID] = curr.Login
+	}
+
+	subjects := map[string]bool{}
+
+	for _, curr := range si.Sudo {
+		if err := curr.Validate(); err != nil {
+			return err
+		}
+
+		// each user and group has a single drop-in
+		subject := curr.User + ":" + curr.Group
+		if subjects[subject] {
+			return errors.ValidationErrorf("Duplicated sudo policy for %s%s", curr.User, curr.Group)
+		}
+		subjects[subject] = true
+	}
+
+	return nil
+}
+
+// validateBundleNames checks every configured bundle exists
+func (si *SystemInstall) validateBundleNames() error {
+	names := append([]string{}, si.Bundles...)
+	names = append(names, si.UserBundles...)
+
+	if si.Kernel != nil && si.Kernel.Bundle != "" && si.Kernel.Bundle != "none" {
+		names = append(names, si.Kernel.Bundle)
+	}
+
+	for _, curr := range names {
+		if si.BundleResolver.Contains(curr) {
+			continue
+		}
+
+		if suggestion := si.BundleResolver.Suggest(curr); suggestion != "" {
+			return errors.ValidationErrorf("Unknown bundle '%s', did you mean '%s'?", curr, suggestion)
+		}
+
+		return errors.ValidationErrorf("Unknown bundle '%s'", curr)
+	}
+
+	return nil
+}
+
 // IsTargetDesktopInstall determines if this installation is a Desktop
 // installation by check all bundle lists for any desktop bundles.
 func (si *SystemInstall) IsTargetDesktopInstall() bool {
@@ -399,6 +564,56 @@ func (si *SystemInstall) Validate() error {
 		return errors.ValidationErrorf("isoApplicationId must be shorter than 128 characters")
 	}
 
+	for _, iface := range si.NetworkInterfaces {
+		if err := iface.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := network.ValidateTopology(si.NetworkInterfaces); err != nil {
+		return err
+	}
+
+	if si.Resolved != nil {
+		if err := si.Resolved.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := si.validateUsers(); err != nil {
+		return err
+	}
+
+	if si.Proxy != nil {
+		if err := si.Proxy.Validate(); err != nil {
+			return err
+		}
+
+		if si.Proxy.HTTPS != "" && si.HTTPSProxy != "" {
+			return errors.ValidationErrorf("httpsProxy and proxy.https are mutually exclusive")
+		}
+	}
+
+	if si.Connectivity != nil {
+		if err := si.Connectivity.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := si.validateThirdParty(); err != nil {
+		return err
+	}
+
+	if si.Updates != nil {
+		if err := si.Updates.Validate(si.AutoUpdate.Value()); err != nil {
+			return err
+		}
+	}
+
+	if si.BundleResolver != nil {
+		return si.validateBundleNames()
+	}
+
 	return nil
 }
 
@@ -445,6 +660,17 @@ func LoadFile(path string, options args.Args) (*SystemInstall, error) {
 		}
 	}
 
+	if len(result.Match) > 0 && !options.NoMatch {
+		facts, err := detectHardwareFacts()
+		if err != nil {
+			return nil, err
+		}
+
+		if err = result.ApplyMatches(facts); err != nil {
+			return nil, err
+		}
+	}
+
 	result.InitializeDefaults()
 
 	// Set default Timezone if not defined
@@ -586,20 +812,36 @@ func (si *SystemInstall) IsTelemetryInstalled() bool {
 	return si.Telemetry.Installed("")
 }
 
-// WriteFile writes a yaml formatted representation of si into the provided file path
+// WriteFile writes a yaml formatted representation of si into the provided file path,
+// the Wi-Fi secrets are removed
 func (si *SystemInstall) WriteFile(path string) error {
+	return si.writeFile(path, false)
+}
+
+// writeFile writes si into the provided file path, keepSecrets is only set for
+// the private files reloaded by the installer itself
+func (si *SystemInstall) writeFile(path string, keepSecrets bool) error {
 	// Sanitized the model to item which should never be written
 	var copyModel SystemInstall
 
-	// Marshal current into bytes
-	confBytes, bytesErr := yaml.Marshal(si)
+	// Marshal current into bytes, without the hardware match overlays
+	conf, err := toMapSlice(si)
+	if err != nil {
+		return err
+	}
+
+	if conf, err = si.revertMatchOverrides(conf); err != nil {
+		return err
+	}
+
+	confBytes, bytesErr := yaml.Marshal(conf)
 	if bytesErr != nil {
 		return errors.Wrap(bytesErr)
 	}
 
 	// Unmarshal into a copy
 	if yamlErr := yaml.UnmarshalStrict(confBytes, &copyModel); yamlErr != nil {
-		return errors.Wrap(bytesErr)
+		return errors.Wrap(yamlErr)
 	}
 
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
@@ -618,6 +860,13 @@ func (si *SystemInstall) WriteFile(path string) error {
 	copyModel.MediaOpts.SkipValidationAll = false
 	copyModel.MediaOpts.SkipValidationSize = false
 
+	// The Wi-Fi secrets are never stored, they end up in world readable files
+	for _, curr := range copyModel.NetworkInterfaces {
+		if curr.Wireless != nil && !keepSecrets {
+			curr.Wireless.RemoveSecrets()
+		}
+	}
+
 	b, err := yaml.Marshal(copyModel)
 	if err != nil {
 		return err
@@ -679,7 +928,8 @@ func (si *SystemInstall) WriteScrubModelTargetMedias() (string, error) {
 		return "", errors.Errorf("Could not make YAML tempfile: %v", err)
 	}
 
-	if saveErr := cleanModel.WriteFile(tmpYaml.Name()); saveErr != nil {
+	// The temporary file is only readable by the owner and reloaded to install
+	if saveErr := cleanModel.writeFile(tmpYaml.Name(), true); saveErr != nil {
 		return "", errors.Errorf("Could not save config to %s", tmpYaml.Name())
 	}
 
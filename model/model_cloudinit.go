@@ -0,0 +1,461 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package model
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/encrypt"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/kernel"
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/telemetry"
+	"github.com/clearlinux/clr-installer/timezone"
+	"github.com/clearlinux/clr-installer/user"
+)
+
+const (
+	// CloudConfigHeader is the first line of a cloud-init user-data config
+	CloudConfigHeader = "#cloud-config"
+
+	// CloudInitSeedDir is the NoCloud seed directory relative to the target root
+	CloudInitSeedDir = "/var/lib/cloud/seed/nocloud"
+
+	// CloudInitRequiredBundle provides the first boot agent reading the NoCloud seed
+	CloudInitRequiredBundle = "os-cloudguest"
+)
+
+// cloudConfig represents the subset of the cloud-init user-data we understand
+type cloudConfig struct {
+	Hostname          string                 `yaml:"hostname"`
+	FQDN              string                 `yaml:"fqdn"`
+	Timezone          string                 `yaml:"timezone"`
+	Locale            string                 `yaml:"locale"`
+	Keyboard          *cloudKeyboard         `yaml:"keyboard"`
+	Users             []interface{}          `yaml:"users"`
+	SSHAuthorizedKeys []string               `yaml:"ssh_authorized_keys"`
+	WriteFiles        []*cloudWriteFile      `yaml:"write_files"`
+	RunCmd            []interface{}          `yaml:"runcmd"`
+	PowerState        map[string]interface{} `yaml:"power_state"`
+}
+
+type cloudKeyboard struct {
+	Layout string `yaml:"layout"`
+}
+
+type cloudUser struct {
+	Name              string      `yaml:"name"`
+	Gecos             string      `yaml:"gecos,omitempty"`
+	Passwd            string      `yaml:"passwd,omitempty"`
+	PlainTextPasswd   string      `yaml:"plain_text_passwd,omitempty"`
+	LockPasswd        *bool       `yaml:"lock_passwd,omitempty"`
+	Groups            interface{} `yaml:"groups,omitempty"`
+	Sudo              interface{} `yaml:"sudo,omitempty"`
+	SSHAuthorizedKeys []string    `yaml:"ssh_authorized_keys,omitempty"`
+	UID               uint        `yaml:"uid,omitempty"`
+	Shell             string      `yaml:"shell,omitempty"`
+	Homedir           string      `yaml:"homedir,omitempty"`
+	System            bool        `yaml:"system,omitempty"`
+	Expiredate        string      `yaml:"expiredate,omitempty"`
+}
+
+type cloudWriteFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Encoding    string `yaml:"encoding"`
+	Permissions string `yaml:"permissions"`
+	Owner       string `yaml:"owner"`
+	Append      bool   `yaml:"append"`
+}
+
+// cloudConfigKnownKeys are the top level keys handled by the importer
+var cloudConfigKnownKeys = []string{
+	"hostname", "fqdn", "timezone", "locale", "keyboard", "users",
+	"ssh_authorized_keys", "write_files", "runcmd", "power_state",
+}
+
+// IsCloudConfig returns true if the file cf starts with the #cloud-config header
+func IsCloudConfig(cf string) bool {
+	fp, err := os.Open(cf)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	scanner := bufio.NewScanner(fp)
+	if !scanner.Scan() {
+		return false
+	}
+
+	return strings.TrimSpace(scanner.Text()) == CloudConfigHeader
+}
+
+func cloudConfigWarning(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Println("WARNING: " + msg)
+	log.Warning(msg)
+}
+
+// cloudStringList accepts both the comma separated string and the list forms
+func cloudStringList(value interface{}) []string {
+	var result []string
+
+	switch v := value.(type) {
+	case string:
+		for _, curr := range strings.Split(v, ",") {
+			if curr = strings.TrimSpace(curr); curr != "" {
+				result = append(result, curr)
+			}
+		}
+	case []interface{}:
+		for _, curr := range v {
+			result = append(result, fmt.Sprintf("%v", curr))
+		}
+	}
+
+	return result
+}
+
+// cloudShellQuote quotes a single word for bash
+func cloudShellQuote(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+// CloudInitToYAMLConfig converts a cloud-init #cloud-config user-data file to
+// the corresponding YAML config fields and return the model
+func CloudInitToYAMLConfig(cf string) (*SystemInstall, error) {
+	var si SystemInstall
+
+	si.InitializeDefaults()
+
+	b, err := ioutil.ReadFile(cf)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	log.Debug("Successfully read cloud-config file: %s", cf)
+
+	if !strings.HasPrefix(strings.TrimSpace(string(b)), CloudConfigHeader) {
+		return nil, errors.Errorf("%s is missing the %s header", cf, CloudConfigHeader)
+	}
+
+	raw := map[string]interface{}{}
+	if err = yaml.Unmarshal(b, &raw); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var keys []string
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		known := false
+		for _, curr := range cloudConfigKnownKeys {
+			known = known || curr == key
+		}
+
+		if !known {
+			cloudConfigWarning("Skipping cloud-config key %q as it is not supported in clr-installer config", key)
+		}
+	}
+
+	cc := cloudConfig{}
+	if err = yaml.Unmarshal(b, &cc); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	si.Hostname = cc.Hostname
+	if cc.FQDN != "" {
+		si.Hostname = strings.SplitN(cc.FQDN, ".", 2)[0]
+	}
+
+	if cc.Timezone != "" {
+		si.Timezone = &timezone.TimeZone{Code: cc.Timezone}
+	}
+
+	if err = si.setCloudUsers(cc); err != nil {
+		return nil, err
+	}
+
+	for _, wf := range cc.WriteFiles {
+		hook, err := cloudWriteFileHook(wf)
+		if err != nil {
+			return nil, err
+		}
+		si.PostInstall = append(si.PostInstall, hook)
+	}
+
+	for _, curr := range cc.RunCmd {
+		cmd := ""
+
+		switch v := curr.(type) {
+		case string:
+			cmd = v
+		case []interface{}:
+			var words []string
+			for _, w := range v {
+				words = append(words, cloudShellQuote(fmt.Sprintf("%v", w)))
+			}
+			cmd = strings.Join(words, " ")
+		default:
+			return nil, errors.Errorf("invalid runcmd entry in config file %s: %v", cf, curr)
+		}
+
+		si.PostInstall = append(si.PostInstall, &InstallHook{Chroot: true, Cmd: cmd})
+	}
+
+	if len(cc.RunCmd) > 0 {
+		cloudConfigWarning("Mapping runcmd to chroot post-install hooks; they run during install, not at first boot")
+	}
+
+	if mode, ok := cc.PowerState["mode"]; ok && mode == "reboot" {
+		si.PostReboot = true
+	}
+
+	// Hardcoding the missing required fields
+	si.Telemetry = &telemetry.Telemetry{Enabled: false}
+	si.Telemetry.SetUserDefined(true)
+
+	si.Keyboard = &keyboard.Keymap{Code: keyboard.DefaultKeyboard}
+	if cc.Keyboard != nil && cc.Keyboard.Layout != "" {
+		si.Keyboard.Code = cc.Keyboard.Layout
+	}
+
+	si.Language = &language.Language{Code: language.DefaultLanguage}
+	if cc.Locale != "" {
+		si.Language.Code = cc.Locale
+	}
+
+	si.Kernel = &kernel.Kernel{Bundle: convertDefaultKernel}
+
+	return &si, nil
+}
+
+// setCloudUsers maps the cloud-config users and the global ssh keys
+func (si *SystemInstall) setCloudUsers(cc cloudConfig) error {
+	for _, curr := range cc.Users {
+		if name, ok := curr.(string); ok {
+			cloudConfigWarning("Skipping cloud-config user %q, only explicit users are supported", name)
+			continue
+		}
+
+		b, err := yaml.Marshal(curr)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		cu := cloudUser{}
+		if err = yaml.Unmarshal(b, &cu); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if cu.Name == "" {
+			return errors.Errorf("cloud-config user is missing the name attribute")
+		}
+
+		u := &user.User{
+			Login:    cu.Name,
+			UserName: cu.Gecos,
+			SSHKeys:  cu.SSHAuthorizedKeys,
+			UID:      cu.UID,
+			Shell:    cu.Shell,
+			Home:     cu.Homedir,
+			System:   cu.System,
+			Expire:   cu.Expiredate,
+		}
+
+		u.Password = cu.Passwd
+		if u.Password == "" && cu.PlainTextPasswd != "" {
+			if u.Password, err = encrypt.Crypt(cu.PlainTextPasswd); err != nil {
+				return err
+			}
+		}
+
+		if cu.LockPasswd != nil && *cu.LockPasswd && u.Password != "" {
+			cloudConfigWarning("User %q has lock_passwd set; the password will not be locked", cu.Name)
+		}
+
+		if len(cloudStringList(cu.Sudo)) > 0 {
+			u.Admin = true
+		}
+
+		for _, group := range cloudStringList(cu.Groups) {
+			if group == "wheel" || group == "sudo" {
+				u.Admin = true
+			} else {
+				u.Groups = append(u.Groups, group)
+			}
+		}
+
+		si.AddUser(u)
+	}
+
+	if len(cc.SSHAuthorizedKeys) > 0 {
+		if len(si.Users) == 0 {
+			cloudConfigWarning("Skipping ssh_authorized_keys as there is no user to assign them to")
+		} else {
+			si.Users[0].SSHKeys = append(si.Users[0].SSHKeys, cc.SSHAuthorizedKeys...)
+			cloudConfigWarning("Assigning ssh_authorized_keys to user %q", si.Users[0].Login)
+		}
+	}
+
+	return nil
+}
+
+// cloudWriteFileHook creates a chroot post-install hook writing the file
+func cloudWriteFileHook(wf *cloudWriteFile) (*InstallHook, error) {
+	if wf.Path == "" {
+		return nil, errors.Errorf("cloud-config write_files entry is missing the path attribute")
+	}
+
+	content := wf.Content
+	switch wf.Encoding {
+	case "", "text/plain":
+		content = base64.StdEncoding.EncodeToString([]byte(content))
+	case "b64", "base64":
+		content = strings.Join(strings.Fields(content), "")
+		if _, err := base64.StdEncoding.DecodeString(content); err != nil {
+			return nil, errors.Errorf("invalid base64 content for %s: %v", wf.Path, err)
+		}
+	default:
+		return nil, errors.Errorf("unsupported encoding %q for %s", wf.Encoding, wf.Path)
+	}
+
+	redirect := ">"
+	if wf.Append {
+		redirect = ">>"
+	}
+
+	path := cloudShellQuote(wf.Path)
+	cmds := []string{
+		fmt.Sprintf("mkdir -p \"$(dirname %s)\"", path),
+		fmt.Sprintf("echo %s | base64 -d %s %s", content, redirect, path),
+	}
+
+	if wf.Permissions != "" {
+		cmds = append(cmds, fmt.Sprintf("chmod %s %s", cloudShellQuote(wf.Permissions), path))
+	}
+
+	if wf.Owner != "" {
+		cmds = append(cmds, fmt.Sprintf("chown %s %s", cloudShellQuote(wf.Owner), path))
+	}
+
+	return &InstallHook{Chroot: true, Cmd: strings.Join(cmds, " && ")}, nil
+}
+
+// cloudSeedUser is a user entry of the generated NoCloud user-data
+type cloudSeedUser struct {
+	Name              string   `yaml:"name"`
+	Gecos             string   `yaml:"gecos,omitempty"`
+	Passwd            string   `yaml:"passwd,omitempty"`
+	LockPasswd        bool     `yaml:"lock_passwd"`
+	Groups            []string `yaml:"groups,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+// cloudSeedUserData is the generated NoCloud user-data
+type cloudSeedUserData struct {
+	Hostname          string           `yaml:"hostname,omitempty"`
+	Timezone          string           `yaml:"timezone,omitempty"`
+	Locale            string           `yaml:"locale,omitempty"`
+	Users             []*cloudSeedUser `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string         `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+// CloudInitUserData returns the #cloud-config user-data describing the
+// identity settings of the model
+func (si *SystemInstall) CloudInitUserData() (string, error) {
+	ud := cloudSeedUserData{Hostname: si.Hostname}
+
+	if si.Timezone != nil {
+		ud.Timezone = si.Timezone.Code
+	}
+
+	if si.Language != nil {
+		ud.Locale = si.Language.Code
+	}
+
+	for _, curr := range si.Users {
+		// root can not be re-created, only its ssh keys are carried
+		if curr.Login == "root" {
+			ud.SSHAuthorizedKeys = append(ud.SSHAuthorizedKeys, curr.SSHKeys...)
+			continue
+		}
+
+		cu := &cloudSeedUser{
+			Name:              curr.Login,
+			Gecos:             curr.UserName,
+			Passwd:            curr.Password,
+			LockPasswd:        curr.Password == "",
+			Groups:            curr.Groups,
+			SSHAuthorizedKeys: curr.SSHKeys,
+		}
+
+		if curr.Admin {
+			cu.Groups = append([]string{"wheel"}, curr.Groups...)
+		}
+
+		ud.Users = append(ud.Users, cu)
+	}
+
+	b, err := yaml.Marshal(&ud)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return CloudConfigHeader + "\n" + string(b), nil
+}
+
+// CloudInitMetaData returns the NoCloud meta-data for the model
+func (si *SystemInstall) CloudInitMetaData() string {
+	name := si.Hostname
+	if name == "" {
+		name = "clr-installer"
+	}
+
+	return fmt.Sprintf("instance-id: iid-%s\nlocal-hostname: %s\n", name, name)
+}
+
+// WriteCloudInitSeed writes the NoCloud seed (user-data and meta-data) to the
+// target rootDir so identity settings are applied by cloud-init on first boot
+func (si *SystemInstall) WriteCloudInitSeed(rootDir string) error {
+	seedDir := filepath.Join(rootDir, CloudInitSeedDir)
+
+	if err := os.MkdirAll(seedDir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	userData, err := si.CloudInitUserData()
+	if err != nil {
+		return err
+	}
+
+	// user-data may carry password hashes
+	if err = ioutil.WriteFile(filepath.Join(seedDir, "user-data"), []byte(userData), 0600); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(seedDir, "meta-data"), []byte(si.CloudInitMetaData()), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Info("Wrote cloud-init NoCloud seed to %s", seedDir)
+
+	return nil
+}
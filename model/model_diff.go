@@ -0,0 +1,485 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/telemetry"
+	"github.com/clearlinux/clr-installer/timezone"
+	"github.com/clearlinux/clr-installer/user"
+)
+
+// DiffSection holds the semantic changes between two configurations for
+// a given group of settings
+type DiffSection struct {
+	Name    string
+	Changes []string
+}
+
+func (ds *DiffSection) value(name string, from, to interface{}) {
+	f := fmt.Sprintf("%v", from)
+	t := fmt.Sprintf("%v", to)
+
+	if f != t {
+		ds.Changes = append(ds.Changes, fmt.Sprintf("~ %s: %s -> %s", name, f, t))
+	}
+}
+
+func (ds *DiffSection) list(prefix string, from, to []string) {
+	fromSet := map[string]bool{}
+	toSet := map[string]bool{}
+
+	for _, curr := range from {
+		fromSet[curr] = true
+	}
+
+	for _, curr := range to {
+		toSet[curr] = true
+	}
+
+	var removed, added []string
+	for curr := range fromSet {
+		if !toSet[curr] {
+			removed = append(removed, curr)
+		}
+	}
+
+	for curr := range toSet {
+		if !fromSet[curr] {
+			added = append(added, curr)
+		}
+	}
+
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	for _, curr := range removed {
+		ds.Changes = append(ds.Changes, "- "+prefix+curr)
+	}
+
+	for _, curr := range added {
+		ds.Changes = append(ds.Changes, "+ "+prefix+curr)
+	}
+}
+
+// normalizeForDiff returns a copy of si with the same defaults the installer
+// applies at install time so only meaningful differences are reported
+func (si *SystemInstall) normalizeForDiff() *SystemInstall {
+	copied := *si
+	si = &copied
+
+	// the defaults are set in place, the flags are copied first
+	if si.PostArchive != nil {
+		postArchive := *si.PostArchive
+		si.PostArchive = &postArchive
+	}
+
+	if si.AutoUpdate != nil {
+		autoUpdate := *si.AutoUpdate
+		si.AutoUpdate = &autoUpdate
+	}
+
+	si.InitializeDefaults()
+	si.SetDefaultSwapFileSize()
+
+	if si.Telemetry == nil {
+		si.Telemetry = &telemetry.Telemetry{}
+	}
+
+	if si.Timezone == nil {
+		si.Timezone = &timezone.TimeZone{Code: timezone.DefaultTimezone}
+	}
+
+	if si.Keyboard == nil {
+		si.Keyboard = &keyboard.Keymap{Code: keyboard.DefaultKeyboard}
+	}
+
+	if si.Language == nil {
+		si.Language = &language.Language{Code: language.DefaultLanguage}
+	}
+
+	return si
+}
+
+// effectiveBundles returns the bundles which would be installed, explicit
+// and implicit ones
+func (si *SystemInstall) effectiveBundles() []string {
+	result := append([]string{}, si.Bundles...)
+
+	for _, curr := range si.ImplicitBundles() {
+		result = append(result, curr.Name)
+	}
+
+	return result
+}
+
+// Diff returns the semantic differences between the from and to
+// configurations grouped by section; sections without changes are omitted
+func Diff(from, to *SystemInstall) []*DiffSection {
+	from = from.normalizeForDiff()
+	to = to.normalizeForDiff()
+
+	sections := []*DiffSection{
+		diffStorage(from, to),
+		diffBundles(from, to),
+		diffUsers(from, to),
+		diffNetwork(from, to),
+		diffKernel(from, to),
+		diffHooks(from, to),
+		diffUpdates(from, to),
+		diffLocalization(from, to),
+	}
+
+	result := []*DiffSection{}
+	for _, curr := range sections {
+		if len(curr.Changes) > 0 {
+			result = append(result, curr)
+		}
+	}
+
+	return result
+}
+
+// describeBlockDevices flattens the partitions keyed by mount point, or by
+// name for partitions without one
+func describeBlockDevices(bds []*storage.BlockDevice, result map[string]string) {
+	for _, bd := range bds {
+		if len(bd.Children) > 0 {
+			describeBlockDevices(bd.Children, result)
+			continue
+		}
+
+		key := bd.MountPoint
+		if key == "" {
+			key = bd.Name
+		}
+
+		size, _ := storage.HumanReadableSizeXiBWithPrecision(bd.Size, 1)
+		if bd.Size == 0 {
+			size = "rest"
+		}
+
+		desc := fmt.Sprintf("type=%s fstype=%s size=%s", bd.Type, bd.FsType, size)
+		if bd.Label != "" {
+			desc += " label=" + bd.Label
+		}
+
+		if bd.Options != "" {
+			desc += " options=" + bd.Options
+		}
+
+		result[key] = desc
+	}
+}
+
+func diffStorage(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Storage"}
+
+	var fromDisks, toDisks []string
+	for _, curr := range from.TargetMedias {
+		fromDisks = append(fromDisks, curr.Name)
+	}
+
+	for _, curr := range to.TargetMedias {
+		toDisks = append(toDisks, curr.Name)
+	}
+	ds.list("target media ", fromDisks, toDisks)
+
+	fromParts := map[string]string{}
+	toParts := map[string]string{}
+	describeBlockDevices(from.TargetMedias, fromParts)
+	describeBlockDevices(to.TargetMedias, toParts)
+
+	keys := map[string]bool{}
+	for key := range fromParts {
+		keys[key] = true
+	}
+
+	for key := range toParts {
+		keys[key] = true
+	}
+
+	var sorted []string
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		f, inFrom := fromParts[key]
+		t, inTo := toParts[key]
+
+		switch {
+		case !inFrom:
+			ds.Changes = append(ds.Changes, fmt.Sprintf("+ %s (%s)", key, t))
+		case !inTo:
+			ds.Changes = append(ds.Changes, fmt.Sprintf("- %s (%s)", key, f))
+		default:
+			ds.value(key, f, t)
+		}
+	}
+
+	ds.value("legacyBios", from.MediaOpts.LegacyBios, to.MediaOpts.LegacyBios)
+	ds.value("swapFileSize", from.MediaOpts.SwapFileSize, to.MediaOpts.SwapFileSize)
+	ds.value("skipValidationSize", from.MediaOpts.SkipValidationSize, to.MediaOpts.SkipValidationSize)
+	ds.value("skipValidationAll", from.MediaOpts.SkipValidationAll, to.MediaOpts.SkipValidationAll)
+
+	return ds
+}
+
+func diffBundles(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Bundles"}
+
+	ds.list("", from.effectiveBundles(), to.effectiveBundles())
+
+	return ds
+}
+
+func diffUsers(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Users"}
+
+	fromUsers := map[string]*user.User{}
+	var fromLogins, toLogins []string
+
+	for _, curr := range from.Users {
+		fromUsers[curr.Login] = curr
+		fromLogins = append(fromLogins, curr.Login)
+	}
+
+	for _, curr := range to.Users {
+		toLogins = append(toLogins, curr.Login)
+	}
+
+	ds.list("", fromLogins, toLogins)
+
+	for _, t := range to.Users {
+		f, ok := fromUsers[t.Login]
+		if !ok {
+			continue
+		}
+
+		ds.value(t.Login+" username", f.UserName, t.UserName)
+		ds.value(t.Login+" admin", f.Admin, t.Admin)
+		ds.value(t.Login+" uid", f.UID, t.UID)
+		ds.value(t.Login+" gid", f.GID, t.GID)
+		ds.list(t.Login+" group ", f.Groups, t.Groups)
+		ds.value(t.Login+" shell", f.Shell, t.Shell)
+		ds.value(t.Login+" home", f.Home, t.Home)
+		ds.value(t.Login+" skel", f.Skeleton, t.Skeleton)
+		ds.value(t.Login+" system", f.System, t.System)
+		ds.value(t.Login+" expire", f.Expire, t.Expire)
+		ds.value(t.Login+" password-max-days", f.PasswordMaxDays, t.PasswordMaxDays)
+		ds.value(t.Login+" force-password-change", f.ForcePasswordChange, t.ForcePasswordChange)
+
+		// never print password hashes
+		if f.Password != t.Password {
+			ds.Changes = append(ds.Changes, fmt.Sprintf("~ %s password changed", t.Login))
+		}
+
+		ds.list(t.Login+" ssh-key ", f.SSHKeys, t.SSHKeys)
+	}
+
+	var fromSudo, toSudo []string
+
+	for _, curr := range from.Sudo {
+		fromSudo = append(fromSudo, curr.String())
+	}
+
+	for _, curr := range to.Sudo {
+		toSudo = append(toSudo, curr.String())
+	}
+
+	ds.list("sudo ", fromSudo, toSudo)
+
+	return ds
+}
+
+func describeInterface(iface *network.Interface) string {
+	var addrs []string
+	for _, addr := range iface.Addrs {
+		addrs = append(addrs, addr.IP+"/"+addr.NetMask)
+	}
+
+	desc := fmt.Sprintf("dhcp=%v addrs=[%s] gateway=%s ipv6=%s gateway6=%s dns=[%s] domain=[%s]",
+		iface.DHCP, strings.Join(addrs, ","), iface.Gateway, iface.IPv6, iface.Gateway6,
+		strings.Join(iface.DNSServers, ","), strings.Join(iface.DNSDomains, ","))
+
+	if iface.DNSOverTLS != "" {
+		desc += " dnsOverTls=" + iface.DNSOverTLS
+	}
+
+	if len(iface.Routes) > 0 {
+		desc += fmt.Sprintf(" routes=[%s]", network.FormatRoutes(iface.Routes))
+	}
+
+	if iface.Match != nil {
+		desc += fmt.Sprintf(" match=[%s] rename=%v", iface.Match, iface.Rename)
+	}
+
+	if iface.IsWireless() {
+		desc += fmt.Sprintf(" ssid=%s hidden=%v security=%s", iface.Wireless.SSID, iface.Wireless.Hidden,
+			iface.Wireless.Security)
+	}
+
+	switch {
+	case iface.Bond != nil:
+		desc += fmt.Sprintf(" bond mode=%s miimon=%d members=[%s]", iface.Bond.Mode, iface.Bond.MIIMon,
+			strings.Join(iface.Bond.Members, ","))
+	case iface.VLAN != nil:
+		desc += fmt.Sprintf(" vlan parent=%s id=%d", iface.VLAN.Parent, iface.VLAN.ID)
+	case iface.Bridge != nil:
+		desc += fmt.Sprintf(" bridge ports=[%s] stp=%v", strings.Join(iface.Bridge.Ports, ","),
+			iface.Bridge.STP)
+	}
+
+	return desc
+}
+
+func diffNetwork(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Network"}
+
+	ds.value("hostname", from.Hostname, to.Hostname)
+	ds.value("httpsProxy", from.HTTPSProxy, to.HTTPSProxy)
+	ds.value("proxy", from.Proxy.String(), to.Proxy.String())
+	ds.value("copyNetwork", from.CopyNetwork, to.CopyNetwork)
+	ds.value("resolved", from.Resolved.String(), to.Resolved.String())
+	ds.value("connectivity", from.Connectivity.String(), to.Connectivity.String())
+
+	fromIfaces := map[string]string{}
+	toIfaces := map[string]string{}
+	var fromNames, toNames []string
+
+	for _, curr := range from.NetworkInterfaces {
+		fromIfaces[curr.Name] = describeInterface(curr)
+		fromNames = append(fromNames, curr.Name)
+	}
+
+	for _, curr := range to.NetworkInterfaces {
+		toIfaces[curr.Name] = describeInterface(curr)
+		toNames = append(toNames, curr.Name)
+	}
+
+	ds.list("interface ", fromNames, toNames)
+
+	for _, name := range toNames {
+		if f, ok := fromIfaces[name]; ok {
+			ds.value("interface "+name, f, toIfaces[name])
+		}
+	}
+
+	return ds
+}
+
+func diffKernel(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Kernel"}
+
+	var fromKernel, toKernel string
+	if from.Kernel != nil {
+		fromKernel = from.Kernel.Bundle
+	}
+
+	if to.Kernel != nil {
+		toKernel = to.Kernel.Bundle
+	}
+	ds.value("kernel", fromKernel, toKernel)
+
+	var fromAdd, fromRemove, toAdd, toRemove []string
+	if from.KernelArguments != nil {
+		fromAdd, fromRemove = from.KernelArguments.Add, from.KernelArguments.Remove
+	}
+
+	if to.KernelArguments != nil {
+		toAdd, toRemove = to.KernelArguments.Add, to.KernelArguments.Remove
+	}
+
+	ds.list("add ", fromAdd, toAdd)
+	ds.list("remove ", fromRemove, toRemove)
+
+	return ds
+}
+
+func describeHooks(hooks []*InstallHook) []string {
+	var result []string
+
+	for _, curr := range hooks {
+		desc := curr.Cmd
+		if curr.Chroot {
+			desc = "[chroot] " + desc
+		}
+		result = append(result, desc)
+	}
+
+	return result
+}
+
+func diffHooks(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Hooks"}
+
+	hooks := []struct {
+		name     string
+		from, to []*InstallHook
+	}{
+		{"pre-install", from.PreInstall, to.PreInstall},
+		{"post-install", from.PostInstall, to.PostInstall},
+		{"post-image", from.PostImage, to.PostImage},
+	}
+
+	for _, curr := range hooks {
+		f := describeHooks(curr.from)
+		t := describeHooks(curr.to)
+
+		before := len(ds.Changes)
+		ds.list(curr.name+": ", f, t)
+
+		// same hooks but a different execution order
+		if before == len(ds.Changes) && strings.Join(f, "\n") != strings.Join(t, "\n") {
+			ds.Changes = append(ds.Changes, fmt.Sprintf("~ %s: execution order changed", curr.name))
+		}
+	}
+
+	return ds
+}
+
+func diffUpdates(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Updates and telemetry"}
+
+	ds.value("autoUpdate", from.AutoUpdate.Value(), to.AutoUpdate.Value())
+	ds.value("telemetry", from.Telemetry.Enabled, to.Telemetry.Enabled)
+	ds.value("telemetryURL", from.TelemetryURL, to.TelemetryURL)
+	ds.value("telemetryTID", from.TelemetryTID, to.TelemetryTID)
+	ds.value("telemetryPolicy", from.TelemetryPolicy, to.TelemetryPolicy)
+	ds.value("version", from.Version, to.Version)
+	ds.value("swupdMirror", from.SwupdMirror, to.SwupdMirror)
+	ds.value("swupdFormat", from.SwupdFormat, to.SwupdFormat)
+	ds.value("swupdSkipOptional", from.SwupdSkipOptional, to.SwupdSkipOptional)
+	ds.value("allowInsecureHTTP", from.AllowInsecureHTTP, to.AllowInsecureHTTP)
+	ds.value("updates", from.Updates.String(), to.Updates.String())
+	ds.list("thirdParty ", thirdPartyStrings(from.ThirdParty), thirdPartyStrings(to.ThirdParty))
+	ds.value("copySwupd", from.CopySwupd, to.CopySwupd)
+	ds.value("offline", from.Offline, to.Offline)
+	ds.value("postReboot", from.PostReboot, to.PostReboot)
+	ds.value("postArchive", from.PostArchive.Value(), to.PostArchive.Value())
+	ds.value("iso", from.MakeISO, to.MakeISO)
+	ds.value("cloudInitSeed", from.CloudInitSeed, to.CloudInitSeed)
+
+	return ds
+}
+
+func diffLocalization(from, to *SystemInstall) *DiffSection {
+	ds := &DiffSection{Name: "Localization"}
+
+	ds.value("keyboard", from.Keyboard.Code, to.Keyboard.Code)
+	ds.value("language", from.Language.Code, to.Language.Code)
+	ds.value("timezone", from.Timezone.Code, to.Timezone.Code)
+
+	return ds
+}
@@ -342,7 +342,7 @@ func (si *SystemInstall) WriteYAMLConfig(cf string) (string, error) {
 		return cf, errors.Wrap(err)
 	}
 
-	msg := fmt.Sprint("Converted config file from JSON to YAML: " + cf)
+	msg := fmt.Sprint("Converted config file to YAML: " + cf)
 	fmt.Println(msg)
 	log.Info(msg)
 
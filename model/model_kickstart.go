@@ -0,0 +1,902 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/encrypt"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/kernel"
+	"github.com/clearlinux/clr-installer/keyboard"
+	"github.com/clearlinux/clr-installer/language"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/telemetry"
+	"github.com/clearlinux/clr-installer/timezone"
+	"github.com/clearlinux/clr-installer/user"
+)
+
+const (
+	// kickstartDefaultDisk is used when no disk is named by ondisk, ignoredisk or clearpart
+	kickstartDefaultDisk = "sda"
+
+	// convertDefaultKernel is the kernel bundle used for converted config files
+	// as foreign package lists do not map to clr-installer bundles
+	convertDefaultKernel = "kernel-native"
+)
+
+var (
+	// kickstartIgnored are directives which have no meaning for clr-installer
+	// and are silently dropped during the conversion
+	kickstartIgnored = []string{
+		"install", "text", "graphical", "cmdline", "skipx", "eula",
+		"zerombr", "firstboot", "logging", "vnc",
+	}
+
+	// kickstartValueOpts are the options which may be given as "--opt value"
+	// instead of "--opt=value"
+	kickstartValueOpts = []string{
+		"size", "fstype", "ondisk", "label", "fsoptions", "vgname", "name",
+		"append", "device", "bootproto", "ip", "netmask", "gateway", "nameserver",
+		"hostname", "password", "groups", "gecos", "username", "vckeymap",
+		"drives", "only-use", "interpreter", "uid", "gid", "shell", "homedir",
+		"passphrase", "location", "timeout",
+	}
+)
+
+// KickstartReport holds the kickstart content which could not be converted
+type KickstartReport struct {
+	Unsupported []string
+	lines       []int
+}
+
+func (kr *KickstartReport) add(line int, directive string, format string, a ...interface{}) {
+	msg := fmt.Sprintf("line %d: %s: %s", line, directive, fmt.Sprintf(format, a...))
+	kr.Unsupported = append(kr.Unsupported, msg)
+	kr.lines = append(kr.lines, line)
+}
+
+// Len is required by sort.Interface
+func (kr *KickstartReport) Len() int {
+	return len(kr.lines)
+}
+
+// Less is required by sort.Interface
+func (kr *KickstartReport) Less(i, j int) bool {
+	return kr.lines[i] < kr.lines[j]
+}
+
+// Swap is required by sort.Interface
+func (kr *KickstartReport) Swap(i, j int) {
+	kr.lines[i], kr.lines[j] = kr.lines[j], kr.lines[i]
+	kr.Unsupported[i], kr.Unsupported[j] = kr.Unsupported[j], kr.Unsupported[i]
+}
+
+// kickstartCommand is a tokenized kickstart directive
+type kickstartCommand struct {
+	line       int
+	name       string
+	positional []string
+	opts       map[string]string
+}
+
+func (kc *kickstartCommand) has(opt string) bool {
+	_, ok := kc.opts[opt]
+	return ok
+}
+
+// kickstartVolume is an intermediate partition or logical volume declaration
+type kickstartVolume struct {
+	line       int
+	mountPoint string
+	fsType     string
+	size       uint64
+	grow       bool
+	label      string
+	options    string
+	encrypted  bool
+}
+
+// kickstartConverter carries the state while walking a kickstart file
+type kickstartConverter struct {
+	si          *SystemInstall
+	report      *KickstartReport
+	disks       []string
+	diskParts   map[string][]*kickstartVolume
+	pvDisk      map[string]string
+	vgDisks     map[string][]string
+	defaultDisk string
+	efiDeclared bool
+	users       []*user.User
+}
+
+// splitKickstartLine splits a kickstart line into shell-like words honoring
+// single quotes, double quotes, backslash escapes and trailing comments
+func splitKickstartLine(line string) ([]string, error) {
+	var words []string
+	var curr strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			curr.WriteRune(r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			curr.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			curr.WriteRune(r)
+		case r == '#' && !inWord:
+			// an unquoted # starting a word comments out the rest of the line
+			i = len(runes)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, curr.String())
+				curr.Reset()
+				inWord = false
+			}
+		default:
+			curr.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated quote in: %s", line)
+	}
+
+	if inWord {
+		words = append(words, curr.String())
+	}
+
+	return words, nil
+}
+
+// parseKickstartCommand splits the words of a directive into positional
+// arguments and options
+func parseKickstartCommand(line int, words []string) *kickstartCommand {
+	kc := &kickstartCommand{line: line, name: words[0], opts: map[string]string{}}
+
+	for i := 1; i < len(words); i++ {
+		curr := words[i]
+
+		if !strings.HasPrefix(curr, "--") {
+			kc.positional = append(kc.positional, curr)
+			continue
+		}
+
+		opt := strings.TrimPrefix(curr, "--")
+		if tks := strings.SplitN(opt, "=", 2); len(tks) == 2 {
+			kc.opts[tks[0]] = tks[1]
+			continue
+		}
+
+		value := ""
+		isValueOpt := false
+		for _, vo := range kickstartValueOpts {
+			if vo == opt {
+				isValueOpt = true
+				break
+			}
+		}
+
+		if isValueOpt && i+1 < len(words) && !strings.HasPrefix(words[i+1], "--") {
+			i++
+			value = words[i]
+		}
+
+		kc.opts[opt] = value
+	}
+
+	return kc
+}
+
+// KickstartToYAMLConfig converts an Anaconda kickstart file to the corresponding
+// YAML config fields and returns the model and a report of everything which
+// could not be converted
+func KickstartToYAMLConfig(cf string) (*SystemInstall, *KickstartReport, error) {
+	var si SystemInstall
+
+	si.InitializeDefaults()
+
+	fp, err := os.Open(cf)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+	log.Debug("Successfully opened kickstart file: %s", cf)
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	kc := &kickstartConverter{
+		si:        &si,
+		report:    &KickstartReport{},
+		diskParts: map[string][]*kickstartVolume{},
+		pvDisk:    map[string]string{},
+		vgDisks:   map[string][]string{},
+	}
+
+	var cmds []*kickstartCommand
+	var section *kickstartCommand
+	var body []string
+
+	scanner := bufio.NewScanner(fp)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// Inside a %section everything is kept verbatim until %end
+		if section != nil {
+			if trimmed == "%end" {
+				if err = kc.applySection(section, body); err != nil {
+					return nil, nil, err
+				}
+				section = nil
+				body = nil
+			} else {
+				body = append(body, line)
+			}
+			continue
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		words, err := splitKickstartLine(trimmed)
+		if err != nil {
+			return nil, nil, errors.Errorf("%s: line %d: %v", cf, lineNum, err)
+		}
+
+		cmd := parseKickstartCommand(lineNum, words)
+		if strings.HasPrefix(cmd.name, "%") {
+			section = cmd
+			continue
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	if section != nil {
+		return nil, nil, errors.Errorf("%s: section %s at line %d is missing %%end",
+			cf, section.name, section.line)
+	}
+
+	// Disk selection directives must be known before any partition is placed
+	for _, cmd := range cmds {
+		kc.applyDiskSelection(cmd)
+	}
+
+	for _, cmd := range cmds {
+		if err = kc.applyCommand(cmd); err != nil {
+			return nil, nil, errors.Errorf("%s: line %d: %v", cf, cmd.line, err)
+		}
+	}
+
+	kc.buildTargetMedias()
+
+	for _, u := range kc.users {
+		si.AddUser(u)
+	}
+
+	// Hardcoding the missing required fields
+	if si.Telemetry == nil {
+		si.Telemetry = &telemetry.Telemetry{Enabled: false}
+		si.Telemetry.SetUserDefined(true)
+	}
+	if si.Keyboard == nil {
+		si.Keyboard = &keyboard.Keymap{Code: keyboard.DefaultKeyboard}
+	}
+	if si.Language == nil {
+		si.Language = &language.Language{Code: language.DefaultLanguage}
+	}
+	if si.Kernel == nil {
+		si.Kernel = &kernel.Kernel{Bundle: convertDefaultKernel}
+	}
+
+	sort.Stable(kc.report)
+
+	return &si, kc.report, nil
+}
+
+// applyDiskSelection records the disks named by clearpart and ignoredisk
+func (kc *kickstartConverter) applyDiskSelection(cmd *kickstartCommand) {
+	var drives string
+
+	switch cmd.name {
+	case "ignoredisk":
+		drives = cmd.opts["only-use"]
+	case "clearpart":
+		drives = cmd.opts["drives"]
+	default:
+		return
+	}
+
+	for _, d := range strings.Split(drives, ",") {
+		if d = strings.TrimSpace(d); d != "" && kc.defaultDisk == "" {
+			kc.defaultDisk = d
+		}
+	}
+}
+
+func (kc *kickstartConverter) applyCommand(cmd *kickstartCommand) error {
+	for _, ign := range kickstartIgnored {
+		if cmd.name == ign {
+			log.Debug("Ignoring kickstart directive %q", cmd.name)
+			return nil
+		}
+	}
+
+	switch cmd.name {
+	case "ignoredisk", "clearpart":
+		// already handled by applyDiskSelection
+		return nil
+	case "part", "partition":
+		return kc.applyPart(cmd)
+	case "volgroup":
+		kc.applyVolGroup(cmd)
+	case "logvol":
+		return kc.applyLogVol(cmd)
+	case "autopart":
+		kc.applyAutoPart(cmd)
+	case "raid":
+		kc.report.add(cmd.line, cmd.name,
+			"software RAID can not be created from a configuration file; pre-create it and use Advanced labels")
+	case "bootloader":
+		kc.applyBootloader(cmd)
+	case "network":
+		kc.applyNetwork(cmd)
+	case "rootpw":
+		return kc.applyRootpw(cmd)
+	case "user":
+		return kc.applyUser(cmd)
+	case "sshkey":
+		kc.applySSHKey(cmd)
+	case "timezone":
+		kc.applyTimezone(cmd)
+	case "keyboard":
+		kc.applyKeyboard(cmd)
+	case "lang":
+		kc.applyLang(cmd)
+	case "reboot":
+		kc.si.PostReboot = true
+	case "poweroff", "halt", "shutdown":
+		kc.si.PostReboot = false
+	default:
+		kc.report.add(cmd.line, cmd.name, "directive is not supported")
+	}
+
+	return nil
+}
+
+func (kc *kickstartConverter) targetDisk(cmd *kickstartCommand) string {
+	if disk := cmd.opts["ondisk"]; disk != "" {
+		return disk
+	}
+
+	if kc.defaultDisk != "" {
+		return kc.defaultDisk
+	}
+
+	return kickstartDefaultDisk
+}
+
+func (kc *kickstartConverter) newVolume(cmd *kickstartCommand, mountPoint string) (*kickstartVolume, error) {
+	vol := &kickstartVolume{
+		line:       cmd.line,
+		mountPoint: mountPoint,
+		fsType:     cmd.opts["fstype"],
+		label:      cmd.opts["label"],
+		options:    cmd.opts["fsoptions"],
+		grow:       cmd.has("grow"),
+		encrypted:  cmd.has("encrypted"),
+	}
+
+	if size := cmd.opts["size"]; size != "" {
+		mib, err := strconv.ParseUint(size, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid size %q", size)
+		}
+		vol.size = mib * 1024 * 1024
+	}
+
+	if vol.mountPoint == "swap" {
+		vol.mountPoint = ""
+		vol.fsType = "swap"
+	}
+
+	if vol.fsType == "" || vol.fsType == "efi" {
+		vol.fsType = "ext4"
+		if vol.mountPoint == "/boot/efi" || cmd.opts["fstype"] == "efi" {
+			vol.fsType = "vfat"
+		}
+	}
+
+	if vol.encrypted && cmd.has("passphrase") {
+		kc.report.add(cmd.line, cmd.name, "passphrase is not stored; use --crypt-file during install")
+	}
+
+	for _, opt := range []string{"maxsize", "recommended", "percent", "resize", "noformat", "useexisting"} {
+		if cmd.has(opt) {
+			kc.report.add(cmd.line, cmd.name, "option --%s is not supported", opt)
+		}
+	}
+
+	return vol, nil
+}
+
+func (kc *kickstartConverter) addDisk(disk string) {
+	for _, curr := range kc.disks {
+		if curr == disk {
+			return
+		}
+	}
+
+	kc.disks = append(kc.disks, disk)
+}
+
+func (kc *kickstartConverter) applyPart(cmd *kickstartCommand) error {
+	if len(cmd.positional) == 0 {
+		return errors.Errorf("%s requires a mount point", cmd.name)
+	}
+
+	mountPoint := cmd.positional[0]
+	disk := kc.targetDisk(cmd)
+
+	switch {
+	case mountPoint == "biosboot":
+		kc.report.add(cmd.line, cmd.name, "biosboot partition dropped; use legacyBios for legacy boot")
+		return nil
+	case strings.HasPrefix(mountPoint, "raid."):
+		kc.report.add(cmd.line, cmd.name, "RAID member %q dropped; software RAID is not supported", mountPoint)
+		return nil
+	case strings.HasPrefix(mountPoint, "pv."):
+		// physical volumes are flattened into partitions for their logical volumes
+		kc.pvDisk[mountPoint] = disk
+		kc.addDisk(disk)
+		return nil
+	}
+
+	vol, err := kc.newVolume(cmd, mountPoint)
+	if err != nil {
+		return err
+	}
+
+	if mountPoint == "/boot/efi" {
+		kc.efiDeclared = true
+	}
+
+	kc.addDisk(disk)
+	kc.diskParts[disk] = append(kc.diskParts[disk], vol)
+
+	return nil
+}
+
+func (kc *kickstartConverter) applyVolGroup(cmd *kickstartCommand) {
+	if len(cmd.positional) < 2 {
+		kc.report.add(cmd.line, cmd.name, "volume group without physical volumes dropped")
+		return
+	}
+
+	name := cmd.positional[0]
+	for _, pv := range cmd.positional[1:] {
+		disk, ok := kc.pvDisk[pv]
+		if !ok {
+			kc.report.add(cmd.line, cmd.name, "physical volume %q is not declared", pv)
+			continue
+		}
+
+		found := false
+		for _, d := range kc.vgDisks[name] {
+			found = found || d == disk
+		}
+		if !found {
+			kc.vgDisks[name] = append(kc.vgDisks[name], disk)
+		}
+	}
+}
+
+func (kc *kickstartConverter) applyLogVol(cmd *kickstartCommand) error {
+	if len(cmd.positional) == 0 {
+		return errors.Errorf("%s requires a mount point", cmd.name)
+	}
+
+	vg := cmd.opts["vgname"]
+	disks := kc.vgDisks[vg]
+	if len(disks) != 1 {
+		kc.report.add(cmd.line, cmd.name,
+			"volume group %q spans %d disks; LVM can not be created from a configuration file", vg, len(disks))
+		return nil
+	}
+
+	vol, err := kc.newVolume(cmd, cmd.positional[0])
+	if err != nil {
+		return err
+	}
+
+	kc.report.add(cmd.line, cmd.name,
+		"logical volume %q flattened into a partition on %s", cmd.opts["name"], disks[0])
+	kc.diskParts[disks[0]] = append(kc.diskParts[disks[0]], vol)
+
+	return nil
+}
+
+func (kc *kickstartConverter) applyAutoPart(cmd *kickstartCommand) {
+	disk := kc.targetDisk(cmd)
+
+	kc.addDisk(disk)
+	kc.diskParts[disk] = append(kc.diskParts[disk],
+		&kickstartVolume{line: cmd.line, mountPoint: "/boot", fsType: "vfat", size: 150 * 1024 * 1024},
+		&kickstartVolume{line: cmd.line, mountPoint: "/", fsType: "ext4", grow: true},
+	)
+
+	if cmd.has("type") || cmd.has("encrypted") {
+		kc.report.add(cmd.line, cmd.name, "autopart mapped to a standard unencrypted layout")
+	}
+}
+
+// buildTargetMedias turns the collected volumes into target medias using
+// storage aliases the same way the ister converter does
+func (kc *kickstartConverter) buildTargetMedias() {
+	for _, disk := range kc.disks {
+		vols := kc.diskParts[disk]
+		if len(vols) == 0 {
+			continue
+		}
+
+		alias := strings.Replace(filepath.Base(disk), "-", "_", -1)
+		file := disk
+		if !strings.HasPrefix(file, "/dev/") {
+			file = "/dev/" + disk
+		}
+
+		kc.si.StorageAlias = append(kc.si.StorageAlias, &StorageAlias{Name: alias, File: file})
+
+		// Only one partition may take the remaining space and it is placed last
+		sort.SliceStable(vols, func(i, j int) bool {
+			return !vols[i].grow && vols[j].grow
+		})
+
+		bd := &storage.BlockDevice{Name: "${" + alias + "}", Type: storage.BlockDeviceTypeDisk}
+		growSeen := false
+
+		// the merged /boot volumes take no partition, so they are not counted
+		partNum := 0
+
+		for _, vol := range vols {
+			mountPoint := vol.mountPoint
+
+			if mountPoint == "/boot/efi" {
+				mountPoint = "/boot"
+			} else if mountPoint == "/boot" && kc.efiDeclared {
+				kc.report.add(vol.line, "part", "/boot merged into the EFI system partition mounted at /boot")
+				continue
+			}
+
+			partNum++
+
+			part := &storage.BlockDevice{
+				Name:            fmt.Sprintf("${%s}%d", alias, partNum),
+				Type:            storage.BlockDeviceTypePart,
+				FsType:          vol.fsType,
+				MountPoint:      mountPoint,
+				Label:           vol.label,
+				Options:         vol.options,
+				Size:            vol.size,
+				MakePartition:   true,
+				FormatPartition: true,
+			}
+
+			if vol.grow {
+				if growSeen {
+					kc.report.add(vol.line, "part", "only one partition can grow; using its --size")
+				} else {
+					part.Size = 0
+					growSeen = true
+				}
+			}
+
+			if vol.encrypted {
+				part.Type = storage.BlockDeviceTypeCrypt
+			}
+
+			bd.Children = append(bd.Children, part)
+		}
+
+		kc.si.AddTargetMedia(bd)
+	}
+}
+
+func (kc *kickstartConverter) applyBootloader(cmd *kickstartCommand) {
+	if args := cmd.opts["append"]; args != "" {
+		kc.si.AddExtraKernelArguments(strings.Fields(args))
+	}
+
+	for opt := range cmd.opts {
+		switch opt {
+		case "append", "location", "timeout", "boot-drive", "driveorder":
+		default:
+			kc.report.add(cmd.line, cmd.name, "option --%s is not supported", opt)
+		}
+	}
+}
+
+func (kc *kickstartConverter) applyNetwork(cmd *kickstartCommand) {
+	if hostname := cmd.opts["hostname"]; hostname != "" {
+		kc.si.Hostname = hostname
+	}
+
+	bootproto := cmd.opts["bootproto"]
+	if bootproto == "" && !cmd.has("device") {
+		return
+	}
+
+	// the interfaces are configured by name, without --device they keep DHCP
+	if cmd.opts["device"] == "" {
+		kc.report.add(cmd.line, cmd.name, "network without --device is not supported, interfaces use DHCP")
+		return
+	}
+
+	iface := &network.Interface{Name: cmd.opts["device"], DHCP: bootproto != "static"}
+
+	if bootproto == "static" {
+		if ip := cmd.opts["ip"]; ip != "" {
+			iface.AddAddr(ip, cmd.opts["netmask"], network.IPv4)
+		}
+
+		iface.Gateway = cmd.opts["gateway"]
+	}
+
+	if ns := cmd.opts["nameserver"]; ns != "" {
+		iface.DNSServers = strings.Split(ns, ",")
+	}
+
+	switch ipv6 := cmd.opts["ipv6"]; ipv6 {
+	case "":
+	case "auto":
+		iface.IPv6 = network.IPv6SLAAC
+	case "dhcp":
+		iface.IPv6 = network.IPv6DHCP
+	default:
+		// the address is given as address[/prefix]
+		prefix := "64"
+		if idx := strings.Index(ipv6, "/"); idx >= 0 {
+			ipv6, prefix = ipv6[:idx], ipv6[idx+1:]
+		}
+
+		iface.IPv6 = network.IPv6Static
+		iface.AddAddr(ipv6, prefix, network.IPv6)
+	}
+
+	if cmd.has("noipv6") {
+		iface.IPv6 = network.IPv6Disabled
+	}
+
+	if iface.IPv6 != "" {
+		iface.Gateway6 = cmd.opts["ipv6gateway"]
+	}
+
+	for _, opt := range []string{"bondslaves", "vlanid", "bridgeslaves", "essid", "wpakey"} {
+		if cmd.has(opt) {
+			kc.report.add(cmd.line, cmd.name, "option --%s is not supported", opt)
+		}
+	}
+
+	kc.si.AddNetworkInterface(iface)
+}
+
+func (kc *kickstartConverter) getUser(login string) *user.User {
+	for _, u := range kc.users {
+		if u.Login == login {
+			return u
+		}
+	}
+
+	u := &user.User{Login: login}
+	kc.users = append(kc.users, u)
+
+	return u
+}
+
+func kickstartPassword(cmd *kickstartCommand, pwd string) (string, error) {
+	if pwd == "" || cmd.has("iscrypted") {
+		return pwd, nil
+	}
+
+	return encrypt.Crypt(pwd)
+}
+
+func (kc *kickstartConverter) applyRootpw(cmd *kickstartCommand) error {
+	if cmd.has("lock") {
+		log.Debug("Kickstart rootpw --lock: root account left undefined")
+		return nil
+	}
+
+	if len(cmd.positional) == 0 {
+		return errors.Errorf("rootpw requires a password")
+	}
+
+	hashed, err := kickstartPassword(cmd, cmd.positional[0])
+	if err != nil {
+		return err
+	}
+
+	kc.getUser("root").Password = hashed
+
+	return nil
+}
+
+func (kc *kickstartConverter) applyUser(cmd *kickstartCommand) error {
+	login := cmd.opts["name"]
+	if login == "" {
+		return errors.Errorf("user requires --name")
+	}
+
+	u := kc.getUser(login)
+	u.UserName = cmd.opts["gecos"]
+
+	hashed, err := kickstartPassword(cmd, cmd.opts["password"])
+	if err != nil {
+		return err
+	}
+	u.Password = hashed
+
+	for _, group := range user.ParseGroups(cmd.opts["groups"]) {
+		if group == "wheel" {
+			u.Admin = true
+		} else {
+			u.Groups = append(u.Groups, group)
+		}
+	}
+
+	for _, id := range []struct {
+		opt   string
+		value *uint
+	}{
+		{"uid", &u.UID},
+		{"gid", &u.GID},
+	} {
+		if !cmd.has(id.opt) {
+			continue
+		}
+
+		value, err := strconv.ParseUint(cmd.opts[id.opt], 10, 32)
+		if err != nil {
+			return errors.Errorf("invalid --%s %q for %q", id.opt, cmd.opts[id.opt], login)
+		}
+		*id.value = uint(value)
+	}
+
+	u.Shell = cmd.opts["shell"]
+	u.Home = cmd.opts["homedir"]
+
+	if cmd.has("lock") {
+		kc.report.add(cmd.line, cmd.name, "option --lock for %q is not supported", login)
+	}
+
+	return nil
+}
+
+func (kc *kickstartConverter) applySSHKey(cmd *kickstartCommand) {
+	login := cmd.opts["username"]
+	if login == "" || len(cmd.positional) == 0 {
+		kc.report.add(cmd.line, cmd.name, "sshkey requires --username and a key")
+		return
+	}
+
+	u := kc.getUser(login)
+	u.SSHKeys = append(u.SSHKeys, cmd.positional[0])
+}
+
+func (kc *kickstartConverter) applyTimezone(cmd *kickstartCommand) {
+	if len(cmd.positional) == 0 {
+		kc.report.add(cmd.line, cmd.name, "timezone without a zone dropped")
+		return
+	}
+
+	kc.si.Timezone = &timezone.TimeZone{Code: cmd.positional[0]}
+
+	if cmd.has("ntpservers") {
+		kc.report.add(cmd.line, cmd.name, "option --ntpservers is not supported")
+	}
+}
+
+func (kc *kickstartConverter) applyKeyboard(cmd *kickstartCommand) {
+	code := cmd.opts["vckeymap"]
+	if code == "" && len(cmd.positional) > 0 {
+		code = cmd.positional[0]
+	}
+
+	if code == "" {
+		kc.report.add(cmd.line, cmd.name, "only --vckeymap or a legacy keymap name are supported")
+		return
+	}
+
+	kc.si.Keyboard = &keyboard.Keymap{Code: code}
+}
+
+func (kc *kickstartConverter) applyLang(cmd *kickstartCommand) {
+	if len(cmd.positional) == 0 {
+		kc.report.add(cmd.line, cmd.name, "lang without a language dropped")
+		return
+	}
+
+	kc.si.Language = &language.Language{Code: cmd.positional[0]}
+
+	if cmd.has("addsupport") {
+		kc.report.add(cmd.line, cmd.name, "option --addsupport is not supported")
+	}
+}
+
+// applySection maps %pre and %post scripts to install hooks
+func (kc *kickstartConverter) applySection(section *kickstartCommand, body []string) error {
+	var hooks *[]*InstallHook
+	chroot := false
+
+	switch section.name {
+	case "%pre":
+		hooks = &kc.si.PreInstall
+	case "%post":
+		hooks = &kc.si.PostInstall
+		chroot = !section.has("nochroot")
+	default:
+		kc.report.add(section.line, section.name, "section is not supported")
+		return nil
+	}
+
+	for opt := range section.opts {
+		switch opt {
+		case "interpreter", "nochroot", "erroronfail":
+		default:
+			kc.report.add(section.line, section.name, "option --%s is not supported", opt)
+		}
+	}
+
+	script := strings.Join(body, "\n")
+	if strings.TrimSpace(script) == "" {
+		return nil
+	}
+
+	cmd := script
+	if interp := section.opts["interpreter"]; interp != "" && filepath.Base(interp) != "bash" &&
+		filepath.Base(interp) != "sh" {
+		cmd = fmt.Sprintf("%s <<'CLR_INSTALLER_KS_EOF'\n%s\nCLR_INSTALLER_KS_EOF", interp, script)
+	}
+
+	// a failing hook aborts the installation, which is what --erroronfail asks
+	// for; kickstart ignores the failures of the other scripts
+	if !section.has("erroronfail") {
+		cmd = fmt.Sprintf("(\n%s\n) || echo 'Ignoring the failure of the kickstart %s script'",
+			cmd, section.name)
+	}
+
+	*hooks = append(*hooks, &InstallHook{Chroot: chroot, Cmd: cmd})
+
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package model
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/hardware"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// detectHardwareFacts is replaced by tests to emulate a given system
+	detectHardwareFacts = hardware.Detect
+)
+
+// Match is a set of configuration values overlaid on top of the
+// configuration when the running system matches the criteria, i.e.:
+// match: [{name: "nuc", when: {dmiVendor: "Intel*"}, set: {kernel: kernel-native}}]
+type Match struct {
+	Name string             `yaml:"name,omitempty,flow"`
+	When *hardware.Criteria `yaml:"when,omitempty,flow"`
+	Set  yaml.MapSlice      `yaml:"set,omitempty"`
+}
+
+// matchOverride records a top level configuration key replaced by the
+// match blocks, so the saved configuration keeps the value from before
+// the overlay
+type matchOverride struct {
+	key     string
+	base    interface{}
+	present bool
+	applied string
+}
+
+func (m *Match) String() string {
+	if m.Name != "" {
+		return m.Name
+	}
+
+	return fmt.Sprintf("%+v", *m.When)
+}
+
+// ApplyMatches overlays the set values of every match block satisfied by
+// facts, in the order they are declared; fields present in a set replace
+// the current value
+func (si *SystemInstall) ApplyMatches(facts *hardware.Facts) error {
+	matches := si.Match
+
+	base, err := toMapSlice(si)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+
+	for _, curr := range matches {
+		if curr.When == nil {
+			return errors.ValidationErrorf("Match block %q is missing the when criteria", curr.Name)
+		}
+
+		if err := curr.When.Validate(); err != nil {
+			return errors.ValidationErrorf("Match block %q: %v", curr.Name, err)
+		}
+
+		for _, item := range curr.Set {
+			if key, ok := item.Key.(string); ok && key == "match" {
+				return errors.ValidationErrorf("Match block %q can not set nested match blocks", curr.Name)
+			}
+		}
+
+		ok, err := curr.When.Matches(facts)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			log.Debug("Hardware match %q not satisfied", curr)
+			continue
+		}
+
+		log.Info("Applying hardware match %q", curr)
+
+		data, err := yaml.Marshal(curr.Set)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err = yaml.UnmarshalStrict(data, si); err != nil {
+			return errors.Errorf("Match block %q: %v", curr, err)
+		}
+
+		for _, item := range curr.Set {
+			if key, ok := item.Key.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	// the matches are kept and the overlaid values are reverted on save, so
+	// the saved configuration can be reused on other systems
+	si.Match = matches
+
+	return si.recordMatchOverrides(base, keys)
+}
+
+// recordMatchOverrides stores the base and overlaid values of keys
+func (si *SystemInstall) recordMatchOverrides(base yaml.MapSlice, keys []string) error {
+	applied, err := toMapSlice(si)
+	if err != nil {
+		return err
+	}
+
+	si.matchOverrides = nil
+
+	for _, key := range keys {
+		found := false
+		for _, curr := range si.matchOverrides {
+			if curr.key == key {
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		override := matchOverride{key: key}
+		override.base, override.present = lookupMapSlice(base, key)
+
+		value, _ := lookupMapSlice(applied, key)
+		if override.applied, err = yamlString(value); err != nil {
+			return err
+		}
+
+		si.matchOverrides = append(si.matchOverrides, override)
+	}
+
+	return nil
+}
+
+// revertMatchOverrides returns conf with the values overlaid by the match
+// blocks set back to their base, a value changed after the overlay is kept
+func (si *SystemInstall) revertMatchOverrides(conf yaml.MapSlice) (yaml.MapSlice, error) {
+	for _, override := range si.matchOverrides {
+		value, ok := lookupMapSlice(conf, override.key)
+		if !ok {
+			continue
+		}
+
+		curr, err := yamlString(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if curr != override.applied {
+			continue
+		}
+
+		result := yaml.MapSlice{}
+		for _, item := range conf {
+			if item.Key != override.key {
+				result = append(result, item)
+			} else if override.present {
+				result = append(result, yaml.MapItem{Key: item.Key, Value: override.base})
+			}
+		}
+		conf = result
+	}
+
+	return conf, nil
+}
+
+// toMapSlice returns the top level keys of the yaml representation of si
+func toMapSlice(si *SystemInstall) (yaml.MapSlice, error) {
+	data, err := yaml.Marshal(si)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	result := yaml.MapSlice{}
+	if err = yaml.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return result, nil
+}
+
+func lookupMapSlice(ms yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range ms {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func yamlString(value interface{}) (string, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return string(data), nil
+}
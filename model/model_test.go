@@ -14,7 +14,10 @@ import (
 	"testing"
 
 	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/hardware"
+	"github.com/clearlinux/clr-installer/network"
 	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/updates"
 	"github.com/clearlinux/clr-installer/user"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -55,6 +58,15 @@ func TestLoadFile(t *testing.T) {
 		{"user-sshkeys.yaml", true},
 		{"valid-minimal.yaml", true},
 		{"valid-network.yaml", true},
+		{"valid-network-ipv6.yaml", true},
+		{"valid-network-wifi.yaml", true},
+		{"valid-network-bond.yaml", true},
+		{"valid-network-routes.yaml", true},
+		{"valid-network-match.yaml", true},
+		{"valid-network-connectivity.yaml", true},
+		{"valid-proxy.yaml", true},
+		{"valid-users-full.yaml", true},
+		{"valid-sudo.yaml", true},
 		{"valid-with-pre-post-hooks.yaml", true},
 		{"valid-with-version.yaml", true},
 		{"iso-bad.yaml", false},
@@ -96,6 +108,9 @@ func TestLoadFile(t *testing.T) {
 		{"invalid-ister-partition-ft.json", false},
 		{"invalid-ister-disk-pmp.json", false},
 		{"invalid-ister-partition-pmp.json", false},
+		{"valid-kickstart-full.ks", true},
+		{"valid-kickstart-lvm.ks", true},
+		{"invalid-kickstart-unterminated.ks", false},
 	}
 
 	for _, curr := range tests {
@@ -110,6 +125,18 @@ func TestLoadFile(t *testing.T) {
 				}()
 			}
 
+			if curr.valid && err != nil {
+				t.Fatalf("%s is a valid test and shouldn't return an error: %v", curr.file, err)
+			}
+		} else if filepath.Ext(curr.file) == ".ks" {
+			md, _, err := KickstartToYAMLConfig(path)
+			if err == nil {
+				path, err = md.WriteYAMLConfig(strings.TrimSuffix(path, ".ks") + ".yaml")
+				defer func() {
+					_ = os.Remove(path)
+				}()
+			}
+
 			if curr.valid && err != nil {
 				t.Fatalf("%s is a valid test and shouldn't return an error: %v", curr.file, err)
 			}
@@ -391,6 +418,44 @@ func TestAddNetworkInterface(t *testing.T) {
 	}
 }
 
+func TestNetworkRoutesAndDNS(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-network-routes.yaml")
+	loaded, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// The DNS lists and routes must survive writing the configuration back
+	path = filepath.Join(dir, "routes.yaml")
+	if err = loaded.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err = LoadFile(path, args.Args{}); err != nil {
+		t.Fatal(err)
+	}
+
+	iface := loaded.NetworkInterfaces[0]
+	if len(iface.DNSServers) != 2 || len(iface.DNSDomains) != 2 || iface.DNSOverTLS != "opportunistic" {
+		t.Fatalf("The DNS settings were not loaded: %+v", iface)
+	}
+
+	if len(iface.Routes) != 1 || iface.Routes[0].Metric != 100 ||
+		loaded.NetworkInterfaces[1].Routes[0].Table != 200 {
+		t.Fatalf("The routes were not loaded: %s", network.FormatRoutes(iface.Routes))
+	}
+
+	if loaded.Resolved == nil || loaded.Resolved.DNSSEC != "allow-downgrade" {
+		t.Fatalf("The resolved options were not loaded: %+v", loaded.Resolved)
+	}
+}
+
 func TestDesktopISOType(t *testing.T) {
 	path := filepath.Join(testsDir, "iso-desktop.yaml")
 	loaded, err := LoadFile(path, args.Args{})
@@ -498,6 +563,28 @@ func TestWriteFile(t *testing.T) {
 	if err := loaded.WriteFile("/invalid-dir/invalid.yaml"); err == nil {
 		t.Fatal("Should have failed writing to an invalid file")
 	}
+
+	path = filepath.Join(testsDir, "valid-network-wifi.yaml")
+	if loaded, err = LoadFile(path, args.Args{}); err != nil {
+		t.Fatalf("Failed to load %s: %v", path, err)
+	}
+
+	if err = loaded.WriteFile(tmpFile.Name()); err != nil {
+		t.Fatalf("Failed to write descriptor: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(content), "correct horse") || strings.Contains(string(content), "secret") {
+		t.Fatalf("The Wi-Fi secrets should not be saved:\n%s", content)
+	}
+
+	if loaded.NetworkInterfaces[0].Wireless.PSK == "" {
+		t.Fatalf("The Wi-Fi secrets should be kept in the model")
+	}
 }
 
 func TestAddExtraKernelArguments(t *testing.T) {
@@ -775,6 +862,511 @@ func TestSetDefaultSwapFilePass(t *testing.T) {
 	}
 }
 
+func TestKickstartToYAMLConfig(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-kickstart-full.ks")
+	md, report, err := KickstartToYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if len(md.TargetMedias) != 1 || len(md.TargetMedias[0].Children) != 4 {
+		t.Fatalf("Expected 1 target media with 4 partitions, got: %+v", md.TargetMedias)
+	}
+
+	children := md.TargetMedias[0].Children
+	for i, curr := range children {
+		if expected := fmt.Sprintf("${sda}%d", i+1); curr.Name != expected {
+			t.Fatalf("Partitions should be numbered without gaps, expected %s, got %s", expected, curr.Name)
+		}
+	}
+
+	if children[0].MountPoint != "/boot" || children[0].FsType != "vfat" {
+		t.Fatalf("EFI partition should be mounted at /boot as vfat, got: %s %s",
+			children[0].MountPoint, children[0].FsType)
+	}
+
+	if last := children[len(children)-1]; last.MountPoint != "/" || last.Size != 0 {
+		t.Fatalf("Growing root partition should be last and use the remaining space, got: %s %d",
+			last.MountPoint, last.Size)
+	}
+
+	if md.Hostname != "ks-host" || len(md.NetworkInterfaces) != 1 || md.NetworkInterfaces[0].DHCP {
+		t.Fatalf("Static network and hostname were not converted")
+	}
+
+	if iface := md.NetworkInterfaces[0]; iface.IPv6 != network.IPv6Static ||
+		!iface.HasIPv6Addr() || iface.Gateway6 != "2001:db8::1" {
+		t.Fatalf("Static ipv6 network was not converted: %+v", iface)
+	}
+
+	for _, addr := range md.NetworkInterfaces[0].Addrs {
+		if addr.Version == network.IPv6 && (addr.IP != "2001:db8::10" || addr.NetMask != "56") {
+			t.Fatalf("The ipv6 prefix should be kept, got %s/%s", addr.IP, addr.NetMask)
+		}
+	}
+
+	if servers := md.NetworkInterfaces[0].DNSServers; len(servers) != 2 || servers[1] != "10.0.0.3" {
+		t.Fatalf("All the nameservers should be converted, got: %v", servers)
+	}
+
+	if md.KernelArguments == nil || len(md.KernelArguments.Add) != 2 {
+		t.Fatalf("Bootloader arguments were not converted: %+v", md.KernelArguments)
+	}
+
+	if len(md.Users) != 2 {
+		t.Fatalf("Expected root and clear users, got %d", len(md.Users))
+	}
+
+	for _, u := range md.Users {
+		if u.Login == "clear" && (!u.Admin || len(u.SSHKeys) != 1 || u.Password == "clear123" ||
+			len(u.Groups) != 1 || u.Groups[0] != "docker") {
+			t.Fatalf("User clear was not converted correctly: %+v", u)
+		}
+	}
+
+	if len(md.PreInstall) != 1 || md.PreInstall[0].Chroot {
+		t.Fatalf("%%pre should be converted to a non chroot pre-install hook")
+	}
+
+	if len(md.PostInstall) != 2 || md.PostInstall[0].Chroot || !md.PostInstall[1].Chroot {
+		t.Fatalf("%%post sections were not converted correctly")
+	}
+
+	if !strings.HasPrefix(md.PostInstall[1].Cmd, "/usr/bin/python3 <<") {
+		t.Fatalf("%%post with an interpreter should run through the interpreter: %s", md.PostInstall[1].Cmd)
+	}
+
+	// only the scripts with --erroronfail abort the installation on failure
+	if !strings.HasSuffix(md.PostInstall[0].Cmd, "|| echo 'Ignoring the failure of the kickstart %post script'") {
+		t.Fatalf("The failure of %%post without --erroronfail should be ignored: %s", md.PostInstall[0].Cmd)
+	}
+
+	if !md.PostReboot || md.Timezone.Code != "America/Los_Angeles" {
+		t.Fatalf("reboot and timezone were not converted")
+	}
+
+	expected := []string{"selinux", "%packages", "/boot merged"}
+	for _, exp := range expected {
+		found := false
+		for _, curr := range report.Unsupported {
+			found = found || strings.Contains(curr, exp)
+		}
+
+		if !found {
+			t.Fatalf("Report should mention %q: %v", exp, report.Unsupported)
+		}
+	}
+}
+
+func TestKickstartLVM(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-kickstart-lvm.ks")
+	md, report, err := KickstartToYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if len(md.StorageAlias) != 1 || md.StorageAlias[0].File != "/dev/vda" {
+		t.Fatalf("Expected a single vda storage alias, got: %+v", md.StorageAlias)
+	}
+
+	if len(md.TargetMedias) != 1 || len(md.TargetMedias[0].Children) != 3 {
+		t.Fatalf("Logical volumes should be flattened into 3 partitions")
+	}
+
+	found := false
+	for _, curr := range report.Unsupported {
+		found = found || strings.Contains(curr, "RAID")
+	}
+	if !found {
+		t.Fatalf("Report should mention the unsupported raid directive: %v", report.Unsupported)
+	}
+}
+
+func TestKickstartNVMe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "nvme.ks")
+	content := "ignoredisk --only-use=nvme0n1\n" +
+		"part /boot/efi --fstype=efi --size=512 # the ESP\n" +
+		"part / --fstype=ext4 --grow --size=4096\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	md, _, err := KickstartToYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	bd := md.TargetMedias[0]
+	bd.ExpandName(map[string]string{"nvme0n1": "nvme0n1"})
+
+	if len(bd.Children) != 2 || bd.Children[0].Name != "nvme0n1p1" || bd.Children[1].Name != "nvme0n1p2" {
+		t.Fatalf("nvme partitions should expand with the p separator: %+v", bd.Children)
+	}
+}
+
+func TestKickstartNetworkWithoutDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatalf("Failed to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "network.ks")
+	content := "network --bootproto=dhcp --hostname=ks-host\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	md, _, err := KickstartToYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if md.Hostname != "ks-host" || len(md.NetworkInterfaces) != 0 {
+		t.Fatalf("No interface without a name should be configured: %+v", md.NetworkInterfaces)
+	}
+}
+
+func TestSplitKickstartLine(t *testing.T) {
+	words, err := splitKickstartLine(`user --name=a --gecos="A \"B\" C" 'x y'`)
+	if err != nil {
+		t.Fatalf("Should have split the line: %v", err)
+	}
+
+	if len(words) != 4 || words[2] != `--gecos=A "B" C` || words[3] != "x y" {
+		t.Fatalf("Unexpected words: %q", words)
+	}
+
+	if _, err = splitKickstartLine(`part / --label="root`); err == nil {
+		t.Fatalf("Unterminated quote should return an error")
+	}
+
+	words, err = splitKickstartLine(`network --hostname=a#b --gecos="x # y" # the host name`)
+	if err != nil {
+		t.Fatalf("Should have split the line: %v", err)
+	}
+
+	if len(words) != 3 || words[1] != "--hostname=a#b" || words[2] != "--gecos=x # y" {
+		t.Fatalf("Unexpected words for a commented line: %q", words)
+	}
+}
+
+func TestCloudInitToYAMLConfig(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-cloud-init-user-data")
+	if !IsCloudConfig(path) {
+		t.Fatalf("%s should be detected as a cloud-config file", path)
+	}
+
+	md, err := CloudInitToYAMLConfig(path)
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if md.Hostname != "cloudy" || md.Timezone.Code != "Europe/Berlin" || md.Keyboard.Code != "de" {
+		t.Fatalf("Identity settings were not converted")
+	}
+
+	if len(md.Users) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(md.Users))
+	}
+
+	if !md.Users[0].Admin || len(md.Users[0].SSHKeys) != 2 {
+		t.Fatalf("User clear should be admin with 2 ssh keys: %+v", md.Users[0])
+	}
+
+	if !md.Users[1].Admin || md.Users[1].Password == "" || md.Users[1].Password == "ops-secret" {
+		t.Fatalf("User ops should be admin with a hashed password: %+v", md.Users[1])
+	}
+
+	if len(md.Users[1].Groups) != 1 || md.Users[1].Groups[0] != "adm" {
+		t.Fatalf("User ops should keep the supplementary group adm: %v", md.Users[1].Groups)
+	}
+
+	if len(md.PostInstall) != 4 || !md.PostInstall[0].Chroot {
+		t.Fatalf("write_files and runcmd should be converted to 4 chroot post-install hooks")
+	}
+
+	if md.PostInstall[2].Cmd != "'systemctl' 'enable' 'sshd'" {
+		t.Fatalf("Unexpected runcmd conversion: %s", md.PostInstall[2].Cmd)
+	}
+
+	if !md.PostReboot {
+		t.Fatalf("power_state reboot should set postReboot")
+	}
+
+	if IsCloudConfig(filepath.Join(testsDir, "valid-kickstart-full.ks")) {
+		t.Fatalf("A kickstart file should not be detected as a cloud-config file")
+	}
+}
+
+func TestWriteCloudInitSeed(t *testing.T) {
+	md, err := CloudInitToYAMLConfig(filepath.Join(testsDir, "valid-cloud-init-user-data"))
+	if err != nil {
+		t.Fatalf("Failed to load cloud-config: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-seed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err = md.WriteCloudInitSeed(dir); err != nil {
+		t.Fatalf("WriteCloudInitSeed shouldn't return an error: %v", err)
+	}
+
+	seedDir := filepath.Join(dir, CloudInitSeedDir)
+	ud, err := ioutil.ReadFile(filepath.Join(seedDir, "user-data"))
+	if err != nil {
+		t.Fatalf("user-data should have been written: %v", err)
+	}
+
+	if !strings.HasPrefix(string(ud), CloudConfigHeader+"\n") || !strings.Contains(string(ud), "name: ops") {
+		t.Fatalf("Unexpected user-data content:\n%s", ud)
+	}
+
+	md.Hostname = ""
+	if md.CloudInitMetaData() != "instance-id: iid-clr-installer\nlocal-hostname: clr-installer\n" {
+		t.Fatalf("Unexpected meta-data content: %s", md.CloudInitMetaData())
+	}
+
+	if _, err = os.Stat(filepath.Join(seedDir, "meta-data")); err != nil {
+		t.Fatalf("meta-data should have been written: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from, err := LoadFile(filepath.Join(testsDir, "basic-valid-descriptor.yaml"), args.Args{ConfigFile: "a"})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	to, err := LoadFile(filepath.Join(testsDir, "encrypt-valid-descriptor.yaml"), args.Args{ConfigFile: "b"})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	to.AutoUpdate.SetValue(false)
+	to.PostInstall = append(to.PostInstall, &InstallHook{Chroot: true, Cmd: "true"})
+
+	sections := Diff(from, to)
+
+	changes := map[string][]string{}
+	for _, curr := range sections {
+		changes[curr.Name] = curr.Changes
+	}
+
+	if len(changes["Storage"]) != 3 {
+		t.Fatalf("Expected 3 storage changes, got: %v", changes["Storage"])
+	}
+
+	expected := map[string]string{
+		"Bundles":               "+ " + storage.RequiredBundle,
+		"Hooks":                 "+ post-install: [chroot] true",
+		"Updates and telemetry": "~ autoUpdate: true -> false",
+	}
+
+	for name, exp := range expected {
+		if len(changes[name]) != 1 || changes[name][0] != exp {
+			t.Fatalf("Expected %q in section %s, got: %v", exp, name, changes[name])
+		}
+	}
+
+	if sections = Diff(from, from); len(sections) != 0 {
+		t.Fatalf("A config should not differ from itself: %v", sections)
+	}
+}
+
+func TestDiffDefaults(t *testing.T) {
+	from := &SystemInstall{}
+	to := &SystemInstall{}
+	to.InitializeDefaults()
+	to.SetDefaultSwapFileSize()
+
+	// explicit defaults are not differences
+	if sections := Diff(from, to); len(sections) != 0 {
+		t.Fatalf("Defaults should be normalized: %v", sections[0].Changes)
+	}
+
+	// the compared configurations are left untouched
+	if from.AutoUpdate != nil || from.Timezone != nil || from.MediaOpts.SwapFileSize != "" {
+		t.Fatalf("Diff should not set the defaults of its inputs: %+v", from)
+	}
+
+	to.Users = append(to.Users, &user.User{Login: "clear"})
+	sections := Diff(from, to)
+	if len(sections) != 2 {
+		t.Fatalf("Adding a user should change users and the implicit bundles: %v", sections)
+	}
+}
+
+func TestImplicitBundles(t *testing.T) {
+	md := &SystemInstall{}
+	md.InitializeDefaults()
+
+	if bundles := md.ImplicitBundles(); len(bundles) != 0 {
+		t.Fatalf("The defaults shouldn't add bundles: %+v", bundles[0])
+	}
+
+	// any encrypted partition needs the storage tools, with or without a passphrase prompt
+	md.AddTargetMedia(&storage.BlockDevice{Name: "sda", Type: storage.BlockDeviceTypeDisk,
+		Children: []*storage.BlockDevice{{Name: "sda1", Type: storage.BlockDeviceTypeCrypt, MountPoint: "/home"}}})
+
+	found := false
+	for _, curr := range md.ImplicitBundles() {
+		found = found || curr.Name == storage.RequiredBundle
+	}
+
+	if !found {
+		t.Fatalf("An encrypted partition should add %s", storage.RequiredBundle)
+	}
+
+	md.CloudInitSeed = true
+
+	found = false
+	for _, curr := range md.ImplicitBundles() {
+		found = found || curr.Name == CloudInitRequiredBundle
+	}
+
+	if !found {
+		t.Fatalf("A NoCloud seed should add its consumer %s", CloudInitRequiredBundle)
+	}
+}
+
+func TestApplyMatches(t *testing.T) {
+	saveDetect := detectHardwareFacts
+	defer func() { detectHardwareFacts = saveDetect }()
+
+	detectHardwareFacts = func() (*hardware.Facts, error) {
+		return &hardware.Facts{
+			DMIVendor:  "Intel Corporation",
+			CPUFlags:   []string{"lm", "avx2"},
+			Memory:     16 * 1024 * 1024 * 1024,
+			DiskSizes:  []uint64{256 * 1024 * 1024 * 1024},
+			MACs:       []string{"52:54:00:12:34:56"},
+			Hypervisor: "kvm",
+		}, nil
+	}
+
+	path := filepath.Join(testsDir, "valid-match.yaml")
+	md, err := LoadFile(path, args.Args{ConfigFile: path})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if md.Kernel.Bundle != "kernel-lts" || md.KernelArguments == nil || len(md.KernelArguments.Add) != 1 {
+		t.Fatalf("Match nuc should have been applied: %+v %+v", md.Kernel, md.KernelArguments)
+	}
+
+	if md.TargetMedias[0].Name != "sda" {
+		t.Fatalf("Match big-server should not have been applied")
+	}
+
+	if !md.ContainsBundle("os-cloudguest-kvm") {
+		t.Fatalf("Match vm should have been applied: %v", md.Bundles)
+	}
+
+	if len(md.Match) != 3 {
+		t.Fatalf("Match blocks should be kept in the model")
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-match-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	md.Hostname = "node"
+	md.Bundles = append(md.Bundles, "editors")
+
+	saved := filepath.Join(dir, "saved.yaml")
+	if err = md.WriteFile(saved); err != nil {
+		t.Fatalf("Failed to write the configuration: %v", err)
+	}
+
+	md, err = LoadFile(saved, args.Args{ConfigFile: saved, NoMatch: true})
+	if err != nil {
+		t.Fatalf("%s should be valid: %v", saved, err)
+	}
+
+	if md.Kernel.Bundle != "kernel-native" || md.KernelArguments != nil {
+		t.Fatalf("The overlaid kernel should not be saved: %+v %+v", md.Kernel, md.KernelArguments)
+	}
+
+	if md.Hostname != "node" || !md.ContainsBundle("editors") || len(md.Match) != 3 {
+		t.Fatalf("The changes made after the overlay should be saved: %s %v", md.Hostname, md.Bundles)
+	}
+
+	md, err = LoadFile(path, args.Args{ConfigFile: path, NoMatch: true})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+
+	if md.Kernel.Bundle != "kernel-native" {
+		t.Fatalf("Match blocks should be ignored with NoMatch")
+	}
+
+	path = filepath.Join(testsDir, "invalid-match-nested.yaml")
+	if _, err = LoadFile(path, args.Args{ConfigFile: path}); err == nil {
+		t.Fatalf("%s should fail due to nested match blocks", path)
+	}
+}
+
+type testBundleResolver []string
+
+func (r testBundleResolver) Contains(name string) bool {
+	for _, curr := range r {
+		if curr == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r testBundleResolver) Suggest(name string) string {
+	for _, curr := range r {
+		if strings.HasPrefix(curr, name) {
+			return curr
+		}
+	}
+
+	return ""
+}
+
+func TestValidateBundleNames(t *testing.T) {
+	path := filepath.Join(testsDir, "basic-valid-descriptor.yaml")
+	md, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+	md.MediaOpts.SkipValidationAll = true
+
+	md.Bundles = []string{"os-core", "editors"}
+	md.Kernel.Bundle = "kernel-native"
+	md.BundleResolver = testBundleResolver{"os-core", "editors", "kernel-native"}
+
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	md.UserBundles = []string{"editor"}
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "did you mean 'editors'") {
+		t.Fatalf("Validate should suggest editors, got: %v", err)
+	}
+
+	md.UserBundles = []string{"vim"}
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "Unknown bundle 'vim'") {
+		t.Fatalf("Validate should fail due to the unknown bundle, got: %v", err)
+	}
+}
+
 func TestJSONUnmarshalIster(t *testing.T) {
 	var us IsterConfig
 
@@ -839,3 +1431,113 @@ func TestJSONUnmarshalIster(t *testing.T) {
 		t.Fatalf("Version 54321 should always be 54321, not %d", us.Version.Number)
 	}
 }
+
+func TestValidateThirdParty(t *testing.T) {
+	path := filepath.Join(testsDir, "basic-valid-descriptor.yaml")
+	md, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+	md.MediaOpts.SkipValidationAll = true
+
+	tools := &ThirdPartyRepo{Name: "tools", URL: "https://example.com/tools", Bundles: []string{"tools-cli"}}
+	md.ThirdParty = []*ThirdPartyRepo{tools}
+
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		repo *ThirdPartyRepo
+		msg  string
+	}{
+		{&ThirdPartyRepo{Name: "my tools", URL: "https://example.com"}, "Invalid third-party repository name"},
+		{&ThirdPartyRepo{Name: "other"}, "missing the url"},
+		{&ThirdPartyRepo{Name: "other", URL: "http://example.com"}, "set allowInsecureHTTP"},
+		{&ThirdPartyRepo{Name: "other", URL: "ftp://example.com"}, "unsupported url scheme"},
+		{&ThirdPartyRepo{Name: "other", URL: "file:///srv/other", Bundles: []string{"a,b"}}, "Invalid bundle name"},
+		{&ThirdPartyRepo{Name: "tools", URL: "file:///srv/tools"}, "Duplicated third-party repository"},
+	}
+
+	for _, curr := range tests {
+		md.ThirdParty = []*ThirdPartyRepo{tools, curr.repo}
+		if err = md.Validate(); err == nil || !strings.Contains(err.Error(), curr.msg) {
+			t.Fatalf("Validate of %+v should fail with %q, got: %v", *curr.repo, curr.msg, err)
+		}
+	}
+
+	md.AllowInsecureHTTP = true
+	md.ThirdParty = []*ThirdPartyRepo{tools, {Name: "other", URL: "http://example.com"}}
+	if err = md.Validate(); err != nil {
+		t.Fatalf("http should be allowed with allowInsecureHTTP: %v", err)
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-users-full.yaml")
+	md, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+	md.MediaOpts.SkipValidationAll = true
+
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	if u := md.Users[0]; u.UID != 41001 || len(u.Groups) != 2 || !u.ForcePasswordChange || u.PasswordMaxDays != 90 {
+		t.Fatalf("User jdoe was not loaded correctly: %+v", u)
+	}
+
+	md.Users[1].UID = md.Users[0].UID
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "share the uid") {
+		t.Fatalf("Validate should fail for a duplicated uid, got: %v", err)
+	}
+
+	md.Users[1].UID = 0
+	md.Users[1].Home = "var/lib/backup"
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "absolute path") {
+		t.Fatalf("Validate should fail for a relative home, got: %v", err)
+	}
+}
+
+func TestValidateSudo(t *testing.T) {
+	path := filepath.Join(testsDir, "valid-sudo.yaml")
+	md, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+	md.MediaOpts.SkipValidationAll = true
+
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	if len(md.Sudo) != 2 || !md.Sudo[0].NoPasswd || md.Sudo[1].Group != "operators" {
+		t.Fatalf("The sudo policies were not loaded correctly: %+v", md.Sudo)
+	}
+
+	md.Sudo = append(md.Sudo, &user.SudoPolicy{User: "ops"})
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "Duplicated sudo policy") {
+		t.Fatalf("Validate should fail for a duplicated sudo policy, got: %v", err)
+	}
+}
+
+func TestValidateUpdates(t *testing.T) {
+	path := filepath.Join(testsDir, "basic-valid-descriptor.yaml")
+	md, err := LoadFile(path, args.Args{})
+	if err != nil {
+		t.Fatalf("%s is a valid test and shouldn't return an error: %v", path, err)
+	}
+	md.MediaOpts.SkipValidationAll = true
+
+	md.Updates = &updates.Policy{Schedule: "Sun *-*-* 02:00:00", Window: "2h"}
+	if err = md.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	md.AutoUpdate.SetValue(false)
+	if err = md.Validate(); err == nil || !strings.Contains(err.Error(), "requires autoUpdate") {
+		t.Fatalf("A schedule without automatic updates should fail, got: %v", err)
+	}
+}
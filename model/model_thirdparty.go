@@ -0,0 +1,103 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+var (
+	// thirdPartyNameExp are the repository names accepted by swupd 3rd-party
+	thirdPartyNameExp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// thirdPartyBundleExp are the valid bundle names
+	thirdPartyBundleExp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+)
+
+// ThirdPartyRepo is a swupd third-party repository registered in the target
+// and the bundles installed from it, i.e.:
+// thirdParty: [{name: tools, url: "https://example.com/tools", bundles: [tools-cli]}]
+type ThirdPartyRepo struct {
+	Name        string   `yaml:"name,omitempty,flow"`
+	URL         string   `yaml:"url,omitempty,flow"`
+	Certificate string   `yaml:"certificate,omitempty,flow"`
+	Bundles     []string `yaml:"bundles,omitempty,flow"`
+}
+
+func (repo *ThirdPartyRepo) String() string {
+	return fmt.Sprintf("%s %s: %s", repo.Name, repo.URL, strings.Join(repo.Bundles, ", "))
+}
+
+// Validate checks the repository can be registered with swupd
+func (repo *ThirdPartyRepo) Validate(allowInsecureHTTP bool) error {
+	if !thirdPartyNameExp.MatchString(repo.Name) {
+		return errors.ValidationErrorf("Invalid third-party repository name '%s', "+
+			"only letters, digits, '-' and '_' are allowed", repo.Name)
+	}
+
+	if repo.URL == "" {
+		return errors.ValidationErrorf("Third-party repository '%s' is missing the url", repo.Name)
+	}
+
+	u, err := url.Parse(repo.URL)
+	if err != nil {
+		return errors.ValidationErrorf("Third-party repository '%s' has an invalid url: %v", repo.Name, err)
+	}
+
+	switch u.Scheme {
+	case "https", "file":
+	case "http":
+		if !allowInsecureHTTP {
+			return errors.ValidationErrorf("Third-party repository '%s' uses http, "+
+				"use https or set allowInsecureHTTP", repo.Name)
+		}
+	default:
+		return errors.ValidationErrorf("Third-party repository '%s' has an unsupported url scheme '%s'",
+			repo.Name, u.Scheme)
+	}
+
+	for _, curr := range repo.Bundles {
+		if !thirdPartyBundleExp.MatchString(curr) {
+			return errors.ValidationErrorf("Invalid bundle name '%s' for third-party repository '%s'",
+				curr, repo.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateThirdParty checks every third-party repository and that their names are unique
+func (si *SystemInstall) validateThirdParty() error {
+	names := map[string]bool{}
+
+	for _, curr := range si.ThirdParty {
+		if err := curr.Validate(si.AllowInsecureHTTP); err != nil {
+			return err
+		}
+
+		if names[curr.Name] {
+			return errors.ValidationErrorf("Duplicated third-party repository name '%s'", curr.Name)
+		}
+		names[curr.Name] = true
+	}
+
+	return nil
+}
+
+// thirdPartyStrings describes the repositories for the configuration diff
+func thirdPartyStrings(repos []*ThirdPartyRepo) []string {
+	result := []string{}
+
+	for _, curr := range repos {
+		result = append(result, curr.String())
+	}
+
+	return result
+}
@@ -0,0 +1,209 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// Connectivity describes how the network connectivity is verified
+type Connectivity struct {
+	URLs      []string `yaml:"urls,omitempty"`
+	DNS       []string `yaml:"dns,omitempty"`
+	Timeout   uint     `yaml:"timeout,omitempty"`
+	Attempts  uint     `yaml:"attempts,omitempty"`
+	Interval  uint     `yaml:"interval,omitempty"`
+	OnFailure string   `yaml:"onFailure,omitempty"`
+}
+
+const (
+	// ConnectivityFail fails the installation when the connectivity checks fail
+	ConnectivityFail = "fail"
+
+	// ConnectivityContinue proceeds with the installation when the connectivity checks fail
+	ConnectivityContinue = "continue"
+
+	// defaultProbeTimeout is the time allowed for a single probe
+	defaultProbeTimeout = 10 * time.Second
+
+	// defaultProbeAttempts is the number of times the probes are run before giving up
+	defaultProbeAttempts = 3
+
+	// defaultProbeInterval is the time waited before each attempt
+	defaultProbeInterval = 2 * time.Second
+
+	// maxProbeBody limits the content read from the probed URLs
+	maxProbeBody = 64 * 1024
+)
+
+var (
+	connectivityFailures = []string{ConnectivityFail, ConnectivityContinue}
+
+	// probeSleep is replaced by the tests
+	probeSleep = time.Sleep
+)
+
+// Validate checks the probed URLs and names and the give-up behaviour
+func (c *Connectivity) Validate() error {
+	for _, curr := range c.URLs {
+		u, err := url.Parse(curr)
+		if err != nil || (u.Scheme != "file" && u.Host == "") {
+			return errors.ValidationErrorf("Invalid connectivity url: %s", curr)
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+			return errors.ValidationErrorf("Invalid connectivity url %s: only http, https and file are supported",
+				curr)
+		}
+	}
+
+	for _, curr := range c.DNS {
+		if msg := IsValidDomainName(curr); msg != "" {
+			return errors.ValidationErrorf("Invalid connectivity dns name %s: %s", curr, msg)
+		}
+	}
+
+	if c.OnFailure != "" && !contains(connectivityFailures, c.OnFailure) {
+		return errors.ValidationErrorf("Invalid connectivity onFailure %q, expected one of: %s", c.OnFailure,
+			strings.Join(connectivityFailures, ", "))
+	}
+
+	return nil
+}
+
+// String returns the checks as a single line, empty for nil
+func (c *Connectivity) String() string {
+	if c == nil {
+		return ""
+	}
+
+	onFailure := c.OnFailure
+	if onFailure == "" {
+		onFailure = ConnectivityFail
+	}
+
+	return fmt.Sprintf("urls=[%s] dns=[%s] timeout=%v attempts=%d interval=%v onFailure=%s",
+		strings.Join(c.URLs, ","), strings.Join(c.DNS, ","), c.timeout(), c.attempts(), c.interval(), onFailure)
+}
+
+// Fatal returns true if a failure of the checks must stop the installation
+func (c *Connectivity) Fatal() bool {
+	return c == nil || c.OnFailure != ConnectivityContinue
+}
+
+func (c *Connectivity) timeout() time.Duration {
+	if c == nil || c.Timeout == 0 {
+		return defaultProbeTimeout
+	}
+
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c *Connectivity) attempts() uint {
+	if c == nil || c.Attempts == 0 {
+		return defaultProbeAttempts
+	}
+
+	return c.Attempts
+}
+
+func (c *Connectivity) interval() time.Duration {
+	if c == nil || c.Interval == 0 {
+		return defaultProbeInterval
+	}
+
+	return time.Duration(c.Interval) * time.Second
+}
+
+// urls returns the probed URLs, the content URL of the installer by default
+func (c *Connectivity) urls() ([]string, error) {
+	if c != nil && (len(c.URLs) > 0 || len(c.DNS) > 0) {
+		return c.URLs, nil
+	}
+
+	content, err := ioutil.ReadFile(versionURLPath)
+	if err != nil {
+		return nil, errors.Errorf("Read version file %s: %v", versionURLPath, err)
+	}
+
+	return []string{strings.TrimSpace(string(content))}, nil
+}
+
+// Check runs every probe once, all the names must resolve and all the URLs be accessible
+func (c *Connectivity) Check() error {
+	urls, err := c.urls()
+	if err != nil {
+		return err
+	}
+
+	if c != nil {
+		for _, curr := range c.DNS {
+			if err := resolveName(curr, c.timeout()); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, curr := range urls {
+		if err := probeURL(curr, c.timeout()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Verify runs the probes until they succeed or the attempts are exhausted, retry
+// is called after each failed attempt and stops the checks if it fails
+func (c *Connectivity) Verify(retry func() error) error {
+	var err error
+
+	for i := uint(0); i < c.attempts(); i++ {
+		probeSleep(c.interval())
+
+		if err = c.Check(); err == nil {
+			return nil
+		}
+		log.Warning("Attempt %d to verify connectivity failed: %v", i+1, err)
+
+		if retry == nil || i+1 == c.attempts() {
+			continue
+		}
+
+		if rerr := retry(); rerr != nil {
+			log.Warning("Giving up the connectivity checks: %v", rerr)
+			break
+		}
+	}
+
+	return err
+}
+
+// resolveName checks the name resolves within the timeout
+func resolveName(name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if _, err := net.DefaultResolver.LookupHost(ctx, name); err != nil {
+		return errors.Errorf("Could not resolve %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// probeURL checks the URL is accessible within the timeout, the local files
+// must exist; the attempts are run by the connectivity checks
+func probeURL(rawURL string, timeout time.Duration) error {
+	return (&Fetcher{Timeout: timeout, Attempts: 1}).Check(rawURL)
+}
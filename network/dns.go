@@ -0,0 +1,194 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Resolved holds the global systemd-resolved options written to resolved.conf
+type Resolved struct {
+	DNS          []string `yaml:"dns,omitempty"`
+	FallbackDNS  []string `yaml:"fallbackDns,omitempty"`
+	Domains      []string `yaml:"domains,omitempty"`
+	DNSOverTLS   string   `yaml:"dnsOverTls,omitempty"`
+	DNSSEC       string   `yaml:"dnssec,omitempty"`
+	LLMNR        string   `yaml:"llmnr,omitempty"`
+	MulticastDNS string   `yaml:"multicastDns,omitempty"`
+}
+
+// stringList is a list of strings which may be given as a single string in YAML
+type stringList []string
+
+const (
+	// resolvedConfDir is the drop-in directory of the resolved.conf options
+	resolvedConfDir = "/etc/systemd/resolved.conf.d"
+
+	resolvedConfFile = "50-clr-installer.conf"
+)
+
+var (
+	dnsOverTLSModes = []string{"yes", "opportunistic", "no"}
+	dnssecModes     = []string{"yes", "allow-downgrade", "no"}
+	resolveModes    = []string{"yes", "resolve", "no"}
+
+	// keyfileDNSOverTLS maps the DNS-over-TLS modes to NetworkManager's connection.dns-over-tls
+	keyfileDNSOverTLS = map[string]string{"no": "0", "opportunistic": "1", "yes": "2"}
+)
+
+// UnmarshalYAML unmarshals a single string or a list of strings
+func (s *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+
+	if err := unmarshal(&list); err == nil {
+		*s = list
+		return nil
+	}
+
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	*s = nil
+	if value != "" {
+		*s = stringList{value}
+	}
+
+	return nil
+}
+
+// MarshalYAML marshals a list of a single string as a string
+func (s stringList) MarshalYAML() (interface{}, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+
+	return []string(s), nil
+}
+
+// IsValidDNSOverTLS returns an error message if the DNS-over-TLS mode is not valid
+func IsValidDNSOverTLS(mode string) string {
+	if mode != "" && !contains(dnsOverTLSModes, mode) {
+		return fmt.Sprintf("Invalid DNS-over-TLS mode %q, expected one of: %s", mode,
+			strings.Join(dnsOverTLSModes, ", "))
+	}
+
+	return ""
+}
+
+// validateDNSServers checks the DNS servers are ip addresses, DNS-over-TLS servers
+// may be followed by their name as in: 1.1.1.1#cloudflare-dns.com
+func validateDNSServers(servers []string) error {
+	for _, curr := range servers {
+		ip := strings.SplitN(curr, "#", 2)[0]
+		if net.ParseIP(ip) == nil {
+			return errors.ValidationErrorf("Invalid DNS server: %s", curr)
+		}
+	}
+
+	return nil
+}
+
+// validateDomains checks the DNS search domains, the root domain "~." routes all
+// the queries to the DNS servers of the link
+func validateDomains(domains []string) error {
+	for _, curr := range domains {
+		domain := strings.TrimPrefix(curr, "~")
+		if domain == "." {
+			continue
+		}
+
+		if msg := IsValidDomainName(domain); msg != "" {
+			return errors.ValidationErrorf("Invalid DNS domain %s: %s", curr, msg)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the resolved.conf options
+func (r *Resolved) Validate() error {
+	if err := validateDNSServers(r.DNS); err != nil {
+		return err
+	}
+
+	if err := validateDNSServers(r.FallbackDNS); err != nil {
+		return err
+	}
+
+	if err := validateDomains(r.Domains); err != nil {
+		return err
+	}
+
+	if msg := IsValidDNSOverTLS(r.DNSOverTLS); msg != "" {
+		return errors.ValidationErrorf(msg)
+	}
+
+	for _, curr := range []struct {
+		name  string
+		value string
+		modes []string
+	}{
+		{"dnssec", r.DNSSEC, dnssecModes},
+		{"llmnr", r.LLMNR, resolveModes},
+		{"multicastDns", r.MulticastDNS, resolveModes},
+	} {
+		if curr.value != "" && !contains(curr.modes, curr.value) {
+			return errors.ValidationErrorf("Invalid %s %q, expected one of: %s", curr.name, curr.value,
+				strings.Join(curr.modes, ", "))
+		}
+	}
+
+	return nil
+}
+
+// String returns the options as a single line, empty for nil
+func (r *Resolved) String() string {
+	if r == nil {
+		return ""
+	}
+
+	return strings.Join(strings.Split(strings.TrimSpace(r.config()), "\n")[1:], " ")
+}
+
+// config returns the resolved.conf drop-in of the options
+func (r *Resolved) config() string {
+	k := &keyfile{}
+	k.section("Resolve").
+		set("DNS", strings.Join(r.DNS, " ")).
+		set("FallbackDNS", strings.Join(r.FallbackDNS, " ")).
+		set("Domains", strings.Join(r.Domains, " ")).
+		set("DNSOverTLS", r.DNSOverTLS).
+		set("DNSSEC", r.DNSSEC).
+		set("LLMNR", r.LLMNR).
+		set("MulticastDNS", r.MulticastDNS)
+
+	return k.String()
+}
+
+// Apply writes the resolved.conf drop-in to the given root, systemd-resolved
+// must be restarted for the running system to use it
+func (r *Resolved) Apply(root string) error {
+	dir := filepath.Join(root, resolvedConfDir)
+	if err := utils.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := writeFileMode(filepath.Join(dir, resolvedConfFile), r.config(), 0644); err != nil {
+		return err
+	}
+
+	log.Info("Wrote the resolved options to %s", dir)
+
+	return nil
+}
@@ -0,0 +1,509 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
+)
+
+// Fetcher downloads http, https and file URLs, the zero value uses the defaults
+type Fetcher struct {
+	// Timeout is the time allowed for each attempt
+	Timeout time.Duration
+
+	// Attempts is the number of times a download is tried
+	Attempts int
+
+	// Backoff is the time waited after the first failed attempt, doubled after each failure
+	Backoff time.Duration
+
+	// MaxSize is the largest content accepted in bytes
+	MaxSize int64
+
+	// SHA256 is the expected checksum of the content, if any
+	SHA256 string
+}
+
+// FetchError is a failed download, the status code is 0 when no response was received
+type FetchError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+const (
+	defaultFetchTimeout  = 30 * time.Second
+	defaultFetchAttempts = 3
+	defaultFetchBackoff  = time.Second
+
+	// defaultFetchMaxSize is large enough for the swupd manifests
+	defaultFetchMaxSize = 128 * 1024 * 1024
+)
+
+var (
+	// tlsConfig holds the CA bundle and client certificate of the downloads
+	tlsConfig *tls.Config
+
+	// fetchSleep is replaced by the tests
+	fetchSleep = time.Sleep
+)
+
+func (e *FetchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Downloading %s failed: %v", e.URL, e.Err)
+	}
+
+	return fmt.Sprintf("Downloading %s failed: %s", e.URL, e.Status)
+}
+
+// temporary returns true if the download may succeed if tried again
+func (e *FetchError) temporary() bool {
+	if e.StatusCode == 0 {
+		return e.Err != nil && !os.IsNotExist(e.Err)
+	}
+
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= http.StatusInternalServerError
+}
+
+// IsNotFound returns true if the download failed because the URL does not exist
+func IsNotFound(err error) bool {
+	fe, ok := err.(*FetchError)
+	if !ok {
+		return false
+	}
+
+	return fe.StatusCode == http.StatusNotFound || (fe.StatusCode == 0 && os.IsNotExist(fe.Err))
+}
+
+// loadCertificates returns the PEM certificates of a file or of the files of a directory
+func loadCertificates(path string) ([][]byte, error) {
+	files := []string{path}
+
+	if fi, err := os.Stat(path); err != nil {
+		return nil, errors.Wrap(err)
+	} else if fi.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		files = []string{}
+		for _, curr := range entries {
+			if !curr.IsDir() {
+				files = append(files, filepath.Join(path, curr.Name()))
+			}
+		}
+	}
+
+	result := [][]byte{}
+	for _, curr := range files {
+		content, err := ioutil.ReadFile(curr)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		result = append(result, content)
+	}
+
+	return result, nil
+}
+
+// SetTLSFiles sets the CA bundle, added to the system certificates, and the
+// client certificate and key used by the downloads, empty values are ignored
+func SetTLSFiles(caPath string, clientCert string, clientKey string) error {
+	if caPath == "" && clientCert == "" && clientKey == "" {
+		tlsConfig = nil
+		return nil
+	}
+
+	config := &tls.Config{}
+
+	if caPath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		certs, err := loadCertificates(caPath)
+		if err != nil {
+			return err
+		}
+
+		found := false
+		for _, curr := range certs {
+			found = pool.AppendCertsFromPEM(curr) || found
+		}
+
+		if !found {
+			return errors.Errorf("No PEM certificate found in %s", caPath)
+		}
+
+		config.RootCAs = pool
+	}
+
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return errors.Errorf("A client certificate requires both the certificate and the key")
+		}
+
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	tlsConfig = config
+
+	return nil
+}
+
+// httpClient returns a client honoring the installer proxies and certificates
+func httpClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.HTTPProxy
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+func (f *Fetcher) timeout() time.Duration {
+	if f.Timeout == 0 {
+		return defaultFetchTimeout
+	}
+
+	return f.Timeout
+}
+
+func (f *Fetcher) attempts() int {
+	if f.Attempts == 0 {
+		return defaultFetchAttempts
+	}
+
+	return f.Attempts
+}
+
+func (f *Fetcher) backoff() time.Duration {
+	if f.Backoff == 0 {
+		return defaultFetchBackoff
+	}
+
+	return f.Backoff
+}
+
+func (f *Fetcher) maxSize() int64 {
+	if f.MaxSize == 0 {
+		return defaultFetchMaxSize
+	}
+
+	return f.MaxSize
+}
+
+// open returns the content of the URL from offset and its size, partial is
+// false when the content is returned from its start instead
+func (f *Fetcher) open(u *url.URL, offset int64) (io.ReadCloser, int64, bool, error) {
+	if u.Scheme == "file" {
+		file, err := os.Open(u.Path)
+		if err != nil {
+			return nil, 0, false, &FetchError{URL: u.String(), Err: err}
+		}
+
+		fi, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, 0, false, &FetchError{URL: u.String(), Err: err}
+		}
+
+		if offset == 0 || offset > fi.Size() {
+			return file, fi.Size(), false, nil
+		}
+
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			_ = file.Close()
+			return nil, 0, false, &FetchError{URL: u.String(), Err: err}
+		}
+
+		return file, fi.Size() - offset, true, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, false, &FetchError{URL: u.String(), Err: err}
+	}
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := httpClient(f.timeout()).Do(req)
+	if err != nil {
+		return nil, 0, false, &FetchError{URL: u.String(), Err: err}
+	}
+
+	// the range is past the end of the content, it changed since the first download
+	if offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		_ = resp.Body.Close()
+		return f.open(u, 0)
+	}
+
+	if resp.StatusCode == http.StatusPartialContent && offset > 0 {
+		return resp.Body, resp.ContentLength, true, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, 0, false, &FetchError{URL: u.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return resp.Body, resp.ContentLength, false, nil
+}
+
+// fetch downloads the URL once to out, the content before offset is already in
+// out and the download starts over when the server ignores the range
+func (f *Fetcher) fetch(u *url.URL, out *os.File, offset int64) error {
+	body, size, partial, err := f.open(u, offset)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+
+	hash := sha256.New()
+
+	if partial {
+		// the checksum covers the content downloaded before
+		if _, err = out.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if _, err = io.CopyN(hash, out, offset); err != nil {
+			return errors.Wrap(err)
+		}
+	} else {
+		offset = 0
+
+		if err = out.Truncate(0); err != nil {
+			return errors.Wrap(err)
+		}
+
+		if _, err = out.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if offset+size > f.maxSize() {
+		return &FetchError{URL: u.String(), Status: fmt.Sprintf("%d bytes exceed the limit of %d bytes",
+			offset+size, f.maxSize())}
+	}
+
+	n, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(body, f.maxSize()-offset+1))
+	if err != nil {
+		return &FetchError{URL: u.String(), Err: err}
+	}
+
+	if offset+n > f.maxSize() {
+		return &FetchError{URL: u.String(), Status: fmt.Sprintf("the content exceeds the limit of %d bytes",
+			f.maxSize())}
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); f.SHA256 != "" && !strings.EqualFold(sum, f.SHA256) {
+		return &FetchError{URL: u.String(), Status: fmt.Sprintf("sha256 checksum %s does not match %s",
+			sum, f.SHA256)}
+	}
+
+	return nil
+}
+
+// parseURL returns the URL if its scheme is supported
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file" {
+		return nil, errors.Errorf("Unsupported URL %s: only http, https and file are supported", rawURL)
+	}
+
+	return u, nil
+}
+
+// retry runs attempt until it succeeds, failed attempts are tried again after
+// a growing delay unless the failure is permanent
+func (f *Fetcher) retry(attempt func() error) error {
+	delay := f.backoff()
+
+	for count := 1; ; count++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+
+		fe, ok := err.(*FetchError)
+		if !ok {
+			return errors.Wrap(err)
+		}
+
+		// the download errors are returned as is so IsNotFound can tell them apart
+		if !fe.temporary() || count >= f.attempts() {
+			return fe
+		}
+
+		log.Warning("%v, trying again in %v", err, delay)
+		fetchSleep(delay)
+		delay *= 2
+	}
+}
+
+// Fetch writes the content of the URL to the file, failed downloads are tried
+// again after a growing delay unless the failure is permanent
+func (f *Fetcher) Fetch(rawURL string, path string) error {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return err
+	}
+
+	err = f.retry(func() error {
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = f.fetch(u, out, 0)
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+
+		return err
+	})
+
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	log.Debug("Downloaded %s to %s", rawURL, path)
+
+	return nil
+}
+
+// Resume continues the download of the URL to the file from its current size
+// with a range request, the download starts over when the server ignores the
+// range; the file is kept when the download may be resumed later
+func (f *Fetcher) Resume(rawURL string, path string) error {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return err
+	}
+
+	err = f.retry(func() error {
+		out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		fi, err := out.Stat()
+		if err == nil {
+			if fi.Size() > 0 {
+				log.Debug("Resuming the download of %s from %d bytes", rawURL, fi.Size())
+			}
+
+			err = f.fetch(u, out, fi.Size())
+		}
+
+		if cerr := out.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+
+		return err
+	})
+
+	if err != nil {
+		if fe, ok := err.(*FetchError); !ok || !fe.temporary() {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+
+	log.Debug("Downloaded %s to %s", rawURL, path)
+
+	return nil
+}
+
+// check requests the URL once, any response but an error status is accepted
+func (f *Fetcher) check(u *url.URL) error {
+	if u.Scheme == "file" {
+		if _, err := os.Stat(u.Path); err != nil {
+			return &FetchError{URL: u.String(), Err: err}
+		}
+
+		return nil
+	}
+
+	resp, err := httpClient(f.timeout()).Get(u.String())
+	if err != nil {
+		return &FetchError{URL: u.String(), Err: err}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxProbeBody))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &FetchError{URL: u.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+// Check verifies the URL is accessible without downloading its content
+func (f *Fetcher) Check(rawURL string) error {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if err = f.retry(func() error { return f.check(u) }); err != nil {
+		log.Debug("Checking %s failed: %v", rawURL, err)
+		return err
+	}
+
+	return nil
+}
+
+// FetchTemp downloads the URL to a new temporary file and returns its path
+func (f *Fetcher) FetchTemp(rawURL string, prefix string) (string, error) {
+	out, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	_ = out.Close()
+
+	if err = f.Fetch(rawURL, out.Name()); err != nil {
+		_ = os.Remove(out.Name())
+		return "", err
+	}
+
+	return out.Name(), nil
+}
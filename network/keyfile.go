@@ -0,0 +1,192 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+// keyfile is a NetworkManager connection keyfile, the sections are written in
+// the order they were added
+type keyfile struct {
+	sections []*keyfileSection
+}
+
+type keyfileSection struct {
+	name string
+	keys []string
+}
+
+// section adds a new section to the keyfile
+func (k *keyfile) section(name string) *keyfileSection {
+	s := &keyfileSection{name: name}
+	k.sections = append(k.sections, s)
+	return s
+}
+
+// set adds a key to the section, keys with empty values are skipped
+func (s *keyfileSection) set(key string, value string) *keyfileSection {
+	if value != "" {
+		s.keys = append(s.keys, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	return s
+}
+
+func (k *keyfile) String() string {
+	sections := []string{}
+
+	for _, curr := range k.sections {
+		lines := append([]string{"[" + curr.name + "]"}, curr.keys...)
+		sections = append(sections, strings.Join(lines, "\n")+"\n")
+	}
+
+	return strings.Join(sections, "\n")
+}
+
+// newKeyfile starts the keyfile of a connection of the interface
+func (i *Interface) newKeyfile(connType string) *keyfile {
+	k := &keyfile{}
+	k.section("connection").
+		set("id", i.ConnectionName()).
+		set("type", connType).
+		set("interface-name", i.keyfileInterfaceName()).
+		set("dns-over-tls", keyfileDNSOverTLS[i.DNSOverTLS])
+
+	return k
+}
+
+// keyfileInterfaceName returns the interface name the connection is bound to, none
+// for the matched interfaces
+func (i *Interface) keyfileInterfaceName() string {
+	if i.Match != nil {
+		return ""
+	}
+
+	return i.Name
+}
+
+// keyfileMethods returns the NetworkManager ipv4 and ipv6 methods of the interface
+func (i *Interface) keyfileMethods(addresses []string) (string, string) {
+	method := "auto"
+	if !i.DHCP && len(addresses) > 0 {
+		method = "manual"
+	} else if !i.DHCP {
+		method = "disabled"
+	}
+
+	method6 := "auto"
+	switch i.IPv6 {
+	case IPv6DHCP:
+		method6 = "dhcp"
+	case IPv6Static:
+		method6 = "manual"
+	case IPv6Disabled:
+		method6 = "disabled"
+	}
+
+	return method, method6
+}
+
+// keyfileList returns the values in the keyfile list syntax, empty for no values
+func keyfileList(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return strings.Join(values, ";") + ";"
+}
+
+// addIPSections adds the ipv4 and ipv6 sections of the interface addressing
+func (i *Interface) addIPSections(k *keyfile) error {
+	addresses, err := i.staticAddrs(IPv4)
+	if err != nil {
+		return err
+	}
+
+	addresses6, err := i.staticAddrs(IPv6)
+	if err != nil {
+		return err
+	}
+
+	method, method6 := i.keyfileMethods(addresses)
+
+	var dns, dns6, domain string
+	if i.staticDNS() {
+		servers, servers6 := i.dnsByVersion()
+		dns, dns6 = keyfileList(servers), keyfileList(servers6)
+		domain = keyfileList(i.DNSDomains)
+	}
+
+	ipv4 := k.section("ipv4").set("method", method)
+	for idx, curr := range addresses {
+		ipv4.set(fmt.Sprintf("address%d", idx+1), curr)
+	}
+
+	if !i.DHCP {
+		ipv4.set("gateway", i.Gateway)
+	}
+	ipv4.set("dns", dns).set("dns-search", domain)
+	i.addKeyfileRoutes(ipv4, IPv4)
+
+	ipv6 := k.section("ipv6").set("method", method6)
+	for idx, curr := range addresses6 {
+		ipv6.set(fmt.Sprintf("address%d", idx+1), curr)
+	}
+
+	if i.IPv6 != "" && i.IPv6 != IPv6Disabled {
+		ipv6.set("gateway", i.Gateway6)
+	}
+	ipv6.set("dns", dns6)
+	i.addKeyfileRoutes(ipv6, IPv6)
+
+	return nil
+}
+
+// addKeyfileRoutes adds the routes of a given version to the section, the routing
+// table is set as a route option
+func (i *Interface) addKeyfileRoutes(s *keyfileSection, version int) {
+	idx := 0
+
+	for _, curr := range i.Routes {
+		if curr.version() != version {
+			continue
+		}
+
+		idx++
+		s.set(fmt.Sprintf("route%d", idx), curr.keyfileRoute())
+
+		if curr.Table != 0 {
+			s.set(fmt.Sprintf("route%d_options", idx), fmt.Sprintf("table=%d", curr.Table))
+		}
+	}
+}
+
+// addKeyfileProxy adds the proxy section of the connection, the proxy auto-config
+// is handed by NetworkManager to pacrunner
+func addKeyfileProxy(k *keyfile, pac string) {
+	if pac != "" {
+		k.section("proxy").set("method", "1").set("pac-url", pac)
+	}
+}
+
+// removeKeyfileSection returns the keyfile content without the named section
+func removeKeyfileSection(content string, name string) string {
+	result := []string{}
+	skip := false
+
+	for _, curr := range strings.Split(content, "\n") {
+		if line := strings.TrimSpace(curr); strings.HasPrefix(line, "[") {
+			skip = line == "["+name+"]"
+		}
+
+		if !skip {
+			result = append(result, curr)
+		}
+	}
+
+	return strings.TrimRight(strings.Join(result, "\n"), "\n") + "\n"
+}
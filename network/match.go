@@ -0,0 +1,393 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Match identifies the network device of an interface independently of its kernel
+// name, the driver and path may be shell globs
+type Match struct {
+	MAC    string `yaml:"mac,omitempty"`
+	Driver string `yaml:"driver,omitempty"`
+	Path   string `yaml:"path,omitempty"`
+}
+
+// device is a network device of the running system as seen by the matches
+type device struct {
+	name   string
+	mac    string
+	driver string
+	path   string
+}
+
+// Validate checks at least one valid property is matched
+func (m *Match) Validate() error {
+	if m.MAC == "" && m.Driver == "" && m.Path == "" {
+		return errors.ValidationErrorf("A match requires a mac, driver or path")
+	}
+
+	if m.MAC != "" {
+		if _, err := net.ParseMAC(m.MAC); err != nil {
+			return errors.ValidationErrorf("Invalid mac address: %s", m.MAC)
+		}
+	}
+
+	for _, curr := range []string{m.Driver, m.Path} {
+		if _, err := filepath.Match(curr, ""); err != nil {
+			return errors.ValidationErrorf("Invalid match pattern: %s", curr)
+		}
+	}
+
+	return nil
+}
+
+// String returns the matched properties
+func (m *Match) String() string {
+	if m == nil {
+		return ""
+	}
+
+	values := []string{}
+	for _, curr := range []struct{ key, value string }{
+		{"mac", m.MAC},
+		{"driver", m.Driver},
+		{"path", m.Path},
+	} {
+		if curr.value != "" {
+			values = append(values, curr.key+"="+curr.value)
+		}
+	}
+
+	return strings.Join(values, " ")
+}
+
+// mac returns the normalized mac address, empty if not matched
+func (m *Match) mac() string {
+	hw, err := net.ParseMAC(m.MAC)
+	if err != nil {
+		return ""
+	}
+
+	return hw.String()
+}
+
+// matches returns true if the device has all the matched properties
+func (m *Match) matches(dev *device) bool {
+	if m.MAC != "" && m.mac() != dev.mac {
+		return false
+	}
+
+	if ok, _ := filepath.Match(m.Driver, dev.driver); m.Driver != "" && !ok {
+		return false
+	}
+
+	if ok, _ := filepath.Match(m.Path, dev.path); m.Path != "" && !ok {
+		return false
+	}
+
+	return true
+}
+
+// validateMatch checks the match of the interface, only the physical devices
+// can be matched and renamed
+func (i *Interface) validateMatch() error {
+	if i.Match == nil {
+		if i.Rename {
+			return errors.ValidationErrorf("Interface %s: rename requires a match", i.Name)
+		}
+
+		return nil
+	}
+
+	if i.IsVirtual() {
+		return errors.ValidationErrorf("Interface %s: a %s can not have a match", i.Name, i.Kind())
+	}
+
+	if err := i.Match.Validate(); err != nil {
+		return errors.ValidationErrorf("Interface %s: %s", i.Name, err)
+	}
+
+	if i.Rename && len(i.Name) > maxInterfaceName {
+		return errors.ValidationErrorf("Interface %s: the name must have at most %d characters",
+			i.Name, maxInterfaceName)
+	}
+
+	return nil
+}
+
+// matchLines returns the systemd [Match] section lines of the interface, the
+// interfaces without a match are matched by name. The permanent mac address is
+// matched as the bond members share the mac address of the bond.
+func (i *Interface) matchLines() []string {
+	if i.Match == nil {
+		return []string{"Name=" + i.Name}
+	}
+
+	result := []string{}
+
+	if i.Match.MAC != "" {
+		result = append(result, "PermanentMACAddress="+i.Match.mac())
+	}
+
+	if i.Match.Driver != "" {
+		result = append(result, "Driver="+i.Match.Driver)
+	}
+
+	if i.Match.Path != "" {
+		result = append(result, "Path="+i.Match.Path)
+	}
+
+	return result
+}
+
+// networkManagerIfname returns the interface name the NetworkManager connection
+// is bound to, the matched interfaces are bound to any interface
+func (i *Interface) networkManagerIfname() string {
+	if i.Match != nil {
+		return "*"
+	}
+
+	return i.Name
+}
+
+// networkManagerMatchArgs returns the nmcli arguments matching the device of the interface
+func (i *Interface) networkManagerMatchArgs(devType string) []string {
+	if i.Match == nil {
+		return []string{}
+	}
+
+	args := []string{}
+
+	if i.Match.MAC != "" {
+		args = append(args, devType+".mac-address", i.Match.mac())
+	}
+
+	if i.Match.Driver != "" {
+		args = append(args, "match.driver", i.Match.Driver)
+	}
+
+	if i.Match.Path != "" {
+		args = append(args, "match.path", i.Match.Path)
+	}
+
+	return args
+}
+
+// addKeyfileMatch adds the keys matching the device of the interface to the
+// keyfile, the mac address is a key of the device type section
+func (i *Interface) addKeyfileMatch(k *keyfile, dev *keyfileSection) {
+	if i.Match == nil {
+		return
+	}
+
+	dev.set("mac-address", i.Match.mac())
+
+	if i.Match.Driver != "" || i.Match.Path != "" {
+		k.section("match").
+			set("driver", i.Match.Driver).
+			set("path", i.Match.Path)
+	}
+}
+
+// linkConfig returns the systemd.link configuration renaming the matched device
+func (i *Interface) linkConfig() string {
+	return fmt.Sprintf("[Match]\n%s\n\n[Link]\nName=%s\n", strings.Join(i.matchLines(), "\n"), i.Name)
+}
+
+// devices lists the network devices of the running system, the path is only
+// looked up if requested as it requires udev
+func devices(withPath bool) ([]*device, error) {
+	entries, err := ioutil.ReadDir(sysClassNetDir)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	result := []*device{}
+
+	for _, curr := range entries {
+		dir := filepath.Join(sysClassNetDir, curr.Name())
+
+		// Virtual devices, including the loopback, are not backed by a device
+		if _, err = os.Stat(filepath.Join(dir, "device")); err != nil {
+			continue
+		}
+
+		dev := &device{name: curr.Name()}
+
+		// The bond members report the mac address of the bond, except in bonding_slave
+		for _, name := range []string{"bonding_slave/perm_hwaddr", "address"} {
+			if content, err := ioutil.ReadFile(filepath.Join(dir, name)); err == nil {
+				dev.mac = strings.TrimSpace(string(content))
+				break
+			}
+		}
+
+		if driver, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+			dev.driver = filepath.Base(driver)
+		}
+
+		if withPath {
+			if dev.path, err = devicePath(dir); err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, dev)
+	}
+
+	return result, nil
+}
+
+// devicePath returns the udev ID_PATH of the network device
+func devicePath(dir string) (string, error) {
+	w := bytes.NewBuffer(nil)
+	if err := cmd.Run(w, "udevadm", "info", "--query=property", "--path="+dir); err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	for _, curr := range strings.Split(w.String(), "\n") {
+		if strings.HasPrefix(curr, "ID_PATH=") {
+			return strings.TrimPrefix(curr, "ID_PATH="), nil
+		}
+	}
+
+	return "", nil
+}
+
+// ResolveNames resolves the kernel name of the matched interfaces in the running
+// system, a match must not resolve to several devices nor two interfaces to the
+// same device. Matches not resolved are only reported as the configuration may
+// target different hardware.
+func ResolveNames(ifaces []*Interface) error {
+	withPath := false
+	matched := false
+
+	for _, curr := range ifaces {
+		if curr.Match != nil {
+			matched = true
+			withPath = withPath || curr.Match.Path != ""
+		}
+	}
+
+	if !matched {
+		return nil
+	}
+
+	devs, err := devices(withPath)
+	if err != nil {
+		return err
+	}
+
+	owners := map[string]string{}
+
+	for _, curr := range ifaces {
+		if curr.Match == nil {
+			continue
+		}
+
+		found := []string{}
+		for _, dev := range devs {
+			if curr.Match.matches(dev) {
+				found = append(found, dev.name)
+			}
+		}
+
+		switch len(found) {
+		case 0:
+			curr.Device = ""
+			log.Warning("Interface %s does not match any network device: %s", curr.Name, curr.Match)
+			continue
+		case 1:
+			curr.Device = found[0]
+		default:
+			return errors.Errorf("Interface %s matches several network devices: %s", curr.Name,
+				strings.Join(found, ", "))
+		}
+
+		if owner, ok := owners[curr.Device]; ok {
+			return errors.Errorf("Interfaces %s and %s match the same network device %s", owner, curr.Name,
+				curr.Device)
+		}
+		owners[curr.Device] = curr.Name
+
+		log.Info("Interface %s matches the network device %s", curr.Name, curr.Device)
+	}
+
+	return nil
+}
+
+// WriteLinkFiles writes the systemd.link files renaming the matched devices of the
+// interfaces requesting it in the target, the vlan connections copied from the
+// installer are moved to the new name of their parent
+func WriteLinkFiles(rootDir string, ifaces []*Interface) error {
+	for _, curr := range ifaces {
+		if !curr.Rename || curr.Match == nil {
+			continue
+		}
+
+		dir := filepath.Join(rootDir, systemdNetworkdDir)
+		if err := utils.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrap(err)
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("10-%s.link", curr.Name))
+		if err := writeFileMode(path, curr.linkConfig(), 0644); err != nil {
+			return err
+		}
+
+		log.Info("Wrote %s renaming the device matching %s", path, curr.Match)
+	}
+
+	return writeRenamedVLANs(rootDir, ifaces)
+}
+
+// writeRenamedVLANs rewrites the vlan connections of the target created on the
+// running system device of a renamed parent
+func writeRenamedVLANs(rootDir string, ifaces []*Interface) error {
+	renamed := map[string]bool{}
+	for _, curr := range ifaces {
+		if curr.Rename && curr.Device != "" && curr.Device != curr.Name {
+			renamed[curr.Name] = true
+		}
+	}
+
+	for _, curr := range ifaces {
+		if curr.VLAN == nil || !renamed[curr.VLAN.Parent] {
+			continue
+		}
+
+		path := filepath.Join(rootDir, networkManagerDir, fmt.Sprintf("%s.nmconnection", curr.ConnectionName()))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		config, err := curr.virtualKeyfile(nil, proxy.TargetPAC())
+		if err != nil {
+			return err
+		}
+
+		if err = writeFileMode(path, config, 0600); err != nil {
+			return err
+		}
+
+		log.Info("Moved the vlan %s to the renamed parent %s", curr.Name, curr.VLAN.Parent)
+	}
+
+	return nil
+}
@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/coreos/go-systemd/v22/dbus"
 	"gopkg.in/yaml.v2"
@@ -24,6 +25,7 @@ import (
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -32,24 +34,46 @@ type Interface struct {
 	Name        string
 	Addrs       []*Addr
 	DHCP        bool
-	Gateway     string `json:"gateway,omitempty"`
-	DNSServer   string
-	DNSDomain   string
+	Gateway     string    `json:"gateway,omitempty"`
+	IPv6        string    `json:"-"`
+	Gateway6    string    `json:"-"`
+	Wireless    *Wireless `json:"-"`
+	Bond        *Bond     `json:"-"`
+	VLAN        *VLAN     `json:"-"`
+	Bridge      *Bridge   `json:"-"`
+	DNSServers  []string  `json:"-"`
+	DNSDomains  []string  `json:"-"`
+	DNSOverTLS  string    `json:"-"`
+	Routes      []*Route  `json:"-"`
+	Match       *Match    `json:"-"`
+	Rename      bool      `json:"-"`
+	Device      string    `json:"-"`
 	UserDefined bool
 	Metric      uint32 `json:"metric,omitempty"`
 }
 
 // Version used for reading and writing YAML
 type interfaceYAMLMarshal struct {
-	Name      string  `yaml:"name,omitempty"`
-	Addrs     []*Addr `yaml:"addrs,omitempty"`
-	DHCP      string  `yaml:"dhcp,omitempty"`
-	Gateway   string  `yaml:"gateway,omitempty"`
-	DNSServer string  `yaml:"dns,omitempty"`
-	DNSDomain string  `yaml:"domain,omitempty"`
+	Name       string     `yaml:"name,omitempty"`
+	Addrs      []*Addr    `yaml:"addrs,omitempty"`
+	DHCP       string     `yaml:"dhcp,omitempty"`
+	Gateway    string     `yaml:"gateway,omitempty"`
+	IPv6       string     `yaml:"ipv6,omitempty"`
+	Gateway6   string     `yaml:"gateway6,omitempty"`
+	DNSServers stringList `yaml:"dns,omitempty"`
+	DNSDomains stringList `yaml:"domain,omitempty"`
+	DNSOverTLS string     `yaml:"dnsOverTls,omitempty"`
+	Routes     []*Route   `yaml:"routes,omitempty"`
+	Match      *Match     `yaml:"match,omitempty"`
+	Rename     bool       `yaml:"rename,omitempty"`
+	Wireless   *Wireless  `yaml:"wifi,omitempty"`
+	Bond       *Bond      `yaml:"bond,omitempty"`
+	VLAN       *VLAN      `yaml:"vlan,omitempty"`
+	Bridge     *Bridge    `yaml:"bridge,omitempty"`
 }
 
-// Addr wraps the net' package Addr struct
+// Addr wraps the net' package Addr struct, the IP may be given in CIDR
+// notation in which case the NetMask is not required
 type Addr struct {
 	IP      string
 	NetMask string
@@ -79,6 +103,18 @@ const (
 	// IPv6 identifies the addr version as ipv6
 	IPv6
 
+	// IPv6SLAAC configures the ipv6 addresses with router advertisements
+	IPv6SLAAC = "slaac"
+
+	// IPv6DHCP configures the ipv6 addresses with DHCPv6
+	IPv6DHCP = "dhcp"
+
+	// IPv6Static configures only the given ipv6 addresses
+	IPv6Static = "static"
+
+	// IPv6Disabled disables ipv6 on the interface
+	IPv6Disabled = "disabled"
+
 	systemdNetworkdDir = "/etc/systemd/network"
 	networkManagerDir  = "/etc/NetworkManager/system-connections"
 
@@ -101,14 +137,16 @@ var (
 
 	numericOnlyExp = regexp.MustCompile(`^[0-9]+[0-9]*$`)
 
-	validIPExp = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)` +
-		`(\.{1})){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?){1}$`)
-	dnsExp    = regexp.MustCompile(`Current DNS Server:(.*)`)
-	domainExp = regexp.MustCompile(`DNS Domain:(.*)`)
+	dnsExp        = regexp.MustCompile(`Current DNS Server:(.*)`)
+	dnsServersExp = regexp.MustCompile(`DNS Servers:(.*)`)
+	domainExp     = regexp.MustCompile(`DNS Domain:(.*)`)
 
 	needPacDiscover = false
 
 	installDataURLBase = "https://cdn.download.clearlinux.org/releases/%s/clear/config/image/.data/%s"
+
+	// installerMessageTimeout is the time allowed to download an installer message
+	installerMessageTimeout = 5 * time.Second
 )
 
 // IsValidDomainName returns error message or nil if is valid
@@ -203,8 +241,18 @@ func (i *Interface) MarshalYAML() (interface{}, error) {
 	im.Addrs = i.Addrs
 	im.DHCP = strconv.FormatBool(i.DHCP)
 	im.Gateway = i.Gateway
-	im.DNSServer = i.DNSServer
-	im.DNSDomain = i.DNSDomain
+	im.IPv6 = i.IPv6
+	im.Gateway6 = i.Gateway6
+	im.DNSServers = i.DNSServers
+	im.DNSDomains = i.DNSDomains
+	im.DNSOverTLS = i.DNSOverTLS
+	im.Routes = i.Routes
+	im.Match = i.Match
+	im.Rename = i.Rename
+	im.Wireless = i.Wireless
+	im.Bond = i.Bond
+	im.VLAN = i.VLAN
+	im.Bridge = i.Bridge
 
 	return im, nil
 }
@@ -220,10 +268,26 @@ func (i *Interface) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	i.Name = im.Name
 	i.Addrs = im.Addrs
 	i.Gateway = im.Gateway
-	i.DNSServer = im.DNSServer
-	i.DNSDomain = im.DNSDomain
+	i.IPv6 = im.IPv6
+	i.Gateway6 = im.Gateway6
+	i.DNSServers = im.DNSServers
+	i.DNSDomains = im.DNSDomains
+	i.DNSOverTLS = im.DNSOverTLS
+	i.Routes = im.Routes
+	i.Match = im.Match
+	i.Rename = im.Rename
+	i.Wireless = im.Wireless
+	i.Bond = im.Bond
+	i.VLAN = im.VLAN
+	i.Bridge = im.Bridge
 	i.UserDefined = false
 
+	// The version is determined by the address itself, the ipv6 mode is
+	// never inferred as the saved addresses may have been autoconfigured
+	for _, curr := range i.Addrs {
+		curr.Version = curr.version()
+	}
+
 	if im.DHCP != "" {
 		dhcp, err := strconv.ParseBool(im.DHCP)
 		if err != nil {
@@ -241,10 +305,21 @@ func (i *Interface) AddAddr(IP string, NetMask string, Version int) {
 	i.Addrs = append(i.Addrs, &Addr{IP: IP, NetMask: NetMask, Version: Version})
 }
 
-// HasIPv4Addr will lookup an addr with Version set to ipv4
+// HasIPv4Addr will lookup an ipv4 addr
 func (i *Interface) HasIPv4Addr() bool {
 	for _, curr := range i.Addrs {
-		if curr.Version == IPv4 {
+		if curr.version() == IPv4 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasIPv6Addr will lookup a non link-local ipv6 addr
+func (i *Interface) HasIPv6Addr() bool {
+	for _, curr := range i.Addrs {
+		if curr.version() == IPv6 && !curr.IsLinkLocal() {
 			return true
 		}
 	}
@@ -254,6 +329,15 @@ func (i *Interface) HasIPv4Addr() bool {
 
 // GetGateway returns the best gateway for the interface
 func (i *Interface) GetGateway() (string, error) {
+	return i.routeGateway("-4")
+}
+
+// GetIPv6Gateway returns the best ipv6 gateway for the interface
+func (i *Interface) GetIPv6Gateway() (string, error) {
+	return i.routeGateway("-6")
+}
+
+func (i *Interface) routeGateway(family string) (string, error) {
 	const (
 		maxUint32 = 1<<32 - 1
 	)
@@ -262,7 +346,7 @@ func (i *Interface) GetGateway() (string, error) {
 
 	w := bytes.NewBuffer(nil)
 	// TODO: Should we remove the absolute path? Absolute file path is used to ensure pkexec doesn't mess up PATH.
-	err := cmd.Run(w, "/usr/bin/ip", "-j", "route", "show", "dev", i.Name)
+	err := cmd.Run(w, "/usr/bin/ip", family, "-j", "route", "show", "dev", i.Name)
 	if err != nil {
 		return "", errors.Wrap(err)
 	}
@@ -286,15 +370,16 @@ func (i *Interface) GetGateway() (string, error) {
 	return gateway, nil
 }
 
-// GetDNSInfo returns the DNS Server and Domain
-func (i *Interface) GetDNSInfo() (string, string, error) {
-	var dns string
-	var domain string
+// GetDNSInfo returns the DNS Servers and Domains
+func (i *Interface) GetDNSInfo() ([]string, []string, error) {
+	var dns []string
+	var current string
+	var domains []string
 
 	w := bytes.NewBuffer(nil)
 	err := cmd.Run(w, "resolvectl", "--no-pager", "status", i.Name)
 	if err != nil {
-		return dns, domain, errors.Wrap(err)
+		return dns, domains, errors.Wrap(err)
 	}
 
 	lines := strings.Split(w.String(), "\n")
@@ -304,28 +389,37 @@ func (i *Interface) GetDNSInfo() (string, string, error) {
 		}
 
 		if dnsExp.MatchString(curr) {
-			dns = strings.TrimSpace(dnsExp.ReplaceAllString(curr, `$1`))
+			current = strings.TrimSpace(dnsExp.ReplaceAllString(curr, `$1`))
+			continue
+		}
+
+		if dnsServersExp.MatchString(curr) {
+			dns = strings.Fields(dnsServersExp.ReplaceAllString(curr, `$1`))
 			continue
 		}
 
 		if domainExp.MatchString(curr) {
-			domain = strings.TrimSpace(domainExp.ReplaceAllString(curr, `$1`))
+			domains = strings.Fields(domainExp.ReplaceAllString(curr, `$1`))
 			continue
 		}
 	}
 
-	if dns == "" && domain == "" {
+	if len(dns) == 0 && current != "" {
+		dns = []string{current}
+	}
+
+	if len(dns) == 0 && len(domains) == 0 {
 		log.Debug("Could not parse DNS Server nor Domain for %s", i.Name)
 	} else {
-		if domain == "" {
+		if len(domains) == 0 {
 			log.Debug("Could not parse DNS Server for %s", i.Name)
 		}
-		if dns == "" {
+		if len(dns) == 0 {
 			log.Debug("Could not parse DNS Domain for %s", i.Name)
 		}
 	}
 
-	return dns, domain, err
+	return dns, domains, err
 }
 
 // VersionString returns a string representation for a given addr version (ipv4/ipv6)
@@ -337,6 +431,156 @@ func (a *Addr) VersionString() string {
 	return "ipv6"
 }
 
+// version returns the addr version as determined by its IP, falling back
+// to the configured Version if the IP can not be parsed
+func (a *Addr) version() int {
+	ip := net.ParseIP(strings.SplitN(a.IP, "/", 2)[0])
+	if ip == nil {
+		return a.Version
+	}
+
+	if ip.To4() != nil {
+		return IPv4
+	}
+
+	return IPv6
+}
+
+// IsLinkLocal returns true if the addr is a link-local address, which is never
+// configured statically
+func (a *Addr) IsLinkLocal() bool {
+	ip := net.ParseIP(strings.SplitN(a.IP, "/", 2)[0])
+	return ip != nil && ip.IsLinkLocalUnicast()
+}
+
+// CIDR returns the addr in CIDR notation, the prefix length is taken from the IP
+// when given in CIDR notation, otherwise from the NetMask which is either a
+// netmask or a prefix length
+func (a *Addr) CIDR() (string, error) {
+	if strings.Contains(a.IP, "/") {
+		ip, _, err := net.ParseCIDR(a.IP)
+		if err != nil {
+			return "", errors.Errorf("Invalid address: %s", a.IP)
+		}
+
+		prefix := strings.SplitN(a.IP, "/", 2)[1]
+		return fmt.Sprintf("%s/%s", ip.String(), prefix), nil
+	}
+
+	ip := net.ParseIP(a.IP)
+	if ip == nil {
+		return "", errors.Errorf("Invalid address: %s", a.IP)
+	}
+
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+
+	if a.NetMask == "" {
+		return "", errors.Errorf("Missing netmask for address: %s", a.IP)
+	}
+
+	prefix, err := strconv.Atoi(a.NetMask)
+	if err != nil {
+		prefix, err = netMaskToCIDR(a.NetMask)
+		if err != nil {
+			return "", err
+		}
+
+		if (net.ParseIP(a.NetMask).To4() != nil) != (bits == 32) {
+			return "", errors.Errorf("Netmask %s does not match address: %s", a.NetMask, a.IP)
+		}
+	}
+
+	if prefix < 0 || prefix > bits {
+		return "", errors.Errorf("Invalid prefix length %d for address: %s", prefix, a.IP)
+	}
+
+	return fmt.Sprintf("%s/%d", ip.String(), prefix), nil
+}
+
+// Validate checks the addresses, gateways and ipv6 mode of the interface
+func (i *Interface) Validate() error {
+	if i.Name == "" {
+		return errors.ValidationErrorf("Network interface name is required")
+	}
+
+	if msg := IsValidIPv6Mode(i.IPv6); msg != "" {
+		return errors.ValidationErrorf("Interface %s: %s", i.Name, msg)
+	}
+
+	if err := i.validateVirtual(); err != nil {
+		return err
+	}
+
+	if err := i.validateMatch(); err != nil {
+		return err
+	}
+
+	if i.IsWireless() {
+		if err := i.Wireless.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, curr := range i.Addrs {
+		if _, err := curr.CIDR(); err != nil {
+			return errors.ValidationErrorf("Interface %s: %s", i.Name, err)
+		}
+	}
+
+	if i.Gateway != "" {
+		if ip := net.ParseIP(i.Gateway); ip == nil || ip.To4() == nil {
+			return errors.ValidationErrorf("Interface %s: invalid ipv4 gateway: %s", i.Name, i.Gateway)
+		}
+
+		if !i.DHCP && !i.HasIPv4Addr() {
+			return errors.ValidationErrorf("Interface %s: an ipv4 gateway requires an ipv4 address", i.Name)
+		}
+	}
+
+	if i.Gateway6 != "" {
+		if ip := net.ParseIP(i.Gateway6); ip == nil || ip.To4() != nil {
+			return errors.ValidationErrorf("Interface %s: invalid ipv6 gateway: %s", i.Name, i.Gateway6)
+		}
+	}
+
+	if err := validateDNSServers(i.DNSServers); err != nil {
+		return errors.ValidationErrorf("Interface %s: %s", i.Name, err)
+	}
+
+	if err := validateDomains(i.DNSDomains); err != nil {
+		return errors.ValidationErrorf("Interface %s: %s", i.Name, err)
+	}
+
+	if msg := IsValidDNSOverTLS(i.DNSOverTLS); msg != "" {
+		return errors.ValidationErrorf("Interface %s: %s", i.Name, msg)
+	}
+
+	for _, curr := range i.Routes {
+		if err := curr.Validate(); err != nil {
+			return errors.ValidationErrorf("Interface %s: %s", i.Name, err)
+		}
+	}
+
+	switch i.IPv6 {
+	case IPv6Static:
+		if !i.HasIPv6Addr() {
+			return errors.ValidationErrorf("Interface %s: static ipv6 requires an ipv6 address", i.Name)
+		}
+	case IPv6Disabled, "":
+		if i.Gateway6 != "" {
+			return errors.ValidationErrorf("Interface %s: an ipv6 gateway requires an ipv6 mode", i.Name)
+		}
+		if i.IPv6 == IPv6Disabled && i.HasIPv6Addr() {
+			return errors.ValidationErrorf("Interface %s: ipv6 addresses given but ipv6 is disabled", i.Name)
+		}
+	}
+
+	return nil
+}
+
 func isDHCP(iface string) (bool, error) {
 	w := bytes.NewBuffer(nil)
 	err := cmd.Run(w, "/usr/bin/ip", "route", "show")
@@ -389,6 +633,8 @@ func Interfaces() ([]*Interface, error) {
 
 			if ip.To4() == nil {
 				addr.Version = IPv6
+				ones, _ := ipNet.Mask.Size()
+				addr.NetMask = strconv.Itoa(ones)
 			}
 
 			iface.Addrs = append(iface.Addrs, addr)
@@ -404,7 +650,12 @@ func Interfaces() ([]*Interface, error) {
 			return nil, err
 		}
 
-		iface.DNSServer, iface.DNSDomain, err = iface.GetDNSInfo()
+		// ipv6 may be disabled in the kernel, it must not prevent the listing
+		if iface.Gateway6, err = iface.GetIPv6Gateway(); err != nil {
+			log.Warning("Could not read the ipv6 gateway of %s: %v", iface.Name, err)
+		}
+
+		iface.DNSServers, iface.DNSDomains, err = iface.GetDNSInfo()
 		if err != nil {
 			return nil, err
 		}
@@ -414,31 +665,22 @@ func Interfaces() ([]*Interface, error) {
 }
 
 func netMaskToCIDR(mask string) (num int, err error) {
-	var tks = strings.Split(mask, ".")
-	if len(tks) != 4 {
+	ip := net.ParseIP(mask)
+	if ip == nil {
 		return 0, errors.Errorf("Invalid mask: %s", mask)
 	}
 
-	var result uint32
-	for _, octet := range tks {
-		bt, err := strconv.ParseInt(octet, 10, 16)
-
-		if err != nil {
-			return 0, errors.Wrap(err)
-		}
-
-		result = result << 8
-		result += uint32(bt)
+	ipMask := net.IPMask(ip.To16())
+	if ip4 := ip.To4(); ip4 != nil && strings.Contains(mask, ".") {
+		ipMask = net.IPMask(ip4)
 	}
 
-	bits := 0
-	for result > 0 {
-		rem := result & 1
-		bits += int(rem)
-		result = result >> 1
+	ones, bits := ipMask.Size()
+	if bits == 0 {
+		return 0, errors.Errorf("Invalid mask, the bits are not contiguous: %s", mask)
 	}
 
-	return bits, nil
+	return ones, nil
 }
 
 // IsNetworkManagerActive is used to
@@ -458,11 +700,11 @@ func IsNetworkManagerActive() bool {
 
 	// Make the new dbus connection
 	conn, err := dbus.New()
-	defer conn.Close()
 	if err != nil {
 		log.Warning("Failed to connect to dbus")
 		return networkManager
 	}
+	defer conn.Close()
 
 	// Get the list of Units
 	units, err := conn.ListUnits()
@@ -499,50 +741,204 @@ func IsNetworkManagerActive() bool {
 	return networkManager
 }
 
-func (i *Interface) applyNetworkDStatic(root string, file *os.File) error {
-	needPacDiscover = true
+// isAutomatic returns true if both ipv4 and ipv6 are left to the system defaults
+// of a wired interface without routes nor DNS-over-TLS in which case no configuration
+// is written for the interface
+func (i *Interface) isAutomatic() bool {
+	return i.DHCP && i.IPv6 == "" && i.DNSOverTLS == "" && len(i.Routes) == 0 && !i.IsWireless() &&
+		!i.IsVirtual()
+}
 
-	config := `[Match]
-Name={{.Name}}
+// staticAddrs returns the addresses of a given version to configure statically
+// in CIDR notation, ipv4 addresses obtained by DHCP, ipv6 addresses without the
+// static mode and link-local addresses are skipped
+func (i *Interface) staticAddrs(version int) ([]string, error) {
+	result := []string{}
 
-[Network]
-DNS={{.DNSServer}}
-Address={{.Address}}
-Gateway={{.Gateway}}
-Domains={{.DNSDomain}}
-`
+	if version == IPv4 && i.DHCP {
+		return result, nil
+	}
 
-	var address string
+	if version == IPv6 && i.IPv6 != IPv6Static {
+		return result, nil
+	}
 
 	for _, curr := range i.Addrs {
-		if curr.Version != IPv4 {
+		if curr.version() != version || curr.IsLinkLocal() {
 			continue
 		}
 
-		cidrd, err := netMaskToCIDR(curr.NetMask)
+		cidr, err := curr.CIDR()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		address = fmt.Sprintf("%s/%d", curr.IP, cidrd)
+		result = append(result, cidr)
 	}
 
-	template := template.Must(template.New("").Parse(config))
-	err := template.Execute(file, struct {
-		Name      string
-		DNSServer string
-		Address   string
-		Gateway   string
-		DNSDomain string
+	return result, nil
+}
+
+// staticDNS returns true if the DNS settings are to be configured statically
+func (i *Interface) staticDNS() bool {
+	return !i.DHCP || i.IPv6 == IPv6Static
+}
+
+// dnsByVersion splits the DNS servers in ipv4 and ipv6 servers
+func (i *Interface) dnsByVersion() ([]string, []string) {
+	dns, dns6 := []string{}, []string{}
+
+	for _, curr := range i.DNSServers {
+		if strings.Contains(strings.SplitN(curr, "#", 2)[0], ":") {
+			dns6 = append(dns6, curr)
+		} else {
+			dns = append(dns, curr)
+		}
+	}
+
+	return dns, dns6
+}
+
+// networkDConfig returns the systemd.network configuration of the interface, the
+// links to the virtual devices are added to the [Network] section
+func (i *Interface) networkDConfig(links []string) (string, error) {
+	config := `[Match]
+{{- range .Match}}
+{{.}}
+{{- end}}
+
+[Network]
+{{- range .Links}}
+{{.}}
+{{- end}}
+{{- if .DHCP}}
+DHCP={{.DHCP}}
+{{- end}}
+{{- if .AcceptRA}}
+IPv6AcceptRA={{.AcceptRA}}
+{{- end}}
+{{- if .LinkLocal}}
+LinkLocalAddressing={{.LinkLocal}}
+{{- end}}
+{{- range .DNSServers}}
+DNS={{.}}
+{{- end}}
+{{- if .DNSOverTLS}}
+DNSOverTLS={{.DNSOverTLS}}
+{{- end}}
+{{- range .Addresses}}
+Address={{.}}
+{{- end}}
+{{- range .Gateways}}
+Gateway={{.}}
+{{- end}}
+{{- if .DNSDomains}}
+Domains={{.DNSDomains}}
+{{- end}}
+{{- range .Routes}}
+
+[Route]
+Destination={{.To}}
+{{- if .Via}}
+Gateway={{.Via}}
+{{- end}}
+{{- if .Metric}}
+Metric={{.Metric}}
+{{- end}}
+{{- if .Table}}
+Table={{.Table}}
+{{- end}}
+{{- end}}
+`
+
+	addresses, err := i.staticAddrs(IPv4)
+	if err != nil {
+		return "", err
+	}
+
+	addresses6, err := i.staticAddrs(IPv6)
+	if err != nil {
+		return "", err
+	}
+
+	var dhcp, acceptRA, linkLocal string
+	gateways := []string{}
+
+	if !i.DHCP && i.Gateway != "" {
+		gateways = append(gateways, i.Gateway)
+	}
+
+	switch i.IPv6 {
+	case IPv6SLAAC:
+		acceptRA = "yes"
+	case IPv6DHCP:
+		acceptRA = "yes"
+	case IPv6Static:
+		acceptRA = "no"
+	case IPv6Disabled:
+		acceptRA = "no"
+		linkLocal = "no"
+	}
+
+	if i.IPv6 != "" && i.IPv6 != IPv6Disabled && i.Gateway6 != "" {
+		gateways = append(gateways, i.Gateway6)
+	}
+
+	if i.DHCP && i.IPv6 == IPv6DHCP {
+		dhcp = "yes"
+	} else if i.DHCP {
+		dhcp = "ipv4"
+	} else if i.IPv6 == IPv6DHCP {
+		dhcp = "ipv6"
+	}
+
+	data := struct {
+		Match      []string
+		DHCP       string
+		AcceptRA   string
+		LinkLocal  string
+		DNSServers []string
+		DNSOverTLS string
+		Addresses  []string
+		Gateways   []string
+		DNSDomains string
+		Routes     []*Route
+		Links      []string
 	}{
-		Name:      i.Name,
-		DNSServer: i.DNSServer,
-		Gateway:   i.Gateway,
-		DNSDomain: i.DNSDomain,
-		Address:   address,
-	})
+		Match:      i.matchLines(),
+		DHCP:       dhcp,
+		AcceptRA:   acceptRA,
+		LinkLocal:  linkLocal,
+		DNSOverTLS: i.DNSOverTLS,
+		Addresses:  append(addresses, addresses6...),
+		Gateways:   gateways,
+		Routes:     i.Routes,
+		Links:      links,
+	}
 
+	if i.staticDNS() {
+		data.DNSServers = i.DNSServers
+		data.DNSDomains = strings.Join(i.DNSDomains, " ")
+	}
+
+	w := bytes.NewBuffer(nil)
+	tmpl := template.Must(template.New("").Parse(config))
+	if err := tmpl.Execute(w, data); err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return w.String(), nil
+}
+
+func (i *Interface) applyNetworkDStatic(root string, file *os.File, links *deviceLinks) error {
+	needPacDiscover = true
+
+	config, err := i.networkDConfig(links.networkDLines())
 	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(config); err != nil {
 		return errors.Wrap(err)
 	}
 
@@ -552,10 +948,32 @@ Domains={{.DNSDomain}}
 // ApplyNetworkD does apply the interface configuration to the running system
 // using systemd.networkd
 func (i *Interface) ApplyNetworkD(root string) error {
+	return i.applyNetworkD(root, nil)
+}
+
+func (i *Interface) applyNetworkD(root string, links *deviceLinks) error {
+	// The target keyfiles are still written, the other interfaces may reach the network
+	if i.IsWireless() {
+		log.Warning("Wi-Fi interface %s requires NetworkManager, skipping config apply.", i.Name)
+		return nil
+	}
+
 	fileName := fmt.Sprintf("10-%s.network", i.Name)
 	filePath := filepath.Join(root, systemdNetworkdDir, fileName)
 
-	if i.DHCP {
+	if i.IsVirtual() {
+		netDevPath := filepath.Join(root, systemdNetworkdDir, fmt.Sprintf("10-%s.netdev", i.Name))
+		if err := writeFileMode(netDevPath, i.netDevConfig(), 0644); err != nil {
+			return err
+		}
+	}
+
+	// Bond members and bridge ports are only linked to their master
+	if links.isSubordinate() {
+		return writeFileMode(filePath, i.subordinateNetworkDConfig(links), 0644)
+	}
+
+	if i.isAutomatic() && len(links.networkDLines()) == 0 {
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
 			return nil
 		}
@@ -571,26 +989,20 @@ func (i *Interface) ApplyNetworkD(root string) error {
 	if err != nil {
 		return errors.Wrap(err)
 	}
+	defer func() { _ = f.Close() }()
 
-	return i.applyNetworkDStatic(root, f)
+	return i.applyNetworkDStatic(root, f, links)
 }
 
-func (i *Interface) applyNetworkManagerStatic(root string, file *os.File) error {
-	needPacDiscover = true
-
-	var address string
-
-	for _, curr := range i.Addrs {
-		if curr.Version != IPv4 {
-			continue
-		}
-
-		cidrd, err := netMaskToCIDR(curr.NetMask)
-		if err != nil {
-			return err
-		}
+func (i *Interface) networkManagerArgs() ([]string, error) {
+	addresses, err := i.staticAddrs(IPv4)
+	if err != nil {
+		return nil, err
+	}
 
-		address = fmt.Sprintf("%s/%d", curr.IP, cidrd)
+	addresses6, err := i.staticAddrs(IPv6)
+	if err != nil {
+		return nil, err
 	}
 
 	args := []string{
@@ -600,22 +1012,100 @@ func (i *Interface) applyNetworkManagerStatic(root string, file *os.File) error
 		"type",
 		"ethernet",
 		"ifname",
-		i.Name,
+		i.networkManagerIfname(),
 		"con-name",
-		fmt.Sprintf("Wired-%s", i.Name),
-		"ip4",
-		address,
-		"gw4",
-		i.Gateway,
-		"ipv4.method",
-		"manual",
-		"ipv4.dns",
-		i.DNSServer,
-		"ipv4.dns-search",
-		i.DNSDomain,
-	}
-
-	err := cmd.RunAndLog(args...)
+		i.ConnectionName(),
+	}
+	args = append(args, i.networkManagerMatchArgs("ethernet")...)
+
+	method := "auto"
+	if !i.DHCP && len(addresses) > 0 {
+		method = "manual"
+	} else if !i.DHCP {
+		method = "disabled"
+	}
+	args = append(args, "ipv4.method", method)
+
+	if len(addresses) > 0 {
+		args = append(args, "ipv4.addresses", strings.Join(addresses, ","))
+	}
+
+	if !i.DHCP && i.Gateway != "" {
+		args = append(args, "ipv4.gateway", i.Gateway)
+	}
+
+	switch i.IPv6 {
+	case IPv6SLAAC:
+		args = append(args, "ipv6.method", "auto")
+	case IPv6DHCP:
+		args = append(args, "ipv6.method", "dhcp")
+	case IPv6Static:
+		args = append(args, "ipv6.method", "manual")
+	case IPv6Disabled:
+		args = append(args, "ipv6.method", "disabled")
+	}
+
+	if len(addresses6) > 0 {
+		args = append(args, "ipv6.addresses", strings.Join(addresses6, ","))
+	}
+
+	if i.IPv6 != "" && i.IPv6 != IPv6Disabled && i.Gateway6 != "" {
+		args = append(args, "ipv6.gateway", i.Gateway6)
+	}
+
+	if i.staticDNS() {
+		dns, dns6 := i.dnsByVersion()
+
+		if len(dns) > 0 {
+			args = append(args, "ipv4.dns", strings.Join(dns, ","))
+		}
+
+		if len(dns6) > 0 {
+			args = append(args, "ipv6.dns", strings.Join(dns6, ","))
+		}
+
+		if len(i.DNSDomains) > 0 {
+			args = append(args, "ipv4.dns-search", strings.Join(i.DNSDomains, ","))
+		}
+	}
+
+	if i.DNSOverTLS != "" {
+		args = append(args, "connection.dns-over-tls", i.DNSOverTLS)
+	}
+
+	routes, routes6 := []string{}, []string{}
+	for _, curr := range i.Routes {
+		if curr.version() == IPv6 {
+			routes6 = append(routes6, curr.networkManagerRoute())
+		} else {
+			routes = append(routes, curr.networkManagerRoute())
+		}
+	}
+
+	if len(routes) > 0 {
+		args = append(args, "ipv4.routes", strings.Join(routes, ","))
+	}
+
+	if len(routes6) > 0 {
+		args = append(args, "ipv6.routes", strings.Join(routes6, ","))
+	}
+
+	if pac := proxy.PAC(); pac != "" {
+		args = append(args, "proxy.method", "auto", "proxy.pac-url", pac)
+	}
+
+	return args, nil
+}
+
+func (i *Interface) applyNetworkManagerStatic(root string, file *os.File) error {
+	needPacDiscover = true
+
+	args, err := i.networkManagerArgs()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.RunAndLog(args...)
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -626,10 +1116,26 @@ func (i *Interface) applyNetworkManagerStatic(root string, file *os.File) error
 // ApplyNetworkManager does apply the interface configuration to the running system
 // using Network Manager
 func (i *Interface) ApplyNetworkManager(root string) error {
-	fileName := fmt.Sprintf("Wired-%s.nmconnection", i.Name)
+	return i.applyNetworkManager(root, nil)
+}
+
+func (i *Interface) applyNetworkManager(root string, links *deviceLinks) error {
+	fileName := fmt.Sprintf("%s.nmconnection", i.ConnectionName())
 	filePath := filepath.Join(root, networkManagerDir, fileName)
 
-	if i.DHCP {
+	// Bond members and bridge ports replace the interface's own connection
+	if links.isSubordinate() {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err)
+		}
+
+		fileName = fmt.Sprintf("%s.nmconnection", i.subordinateConnectionName(links))
+		filePath = filepath.Join(root, networkManagerDir, fileName)
+
+		return writeFileMode(filePath, i.subordinateKeyfile(links), 0600)
+	}
+
+	if i.isAutomatic() {
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
 			return nil
 		}
@@ -641,10 +1147,32 @@ func (i *Interface) ApplyNetworkManager(root string) error {
 		return nil
 	}
 
+	// The Wi-Fi secrets are written to the keyfile only, never to the command line
+	if i.IsWireless() {
+		config, err := i.wirelessKeyfile(false)
+		if err != nil {
+			return err
+		}
+
+		return writeFileMode(filePath, config, 0600)
+	}
+
+	if i.IsVirtual() {
+		needPacDiscover = true
+
+		config, err := i.virtualKeyfile(links, proxy.PAC())
+		if err != nil {
+			return err
+		}
+
+		return writeFileMode(filePath, config, 0600)
+	}
+
 	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return errors.Wrap(err)
 	}
+	defer func() { _ = f.Close() }()
 
 	return i.applyNetworkManagerStatic(root, f)
 }
@@ -672,25 +1200,35 @@ func Apply(root string, ifaces []*Interface) error {
 		}
 	}
 
+	links := linksOf(ifaces)
+
 	for _, curr := range ifaces {
-		if !curr.IsUserDefined() {
+		l := links[curr.Name]
+
+		// Wi-Fi interfaces are required to reach the network at all, as are the
+		// virtual devices and the interfaces they are built on
+		if !curr.IsUserDefined() && !curr.IsWireless() && !curr.IsVirtual() && l == nil {
 			log.Info("Interface %s was not changed, skipping config apply.", curr.Name)
 			continue
 		}
 
 		if netMgr {
-			err := curr.ApplyNetworkManager(root)
+			err := curr.applyNetworkManager(root, l)
 			if err != nil {
 				return err
 			}
 		} else {
-			err := curr.ApplyNetworkD(root)
+			err := curr.applyNetworkD(root, l)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	if err := applySubordinates(root, ifaces, links, netMgr); err != nil {
+		return err
+	}
+
 	if needPacDiscover {
 		err := EnablePacDiscovery(root)
 		if err != nil {
@@ -733,73 +1271,19 @@ func Restart() error {
 
 // VerifyConnectivity tests if the network configuration is working
 func VerifyConnectivity() error {
-	var versionURL []byte
-	var err error
-
-	if versionURL, err = ioutil.ReadFile(versionURLPath); err != nil {
-		return errors.Errorf("Read version file %s: %v", versionURLPath, err)
-	}
-
-	return CheckURL(string(versionURL))
+	var c *Connectivity
+	return c.Check()
 }
 
-// CheckURL tests if the given URL is accessible
+// CheckURL tests if the given URL is accessible with a single attempt
 func CheckURL(url string) error {
-	args := []string{
-		"/usr/bin/timeout",
-		"--kill-after=10s",
-		"10s",
-		"/usr/bin/curl",
-		"--no-sessionid",
-		"-o",
-		"/dev/null",
-		"-s",
-		"-f",
-		url,
-	}
-
-	if err := cmd.Run(nil, args...); err != nil {
-		log.Debug("curl failed : %q", err)
-		return errors.Wrap(err)
-	}
-
-	return nil
+	return (&Fetcher{Timeout: defaultProbeTimeout, Attempts: 1}).Check(url)
 }
 
 // FetchRemoteConfigFile given an config url fetches it from the network. This function
-// currently supports only http/https protocol. After success return the local file path.
+// supports the http, https and file protocols. After success return the local file path.
 func FetchRemoteConfigFile(url string) (string, error) {
-	// Get a temp filename to download to
-	out, err := ioutil.TempFile("", "clr-installer-yaml-")
-	if err != nil {
-		return "", err
-	}
-	_ = out.Close()
-
-	// Since Clear Linux automatically proxy are not support in golang via
-	// the patch to libcurl, we need to use a system call to curl for now.
-	// TODO: Change this back to native http.Get(url) once a better
-	// proxy solution is deployed in the OS
-	args := []string{
-		"timeout",
-		"--kill-after=30s",
-		"30s",
-		"curl",
-		"--no-sessionid",
-		"-o",
-		out.Name(),
-		"-s",
-		"-f",
-		url,
-	}
-
-	if err := cmd.Run(nil, args...); err != nil {
-		log.Debug("FetchRemoteConfigFile failed : %q", err)
-		defer func() { _ = os.Remove(out.Name()) }()
-		return "", err
-	}
-
-	return out.Name(), nil
+	return (&Fetcher{}).FetchTemp(url, "clr-installer-yaml-")
 }
 
 // DownloadInstallerMessage pulls down a message from a URL
@@ -808,8 +1292,10 @@ func FetchRemoteConfigFile(url string) (string, error) {
 func DownloadInstallerMessage(header string, installConf string) string {
 	var result Messenger
 
+	// The message is optional, an offline installer must not wait for it
 	downloadURL := fmt.Sprintf(installDataURLBase, utils.ClearVersion, installConf)
-	msgFile, err := FetchRemoteConfigFile(downloadURL)
+	msgFile, err := (&Fetcher{Timeout: installerMessageTimeout, Attempts: 1}).FetchTemp(downloadURL,
+		"clr-installer-msg-")
 	if err != nil {
 		log.Debug("Failed to download the %s message: %s", header, err)
 		return ""
@@ -852,15 +1338,59 @@ func DownloadInstallerMessage(header string, installConf string) string {
 	return msg
 }
 
-// IsValidIP returns empty string if IP address is valid
+// IsValidIP returns empty string if the ipv4 or ipv6 address is valid
 func IsValidIP(str string) string {
-	if !validIPExp.MatchString(str) {
+	if net.ParseIP(str) == nil {
 		return "Invalid IP Addr"
 	}
 
 	return ""
 }
 
+// IsValidIPv6Mode returns empty string if the ipv6 mode is valid, an empty
+// mode leaves ipv6 to the system defaults
+func IsValidIPv6Mode(mode string) string {
+	modes := []string{IPv6SLAAC, IPv6DHCP, IPv6Static, IPv6Disabled}
+
+	if mode == "" {
+		return ""
+	}
+
+	for _, curr := range modes {
+		if mode == curr {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("Invalid ipv6 mode, expected one of: %s", strings.Join(modes, ", "))
+}
+
+// IsValidNetMask returns empty string if the netmask or prefix length is valid
+func IsValidNetMask(str string) string {
+	if prefix, err := strconv.Atoi(str); err == nil {
+		if prefix < 0 || prefix > 128 {
+			return "Invalid prefix length"
+		}
+
+		return ""
+	}
+
+	if _, err := netMaskToCIDR(str); err != nil {
+		return "Invalid netmask"
+	}
+
+	return ""
+}
+
+// IsValidCIDR returns empty string if the address in CIDR notation is valid
+func IsValidCIDR(str string) string {
+	if _, _, err := net.ParseCIDR(str); err != nil {
+		return "Invalid address, expected address/prefix"
+	}
+
+	return ""
+}
+
 // EnablePacDiscovery turns on the pacdiscovery service
 // Normally this service is enabled by a DHCP lease path, but
 // it must be manually enabled if we set a static IP
@@ -887,6 +1417,28 @@ func EnablePacDiscovery(rootDir string) error {
 	return nil
 }
 
+// removeTargetProxy removes the proxy auto-config of the installer from the
+// NetworkManager connections copied to the target
+func removeTargetProxy(rootDir string) error {
+	paths, err := filepath.Glob(filepath.Join(rootDir, networkManagerDir, "*.nmconnection"))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, curr := range paths {
+		content, err := ioutil.ReadFile(curr)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if err = writeFileMode(curr, removeKeyfileSection(string(content), "proxy"), 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CopyNetworkInterfaces transfer the user defined network interface files
 // to the target installation media
 func CopyNetworkInterfaces(rootDir string) error {
@@ -912,6 +1464,12 @@ func CopyNetworkInterfaces(rootDir string) error {
 		}
 	}
 
+	if proxy.PAC() != "" && proxy.TargetPAC() == "" {
+		if err := removeTargetProxy(rootDir); err != nil {
+			return err
+		}
+	}
+
 	// We likely copied a static IP, so enable PacDiscovery
 	err := EnablePacDiscovery(rootDir)
 	if err != nil {
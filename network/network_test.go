@@ -5,11 +5,22 @@
 package network
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/proxy"
 	"github.com/clearlinux/clr-installer/utils"
 )
 
@@ -70,6 +81,10 @@ func TestIpAddress(t *testing.T) {
 		{"0.0.0.0", ""},
 		{"0.0.0.0.0", "Invalid IP Addr"},
 		{"0.0.0", "Invalid IP Addr"},
+		{"2001:db8::1", ""},
+		{"fe80::1adb:f2ff:fe5c:664b", ""},
+		{"2001:db8::1::2", "Invalid IP Addr"},
+		{"2001:db8::1/64", "Invalid IP Addr"},
 	}
 
 	for _, curr := range tests {
@@ -178,6 +193,13 @@ func TestNetmaskToCID(t *testing.T) {
 		{"255.255.0.0", 16},
 		{"255.0.0.0", 8},
 		{"0.0.0.0", 0},
+		{"ffff:ffff:ffff:ffff::", 64},
+		{"ffff:ffff:ffff:ff00::", 56},
+	}
+
+	// non contiguous masks are invalid
+	if _, err = netMaskToCIDR("255.0.255.0"); err == nil {
+		t.Fatalf("netMaskToCIDR() should have failed for a non contiguous mask")
 	}
 
 	for _, curr := range tests {
@@ -238,7 +260,7 @@ func TestApply(t *testing.T) {
 		},
 		DHCP:        false,
 		Gateway:     "10.0.0.101",
-		DNSServer:   "10.0.0.101",
+		DNSServers:  []string{"10.0.0.101"},
 		UserDefined: false,
 	}
 
@@ -274,6 +296,379 @@ func TestHasIPv4Addr(t *testing.T) {
 	}
 }
 
+func TestAddrCIDR(t *testing.T) {
+	tests := []struct {
+		addr  Addr
+		cidr  string
+		valid bool
+	}{
+		{Addr{IP: "10.0.0.5", NetMask: "255.255.255.0"}, "10.0.0.5/24", true},
+		{Addr{IP: "10.0.0.5", NetMask: "24"}, "10.0.0.5/24", true},
+		{Addr{IP: "10.0.0.5/16"}, "10.0.0.5/16", true},
+		{Addr{IP: "2001:db8::5/64"}, "2001:db8::5/64", true},
+		{Addr{IP: "2001:db8::5", NetMask: "ffff:ffff:ffff:ffff::"}, "2001:db8::5/64", true},
+		{Addr{IP: "2001:0db8:0::5", NetMask: "48"}, "2001:db8::5/48", true},
+		{Addr{IP: "10.0.0.5"}, "", false},
+		{Addr{IP: "10.0.0.5", NetMask: "33"}, "", false},
+		{Addr{IP: "10.0.0.5", NetMask: "ffff:ffff::"}, "", false},
+		{Addr{IP: "2001:db8::5", NetMask: "255.255.255.0"}, "", false},
+		{Addr{IP: "2001:db8::5/129"}, "", false},
+		{Addr{IP: "10.0.0.300/24"}, "", false},
+	}
+
+	for _, curr := range tests {
+		cidr, err := curr.addr.CIDR()
+		if curr.valid && err != nil {
+			t.Fatalf("CIDR() of %+v should not fail: %v", curr.addr, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("CIDR() of %+v should fail", curr.addr)
+		}
+
+		if cidr != curr.cidr {
+			t.Fatalf("CIDR() of %+v expected %q but got %q", curr.addr, curr.cidr, cidr)
+		}
+	}
+}
+
+func TestValidateInterface(t *testing.T) {
+	tests := []struct {
+		iface Interface
+		valid bool
+	}{
+		{Interface{Name: "eth0", DHCP: true}, true},
+		{Interface{Name: "eth0", Addrs: []*Addr{{IP: "10.0.0.5/24"}, {IP: "2001:db8::5/64"}},
+			Gateway: "10.0.0.1", IPv6: IPv6Static, Gateway6: "2001:db8::1"}, true},
+		{Interface{Name: "eth0", Addrs: []*Addr{{IP: "2001:db8::5/64"}}, IPv6: IPv6Static}, true},
+		{Interface{Name: "eth0", DHCP: true, IPv6: IPv6DHCP, Gateway6: "fe80::1"}, true},
+		{Interface{Name: "eth0", DHCP: true, IPv6: "auto"}, false},
+		{Interface{Addrs: []*Addr{{IP: "10.0.0.5/24"}}}, false},
+		{Interface{Name: "eth0", Gateway: "2001:db8::1", Addrs: []*Addr{{IP: "10.0.0.5/24"}}}, false},
+		{Interface{Name: "eth0", Gateway: "10.0.0.1"}, false},
+		{Interface{Name: "eth0", DHCP: true, IPv6: IPv6SLAAC, Gateway6: "10.0.0.1"}, false},
+		{Interface{Name: "eth0", DHCP: true, Gateway6: "2001:db8::1"}, false},
+		{Interface{Name: "eth0", DHCP: true, IPv6: IPv6Static}, false},
+		{Interface{Name: "eth0", Addrs: []*Addr{{IP: "2001:db8::5/64"}}, IPv6: IPv6Disabled}, false},
+		{Interface{Name: "eth0", DHCP: true, DNSServers: []string{"dns.example.com"}}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.iface.Validate()
+		if curr.valid && err != nil {
+			t.Fatalf("Validate() of %+v should not fail: %v", curr.iface, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("Validate() of %+v should fail", curr.iface)
+		}
+	}
+}
+
+func TestNetworkDConfig(t *testing.T) {
+	iface := &Interface{
+		Name: "eth0",
+		Addrs: []*Addr{
+			{IP: "10.0.0.5", NetMask: "255.255.255.0", Version: IPv4},
+			{IP: "fe80::1", NetMask: "64", Version: IPv6},
+			{IP: "2001:db8::5/64", Version: IPv6},
+		},
+		Gateway:    "10.0.0.1",
+		IPv6:       IPv6Static,
+		Gateway6:   "2001:db8::1",
+		DNSServers: []string{"2001:db8::53"},
+		DNSDomains: []string{"example.com"},
+	}
+
+	config, err := iface.networkDConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Match]
+Name=eth0
+
+[Network]
+IPv6AcceptRA=no
+DNS=2001:db8::53
+Address=10.0.0.5/24
+Address=2001:db8::5/64
+Gateway=10.0.0.1
+Gateway=2001:db8::1
+Domains=example.com
+`
+	if config != expected {
+		t.Fatalf("Expected networkd config:\n%s\ngot:\n%s", expected, config)
+	}
+
+	// the autoconfigured ipv6 address must not be pinned
+	iface.DHCP = true
+	iface.IPv6 = IPv6DHCP
+	iface.Gateway6 = ""
+
+	config, err = iface.networkDConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `[Match]
+Name=eth0
+
+[Network]
+DHCP=yes
+IPv6AcceptRA=yes
+`
+	if config != expected {
+		t.Fatalf("Expected networkd config:\n%s\ngot:\n%s", expected, config)
+	}
+}
+
+func TestUnmarshalIPv6Mode(t *testing.T) {
+	iface := &Interface{}
+
+	data := "name: eth0\ndhcp: \"true\"\naddrs:\n- ip: 10.0.0.5/24\n- ip: 2001:db8::5/64\n"
+	if err := yaml.Unmarshal([]byte(data), iface); err != nil {
+		t.Fatal(err)
+	}
+
+	if iface.IPv6 != "" || iface.Addrs[1].Version != IPv6 {
+		t.Fatalf("The ipv6 mode should not be inferred from the addresses: %q", iface.IPv6)
+	}
+
+	addrs, err := iface.staticAddrs(IPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(addrs) != 0 {
+		t.Fatalf("No ipv6 address should be configured without the static mode: %v", addrs)
+	}
+}
+
+func TestNetworkManagerArgs(t *testing.T) {
+	iface := &Interface{
+		Name:       "eth0",
+		Addrs:      []*Addr{{IP: "2001:db8::5/64"}, {IP: "2001:db8:1::5/64"}},
+		IPv6:       IPv6Static,
+		Gateway6:   "2001:db8::1",
+		DNSServers: []string{"2001:db8::53"},
+	}
+
+	args, err := iface.networkManagerArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "nmcli connection add type ethernet ifname eth0 con-name Wired-eth0 " +
+		"ipv4.method disabled ipv6.method manual ipv6.addresses 2001:db8::5/64,2001:db8:1::5/64 " +
+		"ipv6.gateway 2001:db8::1 ipv6.dns 2001:db8::53"
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("Expected nmcli arguments:\n%s\ngot:\n%s", expected, strings.Join(args, " "))
+	}
+
+	iface = &Interface{Name: "eth0", DHCP: true, IPv6: IPv6SLAAC, DNSServers: []string{"10.0.0.53"}}
+
+	args, err = iface.networkManagerArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "nmcli connection add type ethernet ifname eth0 con-name Wired-eth0 " +
+		"ipv4.method auto ipv6.method auto"
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("Expected nmcli arguments:\n%s\ngot:\n%s", expected, strings.Join(args, " "))
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("10.1.0.0/16 via 10.0.0.254 metric 100; 2001:db8:1::/48 via 2001:db8::fe table 200;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Route{
+		{To: "10.1.0.0/16", Via: "10.0.0.254", Metric: 100},
+		{To: "2001:db8:1::/48", Via: "2001:db8::fe", Table: 200},
+	}
+
+	if len(routes) != len(expected) {
+		t.Fatalf("Expected %d routes, got: %d", len(expected), len(routes))
+	}
+
+	for idx, curr := range expected {
+		if *routes[idx] != *curr {
+			t.Fatalf("Expected route %+v, got: %+v", curr, routes[idx])
+		}
+	}
+
+	str := "10.1.0.0/16 via 10.0.0.254 metric 100; 2001:db8:1::/48 via 2001:db8::fe table 200"
+	if FormatRoutes(routes) != str {
+		t.Fatalf("Expected routes %q, got: %q", str, FormatRoutes(routes))
+	}
+
+	for _, curr := range []string{
+		"10.1.0.0/16 via",
+		"10.1.0.0/16 dev eth0",
+		"10.1.0.0/16 metric high",
+		"10.1.0.0 via 10.0.0.254",
+		"10.1.0.0/16 via 2001:db8::fe",
+	} {
+		if _, err = ParseRoutes(curr); err == nil {
+			t.Fatalf("Route %q should be invalid", curr)
+		}
+	}
+}
+
+func TestRoutesAndDNS(t *testing.T) {
+	iface := &Interface{
+		Name:       "eth0",
+		Addrs:      []*Addr{{IP: "10.0.0.5/24"}},
+		Gateway:    "10.0.0.1",
+		DNSServers: []string{"10.0.0.53", "2001:db8::53"},
+		DNSDomains: []string{"example.com", "lab.example.com"},
+		DNSOverTLS: "opportunistic",
+		Routes: []*Route{
+			{To: "10.1.0.0/16", Via: "10.0.0.254", Metric: 100},
+			{To: "10.2.0.0/16", Via: "10.0.0.253", Table: 200},
+		},
+	}
+
+	if err := iface.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := iface.networkDConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Match]
+Name=eth0
+
+[Network]
+DNS=10.0.0.53
+DNS=2001:db8::53
+DNSOverTLS=opportunistic
+Address=10.0.0.5/24
+Gateway=10.0.0.1
+Domains=example.com lab.example.com
+
+[Route]
+Destination=10.1.0.0/16
+Gateway=10.0.0.254
+Metric=100
+
+[Route]
+Destination=10.2.0.0/16
+Gateway=10.0.0.253
+Table=200
+`
+	if config != expected {
+		t.Fatalf("Expected networkd config:\n%s\ngot:\n%s", expected, config)
+	}
+
+	args, err := iface.networkManagerArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "nmcli connection add type ethernet ifname eth0 con-name Wired-eth0 " +
+		"ipv4.method manual ipv4.addresses 10.0.0.5/24 ipv4.gateway 10.0.0.1 " +
+		"ipv4.dns 10.0.0.53 ipv6.dns 2001:db8::53 ipv4.dns-search example.com,lab.example.com " +
+		"connection.dns-over-tls opportunistic " +
+		"ipv4.routes 10.1.0.0/16 10.0.0.254 100,10.2.0.0/16 10.0.0.253 table=200"
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("Expected nmcli arguments:\n%s\ngot:\n%s", expected, strings.Join(args, " "))
+	}
+
+	k := iface.newKeyfile("ethernet")
+	if err = iface.addIPSections(k); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `[connection]
+id=Wired-eth0
+type=ethernet
+interface-name=eth0
+dns-over-tls=1
+
+[ipv4]
+method=manual
+address1=10.0.0.5/24
+gateway=10.0.0.1
+dns=10.0.0.53;
+dns-search=example.com;lab.example.com;
+route1=10.1.0.0/16,10.0.0.254,100
+route2=10.2.0.0/16,10.0.0.253
+route2_options=table=200
+
+[ipv6]
+method=auto
+dns=2001:db8::53;
+`
+	if k.String() != expected {
+		t.Fatalf("Expected keyfile:\n%s\ngot:\n%s", expected, k.String())
+	}
+
+	iface.DNSOverTLS = "strict"
+	if err = iface.Validate(); err == nil {
+		t.Fatalf("DNS-over-TLS mode strict should be invalid")
+	}
+}
+
+func TestResolved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	r := &Resolved{
+		DNS:        []string{"1.1.1.1#cloudflare-dns.com", "2606:4700:4700::1111"},
+		Domains:    []string{"~."},
+		DNSOverTLS: "yes",
+		DNSSEC:     "allow-downgrade",
+		LLMNR:      "no",
+	}
+
+	if err = r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Apply(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, resolvedConfDir, resolvedConfFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Resolve]
+DNS=1.1.1.1#cloudflare-dns.com 2606:4700:4700::1111
+Domains=~.
+DNSOverTLS=yes
+DNSSEC=allow-downgrade
+LLMNR=no
+`
+	if string(content) != expected {
+		t.Fatalf("Expected resolved.conf:\n%s\ngot:\n%s", expected, string(content))
+	}
+
+	for _, curr := range []*Resolved{
+		{DNS: []string{"dns.example.com"}},
+		{Domains: []string{"-example.com"}},
+		{DNSSEC: "strict"},
+		{MulticastDNS: "maybe"},
+	} {
+		if err = curr.Validate(); err == nil {
+			t.Fatalf("Resolved options %+v should be invalid", curr)
+		}
+	}
+}
+
 func TestGoodDomains(t *testing.T) {
 	tests := []struct {
 		domain string
@@ -355,3 +750,925 @@ func TestGoodDownload(t *testing.T) {
 		t.Fatalf("Good Clear Linux HTTPS URL failed: %s", err)
 	}
 }
+
+func TestValidateWireless(t *testing.T) {
+	tests := []struct {
+		w     Wireless
+		valid bool
+	}{
+		{Wireless{SSID: "office", Security: WirelessWPA2PSK, PSK: "secret123"}, true},
+		{Wireless{SSID: "office", Security: WirelessWPA2PSK, PSK: strings.Repeat("ab", 32)}, true},
+		{Wireless{SSID: "office", Security: WirelessWPA3PSK, PSK: "secret"}, true},
+		{Wireless{SSID: "guest", Hidden: true}, true},
+		{Wireless{SSID: "corp", Security: WirelessEAP,
+			EAP: &EAP{Method: "peap", Identity: "user", Password: "pass", CACert: "/etc/ca.pem"}}, true},
+		{Wireless{SSID: "corp", Security: WirelessEAP,
+			EAP: &EAP{Method: "tls", Identity: "user", ClientCert: "/etc/c.pem", PrivateKey: "/etc/k.pem"}}, true},
+		{Wireless{SSID: "", Security: WirelessOpen}, false},
+		{Wireless{SSID: strings.Repeat("a", 33)}, false},
+		{Wireless{SSID: "office", Security: WirelessWPA2PSK, PSK: "short"}, false},
+		{Wireless{SSID: "office", Security: "wep", PSK: "secret123"}, false},
+		{Wireless{SSID: "guest", PSK: "secret123"}, false},
+		{Wireless{SSID: "corp", Security: WirelessEAP}, false},
+		{Wireless{SSID: "corp", Security: WirelessEAP, EAP: &EAP{Method: "leap", Identity: "u", Password: "p"}}, false},
+		{Wireless{SSID: "corp", Security: WirelessEAP, EAP: &EAP{Method: "tls", Identity: "user"}}, false},
+		{Wireless{SSID: "corp", Security: WirelessEAP,
+			EAP: &EAP{Method: "ttls", Identity: "user", Password: "pass", CACert: "ca.pem"}}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.w.Validate()
+		if curr.valid && err != nil {
+			t.Fatalf("Validate() of %+v should not fail: %v", curr.w, err)
+		}
+
+		if !curr.valid && err == nil {
+			t.Fatalf("Validate() of %+v should fail", curr.w)
+		}
+	}
+}
+
+func TestWriteWirelessConnections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	caCert := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(caCert, []byte("certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces := []*Interface{
+		{Name: "eth0", DHCP: true},
+		{
+			Name:  "wlan0",
+			Addrs: []*Addr{{IP: "10.0.0.5/24"}},
+			Wireless: &Wireless{SSID: "corp", Hidden: true, Security: WirelessEAP,
+				EAP: &EAP{Method: "peap", Identity: "user", Password: "pass", CACert: caCert}},
+			Gateway:    "10.0.0.1",
+			DNSServers: []string{"10.0.0.53"},
+			IPv6:       IPv6SLAAC,
+		},
+	}
+
+	rootDir := filepath.Join(dir, "root")
+	if err = WriteWirelessConnections(rootDir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(filepath.Join(rootDir, networkManagerDir, "Wired-eth0.nmconnection")); err == nil {
+		t.Fatalf("Only the Wi-Fi connections should be written")
+	}
+
+	path := filepath.Join(rootDir, networkManagerDir, "Wifi-wlan0.nmconnection")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("The connection holds secrets and should be 0600, got: %v", fi.Mode().Perm())
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[connection]
+id=Wifi-wlan0
+type=wifi
+interface-name=wlan0
+
+[wifi]
+mode=infrastructure
+ssid=corp
+hidden=true
+
+[wifi-security]
+key-mgmt=wpa-eap
+
+[802-1x]
+eap=peap;
+identity=user
+password=pass
+phase2-auth=mschapv2
+ca-cert=/etc/NetworkManager/certs/wlan0-ca.pem
+
+[ipv4]
+method=manual
+address1=10.0.0.5/24
+gateway=10.0.0.1
+dns=10.0.0.53;
+
+[ipv6]
+method=auto
+`
+	if string(content) != expected {
+		t.Fatalf("Expected keyfile:\n%s\ngot:\n%s", expected, content)
+	}
+
+	if _, err = os.Stat(filepath.Join(rootDir, "/etc/NetworkManager/certs/wlan0-ca.pem")); err != nil {
+		t.Fatalf("The CA certificate should be copied to the target: %v", err)
+	}
+
+	// systemd-networkd can not connect to Wi-Fi networks, the interface is skipped
+	if err = utils.MkdirAll(filepath.Join(dir, systemdNetworkdDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ifaces[1].applyNetworkD(dir, nil); err != nil {
+		t.Fatalf("The Wi-Fi interface should be skipped with systemd-networkd: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, systemdNetworkdDir, "10-wlan0.network")); err == nil {
+		t.Fatalf("No systemd-networkd config should be written for Wi-Fi interfaces")
+	}
+}
+
+func TestValidateVirtual(t *testing.T) {
+	tests := []struct {
+		iface *Interface
+		valid bool
+	}{
+		{&Interface{Name: "bond0", DHCP: true, Bond: &Bond{Mode: "802.3ad", Members: []string{"eth0"}}}, true},
+		{&Interface{Name: "bond0", Bond: &Bond{Mode: "round-robin", Members: []string{"eth0"}}}, false},
+		{&Interface{Name: "bond0", Bond: &Bond{}}, false},
+		{&Interface{Name: "vlan10", VLAN: &VLAN{Parent: "eth0", ID: 10}}, true},
+		{&Interface{Name: "vlan10", VLAN: &VLAN{ID: 10}}, false},
+		{&Interface{Name: "vlan10", VLAN: &VLAN{Parent: "eth0", ID: 4095}}, false},
+		{&Interface{Name: "br0", Bridge: &Bridge{}}, true},
+		{&Interface{Name: "br0", Bridge: &Bridge{}, VLAN: &VLAN{Parent: "eth0", ID: 10}}, false},
+		{&Interface{Name: "bridge-for-the-vms", Bridge: &Bridge{}}, false},
+	}
+
+	for _, curr := range tests {
+		err := curr.iface.Validate()
+		if curr.valid && err != nil {
+			t.Fatalf("Interface %s should be valid, got: %v", curr.iface.Name, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Interface %s should be invalid", curr.iface.Name)
+		}
+	}
+
+	topologies := []struct {
+		ifaces []*Interface
+		valid  bool
+	}{
+		{[]*Interface{
+			{Name: "eth0"},
+			{Name: "bond0", Bond: &Bond{Members: []string{"eth0", "eth1"}}},
+			{Name: "vlan10", VLAN: &VLAN{Parent: "bond0", ID: 10}},
+			{Name: "vlan20", VLAN: &VLAN{Parent: "bond0", ID: 20}},
+		}, true},
+		{[]*Interface{
+			{Name: "eth0", DHCP: true},
+			{Name: "bond0", Bond: &Bond{Members: []string{"eth0"}}},
+		}, false},
+		{[]*Interface{
+			{Name: "bond0", Bond: &Bond{Members: []string{"eth0"}}},
+			{Name: "br0", Bridge: &Bridge{Ports: []string{"eth0"}}},
+		}, false},
+		{[]*Interface{
+			{Name: "br0", Bridge: &Bridge{Ports: []string{"br0"}}},
+		}, false},
+		{[]*Interface{{Name: "eth0"}, {Name: "eth0"}}, false},
+	}
+
+	for idx, curr := range topologies {
+		err := ValidateTopology(curr.ifaces)
+		if curr.valid && err != nil {
+			t.Fatalf("Topology %d should be valid, got: %v", idx, err)
+		} else if !curr.valid && err == nil {
+			t.Fatalf("Topology %d should be invalid", idx)
+		}
+	}
+}
+
+func TestApplyVirtualNetworkD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-utest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if err = os.MkdirAll(filepath.Join(dir, systemdNetworkdDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces := []*Interface{
+		{Name: "eth0"},
+		{Name: "bond0", Bond: &Bond{Mode: "active-backup", MIIMon: 100, Members: []string{"eth0", "eth1"}}},
+		{Name: "vlan10", Addrs: []*Addr{{IP: "10.0.10.5/24"}}, VLAN: &VLAN{Parent: "bond0", ID: 10}},
+	}
+
+	links := linksOf(ifaces)
+	for _, curr := range ifaces {
+		if err = curr.applyNetworkD(dir, links[curr.Name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = applySubordinates(dir, ifaces, links, false); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"10-bond0.netdev": `[NetDev]
+Name=bond0
+Kind=bond
+
+[Bond]
+Mode=active-backup
+MIIMonitorSec=100ms
+`,
+		"10-bond0.network": `[Match]
+Name=bond0
+
+[Network]
+VLAN=vlan10
+`,
+		"10-vlan10.netdev": `[NetDev]
+Name=vlan10
+Kind=vlan
+
+[VLAN]
+Id=10
+`,
+		"10-vlan10.network": `[Match]
+Name=vlan10
+
+[Network]
+Address=10.0.10.5/24
+`,
+		"10-eth0.network": `[Match]
+Name=eth0
+
+[Network]
+Bond=bond0
+`,
+		"10-eth1.network": `[Match]
+Name=eth1
+
+[Network]
+Bond=bond0
+`,
+	}
+
+	for file, content := range expected {
+		got, err := ioutil.ReadFile(filepath.Join(dir, systemdNetworkdDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != content {
+			t.Fatalf("Expected %s:\n%s\ngot:\n%s", file, content, string(got))
+		}
+	}
+}
+
+func TestVirtualKeyfile(t *testing.T) {
+	iface := &Interface{Name: "br0", DHCP: true, IPv6: IPv6Disabled, Bridge: &Bridge{Ports: []string{"eth0"}, STP: true}}
+
+	config, err := iface.virtualKeyfile(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[connection]
+id=Bridge-br0
+type=bridge
+interface-name=br0
+
+[bridge]
+stp=true
+
+[ipv4]
+method=auto
+
+[ipv6]
+method=disabled
+`
+	if config != expected {
+		t.Fatalf("Expected keyfile:\n%s\ngot:\n%s", expected, config)
+	}
+
+	links := linksOf([]*Interface{iface})["eth0"]
+
+	expected = `[connection]
+id=Bridge-br0-eth0
+type=ethernet
+interface-name=eth0
+master=br0
+slave-type=bridge
+`
+	if config = (&Interface{Name: "eth0"}).subordinateKeyfile(links); config != expected {
+		t.Fatalf("Expected keyfile:\n%s\ngot:\n%s", expected, config)
+	}
+}
+
+func TestValidateMatch(t *testing.T) {
+	bad := []*Interface{
+		{Name: "mgmt0", DHCP: true, Rename: true},
+		{Name: "eth0", DHCP: true, Match: &Match{}},
+		{Name: "eth0", DHCP: true, Match: &Match{MAC: "00:11:22"}},
+		{Name: "eth0", DHCP: true, Match: &Match{Driver: "e1000["}},
+		{Name: "bond0", DHCP: true, Bond: &Bond{Members: []string{"eth0"}}, Match: &Match{Driver: "e1000"}},
+		{Name: "management-lan01", DHCP: true, Match: &Match{Driver: "e1000"}, Rename: true},
+	}
+
+	for _, curr := range bad {
+		if err := curr.Validate(); err == nil {
+			t.Fatalf("Interface %s with match %q should be invalid", curr.Name, curr.Match)
+		}
+	}
+
+	good := &Interface{
+		Name:   "mgmt0",
+		DHCP:   true,
+		Match:  &Match{MAC: "00:11:22:AA:BB:CC", Driver: "e1000*", Path: "pci-0000:00:1f.6"},
+		Rename: true,
+	}
+
+	if err := good.Validate(); err != nil {
+		t.Fatalf("Interface %s should be valid: %v", good.Name, err)
+	}
+}
+
+func TestMatchConfigs(t *testing.T) {
+	iface := &Interface{
+		Name:   "mgmt0",
+		DHCP:   true,
+		IPv6:   IPv6Disabled,
+		Match:  &Match{MAC: "00:11:22:AA:BB:CC", Driver: "e1000*"},
+		Rename: true,
+	}
+
+	config, err := iface.networkDConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Match]
+PermanentMACAddress=00:11:22:aa:bb:cc
+Driver=e1000*
+
+[Network]
+DHCP=ipv4
+IPv6AcceptRA=no
+LinkLocalAddressing=no
+`
+	if config != expected {
+		t.Fatalf("Expected networkd config:\n%s\ngot:\n%s", expected, config)
+	}
+
+	args, err := iface.networkManagerArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "nmcli connection add type ethernet ifname * con-name Wired-mgmt0 " +
+		"ethernet.mac-address 00:11:22:aa:bb:cc match.driver e1000* ipv4.method auto ipv6.method disabled"
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("Expected nmcli arguments:\n%s\ngot:\n%s", expected, strings.Join(args, " "))
+	}
+
+	expected = `[Match]
+PermanentMACAddress=00:11:22:aa:bb:cc
+Driver=e1000*
+
+[Link]
+Name=mgmt0
+`
+	if config = iface.linkConfig(); config != expected {
+		t.Fatalf("Expected link config:\n%s\ngot:\n%s", expected, config)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the vlan is created on the kernel name of the installer and moved to the
+	// new name in the target
+	iface.Device = "enp0s31f6"
+	vlan := &Interface{Name: "vlan10", DHCP: true, IPv6: IPv6Disabled, VLAN: &VLAN{Parent: "mgmt0", ID: 10}}
+	ifaces := []*Interface{iface, {Name: "eth1", DHCP: true, Match: &Match{Driver: "igb"}}, vlan}
+
+	if config, err = vlan.virtualKeyfile(linksOf(ifaces)[vlan.Name], ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(config, "parent=enp0s31f6\n") {
+		t.Fatalf("The vlan should be created on the running system device:\n%s", config)
+	}
+
+	vlanPath := filepath.Join(dir, networkManagerDir, "Vlan-vlan10.nmconnection")
+	if err = utils.MkdirAll(filepath.Dir(vlanPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(vlanPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = WriteLinkFiles(dir, ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := ioutil.ReadFile(vlanPath); err != nil || !strings.Contains(string(content), "parent=mgmt0\n") {
+		t.Fatalf("The target vlan should be created on the renamed device: %v\n%s", err, content)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, systemdNetworkdDir, "10-mgmt0.link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != expected {
+		t.Fatalf("Expected link file:\n%s\ngot:\n%s", expected, string(content))
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, systemdNetworkdDir, "10-eth1.link")); err == nil {
+		t.Fatal("A link file should only be written for the renamed interfaces")
+	}
+}
+
+func TestResolveNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	prev := sysClassNetDir
+	sysClassNetDir = dir
+	defer func() { sysClassNetDir = prev }()
+
+	// enp4s0 is a bond member reporting the mac address of the bond
+	for _, curr := range []struct{ name, mac, perm, driver string }{
+		{"enp0s31f6", "00:11:22:aa:bb:cc", "", "e1000e"},
+		{"enp2s0", "00:11:22:aa:bb:dd", "", "igb"},
+		{"enp3s0", "00:11:22:aa:bb:ee", "", "igb"},
+		{"enp4s0", "00:11:22:aa:bb:cc", "00:11:22:aa:bb:ff", "bnx2"},
+		{"lo", "00:00:00:00:00:00", "", ""},
+	} {
+		devDir := filepath.Join(dir, curr.name)
+		if err = utils.MkdirAll(filepath.Join(devDir, "bonding_slave"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(devDir, "address"), []byte(curr.mac+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if curr.perm != "" {
+			perm := filepath.Join(devDir, "bonding_slave", "perm_hwaddr")
+			if err = ioutil.WriteFile(perm, []byte(curr.perm+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if curr.driver == "" {
+			continue
+		}
+
+		if err = utils.MkdirAll(filepath.Join(devDir, "device"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		driver := filepath.Join("..", "..", "drivers", curr.driver)
+		if err = os.Symlink(driver, filepath.Join(devDir, "device", "driver")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ifaces := []*Interface{
+		{Name: "mgmt0", Match: &Match{MAC: "00:11:22:AA:BB:CC"}},
+		{Name: "lan0", Match: &Match{MAC: "00:11:22:aa:bb:dd", Driver: "igb"}},
+		{Name: "wan0", Match: &Match{Driver: "ixgbe"}},
+		{Name: "eth0"},
+		{Name: "member1", Match: &Match{MAC: "00:11:22:aa:bb:ff"}},
+	}
+
+	if err = ResolveNames(ifaces); err != nil {
+		t.Fatal(err)
+	}
+
+	for idx, expected := range []string{"enp0s31f6", "enp2s0", "", "", "enp4s0"} {
+		if ifaces[idx].Device != expected {
+			t.Fatalf("Interface %s should match %q, got %q", ifaces[idx].Name, expected, ifaces[idx].Device)
+		}
+	}
+
+	if err = ResolveNames([]*Interface{{Name: "lan0", Match: &Match{Driver: "igb"}}}); err == nil {
+		t.Fatal("A match of several devices should fail")
+	}
+
+	ifaces = []*Interface{
+		{Name: "mgmt0", Match: &Match{MAC: "00:11:22:aa:bb:cc"}},
+		{Name: "lan0", Match: &Match{Driver: "e1000*"}},
+	}
+
+	if err = ResolveNames(ifaces); err == nil {
+		t.Fatal("Two interfaces matching the same device should fail")
+	}
+}
+
+func TestValidateConnectivity(t *testing.T) {
+	bad := []*Connectivity{
+		{URLs: []string{"cdn.download.clearlinux.org/update"}},
+		{URLs: []string{"ftp://mirror.example.com/update"}},
+		{DNS: []string{"bad_name.example.com"}},
+		{OnFailure: "retry"},
+	}
+
+	for _, curr := range bad {
+		if err := curr.Validate(); err == nil {
+			t.Fatalf("Connectivity %q should be invalid", curr)
+		}
+	}
+
+	good := &Connectivity{
+		URLs:      []string{"https://cdn.download.clearlinux.org/update/", "file:///srv/mirror/version"},
+		DNS:       []string{"cdn.download.clearlinux.org"},
+		OnFailure: ConnectivityContinue,
+	}
+
+	if err := good.Validate(); err != nil {
+		t.Fatalf("Connectivity %q should be valid: %v", good, err)
+	}
+
+	expected := "urls=[https://cdn.download.clearlinux.org/update/,file:///srv/mirror/version] " +
+		"dns=[cdn.download.clearlinux.org] timeout=10s attempts=3 interval=2s onFailure=continue"
+	if good.String() != expected {
+		t.Fatalf("Expected connectivity %q, got %q", expected, good.String())
+	}
+
+	if good.Fatal() || !(&Connectivity{}).Fatal() {
+		t.Fatal("Only the checks continuing on failure should not be fatal")
+	}
+}
+
+func TestVerifyConnectivity(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/update/version/formatstaging/latest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, "33000")
+	}))
+	defer srv.Close()
+
+	prev := probeSleep
+	probeSleep = func(time.Duration) {}
+	defer func() { probeSleep = prev }()
+
+	c := &Connectivity{URLs: []string{srv.URL + "/update/version/formatstaging/latest", "file:///proc/cmdline"},
+		DNS: []string{"localhost"}}
+
+	if err := c.Verify(nil); err != nil {
+		t.Fatalf("The connectivity checks should succeed: %v", err)
+	}
+
+	retries := 0
+	c = &Connectivity{URLs: []string{srv.URL + "/missing"}, Attempts: 4}
+
+	err := c.Verify(func() error {
+		retries++
+		return nil
+	})
+	if err == nil {
+		t.Fatal("A missing URL should fail the connectivity checks")
+	}
+
+	if retries != 3 {
+		t.Fatalf("Expected 3 retries, got %d", retries)
+	}
+
+	retries = 0
+	err = c.Verify(func() error {
+		retries++
+		return fmt.Errorf("restart failed")
+	})
+	if err == nil || retries != 1 {
+		t.Fatalf("A failed retry should stop the checks, got %d retries: %v", retries, err)
+	}
+
+	c = &Connectivity{URLs: []string{"file:///missing/clear-mirror"}, Attempts: 1}
+	if err = c.Verify(nil); err == nil {
+		t.Fatal("A missing local mirror should fail the connectivity checks")
+	}
+}
+
+func TestProxyPAC(t *testing.T) {
+	proxy.SetConfig(&proxy.Config{PAC: "http://wpad.example.com/wpad.dat"})
+	defer proxy.SetConfig(nil)
+
+	iface := &Interface{Name: "br0", DHCP: true, IPv6: IPv6Disabled, Bridge: &Bridge{Ports: []string{"eth0"}}}
+
+	config, err := iface.virtualKeyfile(nil, proxy.PAC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[ipv6]
+method=disabled
+
+[proxy]
+method=1
+pac-url=http://wpad.example.com/wpad.dat
+`
+	if !strings.HasSuffix(config, expected) {
+		t.Fatalf("Expected keyfile ending with:\n%s\ngot:\n%s", expected, config)
+	}
+
+	iface = &Interface{Name: "eth0", DHCP: true, IPv6: IPv6SLAAC}
+
+	args, err := iface.networkManagerArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "nmcli connection add type ethernet ifname eth0 con-name Wired-eth0 " +
+		"ipv4.method auto ipv6.method auto proxy.method auto proxy.pac-url http://wpad.example.com/wpad.dat"
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("Expected nmcli arguments:\n%s\ngot:\n%s", expected, strings.Join(args, " "))
+	}
+
+	// the proxies only used by the installer are not written to the target
+	proxy.SetConfig(&proxy.Config{PAC: "http://wpad.example.com/wpad.dat", InstallOnly: true})
+
+	iface = &Interface{Name: "wlan0", DHCP: true, Wireless: &Wireless{SSID: "corp"}}
+
+	if config, err = iface.wirelessKeyfile(false); err != nil || !strings.Contains(config, "[proxy]") {
+		t.Fatalf("The installer connection should use the proxy auto-config: %v\n%s", err, config)
+	}
+
+	if config, err = iface.wirelessKeyfile(true); err != nil || strings.Contains(config, "[proxy]") {
+		t.Fatalf("The target connection should not use the proxy auto-config: %v\n%s", err, config)
+	}
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, networkManagerDir, "Bridge-br0.nmconnection")
+	if err = utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("[connection]\nid=Bridge-br0\n\n"+
+		"[proxy]\nmethod=1\npac-url=http://wpad.example.com/wpad.dat\n\n[ipv6]\nmethod=auto\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = removeTargetProxy(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "[connection]\nid=Bridge-br0\n\n[ipv6]\nmethod=auto\n"
+	if content, err := ioutil.ReadFile(path); err != nil || string(content) != expected {
+		t.Fatalf("Expected copied keyfile:\n%s\ngot:\n%s", expected, content)
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	content := "keyboard: us\n"
+	sum := sha256.Sum256([]byte(content))
+
+	failures := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky.yaml":
+			if failures < 2 {
+				failures++
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+		case "/clr-installer.yaml":
+		default:
+			failures++
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, content)
+	}))
+	defer srv.Close()
+
+	prev := fetchSleep
+	delays := []time.Duration{}
+	fetchSleep = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { fetchSleep = prev }()
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "downloaded.yaml")
+	local := filepath.Join(dir, "local.yaml")
+	if err = ioutil.WriteFile(local, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, curr := range []string{srv.URL + "/clr-installer.yaml", srv.URL + "/flaky.yaml", "file://" + local} {
+		f := &Fetcher{SHA256: hex.EncodeToString(sum[:])}
+		if err = f.Fetch(curr, path); err != nil {
+			t.Fatalf("Fetching %s should succeed: %v", curr, err)
+		}
+
+		if got, _ := ioutil.ReadFile(path); string(got) != content {
+			t.Fatalf("Expected content %q for %s, got %q", content, curr, string(got))
+		}
+	}
+
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Fatalf("Expected the retries to back off, got the delays %v", delays)
+	}
+
+	failures = 0
+	if err = (&Fetcher{}).Fetch(srv.URL+"/missing.yaml", path); err == nil ||
+		!strings.Contains(err.Error(), "404 Not Found") {
+		t.Fatalf("A missing file should fail with its status: %v", err)
+	}
+
+	if !IsNotFound(err) {
+		t.Fatalf("The missing file should be reported as not found: %v", err)
+	}
+
+	if failures != 1 {
+		t.Fatalf("A missing file should not be fetched again, got %d attempts", failures)
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		t.Fatal("A failed download should not leave a file")
+	}
+
+	bad := []*Fetcher{
+		{MaxSize: 4},
+		{SHA256: strings.Repeat("0", 64)},
+	}
+
+	for _, curr := range bad {
+		for _, u := range []string{srv.URL + "/clr-installer.yaml", "file://" + local} {
+			if err = curr.Fetch(u, path); err == nil || IsNotFound(err) {
+				t.Fatalf("Fetching %s with %+v should fail, the file exists: %v", u, curr, err)
+			}
+		}
+	}
+
+	if _, err = (&Fetcher{}).FetchTemp("ftp://ftp.example.com/clr-installer.yaml", "clr-installer-test-"); err == nil {
+		t.Fatal("Fetching an ftp URL should fail")
+	}
+
+	for _, curr := range []string{srv.URL + "/clr-installer.yaml", "file://" + local} {
+		if err = (&Fetcher{Attempts: 1}).Check(curr); err != nil {
+			t.Fatalf("Checking %s should succeed: %v", curr, err)
+		}
+	}
+
+	failures = 0
+	for _, curr := range []string{srv.URL + "/flaky.yaml", "file://" + path} {
+		if err = (&Fetcher{Attempts: 1}).Check(curr); err == nil {
+			t.Fatalf("Checking %s should fail", curr)
+		}
+	}
+
+	if failures != 1 {
+		t.Fatalf("A single attempt should be made, got %d attempts", failures)
+	}
+
+	if err = (&Fetcher{}).Fetch("file://"+path, path+".copy"); !IsNotFound(err) {
+		t.Fatalf("A missing local file should be reported as not found: %v", err)
+	}
+}
+
+func TestFetcherResume(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	sum := sha256.Sum256([]byte(content))
+
+	ranges := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		if r.URL.Path == "/norange" {
+			fmt.Fprint(w, content)
+			return
+		}
+
+		http.ServeContent(w, r, "content", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "content.part")
+	local := filepath.Join(dir, "local")
+	if err = ioutil.WriteFile(local, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, curr := range []struct {
+		url     string
+		partial string
+		rng     string
+	}{
+		{srv.URL + "/range", content[:300], "bytes=300-"},
+		{srv.URL + "/range", "", ""},
+		{srv.URL + "/range", content + "garbage", "bytes=1007-"},
+		{srv.URL + "/norange", content[:300], "bytes=300-"},
+		{"file://" + local, content[:300], ""},
+	} {
+		ranges = []string{}
+		if err = ioutil.WriteFile(path, []byte(curr.partial), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		f := &Fetcher{SHA256: hex.EncodeToString(sum[:])}
+		if err = f.Resume(curr.url, path); err != nil {
+			t.Fatalf("Resuming %s from %d bytes should succeed: %v", curr.url, len(curr.partial), err)
+		}
+
+		if got, _ := ioutil.ReadFile(path); string(got) != content {
+			t.Fatalf("Expected the complete content for %s, got %d bytes", curr.url, len(got))
+		}
+
+		if len(ranges) > 0 && ranges[0] != curr.rng {
+			t.Fatalf("Expected the range %q for %s, got %q", curr.rng, curr.url, ranges[0])
+		}
+	}
+
+	// a corrupted download fails its checksum and is not kept
+	if err = ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Fetcher{SHA256: hex.EncodeToString(sum[:])}
+	if err = f.Resume(srv.URL+"/range", path); err == nil {
+		t.Fatal("Resuming a corrupted download should fail its checksum")
+	}
+
+	if _, err = os.Stat(path); err == nil {
+		t.Fatal("A download failing its checksum should not be kept")
+	}
+}
+
+func TestSetTLSFiles(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer srv.Close()
+	defer func() { _ = SetTLSFiles("", "", "") }()
+
+	dir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	f := &Fetcher{Attempts: 1}
+	path := filepath.Join(dir, "downloaded")
+
+	if err = f.Fetch(srv.URL, path); err == nil {
+		t.Fatal("An unknown certificate authority should fail")
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err = ioutil.WriteFile(caFile, pem.EncodeToMemory(block), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SetTLSFiles(dir, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Fetch(srv.URL, path); err != nil {
+		t.Fatalf("The CA bundle should be trusted: %v", err)
+	}
+
+	if err = SetTLSFiles("", caFile, ""); err == nil {
+		t.Fatal("A client certificate without a key should fail")
+	}
+
+	if err = SetTLSFiles(path, "", ""); err == nil {
+		t.Fatal("A CA bundle without certificates should fail")
+	}
+}
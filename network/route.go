@@ -0,0 +1,177 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+// Route is a static route through an interface, the gateway may be left
+// out for the destinations directly reachable on the link
+type Route struct {
+	To     string `yaml:"to"`
+	Via    string `yaml:"via,omitempty"`
+	Metric uint32 `yaml:"metric,omitempty"`
+	Table  uint32 `yaml:"table,omitempty"`
+}
+
+// version returns the ip version of the route destination
+func (r *Route) version() int {
+	if strings.Contains(r.To, ":") {
+		return IPv6
+	}
+
+	return IPv4
+}
+
+// Validate checks the destination and gateway of the route are of the same ip version
+func (r *Route) Validate() error {
+	if msg := IsValidCIDR(r.To); msg != "" {
+		return errors.ValidationErrorf("Invalid route destination %s: %s", r.To, msg)
+	}
+
+	if r.Via == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(r.Via)
+	if ip == nil {
+		return errors.ValidationErrorf("Invalid gateway %s for route %s", r.Via, r.To)
+	}
+
+	if (ip.To4() == nil) != (r.version() == IPv6) {
+		return errors.ValidationErrorf("The gateway %s of route %s is not of the same ip version", r.Via, r.To)
+	}
+
+	return nil
+}
+
+// String returns the route as parsed by ParseRoutes
+func (r *Route) String() string {
+	fields := []string{r.To}
+
+	if r.Via != "" {
+		fields = append(fields, "via", r.Via)
+	}
+
+	if r.Metric != 0 {
+		fields = append(fields, "metric", strconv.FormatUint(uint64(r.Metric), 10))
+	}
+
+	if r.Table != 0 {
+		fields = append(fields, "table", strconv.FormatUint(uint64(r.Table), 10))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// FormatRoutes returns the routes separated by semicolons as parsed by ParseRoutes
+func FormatRoutes(routes []*Route) string {
+	result := []string{}
+
+	for _, curr := range routes {
+		result = append(result, curr.String())
+	}
+
+	return strings.Join(result, "; ")
+}
+
+// ParseRoutes parses a list of routes separated by semicolons, each route is
+// written as: <destination> [via <gateway>] [metric <metric>] [table <table>]
+func ParseRoutes(str string) ([]*Route, error) {
+	result := []*Route{}
+
+	for _, curr := range strings.Split(str, ";") {
+		fields := strings.Fields(curr)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields)%2 == 0 {
+			return nil, errors.ValidationErrorf("Invalid route: %s", strings.TrimSpace(curr))
+		}
+
+		route := &Route{To: fields[0]}
+
+		for idx := 1; idx < len(fields); idx += 2 {
+			key, value := fields[idx], fields[idx+1]
+
+			switch key {
+			case "via":
+				route.Via = value
+			case "metric", "table":
+				num, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					return nil, errors.ValidationErrorf("Invalid route %s %s: %s", key, value, route.To)
+				}
+
+				if key == "metric" {
+					route.Metric = uint32(num)
+				} else {
+					route.Table = uint32(num)
+				}
+			default:
+				return nil, errors.ValidationErrorf("Invalid route option %q, expected one of: via, metric, table",
+					key)
+			}
+		}
+
+		if err := route.Validate(); err != nil {
+			return nil, err
+		}
+
+		result = append(result, route)
+	}
+
+	return result, nil
+}
+
+// networkManagerRoute returns the route in the nmcli ipv4.routes and ipv6.routes syntax
+func (r *Route) networkManagerRoute() string {
+	fields := []string{r.To}
+
+	if r.Via != "" {
+		fields = append(fields, r.Via)
+	}
+
+	if r.Metric != 0 {
+		fields = append(fields, strconv.FormatUint(uint64(r.Metric), 10))
+	}
+
+	if r.Table != 0 {
+		fields = append(fields, fmt.Sprintf("table=%d", r.Table))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// keyfileRoute returns the route in the NetworkManager keyfile syntax, the
+// unspecified address stands for a missing gateway when a metric is given
+func (r *Route) keyfileRoute() string {
+	via := r.Via
+	if via == "" && r.Metric != 0 {
+		via = net.IPv4zero.String()
+		if r.version() == IPv6 {
+			via = net.IPv6unspecified.String()
+		}
+	}
+
+	fields := []string{r.To}
+
+	if via != "" {
+		fields = append(fields, via)
+	}
+
+	if r.Metric != 0 {
+		fields = append(fields, strconv.FormatUint(uint64(r.Metric), 10))
+	}
+
+	return strings.Join(fields, ",")
+}
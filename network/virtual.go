@@ -0,0 +1,463 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+// Bond aggregates the member interfaces into a single link
+type Bond struct {
+	Mode    string   `yaml:"mode,omitempty"`
+	MIIMon  uint     `yaml:"miimon,omitempty"`
+	Members []string `yaml:"members"`
+}
+
+// VLAN is a tagged virtual interface on top of a parent interface
+type VLAN struct {
+	Parent string `yaml:"parent"`
+	ID     int    `yaml:"id"`
+}
+
+// Bridge connects the port interfaces in a single ethernet segment
+type Bridge struct {
+	Ports []string `yaml:"ports,omitempty"`
+	STP   bool     `yaml:"stp,omitempty"`
+}
+
+const (
+	// KindBond identifies a bond virtual device
+	KindBond = "bond"
+
+	// KindVLAN identifies a VLAN virtual device
+	KindVLAN = "vlan"
+
+	// KindBridge identifies a bridge virtual device
+	KindBridge = "bridge"
+
+	// maxInterfaceName is the kernel limit of the interface names (IFNAMSIZ - 1)
+	maxInterfaceName = 15
+)
+
+var (
+	bondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad",
+		"balance-tlb", "balance-alb"}
+
+	// linkWaitTimeout is how long the virtual devices are waited for to come up
+	linkWaitTimeout = 30 * time.Second
+)
+
+// deviceLinks holds how an interface is linked to the virtual devices, parent is
+// the running system device a vlan is created on
+type deviceLinks struct {
+	master string
+	kind   string
+	vlans  []string
+	parent string
+}
+
+// Kind returns the kind of the virtual device of the interface, empty for
+// physical interfaces
+func (i *Interface) Kind() string {
+	switch {
+	case i.Bond != nil:
+		return KindBond
+	case i.VLAN != nil:
+		return KindVLAN
+	case i.Bridge != nil:
+		return KindBridge
+	}
+
+	return ""
+}
+
+// IsVirtual returns true if the interface is a bond, VLAN or bridge created by the installer
+func (i *Interface) IsVirtual() bool {
+	return i.Kind() != ""
+}
+
+// validateVirtual checks the virtual device settings of the interface
+func (i *Interface) validateVirtual() error {
+	kinds := 0
+	for _, curr := range []bool{i.IsWireless(), i.Bond != nil, i.VLAN != nil, i.Bridge != nil} {
+		if curr {
+			kinds++
+		}
+	}
+
+	if kinds > 1 {
+		return errors.ValidationErrorf("Interface %s: only one of wifi, bond, vlan or bridge can be set", i.Name)
+	}
+
+	if !i.IsVirtual() {
+		return nil
+	}
+
+	if len(i.Name) > maxInterfaceName {
+		return errors.ValidationErrorf("Interface %s: the name must have at most %d characters",
+			i.Name, maxInterfaceName)
+	}
+
+	switch {
+	case i.Bond != nil:
+		if i.Bond.Mode != "" && !contains(bondModes, i.Bond.Mode) {
+			return errors.ValidationErrorf("Interface %s: invalid bond mode %q, expected one of: %s",
+				i.Name, i.Bond.Mode, strings.Join(bondModes, ", "))
+		}
+
+		if len(i.Bond.Members) == 0 {
+			return errors.ValidationErrorf("Interface %s: a bond requires at least one member", i.Name)
+		}
+	case i.VLAN != nil:
+		if i.VLAN.Parent == "" {
+			return errors.ValidationErrorf("Interface %s: a vlan requires a parent interface", i.Name)
+		}
+
+		if i.VLAN.ID < 1 || i.VLAN.ID > 4094 {
+			return errors.ValidationErrorf("Interface %s: the vlan id must be between 1 and 4094", i.Name)
+		}
+	}
+
+	return nil
+}
+
+// subordinates returns the interfaces the virtual device is built on
+func (i *Interface) subordinates() []string {
+	switch {
+	case i.Bond != nil:
+		return i.Bond.Members
+	case i.VLAN != nil:
+		return []string{i.VLAN.Parent}
+	case i.Bridge != nil:
+		return i.Bridge.Ports
+	}
+
+	return nil
+}
+
+// hasAddressing returns true if any addressing is configured for the interface
+func (i *Interface) hasAddressing() bool {
+	return i.DHCP || len(i.Addrs) > 0 || i.Gateway != "" || i.Gateway6 != "" || len(i.DNSServers) > 0 ||
+		len(i.Routes) > 0 || (i.IPv6 != "" && i.IPv6 != IPv6Disabled)
+}
+
+// ValidateTopology checks the virtual devices against each other and the interfaces
+// they are built on: the names are unique, an interface belongs to a single bond or
+// bridge and the bond members and bridge ports have no addressing of their own
+func ValidateTopology(ifaces []*Interface) error {
+	byName := map[string]*Interface{}
+
+	for _, curr := range ifaces {
+		if _, ok := byName[curr.Name]; ok {
+			return errors.ValidationErrorf("Interface %s is defined more than once", curr.Name)
+		}
+
+		byName[curr.Name] = curr
+	}
+
+	masters := map[string]string{}
+
+	for _, curr := range ifaces {
+		for _, sub := range curr.subordinates() {
+			if sub == curr.Name {
+				return errors.ValidationErrorf("Interface %s can not be built on itself", curr.Name)
+			}
+
+			if curr.VLAN != nil {
+				continue
+			}
+
+			if master, ok := masters[sub]; ok {
+				return errors.ValidationErrorf("Interface %s belongs to both %s and %s", sub, master, curr.Name)
+			}
+			masters[sub] = curr.Name
+
+			if iface, ok := byName[sub]; ok && iface.hasAddressing() {
+				return errors.ValidationErrorf("Interface %s belongs to %s and can not have addressing",
+					sub, curr.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// linksOf returns how the interfaces are linked to the virtual devices by interface
+// name, including the interfaces referenced but not defined
+func linksOf(ifaces []*Interface) map[string]*deviceLinks {
+	result := map[string]*deviceLinks{}
+
+	get := func(name string) *deviceLinks {
+		if _, ok := result[name]; !ok {
+			result[name] = &deviceLinks{}
+		}
+		return result[name]
+	}
+
+	for _, curr := range ifaces {
+		switch {
+		case curr.Bond != nil:
+			for _, member := range curr.Bond.Members {
+				l := get(member)
+				l.master, l.kind = curr.Name, KindBond
+			}
+		case curr.Bridge != nil:
+			for _, port := range curr.Bridge.Ports {
+				l := get(port)
+				l.master, l.kind = curr.Name, KindBridge
+			}
+		case curr.VLAN != nil:
+			l := get(curr.VLAN.Parent)
+			l.vlans = append(l.vlans, curr.Name)
+			get(curr.Name).parent = deviceName(ifaces, curr.VLAN.Parent)
+		}
+	}
+
+	return result
+}
+
+// deviceName returns the running system device of the named interface, the
+// matched devices are only renamed when the target boots
+func deviceName(ifaces []*Interface, name string) string {
+	for _, curr := range ifaces {
+		if curr.Name == name && curr.Device != "" {
+			return curr.Device
+		}
+	}
+
+	return name
+}
+
+// vlanParent returns the device the vlan is created on, the parent name of the
+// configuration if the links are not known
+func (l *deviceLinks) vlanParent(i *Interface) string {
+	if l == nil || l.parent == "" {
+		return i.VLAN.Parent
+	}
+
+	return l.parent
+}
+
+// isSubordinate returns true if the interface is a bond member or bridge port
+func (l *deviceLinks) isSubordinate() bool {
+	return l != nil && l.master != ""
+}
+
+// networkDLines returns the [Network] lines linking the interface to the virtual devices
+func (l *deviceLinks) networkDLines() []string {
+	result := []string{}
+
+	if l == nil {
+		return result
+	}
+
+	switch l.kind {
+	case KindBond:
+		result = append(result, "Bond="+l.master)
+	case KindBridge:
+		result = append(result, "Bridge="+l.master)
+	}
+
+	for _, curr := range l.vlans {
+		result = append(result, "VLAN="+curr)
+	}
+
+	return result
+}
+
+// netDevConfig returns the systemd.netdev configuration creating the virtual device
+func (i *Interface) netDevConfig() string {
+	k := &keyfile{}
+	k.section("NetDev").
+		set("Name", i.Name).
+		set("Kind", i.Kind())
+
+	switch {
+	case i.Bond != nil:
+		miimon := ""
+		if i.Bond.MIIMon > 0 {
+			miimon = fmt.Sprintf("%dms", i.Bond.MIIMon)
+		}
+
+		k.section("Bond").
+			set("Mode", i.Bond.Mode).
+			set("MIIMonitorSec", miimon)
+	case i.VLAN != nil:
+		k.section("VLAN").set("Id", strconv.Itoa(i.VLAN.ID))
+	case i.Bridge != nil:
+		stp := "no"
+		if i.Bridge.STP {
+			stp = "yes"
+		}
+
+		k.section("Bridge").set("STP", stp)
+	}
+
+	return k.String()
+}
+
+// subordinateNetworkDConfig returns the systemd.network configuration of a bond
+// member or bridge port, which has no addressing of its own
+func (i *Interface) subordinateNetworkDConfig(links *deviceLinks) string {
+	return fmt.Sprintf("[Match]\n%s\n\n[Network]\n%s\n", strings.Join(i.matchLines(), "\n"),
+		strings.Join(links.networkDLines(), "\n"))
+}
+
+// virtualKeyfile returns the NetworkManager keyfile creating the virtual device
+// with the given proxy auto-config
+func (i *Interface) virtualKeyfile(links *deviceLinks, pac string) (string, error) {
+	k := i.newKeyfile(i.Kind())
+
+	switch {
+	case i.Bond != nil:
+		miimon := ""
+		if i.Bond.MIIMon > 0 {
+			miimon = strconv.FormatUint(uint64(i.Bond.MIIMon), 10)
+		}
+
+		k.section("bond").
+			set("mode", i.Bond.Mode).
+			set("miimon", miimon)
+	case i.VLAN != nil:
+		k.section("vlan").
+			set("parent", links.vlanParent(i)).
+			set("id", strconv.Itoa(i.VLAN.ID))
+	case i.Bridge != nil:
+		k.section("bridge").set("stp", strconv.FormatBool(i.Bridge.STP))
+	}
+
+	if err := i.addIPSections(k); err != nil {
+		return "", err
+	}
+	addKeyfileProxy(k, pac)
+
+	return k.String(), nil
+}
+
+// subordinateConnectionName returns the NetworkManager connection name of a bond
+// member or bridge port
+func (i *Interface) subordinateConnectionName(links *deviceLinks) string {
+	prefix := map[string]string{KindBond: "Bond", KindBridge: "Bridge"}[links.kind]
+	return fmt.Sprintf("%s-%s-%s", prefix, links.master, i.Name)
+}
+
+// subordinateKeyfile returns the NetworkManager keyfile enslaving the ethernet
+// interface to a bond or bridge
+func (i *Interface) subordinateKeyfile(links *deviceLinks) string {
+	k := &keyfile{}
+	k.section("connection").
+		set("id", i.subordinateConnectionName(links)).
+		set("type", "ethernet").
+		set("interface-name", i.keyfileInterfaceName()).
+		set("master", links.master).
+		set("slave-type", links.kind)
+
+	if i.Match != nil {
+		i.addKeyfileMatch(k, k.section("ethernet"))
+	}
+
+	return k.String()
+}
+
+// applySubordinates writes the configuration of the bond members, bridge ports and
+// vlan parents referenced by the virtual devices but not defined themselves
+func applySubordinates(root string, ifaces []*Interface, links map[string]*deviceLinks, netMgr bool) error {
+	defined := map[string]bool{}
+	for _, curr := range ifaces {
+		defined[curr.Name] = true
+	}
+
+	names := []string{}
+	for name := range links {
+		if !defined[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		l := links[name]
+		iface := &Interface{Name: name}
+
+		if netMgr {
+			// NetworkManager brings up the vlan parents on its own
+			if !l.isSubordinate() {
+				continue
+			}
+
+			fileName := fmt.Sprintf("%s.nmconnection", iface.subordinateConnectionName(l))
+			filePath := filepath.Join(root, networkManagerDir, fileName)
+			if err := writeFileMode(filePath, iface.subordinateKeyfile(l), 0600); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fileName := fmt.Sprintf("10-%s.network", name)
+		filePath := filepath.Join(root, systemdNetworkdDir, fileName)
+		if err := writeFileMode(filePath, iface.subordinateNetworkDConfig(l), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WaitForVirtualDevices waits for the virtual devices to be created and come up
+// after the network services are restarted, devices still down when the timeout
+// expires are reported and left to the connectivity check
+func WaitForVirtualDevices(ifaces []*Interface) error {
+	pending := []string{}
+	for _, curr := range ifaces {
+		if curr.IsVirtual() {
+			pending = append(pending, curr.Name)
+		}
+	}
+
+	deadline := time.Now().Add(linkWaitTimeout)
+
+	for len(pending) > 0 {
+		down := []string{}
+
+		for _, name := range pending {
+			state, err := ioutil.ReadFile(filepath.Join(sysClassNetDir, name, "operstate"))
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err)
+			}
+
+			switch strings.TrimSpace(string(state)) {
+			case "up", "unknown":
+				log.Info("Virtual device %s is up", name)
+			default:
+				down = append(down, name)
+			}
+		}
+
+		pending = down
+		if len(pending) == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("Virtual devices not up after %v: %s", linkWaitTimeout,
+				strings.Join(pending, ", "))
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/proxy"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Wireless holds the Wi-Fi network an interface connects to
+type Wireless struct {
+	SSID     string `yaml:"ssid"`
+	Hidden   bool   `yaml:"hidden,omitempty"`
+	Security string `yaml:"security,omitempty"`
+	PSK      string `yaml:"psk,omitempty"`
+	EAP      *EAP   `yaml:"eap,omitempty"`
+}
+
+// EAP holds the enterprise (802.1X) authentication settings of a Wi-Fi network
+type EAP struct {
+	Method             string `yaml:"method"`
+	Identity           string `yaml:"identity,omitempty"`
+	AnonymousIdentity  string `yaml:"anonymousIdentity,omitempty"`
+	Password           string `yaml:"password,omitempty"`
+	Phase2             string `yaml:"phase2,omitempty"`
+	CACert             string `yaml:"caCert,omitempty"`
+	ClientCert         string `yaml:"clientCert,omitempty"`
+	PrivateKey         string `yaml:"privateKey,omitempty"`
+	PrivateKeyPassword string `yaml:"privateKeyPassword,omitempty"`
+}
+
+const (
+	// WirelessOpen is an open Wi-Fi network without authentication
+	WirelessOpen = "none"
+
+	// WirelessWPA2PSK is a WPA2 Personal Wi-Fi network
+	WirelessWPA2PSK = "wpa2-psk"
+
+	// WirelessWPA3PSK is a WPA3 Personal (SAE) Wi-Fi network
+	WirelessWPA3PSK = "wpa3-psk"
+
+	// WirelessEAP is a WPA2/WPA3 Enterprise (802.1X) Wi-Fi network
+	WirelessEAP = "wpa-eap"
+
+	// wirelessCertDir is where the certificates of the Wi-Fi networks are copied in the target
+	wirelessCertDir = "/etc/NetworkManager/certs"
+)
+
+var (
+	sysClassNetDir = "/sys/class/net"
+
+	pskHexExp = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+	// keyMgmt maps the security to NetworkManager's wifi-sec.key-mgmt
+	keyMgmt = map[string]string{
+		WirelessWPA2PSK: "wpa-psk",
+		WirelessWPA3PSK: "sae",
+		WirelessEAP:     "wpa-eap",
+	}
+
+	eapMethods = []string{"peap", "ttls", "tls"}
+	eapPhase2  = []string{"mschapv2", "mschap", "pap", "chap", "gtc", "md5"}
+)
+
+// IsWireless returns true if the named network interface is a Wi-Fi device
+func IsWireless(name string) bool {
+	_, err := os.Stat(filepath.Join(sysClassNetDir, name, "wireless"))
+	return err == nil
+}
+
+// WirelessDevices lists the names of the Wi-Fi network interfaces
+func WirelessDevices() ([]string, error) {
+	result := []string{}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	for _, curr := range ifaces {
+		if IsWireless(curr.Name) {
+			result = append(result, curr.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// IsWireless returns true if the interface connects to a Wi-Fi network
+func (i *Interface) IsWireless() bool {
+	return i.Wireless != nil
+}
+
+// ConnectionName returns the NetworkManager connection name of the interface
+func (i *Interface) ConnectionName() string {
+	switch {
+	case i.IsWireless():
+		return fmt.Sprintf("Wifi-%s", i.Name)
+	case i.Bond != nil:
+		return fmt.Sprintf("Bond-%s", i.Name)
+	case i.VLAN != nil:
+		return fmt.Sprintf("Vlan-%s", i.Name)
+	case i.Bridge != nil:
+		return fmt.Sprintf("Bridge-%s", i.Name)
+	}
+
+	return fmt.Sprintf("Wired-%s", i.Name)
+}
+
+func contains(list []string, value string) bool {
+	for _, curr := range list {
+		if curr == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the Wi-Fi network settings
+func (w *Wireless) Validate() error {
+	if len(w.SSID) < 1 || len(w.SSID) > 32 {
+		return errors.ValidationErrorf("The SSID must have 1 to 32 characters")
+	}
+
+	switch w.Security {
+	case "", WirelessOpen:
+		if w.PSK != "" || w.EAP != nil {
+			return errors.ValidationErrorf("Open Wi-Fi network %s can not have a psk nor eap settings", w.SSID)
+		}
+	case WirelessWPA2PSK:
+		if !pskHexExp.MatchString(w.PSK) && (len(w.PSK) < 8 || len(w.PSK) > 63) {
+			return errors.ValidationErrorf("The psk of Wi-Fi network %s must have 8 to 63 characters", w.SSID)
+		}
+	case WirelessWPA3PSK:
+		if w.PSK == "" {
+			return errors.ValidationErrorf("Wi-Fi network %s requires a psk", w.SSID)
+		}
+	case WirelessEAP:
+		if w.EAP == nil {
+			return errors.ValidationErrorf("Wi-Fi network %s requires eap settings", w.SSID)
+		}
+
+		return w.EAP.validate(w.SSID)
+	default:
+		return errors.ValidationErrorf("Invalid security %q for Wi-Fi network %s, expected one of: %s",
+			w.Security, w.SSID, strings.Join([]string{WirelessOpen, WirelessWPA2PSK, WirelessWPA3PSK,
+				WirelessEAP}, ", "))
+	}
+
+	return nil
+}
+
+// RemoveSecrets clears the psk and eap passwords so they are never written
+// to a saved configuration
+func (w *Wireless) RemoveSecrets() {
+	w.PSK = ""
+
+	if w.EAP != nil {
+		w.EAP.Password = ""
+		w.EAP.PrivateKeyPassword = ""
+	}
+}
+
+func (e *EAP) validate(ssid string) error {
+	if !contains(eapMethods, e.Method) {
+		return errors.ValidationErrorf("Invalid eap method %q for Wi-Fi network %s, expected one of: %s",
+			e.Method, ssid, strings.Join(eapMethods, ", "))
+	}
+
+	if e.Identity == "" {
+		return errors.ValidationErrorf("Wi-Fi network %s requires an eap identity", ssid)
+	}
+
+	if e.Method == "tls" && (e.ClientCert == "" || e.PrivateKey == "") {
+		return errors.ValidationErrorf("Wi-Fi network %s requires a client certificate and private key", ssid)
+	}
+
+	if e.Method != "tls" && e.Password == "" {
+		return errors.ValidationErrorf("Wi-Fi network %s requires an eap password", ssid)
+	}
+
+	if e.Phase2 != "" && !contains(eapPhase2, e.Phase2) {
+		return errors.ValidationErrorf("Invalid eap phase2 %q for Wi-Fi network %s, expected one of: %s",
+			e.Phase2, ssid, strings.Join(eapPhase2, ", "))
+	}
+
+	for _, path := range []string{e.CACert, e.ClientCert, e.PrivateKey} {
+		if path != "" && !filepath.IsAbs(path) {
+			return errors.ValidationErrorf("Certificate %s of Wi-Fi network %s must be an absolute path",
+				path, ssid)
+		}
+	}
+
+	return nil
+}
+
+func (e *EAP) phase2() string {
+	if e.Method == "tls" {
+		return ""
+	}
+
+	if e.Phase2 == "" {
+		return "mschapv2"
+	}
+
+	return e.Phase2
+}
+
+// certificates returns the certificate files of the eap settings by NetworkManager key
+func (e *EAP) certificates() map[string]string {
+	result := map[string]string{}
+
+	for key, path := range map[string]string{
+		"ca-cert":     e.CACert,
+		"client-cert": e.ClientCert,
+		"private-key": e.PrivateKey,
+	} {
+		if path != "" {
+			result[key] = path
+		}
+	}
+
+	return result
+}
+
+// targetCertPath returns where a certificate of the interface is copied in the target
+func (i *Interface) targetCertPath(path string) string {
+	return filepath.Join(wirelessCertDir, fmt.Sprintf("%s-%s", i.Name, filepath.Base(path)))
+}
+
+// wirelessKeyfile returns the NetworkManager keyfile of a Wi-Fi interface, the
+// certificates and the proxy are the ones of the target if requested
+func (i *Interface) wirelessKeyfile(target bool) (string, error) {
+	w := i.Wireless
+	k := i.newKeyfile("wifi")
+
+	hidden := ""
+	if w.Hidden {
+		hidden = "true"
+	}
+
+	wifi := k.section("wifi").
+		set("mode", "infrastructure").
+		set("ssid", w.SSID).
+		set("hidden", hidden)
+	i.addKeyfileMatch(k, wifi)
+
+	if km := keyMgmt[w.Security]; km != "" {
+		k.section("wifi-security").
+			set("key-mgmt", km).
+			set("psk", w.PSK)
+	}
+
+	if w.Security == WirelessEAP && w.EAP != nil {
+		eap := w.EAP
+		sec := k.section("802-1x").
+			set("eap", eap.Method+";").
+			set("identity", eap.Identity).
+			set("anonymous-identity", eap.AnonymousIdentity).
+			set("password", eap.Password).
+			set("phase2-auth", eap.phase2())
+
+		certs := eap.certificates()
+		for _, key := range []string{"ca-cert", "client-cert", "private-key"} {
+			path, ok := certs[key]
+			if !ok {
+				continue
+			}
+
+			if target {
+				path = i.targetCertPath(path)
+			}
+			sec.set(key, path)
+		}
+
+		sec.set("private-key-password", eap.PrivateKeyPassword)
+	}
+
+	if err := i.addIPSections(k); err != nil {
+		return "", err
+	}
+
+	pac := proxy.PAC()
+	if target {
+		pac = proxy.TargetPAC()
+	}
+	addKeyfileProxy(k, pac)
+
+	return k.String(), nil
+}
+
+// WriteWirelessConnections writes the NetworkManager connections of the Wi-Fi
+// interfaces to the target along with the certificates they use
+func WriteWirelessConnections(rootDir string, ifaces []*Interface) error {
+	for _, curr := range ifaces {
+		if !curr.IsWireless() {
+			continue
+		}
+
+		if curr.Wireless.EAP != nil {
+			for _, path := range curr.Wireless.EAP.certificates() {
+				target := filepath.Join(rootDir, curr.targetCertPath(path))
+
+				if err := utils.MkdirAll(filepath.Dir(target), 0700); err != nil {
+					return errors.Wrap(err)
+				}
+
+				if err := utils.CopyFile(path, target); err != nil {
+					return errors.Wrap(err)
+				}
+
+				if err := os.Chmod(target, 0600); err != nil {
+					return errors.Wrap(err)
+				}
+			}
+		}
+
+		config, err := curr.wirelessKeyfile(true)
+		if err != nil {
+			return err
+		}
+
+		connDir := filepath.Join(rootDir, networkManagerDir)
+		if err = utils.MkdirAll(connDir, 0755); err != nil {
+			return errors.Wrap(err)
+		}
+
+		filePath := filepath.Join(connDir, curr.ConnectionName()+".nmconnection")
+		if err = writeFileMode(filePath, config, 0600); err != nil {
+			return err
+		}
+
+		log.Info("Wrote the Wi-Fi connection %s to the target", curr.ConnectionName())
+	}
+
+	return nil
+}
+
+func writeFileMode(path string, content string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if err = f.Chmod(mode); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err = f.WriteString(content); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Phase is a part of the installation, weighted by its usual share of the
+// installation time in the overall progress
+type Phase int
+
+const (
+	// PhasePartition prepares the target media and its partitions
+	PhasePartition Phase = iota
+
+	// PhaseFormat creates the file systems
+	PhaseFormat
+
+	// PhaseDownload downloads the content of the bundles
+	PhaseDownload
+
+	// PhaseExtract extracts and installs the content of the bundles
+	PhaseExtract
+
+	// PhaseBootloader installs the boot loader
+	PhaseBootloader
+
+	// PhaseUsers configures the target system and creates the users
+	PhaseUsers
+
+	// PhaseHooks runs the post-install hooks and saves the installation results
+	PhaseHooks
+
+	phaseCount
+)
+
+// minETAProgress is the overall progress needed for a meaningful ETA
+const minETAProgress = 0.02
+
+var (
+	// phaseWeights is the share of each phase in a network installation
+	phaseWeights = [phaseCount]float64{3, 4, 50, 30, 5, 3, 5}
+
+	// now is replaced by tests to control the ETA
+	now = time.Now
+
+	overall struct {
+		sync.Mutex
+		active   bool
+		start    time.Time
+		fraction [phaseCount]float64
+		percent  int
+	}
+)
+
+// BeginInstall starts tracking the overall progress of an installation
+func BeginInstall() {
+	overall.Lock()
+	overall.active = true
+	overall.start = now()
+	overall.fraction = [phaseCount]float64{}
+	overall.percent = -1
+	overall.Unlock()
+
+	reportOverall()
+}
+
+// StartPhase sets the phases before phase as completed
+func StartPhase(phase Phase) {
+	overall.Lock()
+	for i := PhasePartition; i < phase && i < phaseCount; i++ {
+		overall.fraction[i] = 1
+	}
+	overall.Unlock()
+
+	reportOverall()
+}
+
+// PhaseProgress sets the completed fraction of phase, from 0 to 1; the
+// progress of a phase never goes back
+func PhaseProgress(phase Phase, fraction float64) {
+	if phase < PhasePartition || phase >= phaseCount {
+		return
+	}
+
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	overall.Lock()
+	if fraction > overall.fraction[phase] {
+		overall.fraction[phase] = fraction
+	}
+	overall.Unlock()
+
+	reportOverall()
+}
+
+// EndInstall completes the overall progress and stops tracking it
+func EndInstall() {
+	StartPhase(phaseCount)
+
+	overall.Lock()
+	overall.active = false
+	overall.Unlock()
+}
+
+// overallProgress returns the overall completed fraction of the installation
+// and the estimated remaining time, which is zero until it can be estimated
+func overallProgress() (float64, time.Duration) {
+	total := 0.0
+	done := 0.0
+
+	for i, weight := range phaseWeights {
+		total += weight
+		done += weight * overall.fraction[i]
+	}
+
+	done = done / total
+	if done < minETAProgress || done >= 1 {
+		return done, 0
+	}
+
+	elapsed := now().Sub(overall.start)
+	eta := time.Duration(float64(elapsed) * (1 - done) / done)
+
+	return done, eta.Round(time.Second)
+}
+
+// reportOverall notifies the client when the overall percentage changes
+func reportOverall() {
+	overall.Lock()
+
+	if !overall.active || impl == nil {
+		overall.Unlock()
+		return
+	}
+
+	done, eta := overallProgress()
+	percent := int(done * 100)
+
+	if percent == overall.percent {
+		overall.Unlock()
+		return
+	}
+	overall.percent = percent
+
+	overall.Unlock()
+
+	impl.Overall(percent, eta)
+}
+
+// OverallString formats the overall progress for display
+func OverallString(percent int, eta time.Duration) string {
+	if eta <= 0 {
+		return fmt.Sprintf("%d%%", percent)
+	}
+
+	return fmt.Sprintf("%d%% (ETA %s)", percent, eta)
+}
@@ -0,0 +1,89 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+type overallClient struct {
+	percents []int
+	eta      time.Duration
+}
+
+func (c *overallClient) Desc(desc string)                {}
+func (c *overallClient) Partial(total int, step int)     {}
+func (c *overallClient) Step()                           {}
+func (c *overallClient) Success()                        {}
+func (c *overallClient) Failure()                        {}
+func (c *overallClient) LoopWaitDuration() time.Duration { return time.Millisecond }
+
+func (c *overallClient) Overall(percent int, eta time.Duration) {
+	c.percents = append(c.percents, percent)
+	c.eta = eta
+}
+
+func TestOverallProgress(t *testing.T) {
+	client := &overallClient{}
+	Set(client)
+	defer Set(nil)
+
+	start := time.Now()
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	BeginInstall()
+	if len(client.percents) != 1 || client.percents[0] != 0 || client.eta != 0 {
+		t.Fatalf("Expected 0%% with no ETA at the beginning, got %v %s", client.percents, client.eta)
+	}
+
+	// partition and format are 7% of the installation
+	StartPhase(PhaseDownload)
+	if last := client.percents[len(client.percents)-1]; last != 7 {
+		t.Fatalf("Expected 7%% at the download phase, got %d", last)
+	}
+
+	// half of the download is 25% more, after 64s the remaining 68% take 2m16s
+	clock = start.Add(64 * time.Second)
+	PhaseProgress(PhaseDownload, 0.5)
+	if last := client.percents[len(client.percents)-1]; last != 32 {
+		t.Fatalf("Expected 32%% at half of the download, got %d", last)
+	}
+
+	if client.eta != 136*time.Second {
+		t.Fatalf("Expected an ETA of 2m16s, got %s", client.eta)
+	}
+
+	// the progress of a phase never goes back
+	count := len(client.percents)
+	PhaseProgress(PhaseDownload, 0.2)
+	if len(client.percents) != count {
+		t.Fatalf("Expected no report for a lower phase progress, got %v", client.percents)
+	}
+
+	EndInstall()
+	if last := client.percents[len(client.percents)-1]; last != 100 || client.eta != 0 {
+		t.Fatalf("Expected 100%% with no ETA at the end, got %d %s", last, client.eta)
+	}
+
+	// nothing is reported out of an installation
+	count = len(client.percents)
+	PhaseProgress(PhaseHooks, 1)
+	if len(client.percents) != count {
+		t.Fatalf("Expected no report out of an installation, got %v", client.percents)
+	}
+}
+
+func TestOverallString(t *testing.T) {
+	if str := OverallString(42, 0); str != "42%" {
+		t.Fatalf("Expected 42%%, got %s", str)
+	}
+
+	if str := OverallString(42, 270*time.Second); str != "42% (ETA 4m30s)" {
+		t.Fatalf("Expected 42%% (ETA 4m30s), got %s", str)
+	}
+}
@@ -32,6 +32,10 @@ type Client interface {
 	// LoopWaitDuration gives the implementation the opportunity configure the loop progress
 	// step period
 	LoopWaitDuration() time.Duration
+
+	// Overall is called whenever the overall installation percentage changes, eta is
+	// the estimated remaining time or zero while it is unknown
+	Overall(percent int, eta time.Duration)
 }
 
 // Progress is the internal interface for the progress subsystem, currently we have
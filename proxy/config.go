@@ -0,0 +1,327 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Config is the proxy configuration of the installer and the target, the
+// password is read from a file or an environment variable and never stored
+// in the configuration
+type Config struct {
+	HTTP         string   `yaml:"http,omitempty"`
+	HTTPS        string   `yaml:"https,omitempty"`
+	FTP          string   `yaml:"ftp,omitempty"`
+	NoProxy      []string `yaml:"noProxy,omitempty,flow"`
+	PAC          string   `yaml:"pac,omitempty"`
+	Username     string   `yaml:"username,omitempty"`
+	PasswordFile string   `yaml:"passwordFile,omitempty"`
+	PasswordEnv  string   `yaml:"passwordEnv,omitempty"`
+	InstallOnly  bool     `yaml:"installOnly,omitempty"`
+	password     string
+}
+
+const (
+	// environmentFile sets the proxies of the user sessions of the target
+	environmentFile = "/etc/environment.d/10-proxy.conf"
+
+	// swupdEnvironmentFile sets the proxies, with the credentials, of the swupd updates
+	swupdEnvironmentFile = "/etc/swupd/proxy.env"
+
+	// swupdServiceDropIn makes the swupd update service use the proxies
+	swupdServiceDropIn = "/etc/systemd/system/swupd-update.service.d/40-proxy.conf"
+)
+
+var (
+	config *Config
+
+	credentialsExp = regexp.MustCompile(`://([^:/@\s]*):[^@\s]*@`)
+)
+
+// SetConfig defines the proxies used by the installer, nil to use the
+// environment and the desktop settings only
+func SetConfig(c *Config) {
+	config = c
+}
+
+// PAC returns the proxy auto-config URL of the installer, if any
+func PAC() string {
+	if config == nil {
+		return ""
+	}
+
+	return config.PAC
+}
+
+// TargetPAC returns the proxy auto-config URL written to the target, empty if
+// the proxies are only used by the installer
+func TargetPAC() string {
+	if config == nil || config.InstallOnly {
+		return ""
+	}
+
+	return config.PAC
+}
+
+// Redact hides the proxy passwords of the given text, as the proxy values
+// are logged with the commands
+func Redact(text string) string {
+	return credentialsExp.ReplaceAllString(text, "://$1:xxxxx@")
+}
+
+// parseProxy parses a proxy address, the scheme defaults to http
+func parseProxy(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// Validate checks the proxy addresses and the source of the credentials
+func (c *Config) Validate() error {
+	for _, curr := range []struct{ name, value string }{
+		{"http", c.HTTP},
+		{"https", c.HTTPS},
+		{"ftp", c.FTP},
+	} {
+		if curr.value == "" {
+			continue
+		}
+
+		u, err := parseProxy(curr.value)
+		if err != nil {
+			return errors.ValidationErrorf("Invalid %s proxy %q: %v", curr.name, curr.value, err)
+		}
+
+		if u.User != nil {
+			return errors.ValidationErrorf("The %s proxy must not include credentials, use username and "+
+				"passwordFile or passwordEnv", curr.name)
+		}
+	}
+
+	for _, curr := range c.NoProxy {
+		if curr == "" || strings.ContainsAny(curr, ", ") {
+			return errors.ValidationErrorf("Invalid noProxy entry %q", curr)
+		}
+	}
+
+	if c.PAC != "" {
+		u, err := url.Parse(c.PAC)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "file") {
+			return errors.ValidationErrorf("Invalid pac URL %q, expected an http, https or file URL", c.PAC)
+		}
+	}
+
+	if c.PasswordFile != "" && c.PasswordEnv != "" {
+		return errors.ValidationErrorf("passwordFile and passwordEnv are mutually exclusive")
+	}
+
+	if c.PasswordFile != "" && !filepath.IsAbs(c.PasswordFile) {
+		return errors.ValidationErrorf("passwordFile must be an absolute path: %s", c.PasswordFile)
+	}
+
+	if (c.PasswordFile != "" || c.PasswordEnv != "") && c.Username == "" {
+		return errors.ValidationErrorf("A proxy password requires a username")
+	}
+
+	return nil
+}
+
+// Load reads the password from its secret source
+func (c *Config) Load() error {
+	switch {
+	case c.PasswordFile != "":
+		content, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		c.password = strings.TrimRight(string(content), "\r\n")
+	case c.PasswordEnv != "":
+		value, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return errors.Errorf("The proxy password variable %s is not set", c.PasswordEnv)
+		}
+		c.password = value
+	}
+
+	return nil
+}
+
+// String returns the configuration as a single line without the credentials,
+// empty for nil
+func (c *Config) String() string {
+	if c == nil {
+		return ""
+	}
+
+	values := []string{}
+	for _, curr := range []struct{ key, value string }{
+		{"http", c.HTTP},
+		{"https", c.HTTPS},
+		{"ftp", c.FTP},
+		{"noProxy", strings.Join(c.NoProxy, ",")},
+		{"pac", c.PAC},
+		{"username", c.Username},
+	} {
+		if curr.value != "" {
+			values = append(values, curr.key+"="+curr.value)
+		}
+	}
+
+	if c.InstallOnly {
+		values = append(values, "installOnly")
+	}
+
+	return strings.Join(values, " ")
+}
+
+// value returns the proxy of a prefix, with the credentials if requested
+func (c *Config) value(prefix string, credentials bool) string {
+	var value string
+
+	switch prefix {
+	case "http":
+		value = c.HTTP
+	case "https":
+		value = c.HTTPS
+	case "ftp":
+		value = c.FTP
+	case "no":
+		return strings.Join(c.NoProxy, ",")
+	}
+
+	if value == "" || !credentials || c.Username == "" {
+		return value
+	}
+
+	u, err := parseProxy(value)
+	if err != nil {
+		return value
+	}
+
+	if c.password != "" {
+		u.User = url.UserPassword(c.Username, c.password)
+	} else {
+		u.User = url.User(c.Username)
+	}
+
+	return u.String()
+}
+
+// environment returns the proxy variables of the configuration
+func (c *Config) environment(credentials bool) string {
+	var buf bytes.Buffer
+
+	for _, prefix := range []string{"http", "https", "ftp", "no"} {
+		value := c.value(prefix, credentials)
+		if value == "" {
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("%s_proxy=%s\n", prefix, value))
+		buf.WriteString(fmt.Sprintf("%s_PROXY=%s\n", strings.ToUpper(prefix), value))
+	}
+
+	return buf.String()
+}
+
+func writeTargetFile(rootDir string, file string, content string, perm os.FileMode) error {
+	path := filepath.Join(rootDir, file)
+
+	if err := utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), perm); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Debug("Created the proxy configuration file %s", path)
+
+	return nil
+}
+
+// Apply writes the proxies to the target: the user sessions get the proxies
+// without the credentials, which are only readable by the swupd updates
+func (c *Config) Apply(rootDir string) error {
+	if c.InstallOnly {
+		return nil
+	}
+
+	env := c.environment(false)
+	if env == "" {
+		return nil
+	}
+
+	header := "# Generated by clr-installer from the proxy configuration\n"
+	if err := writeTargetFile(rootDir, environmentFile, header+env, 0644); err != nil {
+		return err
+	}
+
+	if err := writeTargetFile(rootDir, swupdEnvironmentFile, header+c.environment(true), 0600); err != nil {
+		return err
+	}
+
+	dropIn := header + "[Service]\nEnvironmentFile=" + swupdEnvironmentFile + "\n"
+	if err := writeTargetFile(rootDir, swupdServiceDropIn, dropIn, 0644); err != nil {
+		return err
+	}
+
+	log.Info("Wrote the proxy configuration to the target")
+
+	return nil
+}
+
+// matchNoProxy returns true if the host matches a no_proxy entry: a domain,
+// matching its sub-domains, an ip address or a CIDR
+func matchNoProxy(host string, entry string) bool {
+	if entry == "*" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	if _, cidr, err := net.ParseCIDR(entry); err == nil {
+		return ip != nil && cidr.Contains(ip)
+	}
+
+	if entryIP := net.ParseIP(entry); entryIP != nil {
+		return ip != nil && entryIP.Equal(ip)
+	}
+
+	entry = strings.TrimPrefix(entry, ".")
+	if h, _, err := net.SplitHostPort(entry); err == nil {
+		entry = h
+	}
+
+	return host == entry || strings.HasSuffix(host, "."+entry)
+}
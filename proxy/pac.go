@@ -0,0 +1,86 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package proxy
+
+import (
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// findProxyForURL returns the PAC result for the URL, it is replaced by the tests
+	findProxyForURL = pacrunnerFindProxy
+)
+
+// pacrunnerFindProxy asks pacrunner, which evaluates the proxy auto-config
+// set by NetworkManager or discovered by pacdiscovery, for the proxies of the URL
+func pacrunnerFindProxy(u *url.URL) (string, error) {
+	// The commands run by the installer use the proxy values, not pacrunner,
+	// so this must not recurse through the cmd package
+	out, err := exec.Command("busctl", "--system", "call", "org.pacrunner", "/org/pacrunner/client",
+		"org.pacrunner.Client", "FindProxyForURL", "ss", u.String(), u.Hostname()).Output()
+	if err != nil {
+		return "", err
+	}
+
+	// The reply is a string as in: s "PROXY proxy.example.com:911; DIRECT"
+	reply := strings.TrimSpace(string(out))
+	reply = strings.TrimPrefix(reply, "s ")
+
+	return strings.Trim(reply, `"`), nil
+}
+
+// parsePAC returns the first usable proxy of a PAC result, nil for a direct connection
+func parsePAC(result string) *url.URL {
+	schemes := map[string]string{"PROXY": "http", "HTTP": "http", "HTTPS": "https", "SOCKS": "socks5",
+		"SOCKS5": "socks5"}
+
+	for _, curr := range strings.Split(result, ";") {
+		fields := strings.Fields(curr)
+		if len(fields) == 0 {
+			continue
+		}
+
+		keyword := strings.ToUpper(fields[0])
+		if keyword == "DIRECT" {
+			return nil
+		}
+
+		scheme, ok := schemes[keyword]
+		if !ok || len(fields) != 2 {
+			continue
+		}
+
+		if u, err := url.Parse(scheme + "://" + fields[1]); err == nil && u.Hostname() != "" {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// autoProxy returns the proxy of the URL according to the proxy auto-config,
+// nil for a direct connection or when no auto-config is available
+func autoProxy(u *url.URL) *url.URL {
+	result, err := findProxyForURL(u)
+	if err != nil {
+		log.Debug("No proxy auto-config for %s: %v", u, err)
+		return nil
+	}
+
+	proxyURL := parsePAC(result)
+	if proxyURL != nil && config != nil && config.Username != "" {
+		if config.password != "" {
+			proxyURL.User = url.UserPassword(config.Username, config.password)
+		} else {
+			proxyURL.User = url.User(config.Username)
+		}
+	}
+
+	return proxyURL
+}
@@ -6,9 +6,13 @@ package proxy
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
+	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/log"
 )
 
@@ -55,13 +59,23 @@ func GetProxyValues() []string {
 
 	var value string
 	for _, prefix := range proxyPrefixes {
-		if prefix == "https" && httpsProxy != "" {
+		configured := ""
+		if config != nil {
+			configured = config.value(prefix, true)
+		}
+
+		if configured != "" {
+			value = configured
+		} else if prefix == "https" && httpsProxy != "" {
 			value = httpsProxy
 		} else {
 			value = myGetProxyValueFunc(prefix)
 		}
 
-		if value != "" {
+		if configured != "" {
+			values = append(values, fmt.Sprintf("%s_proxy=%s", prefix, value))
+			values = append(values, fmt.Sprintf("%s_PROXY=%s", strings.ToUpper(prefix), value))
+		} else if value != "" {
 			values = append(values, fmt.Sprintf("%s_proxy=%s", prefix, value))
 
 			upperValue := os.Getenv(strings.ToUpper(prefix) + "_PROXY")
@@ -82,3 +96,66 @@ func DefaultGetProxyValue(prefix string) string {
 	log.Debug("Using default shellProxy.DefaultGetProxyValue")
 	return os.Getenv(prefix + "_proxy")
 }
+
+// values returns the lower case proxy values by prefix
+func values() map[string]string {
+	result := map[string]string{}
+
+	for _, curr := range GetProxyValues() {
+		kv := strings.SplitN(curr, "=", 2)
+		if len(kv) != 2 || kv[0] != strings.ToLower(kv[0]) {
+			continue
+		}
+
+		result[strings.TrimSuffix(kv[0], "_proxy")] = kv[1]
+	}
+
+	return result
+}
+
+// bypass returns true if the host is local or matches an entry of the no_proxy list
+func bypass(host string, noProxy string) bool {
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	for _, curr := range strings.Split(noProxy, ",") {
+		curr = strings.TrimSpace(curr)
+		if curr != "" && matchNoProxy(host, curr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ForURL returns the proxy to use for the given URL, nil for a direct connection
+func ForURL(u *url.URL) (*url.URL, error) {
+	proxies := values()
+
+	if bypass(u.Hostname(), proxies["no"]) {
+		return nil, nil
+	}
+
+	value := proxies[u.Scheme]
+	if value == "" {
+		return autoProxy(u), nil
+	}
+
+	// The proxies are commonly set without a scheme
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+
+	result, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.Errorf("Invalid %s_proxy %q", u.Scheme, Redact(value))
+	}
+
+	return result, nil
+}
+
+// HTTPProxy is a net/http Transport Proxy function honoring the proxy values
+func HTTPProxy(req *http.Request) (*url.URL, error) {
+	return ForURL(req.URL)
+}
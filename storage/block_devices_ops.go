@@ -30,6 +30,7 @@ type MediaOpts struct {
 	SwapFileSize       string `yaml:"swapFileSize,omitempty,flow"`
 	SwapFileSet        bool   `yaml:"-"`
 	ForceDestructive   bool   `yaml:"-"`
+	EstimatedSize      uint64 `yaml:"-"`
 }
 
 // DryRunType to hold results of dryrun from calling WritePartitionTable
@@ -1198,6 +1199,22 @@ func PrepareInstallationMedia(targets map[string]InstallTarget,
 		}
 	}
 
+	// The seeded GUIDs replace the ones of every partition created above,
+	// whatever the partitioning path of the media was
+	if dryRun == nil {
+		for _, target := range targets {
+			for _, curr := range medias {
+				if target.Name != curr.Name {
+					continue
+				}
+
+				if err := curr.setReproducibleGUIDs(target.WholeDisk); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	// Ensure media changes become visible to kernel/OS
 	if dryRun == nil {
 		var prg progress.Progress
@@ -1608,6 +1625,7 @@ func commonMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
 	}
 
 	cmd = append(cmd, args...)
+	cmd = append(cmd, reproducibleMakeFsArgs(bd)...)
 
 	return cmd, nil
 }
@@ -1668,6 +1686,7 @@ func swapMakeFsCommand(bd *BlockDevice, args []string) ([]string, error) {
 		}
 
 		cmd = append(cmd, args...)
+		cmd = append(cmd, reproducibleMakeFsArgs(bd)...)
 	}
 
 	return cmd, nil
@@ -2290,7 +2309,7 @@ func validateBoot(found *bool, bd *BlockDevice, mediaOpts MediaOpts, bootLabel s
 
 // Helper to validatePartitions for validating root minimum size etc
 func validateRoot(found *bool, bd *BlockDevice,
-	minRootSize uint64, skipSize bool, rootLabel string) (*BlockDevice, []string) {
+	minRootSize uint64, estimatedSize uint64, skipSize bool, rootLabel string) (*BlockDevice, []string) {
 	var rootBlockDevice *BlockDevice
 	var results []string
 
@@ -2311,12 +2330,41 @@ func validateRoot(found *bool, bd *BlockDevice,
 	} else {
 		if bd.Size < minRootSize {
 			results = append(results, logPartitionSizeWarning(bd, minRootSize, rootLabel))
+		} else {
+			results = append(results, validateEstimatedSize(bd, estimatedSize, rootLabel)...)
 		}
 	}
 
 	return rootBlockDevice, results
 }
 
+// Helper to validateRoot for validating the root size against the size estimated
+// for the selected bundles
+func validateEstimatedSize(bd *BlockDevice, estimatedSize uint64, rootLabel string) []string {
+	if estimatedSize == 0 || bd.Size == 0 || bd.Size >= estimatedSize {
+		return []string{}
+	}
+
+	size, _ := HumanReadableSizeXiBWithPrecision(estimatedSize, 1)
+	return []string{logPartitionWarning(bd, "%s must be >= %s to hold the selected bundles", rootLabel, size)}
+}
+
+// ValidateEstimatedSize returns an array of validation error strings if the
+// root partition is smaller than the estimated size of the installation
+func ValidateEstimatedSize(medias []*BlockDevice, mediaOpts MediaOpts) []string {
+	results := []string{}
+
+	for _, curr := range medias {
+		for _, ch := range curr.FindAllChildren() {
+			if ch.MountPoint == "/" || ch.Label == "CLR_ROOT" {
+				results = append(results, validateEstimatedSize(ch, mediaOpts.EstimatedSize, "/ (root)")...)
+			}
+		}
+	}
+
+	return results
+}
+
 // Helper to validatePartitions for validating Swap minimum size etc
 func validateSwap(found *bool, bd *BlockDevice, skipSize bool, swapLabel string) []string {
 	var results []string
@@ -2501,7 +2549,7 @@ func validatePartitions(rootSize uint64, medias []*BlockDevice, mediaOpts MediaO
 		}
 		if ch.MountPoint == "/" || (advancedMode && ch.Label == rootLabel) {
 			var newResults []string
-			rootBlockDevice, newResults = validateRoot(&rootFound, ch, rootSize,
+			rootBlockDevice, newResults = validateRoot(&rootFound, ch, rootSize, mediaOpts.EstimatedSize,
 				mediaOpts.SkipValidationSize, rootLabel)
 			results = append(results, newResults...)
 		}
@@ -2717,7 +2765,7 @@ func GetAdvancedPartitions(medias []*BlockDevice) []string {
 			}
 		}
 		if strings.HasPrefix(ch.PartitionLabel, "CLR_ROOT") {
-			_, rootResults := validateRoot(&found, ch, 0, false, "CLR_ROOT")
+			_, rootResults := validateRoot(&found, ch, 0, 0, false, "CLR_ROOT")
 			if len(rootResults) == 0 && found {
 				results = append(results, formatter(ch))
 			}
@@ -80,6 +80,7 @@ func (bd *BlockDevice) MapEncrypted(passphrase string) error {
 		args = append(args, "--label="+bd.Label)
 	}
 
+	args = append(args, reproducibleCryptArgs(bd)...)
 	args = append(args, "luksFormat", bd.GetDeviceFile(), "-")
 
 	if err := cmd.PipeRunAndLog(passphrase, args...); err != nil {
@@ -0,0 +1,134 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+)
+
+var (
+	// reproducibleSeed derives the file system UUIDs and the GPT GUIDs when
+	// set, otherwise the tools generate random ones
+	reproducibleSeed string
+)
+
+// SetReproducibleSeed makes the file system UUIDs and the GPT GUIDs of the next
+// installations derived from seed, an empty seed restores the random ones
+func SetReproducibleSeed(seed string) {
+	reproducibleSeed = seed
+}
+
+// IsReproducible returns true if the identifiers are derived from a seed
+func IsReproducible() bool {
+	return reproducibleSeed != ""
+}
+
+// seededUUID returns a UUID derived from the seed and the names identifying
+// the object, formatted as a name based UUID
+func seededUUID(names ...string) string {
+	sum := sha256.Sum256([]byte(reproducibleSeed + "\x00" + strings.Join(names, "\x00")))
+
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// seedNames identifies the block device by its place in the installation
+// rather than by its device name, which changes between image builds
+func (bd *BlockDevice) seedNames() []string {
+	return []string{
+		fmt.Sprintf("%d", bd.GetPartitionNumber()),
+		bd.MountPoint,
+		bd.FsType,
+		bd.Label,
+	}
+}
+
+// reproducibleMakeFsArgs returns the mkfs arguments setting the seeded identifiers
+func reproducibleMakeFsArgs(bd *BlockDevice) []string {
+	if !IsReproducible() {
+		return []string{}
+	}
+
+	uuid := seededUUID(append([]string{"fs"}, bd.seedNames()...)...)
+
+	switch bd.FsType {
+	case "ext2", "ext3", "ext4":
+		hashSeed := seededUUID(append([]string{"hash"}, bd.seedNames()...)...)
+		return []string{"-U", uuid, "-E", "hash_seed=" + hashSeed}
+	case "btrfs", "f2fs", "swap":
+		return []string{"-U", uuid}
+	case "xfs":
+		return []string{"-m", "uuid=" + uuid}
+	case "vfat":
+		// the FAT volume id is 32 bits
+		return []string{"-i", strings.Replace(uuid, "-", "", -1)[0:8]}
+	}
+
+	log.Warning("No reproducible file system identifiers for %s", bd.FsType)
+
+	return []string{}
+}
+
+// reproducibleCryptArgs returns the luksFormat arguments setting the seeded
+// LUKS UUID, the crypttab and the kernel arguments refer to it
+func reproducibleCryptArgs(bd *BlockDevice) []string {
+	if !IsReproducible() {
+		return []string{}
+	}
+
+	return []string{"--uuid=" + seededUUID(append([]string{"luks"}, bd.seedNames()...)...)}
+}
+
+// setReproducibleGUIDs replaces the random GUIDs of the partitions created by
+// the installation with seeded ones, and the disk GUID if the whole disk is used
+func (bd *BlockDevice) setReproducibleGUIDs(wholeDisk bool) error {
+	if !IsReproducible() {
+		return nil
+	}
+
+	args := []string{
+		"sgdisk",
+		bd.GetDeviceFile(),
+	}
+
+	if wholeDisk {
+		names := []string{"disk"}
+		for _, curr := range bd.Children {
+			names = append(names, curr.MountPoint)
+		}
+
+		args = append(args, fmt.Sprintf("--disk-guid=%s", seededUUID(names...)))
+	}
+
+	for _, curr := range bd.Children {
+		if !curr.MakePartition {
+			continue
+		}
+
+		guid := seededUUID(append([]string{"part"}, curr.seedNames()...)...)
+		args = append(args, fmt.Sprintf("--partition-guid=%d:%s", curr.GetPartitionNumber(), guid))
+	}
+
+	if len(args) == 2 {
+		return nil
+	}
+
+	log.Info("Setting reproducible GUIDs for device: %s", bd.GetDeviceFile())
+
+	if err := cmd.RunAndLog(args...); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -37,6 +37,10 @@ func (mi *FakeInstall) LoopWaitDuration() time.Duration {
 	return 1 * time.Millisecond
 }
 
+// Overall is part of the progress.Client implementation and reports the overall
+// installation progress
+func (mi *FakeInstall) Overall(percent int, eta time.Duration) { return }
+
 // Desc is part of the implementation for ProgresIface and is used to adjust the progress bar
 // label content
 func (mi *FakeInstall) Desc(desc string) {
@@ -993,6 +997,7 @@ func TestPartitionValidation(t *testing.T) {
 		mediaOpts.LegacyBios = false
 		mediaOpts.SkipValidationSize = false
 		mediaOpts.SkipValidationAll = false
+		mediaOpts.EstimatedSize = 0
 		targets = []*BlockDevice{}
 
 		for _, bd := range medias {
@@ -1066,6 +1071,29 @@ func TestPartitionValidation(t *testing.T) {
 		t.Fatalf("ServerValidatePartitions returned %d errors, but should be 3", cnt)
 	}
 
+	resetWith("sde")
+	mediaOpts.EstimatedSize = uint64(1000) * (1000 * 1000 * 1000)
+	results = ServerValidatePartitions(targets, mediaOpts)
+	if cnt := len(results); cnt != 1 {
+		t.Fatalf("ServerValidatePartitions returned %d errors, but should be 1", cnt)
+	}
+
+	// the estimate is not enforced when the size validation is skipped
+	mediaOpts.SkipValidationSize = true
+	results = ServerValidatePartitions(targets, mediaOpts)
+	if cnt := len(results); cnt != 0 {
+		t.Fatalf("ServerValidatePartitions returned %d errors, but should be 0", cnt)
+	}
+
+	if cnt := len(ValidateEstimatedSize(targets, mediaOpts)); cnt != 1 {
+		t.Fatalf("ValidateEstimatedSize returned %d errors, but should be 1", cnt)
+	}
+
+	mediaOpts.EstimatedSize = MinimumServerInstallSize / 2
+	if cnt := len(ValidateEstimatedSize(targets, mediaOpts)); cnt != 0 {
+		t.Fatalf("ValidateEstimatedSize returned %d errors, but should be 0", cnt)
+	}
+
 	resetWith("sda")
 	results = DesktopValidatePartitions(targets, mediaOpts)
 	if len(results) > 0 {
@@ -1428,3 +1456,76 @@ func TestHumanReadableSizeXiB(t *testing.T) {
 		}
 	}
 }
+
+func TestReproducibleMakeFsArgs(t *testing.T) {
+	root := &BlockDevice{Name: "loop0p2", FsType: "ext4", MountPoint: "/", Label: "root", partition: 2}
+	boot := &BlockDevice{Name: "loop0p1", FsType: "vfat", MountPoint: "/boot", partition: 1}
+
+	if args := reproducibleMakeFsArgs(root); len(args) != 0 {
+		t.Fatalf("No arguments expected without a seed, got %v", args)
+	}
+
+	SetReproducibleSeed("0123456789abcdef")
+	defer SetReproducibleSeed("")
+
+	args := reproducibleMakeFsArgs(root)
+	if len(args) != 4 || args[0] != "-U" || len(args[1]) != 36 || args[1][14] != '5' {
+		t.Fatalf("Expected a name based UUID for ext4, got %v", args)
+	}
+
+	// the device name does not change the identifiers
+	other := *root
+	other.Name = "loop1p2"
+	if again := reproducibleMakeFsArgs(&other); again[1] != args[1] || again[3] != args[3] {
+		t.Fatalf("The UUID should not depend on the device name: %v and %v", args, again)
+	}
+
+	if volID := reproducibleMakeFsArgs(boot); len(volID) != 2 || volID[0] != "-i" || len(volID[1]) != 8 {
+		t.Fatalf("Expected a 32 bits volume id for vfat, got %v", volID)
+	}
+
+	// the LUKS UUID of an encrypted root differs from its file system UUID
+	crypt := reproducibleCryptArgs(root)
+	if len(crypt) != 1 || len(crypt[0]) != len("--uuid=")+36 || crypt[0] == "--uuid="+args[1] {
+		t.Fatalf("Expected a name based LUKS UUID, got %v", crypt)
+	}
+
+	SetReproducibleSeed("fedcba9876543210")
+	if again := reproducibleMakeFsArgs(root); again[1] == args[1] {
+		t.Fatalf("A different seed should give a different UUID")
+	}
+
+	SetReproducibleSeed("")
+	if crypt = reproducibleCryptArgs(root); len(crypt) != 0 {
+		t.Fatalf("No LUKS arguments expected without a seed, got %v", crypt)
+	}
+}
+
+func TestExpandName(t *testing.T) {
+	for _, curr := range []struct {
+		file     string
+		children []string
+		expected []string
+	}{
+		{"sda", []string{"${disk}1", "${disk}2"}, []string{"sda1", "sda2"}},
+		{"nvme0n1", []string{"${disk}1", "${disk}2"}, []string{"nvme0n1p1", "nvme0n1p2"}},
+		{"mmcblk0", []string{"${disk}1"}, []string{"mmcblk0p1"}},
+	} {
+		bd := &BlockDevice{Name: "${disk}", Type: BlockDeviceTypeDisk}
+		for _, name := range curr.children {
+			bd.Children = append(bd.Children, &BlockDevice{Name: name, Type: BlockDeviceTypePart})
+		}
+
+		bd.ExpandName(map[string]string{"disk": curr.file})
+
+		if bd.Name != curr.file {
+			t.Fatalf("Expected the disk name %s, got %s", curr.file, bd.Name)
+		}
+
+		for i, child := range bd.Children {
+			if child.Name != curr.expected[i] {
+				t.Fatalf("Expected the partition name %s, got %s", curr.expected[i], child.Name)
+			}
+		}
+	}
+}
@@ -36,9 +36,14 @@ func CreateSwapFile(rootDir string, sizeString string) error {
 	}
 	args := []string{
 		"mkswap",
-		swapFile,
 	}
 
+	if IsReproducible() {
+		args = append(args, "-U", seededUUID("swapfile"))
+	}
+
+	args = append(args, swapFile)
+
 	if err := cmd.RunAndLog(args...); err != nil {
 		return errors.Wrap(err)
 	}
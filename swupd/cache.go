@@ -0,0 +1,430 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultContentCacheSize is the content cache size limit used when
+	// --content-cache-size is not set
+	DefaultContentCacheSize = "20G"
+
+	// cacheLockFile serializes the evictions of the content cache
+	cacheLockFile = ".lock"
+
+	// cacheVersionLock suffixes the lock file of each cached version, its
+	// modification time records the last use of the version; lock files are
+	// never removed so every process locks the same inode
+	cacheVersionLock = ".lock"
+
+	// cacheTmpSuffix marks the files being copied to the cache, each copy
+	// has its own temporary file
+	cacheTmpSuffix = ".tmp"
+)
+
+var (
+	// cachedStateDirs are the parts of a swupd state directory kept in the
+	// cache, the ones swupd looks up in --statedir-cache
+	cachedStateDirs = []string{"manifest", "staged"}
+)
+
+// ContentCache keeps the swupd content of the installed versions on the host,
+// one state directory per version and content source, so the following
+// installations of the version from the same source use it as --statedir-cache
+// instead of downloading the content again.
+// The cache is shared by concurrent installer processes: the versions in use
+// hold a shared lock and are never evicted, the files are copied to the cache
+// atomically and the least recently used versions are evicted past the size limit
+type ContentCache struct {
+	dir     string
+	maxSize uint64
+}
+
+// CacheEntry is a version of the content cache used by an installation, name
+// is the cache directory of the version and content source
+type CacheEntry struct {
+	cache   *ContentCache
+	version string
+	name    string
+	lock    *os.File
+}
+
+// NewContentCache creates a content cache in dir limited to maxSize bytes
+func NewContentCache(dir string, maxSize uint64) (*ContentCache, error) {
+	if err := utils.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &ContentCache{dir: dir, maxSize: maxSize}, nil
+}
+
+// AcquireContentCache returns the content cache entry of the version of the
+// installation, a nil entry is returned if the content cache is not enabled
+func AcquireContentCache(md *model.SystemInstall, options args.Args) (*CacheEntry, error) {
+	if options.ContentCache == "" {
+		return nil, nil
+	}
+
+	sizeStr := options.ContentCacheSize
+	if sizeStr == "" {
+		sizeStr = DefaultContentCacheSize
+	}
+
+	maxSize, err := storage.ParseVolumeSize(sizeStr)
+	if err != nil {
+		return nil, errors.Errorf("Invalid content cache size %q: %v", sizeStr, err)
+	}
+
+	cache, err := NewContentCache(options.ContentCache, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	source := installSource(md, options)
+
+	version, err := resolveVersion(source, utils.VersionUintString(md.Version))
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Acquire(source.String(), version)
+}
+
+// lockFile opens and locks a lock file of the cache with the flock operation how
+func (c *ContentCache) lockFile(name string, how int) (*os.File, error) {
+	fp, err := os.OpenFile(filepath.Join(c.dir, name), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if err = syscall.Flock(int(fp.Fd()), how); err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+// sourceKey identifies a content source in the cache names
+func sourceKey(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Acquire marks the version of the content source as used and prevents its
+// eviction until Release
+func (c *ContentCache) Acquire(source string, version string) (*CacheEntry, error) {
+	if version == "" || strings.ContainsAny(version, "/.-") {
+		return nil, errors.Errorf("Invalid content cache version %q", version)
+	}
+
+	// The versions of different content sources, such as mixes, do not match
+	name := version + "-" + sourceKey(source)
+
+	lock, err := c.lockFile(name+cacheVersionLock, syscall.LOCK_SH)
+	if err != nil {
+		return nil, errors.Errorf("Could not lock the content cache of version %s: %v", version, err)
+	}
+
+	now := time.Now()
+	if err = os.Chtimes(lock.Name(), now, now); err != nil {
+		log.Warning("Could not update the last use of the content cache of version %s: %v", version, err)
+	}
+
+	log.Debug("Using the content cache %s for version %s of %s", c.dir, version, source)
+
+	return &CacheEntry{cache: c, version: version, name: name, lock: lock}, nil
+}
+
+// Dir returns the state directory cache of the version
+func (e *CacheEntry) Dir() string {
+	return filepath.Join(e.cache.dir, e.name)
+}
+
+// Release allows the eviction of the version
+func (e *CacheEntry) Release() {
+	if e.lock == nil {
+		return
+	}
+
+	_ = syscall.Flock(int(e.lock.Fd()), syscall.LOCK_UN)
+	_ = e.lock.Close()
+	e.lock = nil
+}
+
+// Store adds the content of a swupd state directory to the cache of the
+// version, then evicts the least recently used versions past the size limit
+func (e *CacheEntry) Store(stateDir string) error {
+	for _, curr := range cachedStateDirs {
+		src := filepath.Join(stateDir, curr)
+
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := copyToCache(src, filepath.Join(e.Dir(), curr)); err != nil {
+			return err
+		}
+	}
+
+	return e.cache.evict()
+}
+
+// copyToCache copies the missing files of src to dst keeping their mode,
+// owner and times which are part of the swupd file hashes; every file is
+// copied to a unique temporary file renamed into place once complete, so
+// concurrent readers never see partial files nor concurrent writers share one
+func copyToCache(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if strings.HasSuffix(path, cacheTmpSuffix) {
+			return nil
+		}
+
+		if _, err = os.Lstat(target); err == nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if err = os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return errors.Wrap(err)
+			}
+
+			chownCacheFile(target, info)
+
+			return nil
+		}
+
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		tmp, err := cacheTempFile(target)
+		if err != nil {
+			return err
+		}
+
+		if err = copyCacheEntry(path, tmp, info); err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+
+		chownCacheFile(tmp, info)
+
+		if err = os.Rename(tmp, target); err != nil {
+			_ = os.Remove(tmp)
+			return errors.Wrap(err)
+		}
+
+		return nil
+	})
+}
+
+// cacheTempFile returns the path of a new temporary file next to target
+func cacheTempFile(target string) (string, error) {
+	fp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+"-*"+cacheTmpSuffix)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	if err = fp.Close(); err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return fp.Name(), nil
+}
+
+// copyCacheEntry copies the regular file or symlink src over the temporary file tmp
+func copyCacheEntry(src string, tmp string, info os.FileInfo) error {
+	if info.Mode().IsRegular() {
+		return copyCacheFile(src, tmp, info)
+	}
+
+	link, err := os.Readlink(src)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = os.Remove(tmp); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = os.Symlink(link, tmp); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// chownCacheFile keeps the owner of a cached file, when permitted
+func chownCacheFile(path string, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	if err := os.Lchown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+		log.Debug("Could not keep the owner of %s: %v", path, err)
+	}
+}
+
+// copyCacheFile copies a regular file keeping its mode and times
+func copyCacheFile(src string, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.Wrap(err)
+	}
+
+	if err = out.Close(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = os.Chmod(dst, info.Mode()); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = os.Chtimes(dst, info.ModTime(), info.ModTime()); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// cachedVersion is a version of the cache considered for eviction
+type cachedVersion struct {
+	name     string
+	size     uint64
+	lastUsed time.Time
+}
+
+// dirSize returns the size of the files of a directory
+func dirSize(dir string) uint64 {
+	var size uint64
+
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+
+	return size
+}
+
+// versions lists the cached versions and their size
+func (c *ContentCache) versions() ([]*cachedVersion, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	result := []*cachedVersion{}
+
+	for _, curr := range entries {
+		if !curr.IsDir() {
+			continue
+		}
+
+		version := &cachedVersion{name: curr.Name(), size: dirSize(filepath.Join(c.dir, curr.Name()))}
+
+		if info, err := os.Stat(filepath.Join(c.dir, curr.Name()+cacheVersionLock)); err == nil {
+			version.lastUsed = info.ModTime()
+		}
+
+		result = append(result, version)
+	}
+
+	return result, nil
+}
+
+// evict removes the least recently used versions until the cache fits its
+// size limit; the versions in use by any installer process are kept
+func (c *ContentCache) evict() error {
+	lock, err := c.lockFile(cacheLockFile, syscall.LOCK_EX)
+	if err != nil {
+		return errors.Errorf("Could not lock the content cache %s: %v", c.dir, err)
+	}
+	defer func() { _ = lock.Close() }()
+
+	versions, err := c.versions()
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	for _, curr := range versions {
+		total += curr.size
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].lastUsed.Before(versions[j].lastUsed)
+	})
+
+	for _, curr := range versions {
+		if total <= c.maxSize {
+			break
+		}
+
+		inUse, err := c.lockFile(curr.name+cacheVersionLock, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			log.Debug("Keeping the content cache of version %s in use", curr.name)
+			continue
+		}
+
+		log.Info("Evicting the content cache of version %s (%d bytes)", curr.name, curr.size)
+
+		// the lock file is kept: a process blocked in Acquire holds it open and
+		// removing it would let the next Acquire lock a new inode
+		err = os.RemoveAll(filepath.Join(c.dir, curr.name))
+		_ = inUse.Close()
+
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		total -= curr.size
+	}
+
+	return nil
+}
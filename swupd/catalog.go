@@ -0,0 +1,998 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultContentURL is the content URL used when neither the command line
+	// nor the configuration define one
+	DefaultContentURL = "https://cdn.download.clearlinux.org"
+
+	// BundleStatusExperimental marks bundles which are not yet stable
+	BundleStatusExperimental = "Experimental"
+
+	// BundleStatusDeprecated marks bundles which will be removed in a future release
+	BundleStatusDeprecated = "Deprecated"
+
+	// BundleCategoryAll matches every bundle category
+	BundleCategoryAll = "All"
+
+	// momName is the manifest of manifests listing every bundle of a version
+	momName = "MoM"
+
+	// maxManifestFetches limits the concurrent manifest downloads
+	maxManifestFetches = 8
+
+	// bundleDefsBundle installs the bundle definitions of its version to bundleDefsPath
+	bundleDefsBundle = "os-core"
+	bundleDefsPath   = "/usr/share/clear/allbundles"
+)
+
+var (
+	// BundleCategories lists the bundle categories in display order
+	BundleCategories = []string{
+		BundleCategoryAll,
+		"Desktop",
+		"Development",
+		"Languages",
+		"Servers",
+		"Containers and Cloud",
+		"Machine Learning",
+		"Other",
+	}
+
+	// bundleCategoryRules assigns a category to the bundles matching any of the patterns
+	bundleCategoryRules = []struct {
+		category string
+		patterns []string
+	}{
+		{"Development", []string{"*-dev", "devpkg-*", "dev-utils*", "c-basic*", "*-debug*", "os-testsuite*"}},
+		{"Desktop", []string{"desktop*", "*-desktop*", "gnome*", "kde*", "xfce4*", "lxqt*", "mate*", "x11*",
+			"games*", "media*", "*-apps"}},
+		{"Machine Learning", []string{"machine-learning*", "computer-vision*", "*tensorflow*", "*pytorch*",
+			"openvino*", "*-ml*"}},
+		{"Containers and Cloud", []string{"containers*", "kata*", "*docker*", "*kubernetes*", "cloud-*", "*-cloud*",
+			"kvm-host*", "*-virt*", "openstack*"}},
+		{"Languages", []string{"python*", "perl*", "R-*", "R", "ruby*", "go-*", "java*", "lua*", "nodejs*",
+			"php*", "rust*", "haskell*", "ocaml*", "scala*", "dotnet*", "erlang*", "elixir*", "julia*"}},
+		{"Servers", []string{"*-server*", "*server", "nginx*", "httpd*", "database*", "postgresql*", "mariadb*",
+			"redis*", "mail*", "web-server*", "*-basic-server*"}},
+	}
+
+	// bundleHeaderDir holds the bundle definitions of the running system, their
+	// headers provide the bundle description and status
+	bundleHeaderDir = bundleDefsPath
+
+	// catalogCacheDir keeps the manifests downloaded from the content URL
+	catalogCacheDir = "/var/lib/clr-installer/bundle-catalog"
+
+	// fetchURL is replaced by tests to emulate a content server
+	fetchURL = network.FetchRemoteConfigFile
+
+	// loadedCatalogs keeps the catalogs already built by source and requested version
+	loadedCatalogs      = map[string]*Catalog{}
+	loadedCatalogsMutex sync.Mutex
+)
+
+// Catalog lists the bundles of a version from its manifest of manifests, the
+// manifests of the bundles are only read when their size or includes are needed
+type Catalog struct {
+	source    manifestSource
+	version   string
+	bundles   []*Bundle
+	byName    map[string]*Bundle
+	entries   map[string]*momEntry
+	loaded    map[string]bool
+	described bool
+
+	// loading serializes the downloads, which share the cache of the source
+	loading sync.Mutex
+
+	// mu guards the bundle details read from the manifests and definitions,
+	// the bundles are only handed out as copies
+	mu sync.RWMutex
+}
+
+// manifestHeader is the subset of a swupd manifest header used by the catalog
+type manifestHeader struct {
+	format      string
+	timestamp   int64
+	contentSize uint64
+	includes    []string
+	optional    []string
+}
+
+// manifestFile is a file, directory or link listed by a bundle manifest
+type manifestFile struct {
+	flags   string
+	hash    string
+	version string
+	path    string
+}
+
+// momEntry is a bundle listed in the manifest of manifests
+type momEntry struct {
+	name         string
+	hash         string
+	version      string
+	experimental bool
+}
+
+// manifestSource opens the manifests of a given version, a nil reader is
+// returned if the source does not hold the manifest
+type manifestSource interface {
+	// open returns the manifest of name of version, it is checked against
+	// hash, the hash listed by the manifest of manifests, unless it is empty
+	open(version string, name string, hash string) (io.ReadCloser, error)
+	String() string
+}
+
+// dirSource reads the manifests from a swupd state directory
+type dirSource struct {
+	dir string
+}
+
+// urlSource downloads the manifests from a content URL and caches them locally
+type urlSource struct {
+	url      string
+	cacheDir string
+}
+
+func (s *dirSource) String() string {
+	return s.dir
+}
+
+func (s *dirSource) open(version string, name string, hash string) (io.ReadCloser, error) {
+	file := "Manifest." + name
+
+	// newer swupd releases keep the manifests under the manifest sub directory
+	paths := []string{
+		filepath.Join(s.dir, "manifest", version, file),
+		filepath.Join(s.dir, version, file),
+	}
+
+	for _, curr := range paths {
+		fp, err := os.Open(curr)
+		if err == nil {
+			if err = checkManifest(curr, hash); err != nil {
+				_ = fp.Close()
+				return nil, errors.Errorf("%s for version %s in %s: %v", file, version, s.dir, err)
+			}
+
+			return fp, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	// the offline content only holds the manifests of the downloaded bundles
+	log.Debug("Manifest %s not found for version %s in %s", file, version, s.dir)
+
+	return nil, nil
+}
+
+func (s *urlSource) String() string {
+	return s.url
+}
+
+func (s *urlSource) open(version string, name string, hash string) (io.ReadCloser, error) {
+	file := "Manifest." + name
+
+	// the versions of different content URLs, such as mixes, do not match
+	cached := filepath.Join(s.cacheDir, sourceKey(s.url), version, file)
+
+	if fp, err := os.Open(cached); err == nil {
+		if err = checkManifest(cached, hash); err == nil {
+			return fp, nil
+		}
+
+		_ = fp.Close()
+		log.Warning("Discarding the cached %s of version %s from %s: %v", file, version, s.url, err)
+	}
+
+	tmp, err := fetchURL(fmt.Sprintf("%s/update/%s/%s", s.url, version, file))
+	if err != nil {
+		return nil, errors.Errorf("Could not download %s for version %s from %s: %v", file, version, s.url, err)
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	if err = checkManifest(tmp, hash); err != nil {
+		return nil, errors.Errorf("%s for version %s from %s: %v", file, version, s.url, err)
+	}
+
+	if err = utils.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return nil, err
+	}
+
+	// the manifest is copied to a temporary file of the cache first so a
+	// concurrent reader never sees a partial copy
+	out, err := ioutil.TempFile(filepath.Dir(cached), "."+file+"-")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	_ = out.Close()
+	defer func() { _ = os.Remove(out.Name()) }()
+
+	if err = utils.CopyFile(tmp, out.Name()); err != nil {
+		return nil, err
+	}
+
+	if err = os.Rename(out.Name(), cached); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	fp, err := os.Open(cached)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return fp, nil
+}
+
+// checkManifest checks the manifest at path matches the hash listed by the
+// manifest of manifests, an empty hash is not checked
+func checkManifest(path string, hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	curr, err := manifestHash(path)
+	if err != nil {
+		return err
+	}
+
+	if curr != hash {
+		return errors.Errorf("Manifest hash %s does not match %s", curr, hash)
+	}
+
+	return nil
+}
+
+// openFile returns the content of a regular file published by the content URL,
+// the files are verified against their hash and cached by hash
+func (s *urlSource) openFile(version string, hash string) (io.ReadCloser, error) {
+	cached := filepath.Join(s.cacheDir, sourceKey(s.url), "files", hash)
+
+	// the files are only renamed to their hash once verified
+	if fp, err := os.Open(cached); err == nil {
+		return fp, nil
+	}
+
+	tmp, err := fetchURL(fmt.Sprintf("%s/update/%s/files/%s.tar", s.url, version, hash))
+	if err != nil {
+		return nil, errors.Errorf("Could not download the file %s of version %s from %s: %v", hash, version, s.url, err)
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	if err = utils.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return nil, err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(cached), "."+hash+"-")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer func() { _ = os.Remove(out.Name()) }()
+
+	found := false
+
+	err = walkArchive(tmp, func(hdr *tar.Header, r io.Reader) error {
+		if archiveName(hdr) != hash {
+			return nil
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return errors.Errorf("File %s is not a regular file", hash)
+		}
+
+		curr, err := entryHash(hdr, io.TeeReader(r, out))
+		if err != nil {
+			return err
+		}
+
+		if curr != hash {
+			return errors.Errorf("File hash %s does not match %s", curr, hash)
+		}
+
+		found = true
+
+		return nil
+	})
+
+	if cerr := out.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, errors.Errorf("Archive of the file %s of version %s does not contain it", hash, version)
+	}
+
+	if err = os.Rename(out.Name(), cached); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	fp, err := os.Open(cached)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return fp, nil
+}
+
+// latestVersion reads the most recent version published at the content URL
+func (s *urlSource) latestVersion() (string, error) {
+	tmp, err := fetchURL(s.url + "/latest")
+	if err != nil {
+		return "", errors.Errorf("Could not read the latest version from %s: %v", s.url, err)
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	content, err := ioutil.ReadFile(tmp)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	version := strings.TrimSpace(string(content))
+	if _, err = strconv.ParseUint(version, 10, 32); err != nil {
+		return "", errors.Errorf("Invalid latest version %q from %s", version, s.url)
+	}
+
+	return version, nil
+}
+
+// parseManifestHeader reads the header of a manifest, which ends with the first empty line
+func parseManifestHeader(r io.Reader) (*manifestHeader, *bufio.Scanner, error) {
+	header := &manifestHeader{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if first {
+			if !strings.HasPrefix(line, "MANIFEST") {
+				return nil, nil, errors.Errorf("Invalid manifest, missing the MANIFEST header")
+			}
+			header.format = strings.TrimSpace(strings.TrimPrefix(line, "MANIFEST"))
+			first = false
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+
+		tks := strings.SplitN(line, ":", 2)
+		if len(tks) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(tks[1])
+
+		switch tks[0] {
+		case "contentsize":
+			size, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, nil, errors.Errorf("Invalid manifest contentsize %q", value)
+			}
+			header.contentSize = size
+		case "timestamp":
+			timestamp, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, nil, errors.Errorf("Invalid manifest timestamp %q", value)
+			}
+			header.timestamp = timestamp
+		case "includes":
+			header.includes = append(header.includes, value)
+		case "also-add":
+			header.optional = append(header.optional, value)
+		}
+	}
+
+	if first {
+		return nil, nil, errors.Errorf("Invalid manifest, empty file")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	return header, scanner, nil
+}
+
+// parseMoM lists the bundles of the manifest of manifests, skipping deleted bundles
+func parseMoM(r io.Reader) ([]*momEntry, error) {
+	_, scanner, err := parseManifestHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*momEntry{}
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || len(fields[0]) != 4 {
+			continue
+		}
+
+		// flags are the type, status, modifier and rename bytes, i.e.: M.e.
+		flags := fields[0]
+		if flags[0] != 'M' || flags[1] == 'd' {
+			continue
+		}
+
+		entries = append(entries, &momEntry{
+			name:         fields[3],
+			hash:         fields[1],
+			version:      fields[2],
+			experimental: flags[1] == 'e',
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return entries, nil
+}
+
+// parseManifestFiles reads the header of a bundle manifest and its files,
+// directories and links; the deleted ones are skipped
+func parseManifestFiles(r io.Reader) (*manifestHeader, []*manifestFile, error) {
+	header, scanner, err := parseManifestHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := []*manifestFile{}
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 4 || len(fields[0]) != 4 {
+			continue
+		}
+
+		// flags are the type, status, modifier and rename bytes, i.e.: F...
+		flags := fields[0]
+		if !strings.ContainsRune("FDL", rune(flags[0])) || flags[1] == 'd' || fields[1] == zeroHash {
+			continue
+		}
+
+		files = append(files, &manifestFile{flags: flags, hash: fields[1], version: fields[2], path: fields[3]})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	return header, files, nil
+}
+
+// parseBundleHeader reads the description and status from the header of a
+// bundle definition, i.e.: # [DESCRIPTION]: Popular text editors
+func parseBundleHeader(r io.Reader) (string, string) {
+	desc := ""
+	status := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+
+		tks := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":", 2)
+		if len(tks) != 2 {
+			continue
+		}
+
+		switch strings.TrimSpace(tks[0]) {
+		case "[DESCRIPTION]":
+			desc = strings.TrimSpace(tks[1])
+		case "[STATUS]":
+			status = strings.TrimSpace(tks[1])
+		}
+	}
+
+	return desc, status
+}
+
+// bundleCategory returns the category of a bundle based on its name
+func bundleCategory(name string) string {
+	for _, rule := range bundleCategoryRules {
+		for _, pattern := range rule.patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return rule.category
+			}
+		}
+	}
+
+	return "Other"
+}
+
+// newCatalog creates a catalog of bundles whose manifests are already read
+func newCatalog(bundles []*Bundle) *Catalog {
+	catalog := &Catalog{
+		bundles: append([]*Bundle{}, bundles...),
+		byName:  map[string]*Bundle{},
+		entries: map[string]*momEntry{},
+		loaded:  map[string]bool{},
+	}
+
+	sort.Slice(catalog.bundles, func(i, j int) bool {
+		return catalog.bundles[i].Name < catalog.bundles[j].Name
+	})
+
+	for _, curr := range catalog.bundles {
+		catalog.byName[curr.Name] = curr
+		catalog.loaded[curr.Name] = true
+	}
+
+	return catalog
+}
+
+// loadCatalog builds the bundle list of version from the manifest of manifests
+// provided by source
+func loadCatalog(source manifestSource, version string) (*Catalog, error) {
+	fp, err := source.open(version, momName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if fp == nil {
+		return nil, errors.Errorf("Manifest.MoM not found for version %s in %s", version, source)
+	}
+
+	entries, err := parseMoM(fp)
+	_ = fp.Close()
+	if err != nil {
+		return nil, errors.Errorf("%s Manifest.MoM: %v", source, err)
+	}
+
+	return momCatalog(source, version, entries), nil
+}
+
+// momCatalog creates the catalog of the bundles listed by the manifest of
+// manifests of version, their manifests are read from source when needed
+func momCatalog(source manifestSource, version string, entries []*momEntry) *Catalog {
+	bundles := []*Bundle{}
+
+	for _, curr := range entries {
+		bundle := &Bundle{
+			Name:     curr.name,
+			Category: bundleCategory(curr.name),
+		}
+
+		if curr.experimental {
+			bundle.Status = BundleStatusExperimental
+		}
+
+		bundles = append(bundles, bundle)
+	}
+
+	catalog := newCatalog(bundles)
+	catalog.source = source
+	catalog.version = version
+	catalog.loaded = map[string]bool{}
+
+	for _, curr := range entries {
+		catalog.entries[curr.name] = curr
+	}
+
+	return catalog
+}
+
+// Bundles returns copies of the bundles of the catalog sorted by name, with
+// the details read so far
+func (c *Catalog) Bundles() []*Bundle {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]*Bundle, len(c.bundles))
+	for i, curr := range c.bundles {
+		bundle := *curr
+		result[i] = &bundle
+	}
+
+	return result
+}
+
+// Contains returns true if the bundle is part of the catalog
+func (c *Catalog) Contains(name string) bool {
+	_, ok := c.byName[name]
+	return ok
+}
+
+// load reads the manifests of the bundles not read yet to set their size and
+// includes, the bundles missing from the catalog are ignored
+func (c *Catalog) load(names []string) error {
+	if c.source == nil {
+		return nil
+	}
+
+	c.loading.Lock()
+	defer c.loading.Unlock()
+
+	pending := []*momEntry{}
+	for _, curr := range names {
+		if entry, ok := c.entries[curr]; ok && !c.loaded[curr] {
+			c.loaded[curr] = true
+			pending = append(pending, entry)
+		}
+	}
+
+	errs := make([]error, len(pending))
+	headers := make([]*manifestHeader, len(pending))
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxManifestFetches)
+
+	for i, curr := range pending {
+		wg.Add(1)
+		sem <- true
+
+		go func(i int, entry *momEntry) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			mfp, err := c.source.open(entry.version, entry.name, entry.hash)
+			if err != nil || mfp == nil {
+				errs[i] = err
+				return
+			}
+			defer func() {
+				_ = mfp.Close()
+			}()
+
+			if headers[i], _, err = parseManifestHeader(mfp); err != nil {
+				errs[i] = errors.Errorf("%s Manifest.%s: %v", c.source, entry.name, err)
+			}
+		}(i, curr)
+	}
+
+	wg.Wait()
+
+	var result error
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, err := range errs {
+		if err == nil {
+			if header := headers[i]; header != nil {
+				bundle := c.byName[pending[i].name]
+				bundle.Size = header.contentSize
+				bundle.Includes = header.includes
+				bundle.Optional = header.optional
+			}
+
+			continue
+		}
+
+		// the manifest is read again by the next request
+		delete(c.loaded, pending[i].name)
+
+		if result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// closure returns the bundles of the catalog installed along with names,
+// including them, and the names missing from the catalog; only the manifests
+// of these bundles are read
+func (c *Catalog) closure(names []string, skipOptional bool) (map[string]bool, []string, error) {
+	visited := map[string]bool{}
+	found := map[string]bool{}
+	unknown := []string{}
+	pending := append([]string{}, names...)
+
+	for len(pending) > 0 {
+		level := []string{}
+
+		for _, curr := range pending {
+			if visited[curr] {
+				continue
+			}
+			visited[curr] = true
+
+			if !c.Contains(curr) {
+				unknown = append(unknown, curr)
+				continue
+			}
+
+			found[curr] = true
+			level = append(level, curr)
+		}
+
+		if err := c.load(level); err != nil {
+			return nil, nil, err
+		}
+
+		pending = []string{}
+
+		c.mu.RLock()
+		for _, curr := range level {
+			bundle := c.byName[curr]
+
+			pending = append(pending, bundle.Includes...)
+			if !skipOptional {
+				pending = append(pending, bundle.Optional...)
+			}
+		}
+		c.mu.RUnlock()
+	}
+
+	return found, unknown, nil
+}
+
+// setDefinition sets the description and status of a bundle from its definition
+func setDefinition(bundle *Bundle, desc string, status string) {
+	bundle.Desc = desc
+
+	if strings.EqualFold(status, BundleStatusDeprecated) {
+		bundle.Status = BundleStatusDeprecated
+	} else if strings.EqualFold(status, BundleStatusExperimental) {
+		bundle.Status = BundleStatusExperimental
+	}
+}
+
+// loadDescriptions sets the description and status of the bundles from the
+// bundle definitions of the catalog version: the ones of the running system
+// for the offline content and the running version, the ones published with
+// the bundleDefsBundle of the version otherwise
+func (c *Catalog) loadDescriptions() error {
+	c.loading.Lock()
+	defer c.loading.Unlock()
+
+	if c.described {
+		return nil
+	}
+
+	source, remote := c.source.(*urlSource)
+	if !remote || c.version == utils.ClearVersion {
+		defs := map[string][2]string{}
+
+		for _, curr := range c.bundles {
+			fp, err := os.Open(filepath.Join(bundleHeaderDir, curr.Name))
+			if err != nil {
+				continue
+			}
+
+			desc, status := parseBundleHeader(fp)
+			_ = fp.Close()
+
+			defs[curr.Name] = [2]string{desc, status}
+		}
+
+		c.setDefinitions(defs)
+
+		return nil
+	}
+
+	entry, ok := c.entries[bundleDefsBundle]
+	if !ok {
+		return errors.Errorf("Bundle %s not found in version %s", bundleDefsBundle, c.version)
+	}
+
+	mfp, err := source.open(entry.version, entry.name, entry.hash)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mfp.Close() }()
+
+	_, files, err := parseManifestFiles(mfp)
+	if err != nil {
+		return errors.Errorf("%s Manifest.%s: %v", source, entry.name, err)
+	}
+
+	defs := []*manifestFile{}
+	for _, curr := range files {
+		if curr.flags[0] == 'F' && filepath.Dir(curr.path) == bundleDefsPath && c.Contains(filepath.Base(curr.path)) {
+			defs = append(defs, curr)
+		}
+	}
+
+	errs := make([]error, len(defs))
+	headers := make([][2]string, len(defs))
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxManifestFetches)
+
+	for i, curr := range defs {
+		wg.Add(1)
+		sem <- true
+
+		go func(i int, def *manifestFile) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			fp, err := source.openFile(def.version, def.hash)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer func() { _ = fp.Close() }()
+
+			desc, status := parseBundleHeader(fp)
+			headers[i] = [2]string{desc, status}
+		}(i, curr)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	found := map[string][2]string{}
+	for i, curr := range defs {
+		found[filepath.Base(curr.path)] = headers[i]
+	}
+
+	c.setDefinitions(found)
+
+	return nil
+}
+
+// setDefinitions publishes the description and status of the bundles, defs
+// maps the bundle names to their description and status
+func (c *Catalog) setDefinitions(defs map[string][2]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, def := range defs {
+		setDefinition(c.byName[name], def[0], def[1])
+	}
+
+	c.described = true
+}
+
+// ContentURL returns the swupd content URL of the installation: the command
+// line content URL or URL, the configured mirror or DefaultContentURL
+func ContentURL(md *model.SystemInstall, options args.Args) string {
+	url := DefaultContentURL
+
+	if options.SwupdContentURL != "" {
+		url = options.SwupdContentURL
+	} else if options.SwupdURL != "" {
+		url = options.SwupdURL
+	} else if md.SwupdMirror != "" {
+		url = md.SwupdMirror
+	}
+
+	return strings.TrimSuffix(url, "/")
+}
+
+// installSource returns the manifest source of the installation: the offline
+// content when it is usable for the version or the content URL otherwise
+func installSource(md *model.SystemInstall, options args.Args) manifestSource {
+	if OfflineIsUsable(utils.VersionUintString(md.Version), options) {
+		return &dirSource{dir: conf.OfflineContentDir}
+	}
+
+	return &urlSource{url: ContentURL(md, options), cacheDir: catalogCacheDir}
+}
+
+// resolveVersion returns the version installed from source for the requested
+// version: the offline content version or the latest published version
+func resolveVersion(source manifestSource, version string) (string, error) {
+	if _, ok := source.(*dirSource); ok {
+		return utils.ClearVersion, nil
+	}
+
+	if url, ok := source.(*urlSource); ok && utils.IsLatestVersion(version) {
+		return url.latestVersion()
+	}
+
+	return version, nil
+}
+
+// LoadCatalog builds the list of every bundle of the selected version from the
+// swupd manifest of manifests, read from the offline content when it is usable
+// for the version or downloaded from the content URL otherwise
+func LoadCatalog(md *model.SystemInstall, options args.Args) (*Catalog, error) {
+	return sourceCatalog(installSource(md, options), utils.VersionUintString(md.Version))
+}
+
+// sourceCatalog returns the catalog of the requested version provided by
+// source, building it the first time
+func sourceCatalog(source manifestSource, version string) (*Catalog, error) {
+	key := fmt.Sprintf("%s@%s", source, version)
+
+	loadedCatalogsMutex.Lock()
+	defer loadedCatalogsMutex.Unlock()
+
+	if catalog, ok := loadedCatalogs[key]; ok {
+		return catalog, nil
+	}
+
+	version, err := resolveVersion(source, version)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("Loading the bundle catalog for version %s from %s", version, source)
+
+	catalog, err := loadCatalog(source, version)
+	if err != nil {
+		return nil, err
+	}
+
+	loadedCatalogs[key] = catalog
+
+	return catalog, nil
+}
+
+// Matches returns true if the bundle is in category, or category is
+// BundleCategoryAll, and every word of query is part of its name or description
+func (b *Bundle) Matches(query string, category string) bool {
+	if category != "" && category != BundleCategoryAll && category != b.Category {
+		return false
+	}
+
+	text := strings.ToLower(b.Name + " " + b.Desc)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Summary returns the bundle status and install size for display
+func (b *Bundle) Summary() string {
+	details := []string{}
+
+	if b.Status != "" {
+		details = append(details, utils.Locale.Get(b.Status))
+	}
+
+	if b.Size > 0 {
+		if size, err := storage.HumanReadableSizeXBWithPrecision(b.Size, 1); err == nil {
+			details = append(details, size)
+		}
+	}
+
+	return strings.Join(details, ", ")
+}
@@ -0,0 +1,224 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// packRatio is the assumed size of the xz compressed swupd packs relative
+	// to the installed content; the manifests only publish the installed size
+	// so the download can not be derived from them, 0.4 is the usual xz ratio
+	// of a mix of binaries and text and the estimate is a rough figure whose
+	// error is covered by estimateHeadroom
+	packRatio = 0.4
+
+	// estimateHeadroom is the extra space kept for the file system metadata
+	// and the files created after the installation, in percent
+	estimateHeadroom = 10
+)
+
+var (
+	// estimateSeq identifies the last estimate started in the background
+	estimateSeq uint64
+)
+
+// InstallEstimate is the estimated size of an installation
+type InstallEstimate struct {
+	Bundles       []string // Bundles is the closure of the bundles which will be installed
+	Unknown       []string // Unknown are the requested bundles missing from the catalog
+	InstalledSize uint64   // InstalledSize is the size of the installed content
+	StateSize     uint64   // StateSize is the size of the content kept in the swupd state directory
+	DownloadSize  uint64   // DownloadSize is the size of the content to download
+}
+
+// RequiredSize returns the space needed in the root file system, which holds
+// the installed content and the swupd state directory
+func (e *InstallEstimate) RequiredSize() uint64 {
+	size := e.InstalledSize + e.StateSize
+	return size + size*estimateHeadroom/100
+}
+
+// String returns the estimate for display
+func (e *InstallEstimate) String() string {
+	installed, _ := storage.HumanReadableSizeXBWithPrecision(e.RequiredSize(), 1)
+	download, _ := storage.HumanReadableSizeXBWithPrecision(e.DownloadSize, 1)
+
+	return utils.Locale.Get("Estimated installation size: %s, download size: %s", installed, download)
+}
+
+// InstallBundles returns the bundles the installation requests: the core
+// bundles, the configured bundles, the kernel bundle and the bundles the
+// installer adds based on the configuration
+func InstallBundles(md *model.SystemInstall) []string {
+	return installBundles(md, ImplicitBundles(md))
+}
+
+// installBundles returns the core, configured and kernel bundles followed by
+// the implicit ones
+func installBundles(md *model.SystemInstall, implicit []*model.ImplicitBundle) []string {
+	bundles := append([]string{}, CoreBundles...)
+	bundles = append(bundles, md.Bundles...)
+
+	if md.Kernel != nil && md.Kernel.Bundle != "" && md.Kernel.Bundle != "none" {
+		bundles = append(bundles, md.Kernel.Bundle)
+	}
+
+	for _, curr := range implicit {
+		bundles = append(bundles, curr.Name)
+	}
+
+	return bundles
+}
+
+// estimateFromCatalog computes the bundle closure of the requested bundles and
+// its size, only the manifests of the closure are read
+func estimateFromCatalog(catalog *Catalog, requested []string, skipOptional bool,
+	offline bool) (*InstallEstimate, error) {
+	closure, unknown, err := catalog.closure(requested, skipOptional)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &InstallEstimate{Bundles: []string{}, Unknown: unknown}
+
+	for _, curr := range catalog.Bundles() {
+		if closure[curr.Name] {
+			estimate.Bundles = append(estimate.Bundles, curr.Name)
+			estimate.InstalledSize += curr.Size
+		}
+	}
+
+	estimate.StateSize = uint64(float64(estimate.InstalledSize) * packRatio)
+	if !offline {
+		estimate.DownloadSize = estimate.StateSize
+	}
+
+	return estimate, nil
+}
+
+// estimateRequest is the part of the model an estimate depends on, it is taken
+// before the estimate so the model is not read while it is computed
+type estimateRequest struct {
+	source       manifestSource
+	version      string
+	bundles      []string
+	skipOptional bool
+	offline      bool
+}
+
+// newEstimateRequest takes the estimate inputs from the model
+func newEstimateRequest(md *model.SystemInstall, options args.Args) *estimateRequest {
+	version := utils.VersionUintString(md.Version)
+
+	return &estimateRequest{
+		source:       installSource(md, options),
+		version:      version,
+		bundles:      InstallBundles(md),
+		skipOptional: md.SwupdSkipOptional,
+		offline:      OfflineIsUsable(version, options),
+	}
+}
+
+// estimate computes the estimate of the request from the bundle catalog
+func (r *estimateRequest) estimate() (*InstallEstimate, error) {
+	catalog, err := sourceCatalog(r.source, r.version)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate, err := estimateFromCatalog(catalog, r.bundles, r.skipOptional, r.offline)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(estimate.Unknown) > 0 {
+		log.Warning("Bundles not found in the catalog: %v", estimate.Unknown)
+	}
+
+	log.Debug("Install estimate for %d bundles: installed %d, state %d, download %d bytes",
+		len(estimate.Bundles), estimate.InstalledSize, estimate.StateSize, estimate.DownloadSize)
+
+	return estimate, nil
+}
+
+// EstimateInstall computes the bundle closure of the installation and
+// estimates the installed and download sizes from the bundle catalog
+func EstimateInstall(md *model.SystemInstall, options args.Args) (*InstallEstimate, error) {
+	return newEstimateRequest(md, options).estimate()
+}
+
+// SetEstimatedSize stores the required size of estimate in the media options so
+// the partition validation accounts for the selected bundles, a nil estimate
+// clears it
+func SetEstimatedSize(md *model.SystemInstall, estimate *InstallEstimate) {
+	md.MediaOpts.EstimatedSize = 0
+
+	if estimate != nil {
+		md.MediaOpts.EstimatedSize = estimate.RequiredSize()
+	}
+}
+
+// UpdateEstimatedSize estimates the installation and stores the required size in
+// the media options so the partition validation accounts for the selected
+// bundles; the estimate is returned for display and is nil if it failed
+func UpdateEstimatedSize(md *model.SystemInstall, options args.Args) *InstallEstimate {
+	estimate, err := EstimateInstall(md, options)
+	if err != nil {
+		log.Warning("Could not estimate the installation size: %v", err)
+	}
+
+	SetEstimatedSize(md, estimate)
+
+	return estimate
+}
+
+// EstimateInBackground estimates the installation of the current selection in
+// a goroutine since the bundle manifests may have to be downloaded, the model
+// is only read before it returns. done is called from the goroutine with the
+// estimate, nil if it failed, unless a later estimate was started meanwhile;
+// the UIs store it with SetEstimatedSize
+func EstimateInBackground(md *model.SystemInstall, options args.Args, done func(*InstallEstimate)) {
+	request := newEstimateRequest(md, options)
+	seq := atomic.AddUint64(&estimateSeq, 1)
+
+	go func() {
+		estimate, err := request.estimate()
+		if err != nil {
+			log.Warning("Could not estimate the installation size: %v", err)
+			estimate = nil
+		}
+
+		if atomic.LoadUint64(&estimateSeq) != seq {
+			return
+		}
+
+		done(estimate)
+	}()
+}
+
+// EstimateSummary returns the estimate of the installation for the confirm dialogs
+func EstimateSummary(md *model.SystemInstall, options args.Args) []string {
+	estimate := UpdateEstimatedSize(md, options)
+	if estimate == nil {
+		return []string{}
+	}
+
+	results := []string{estimate.String()}
+
+	for _, curr := range storage.ValidateEstimatedSize(md.TargetMedias, md.MediaOpts) {
+		results = append(results, fmt.Sprintf("WARNING: %s", curr))
+	}
+
+	return results
+}
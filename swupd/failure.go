@@ -0,0 +1,211 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// FailureKind classifies the failures of the swupd commands
+type FailureKind int
+
+const (
+	// FailureUnknown is a failure with no specific handling
+	FailureUnknown FailureKind = iota
+
+	// FailureNetwork is a failure to reach the content server, usually transient
+	FailureNetwork
+
+	// FailureSignature is a failure to verify the signature of the content
+	FailureSignature
+
+	// FailureDiskFull is a failure due to the lack of space in the target
+	FailureDiskFull
+
+	// FailureBundleNotFound is a request for a bundle the version does not have
+	FailureBundleNotFound
+
+	// FailureVersionNotFound is a request for a version the server does not publish
+	FailureVersionNotFound
+)
+
+// swupd exit codes, see swupd_exit_codes.h in swupd-client
+const (
+	exitInvalidBundle         = 3
+	exitCouldntLoadMoM        = 6
+	exitSignatureFailed       = 8
+	exitCouldntDownloadPack   = 10
+	exitBadCert               = 11
+	exitDiskSpaceError        = 12
+	exitNoNetwork             = 19
+	exitCurlInitFailed        = 24
+	exitServerConnectionError = 29
+	exitCouldntDownloadFile   = 30
+)
+
+var (
+	// runSwupd runs a swupd command processing its output, replaced by tests
+	runSwupd = cmd.RunAndProcessOutput
+
+	// retryDelays is the wait before each retry of a command which failed
+	// due to a transient failure, the number of retries is its length
+	retryDelays = []time.Duration{10 * time.Second, 30 * time.Second, 60 * time.Second}
+
+	exitKinds = map[int]FailureKind{
+		exitInvalidBundle:         FailureBundleNotFound,
+		exitCouldntLoadMoM:        FailureVersionNotFound,
+		exitSignatureFailed:       FailureSignature,
+		exitCouldntDownloadPack:   FailureNetwork,
+		exitBadCert:               FailureSignature,
+		exitDiskSpaceError:        FailureDiskFull,
+		exitNoNetwork:             FailureNetwork,
+		exitCurlInitFailed:        FailureNetwork,
+		exitServerConnectionError: FailureNetwork,
+		exitCouldntDownloadFile:   FailureNetwork,
+	}
+
+	// messageKinds classifies the swupd error messages, in order of precedence
+	messageKinds = []struct {
+		kind    FailureKind
+		pattern *regexp.Regexp
+	}{
+		{FailureDiskFull, regexp.MustCompile(`(?i)no space left|not enough (free )?disk space|disk space`)},
+		{FailureSignature, regexp.MustCompile(`(?i)signature|certificate`)},
+		{FailureNetwork, regexp.MustCompile(`(?i)network|could(n't| not) (connect|resolve)|connection|timed? ?out|` +
+			`server (is )?(not )?(responding|available|reachable)|unreachable`)},
+		{FailureBundleNotFound, regexp.MustCompile(`(?i)bundle "?[^" ]+"? (is invalid|not found|does not exist)`)},
+		{FailureVersionNotFound, regexp.MustCompile(`(?i)(failed to retrieve|couldn't load|cannot load|` +
+			`invalid) .*(version|manifest\.?mom)|version .* not (found|available)`)},
+	}
+
+	bundleNameExp = regexp.MustCompile(`(?i)bundle "?([^" ]+)"? (is invalid|not found|does not exist)`)
+)
+
+// Error is a classified failure of a swupd command
+type Error struct {
+	Kind     FailureKind // Kind is the class of the failure
+	Command  string      // Command is the swupd command line which failed
+	Status   int         // Status is the swupd exit code, -1 if unknown
+	Messages []string    // Messages are the errors reported by swupd
+	Bundle   string      // Bundle is the bundle missing for FailureBundleNotFound
+	Version  string      // Version is the version requested
+}
+
+// Error returns the failure, the swupd messages and the action to take
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("The swupd command \"%s\" failed with exit status %d", e.Command, e.Status)
+
+	if len(e.Messages) > 0 {
+		msg = msg + ": " + strings.Join(e.Messages, "; ")
+	}
+
+	if hint := e.Hint(); hint != "" {
+		msg = msg + "\n" + hint
+	}
+
+	return msg
+}
+
+// Transient returns true if the failure may not happen again
+func (e *Error) Transient() bool {
+	return e.Kind == FailureNetwork
+}
+
+// Hint returns the action the user can take to fix the failure
+func (e *Error) Hint() string {
+	switch e.Kind {
+	case FailureNetwork:
+		return utils.Locale.Get("The content server could not be reached. " +
+			"Check the network connection, the proxy settings and the swupd mirror.")
+	case FailureSignature:
+		return utils.Locale.Get("The content signature could not be verified. " +
+			"Check the system date and time and the swupd certificate.")
+	case FailureDiskFull:
+		return utils.Locale.Get("The target ran out of disk space. " +
+			"Increase the size of the root partition or select fewer bundles.")
+	case FailureBundleNotFound:
+		if e.Bundle != "" {
+			return utils.Locale.Get("Bundle %s is not available in version %s. Remove it from the bundle list.",
+				e.Bundle, e.Version)
+		}
+		return utils.Locale.Get("A requested bundle is not available in version %s. Check the bundle list.",
+			e.Version)
+	case FailureVersionNotFound:
+		return utils.Locale.Get("Version %s is not available from the content server. "+
+			"Check the version and the swupd mirror.", e.Version)
+	}
+
+	return ""
+}
+
+// IsTransientError returns true if err is a swupd failure worth retrying
+func IsTransientError(err error) bool {
+	if serr, ok := err.(*Error); ok {
+		return serr.Transient()
+	}
+
+	return false
+}
+
+// FailureHint returns the action to fix err if it is a classified swupd
+// failure, an empty string is returned otherwise
+func FailureHint(err error) string {
+	if serr, ok := err.(*Error); ok {
+		return serr.Hint()
+	}
+
+	return ""
+}
+
+// classifyFailure builds the swupd Error of a failed command from its exit
+// status and the error messages of its JSON output; the messages take
+// precedence over the exit code which is shared by different failures
+func classifyFailure(args []string, version string, out *output, err error) *Error {
+	serr := &Error{
+		Kind:     FailureUnknown,
+		Command:  strings.Join(args, " "),
+		Status:   -1,
+		Messages: out.errors,
+		Version:  version,
+	}
+
+	if out.status > 0 {
+		serr.Status = out.status
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		serr.Status = exitErr.ExitCode()
+	}
+
+	if kind, ok := exitKinds[serr.Status]; ok {
+		serr.Kind = kind
+	}
+
+	text := strings.Join(out.errors, "\n")
+
+	for _, curr := range messageKinds {
+		if curr.pattern.MatchString(text) {
+			serr.Kind = curr.kind
+			break
+		}
+	}
+
+	if serr.Kind == FailureBundleNotFound {
+		if match := bundleNameExp.FindStringSubmatch(text); match != nil {
+			serr.Bundle = match[1]
+		}
+	}
+
+	if len(serr.Messages) == 0 && err != nil {
+		serr.Messages = []string{err.Error()}
+	}
+
+	return serr
+}
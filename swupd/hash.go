@@ -0,0 +1,225 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/clearlinux/clr-installer/errors"
+)
+
+// hashStat is the file information swupd keys the hash of a file with, it must
+// match the update_stat struct of swupd
+type hashStat struct {
+	Mode uint64
+	UID  uint64
+	GID  uint64
+	Rdev uint64
+	Size uint64
+}
+
+// dirHashData replaces the content of the directories in their hash
+const dirHashData = "DIRECTORY"
+
+var (
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// hashKey returns the HMAC key swupd derives from the file information
+func hashKey(st hashStat) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, st)
+
+	mac := hmac.New(sha256.New, buf.Bytes())
+
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// contentHash returns the swupd hash of a file with the information st and content r
+func contentHash(st hashStat, r io.Reader) (string, error) {
+	mac := hmac.New(sha256.New, hashKey(st))
+
+	if _, err := io.Copy(mac, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// manifestHash returns the hash the manifest of manifests lists for a manifest,
+// which is published as a regular file owned by root with the mode 0644
+func manifestHash(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	fi, err := fp.Stat()
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	hash, err := contentHash(hashStat{Mode: syscall.S_IFREG | 0644, Size: uint64(fi.Size())}, fp)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return hash, nil
+}
+
+// entryHash returns the swupd hash of an archived file, directory or symbolic link
+func entryHash(hdr *tar.Header, r io.Reader) (string, error) {
+	st := hashStat{
+		Mode: uint64(hdr.Mode & 07777),
+		UID:  uint64(hdr.Uid),
+		GID:  uint64(hdr.Gid),
+		Size: uint64(hdr.Size),
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		st.Mode |= syscall.S_IFREG
+	case tar.TypeDir:
+		st.Mode |= syscall.S_IFDIR
+		st.Size = 0
+		r = strings.NewReader(dirHashData)
+	case tar.TypeSymlink:
+		// the mode of the links differs between the file systems, swupd ignores it
+		st.Mode = 0
+		st.Size = uint64(len(hdr.Linkname))
+		r = strings.NewReader(hdr.Linkname)
+	default:
+		return "", errors.Errorf("Unsupported archive entry type %q for %s", hdr.Typeflag, hdr.Name)
+	}
+
+	hash, err := contentHash(st, r)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	return hash, nil
+}
+
+// archiveName returns the name of an archive entry without the leading ./ and slashes
+func archiveName(hdr *tar.Header) string {
+	return strings.Trim(strings.TrimPrefix(hdr.Name, "./"), "/")
+}
+
+// cmdReader reads the output of a decompression command
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	_ = r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// openArchive returns the content of a tar archive, decompressing the xz, gzip
+// and bzip2 archives swupd publishes
+func openArchive(path string) (io.ReadCloser, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	br := bufio.NewReader(fp)
+	magic, _ := br.Peek(len(xzMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, xzMagic):
+		_ = fp.Close()
+
+		// the standard library has no xz support
+		xz := exec.Command("xz", "-dc", path)
+		out, err := xz.StdoutPipe()
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		if err = xz.Start(); err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		return &cmdReader{ReadCloser: out, cmd: xz}, nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			_ = fp.Close()
+			return nil, errors.Wrap(err)
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{zr, fp}, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return struct {
+			io.Reader
+			io.Closer
+		}{bzip2.NewReader(br), fp}, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, fp}, nil
+}
+
+// walkArchive calls fn with each entry of a possibly compressed tar archive
+func walkArchive(path string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	rc, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(rc)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			_ = rc.Close()
+			return errors.Errorf("Invalid archive: %v", err)
+		}
+
+		if err = fn(hdr, tr); err != nil {
+			_ = rc.Close()
+			return err
+		}
+	}
+
+	// the padding after the last entry is read so the decompression completes
+	_, _ = io.Copy(ioutil.Discard, rc)
+
+	if err = rc.Close(); err != nil {
+		return errors.Errorf("Invalid archive: %v", err)
+	}
+
+	return nil
+}
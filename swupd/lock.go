@@ -0,0 +1,308 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/storage"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// LockFileSuffix replaces the extension of the configuration file to name its lock file
+	LockFileSuffix = ".lock"
+
+	// lockHeader is written at the top of the lock files
+	lockHeader = "# Generated by clr-installer --reproducible, do not edit.\n" +
+		"# Remove this file to pin a new version.\n"
+)
+
+// Lock pins the content of a reproducible installation: the OS version, the
+// checksums of the manifests of the installed bundles, the bundles added for
+// the host of the first build, the timestamp of the created files and the seed
+// of the file system and partition identifiers
+type Lock struct {
+	Version         string            `yaml:"version"`
+	SourceDateEpoch int64             `yaml:"sourceDateEpoch"`
+	Seed            string            `yaml:"seed"`
+	HostBundles     []string          `yaml:"hostBundles"`
+	Manifests       map[string]string `yaml:"manifests"`
+}
+
+// LockFile returns the lock file of a configuration file, next to it
+func LockFile(configFile string) string {
+	return strings.TrimSuffix(configFile, filepath.Ext(configFile)) + LockFileSuffix
+}
+
+// hashManifest returns the sha256 checksum of a manifest and its content, the
+// manifest is checked against its hash in the manifest of manifests unless
+// momHash is empty
+func hashManifest(source manifestSource, version string, name string, momHash string) (string, []byte, error) {
+	fp, err := source.open(version, name, momHash)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if fp == nil {
+		return "", nil, errors.Errorf("Manifest.%s not found for version %s in %s", name, version, source)
+	}
+	defer func() { _ = fp.Close() }()
+
+	content, err := ioutil.ReadAll(fp)
+	if err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:]), content, nil
+}
+
+// buildLock computes the lock of the requested bundles for a version
+func buildLock(source manifestSource, version string, bundles []string, skipOptional bool) (*Lock, error) {
+	momHash, content, err := hashManifest(source, version, momName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	header, _, err := parseManifestHeader(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Errorf("%s Manifest.MoM: %v", source, err)
+	}
+
+	entries, err := parseMoM(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Errorf("%s Manifest.MoM: %v", source, err)
+	}
+
+	// only the manifests of the requested bundles and their includes are read
+	closure, unknown, err := momCatalog(source, version, entries).closure(bundles, skipOptional)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("Bundle %s is not available in version %s", strings.Join(unknown, ", "), version)
+	}
+
+	lock := &Lock{
+		Version:         version,
+		SourceDateEpoch: header.timestamp,
+		Manifests:       map[string]string{momName: momHash},
+	}
+
+	for _, curr := range entries {
+		if !closure[curr.name] {
+			continue
+		}
+
+		hash, _, err := hashManifest(source, curr.version, curr.name, curr.hash)
+		if err != nil {
+			return nil, err
+		}
+
+		lock.Manifests[curr.name] = hash
+	}
+
+	// the seed only depends on the pinned content
+	names := []string{}
+	for curr := range lock.Manifests {
+		names = append(names, curr+"="+lock.Manifests[curr])
+	}
+	sort.Strings(names)
+
+	sum := sha256.Sum256([]byte(version + "\n" + strings.Join(names, "\n")))
+	lock.Seed = hex.EncodeToString(sum[:16])
+
+	return lock, nil
+}
+
+// verifyLock checks the content of the installation still matches the lock
+func verifyLock(lock *Lock, current *Lock) error {
+	problems := []string{}
+
+	for name, hash := range current.Manifests {
+		locked, ok := lock.Manifests[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("bundle %s is not pinned", name))
+		} else if locked != hash {
+			problems = append(problems, fmt.Sprintf("Manifest.%s changed", name))
+		}
+	}
+
+	for name := range lock.Manifests {
+		if _, ok := current.Manifests[name]; !ok {
+			problems = append(problems, fmt.Sprintf("bundle %s is no longer installed", name))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+
+	return errors.Errorf("The content of version %s does not match the lock file: %s",
+		lock.Version, strings.Join(problems, ", "))
+}
+
+// readLock loads a lock file, a nil lock is returned if the file does not exist
+func readLock(file string) (*Lock, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	lock := &Lock{}
+	if err = yaml.UnmarshalStrict(content, lock); err != nil {
+		return nil, errors.Errorf("Invalid lock file %s: %v", file, err)
+	}
+
+	if lock.Version == "" || lock.Seed == "" || len(lock.Manifests) == 0 {
+		return nil, errors.Errorf("Invalid lock file %s: missing the version, seed or manifests", file)
+	}
+
+	return lock, nil
+}
+
+// writeLock saves the lock file
+func writeLock(file string, lock *Lock) error {
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(file, append([]byte(lockHeader), content...), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// lockVersion returns the version pinned by the lock
+func lockVersion(lock *Lock, file string) (uint, error) {
+	pinned, err := strconv.ParseUint(lock.Version, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("Invalid version %q in the lock file %s", lock.Version, file)
+	}
+
+	return uint(pinned), nil
+}
+
+// ApplyLock pins the installation to the lock file of the configuration file:
+// a new lock is written by the first build, the following builds check the
+// content still matches it. The version of the model is set to the pinned
+// version, the created files get its timestamp through SOURCE_DATE_EPOCH and
+// the file system and partition identifiers are derived from its seed
+func ApplyLock(md *model.SystemInstall, options args.Args) (*Lock, error) {
+	if options.ConfigFile == "" {
+		return nil, errors.Errorf("Reproducible builds require a configuration file")
+	}
+
+	file := LockFile(options.ConfigFile)
+
+	lock, err := readLock(file)
+	if err != nil {
+		return nil, err
+	}
+
+	version := utils.VersionUintString(md.Version)
+
+	if lock != nil && !utils.IsLatestVersion(version) && version != lock.Version {
+		return nil, errors.Errorf("The configured version %s does not match the version %s of the lock file %s",
+			version, lock.Version, file)
+	}
+
+	// the pinned version also decides if the offline content is usable
+	if lock != nil {
+		if md.Version, err = lockVersion(lock, file); err != nil {
+			return nil, err
+		}
+		version = lock.Version
+	}
+
+	source := installSource(md, options)
+
+	if version, err = resolveVersion(source, version); err != nil {
+		return nil, err
+	}
+
+	// the bundles added for the build host, such as NetworkManager, are
+	// decided by the first build and installed by the following ones
+	hosts := []string{}
+	if lock != nil {
+		hosts = append(hosts, lock.HostBundles...)
+	} else {
+		md.HostBundles = nil
+		for _, curr := range hostBundles(md) {
+			hosts = append(hosts, curr.Name)
+		}
+	}
+	md.HostBundles = hosts
+
+	current, err := buildLock(source, version, InstallBundles(md), md.SwupdSkipOptional)
+	if err != nil {
+		return nil, err
+	}
+	current.HostBundles = hosts
+
+	if lock == nil {
+		// an explicit SOURCE_DATE_EPOCH takes precedence over the timestamp of the version
+		if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+			if current.SourceDateEpoch, err = strconv.ParseInt(epoch, 10, 64); err != nil {
+				return nil, errors.Errorf("Invalid SOURCE_DATE_EPOCH %q", epoch)
+			}
+		}
+
+		lock = current
+		if err = writeLock(file, lock); err != nil {
+			return nil, err
+		}
+
+		log.Info("Pinned version %s to the lock file %s", lock.Version, file)
+
+		if len(hosts) > 0 {
+			log.Info("Pinned the bundles of the build host: %s", strings.Join(hosts, ", "))
+		}
+	} else if err = verifyLock(lock, current); err != nil {
+		return nil, err
+	} else {
+		log.Info("Verified the content of version %s against the lock file %s", lock.Version, file)
+	}
+
+	if md.Version, err = lockVersion(lock, file); err != nil {
+		return nil, err
+	}
+
+	epoch := strconv.FormatInt(lock.SourceDateEpoch, 10)
+	for _, curr := range []string{"SOURCE_DATE_EPOCH", "E2FSPROGS_FAKE_TIME"} {
+		if err = os.Setenv(curr, epoch); err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	storage.SetReproducibleSeed(lock.Seed)
+
+	return lock, nil
+}
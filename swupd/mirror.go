@@ -0,0 +1,918 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// DefaultCertPath is the certificate swupd verifies the content signatures with
+	DefaultCertPath = "/usr/share/clear/update-ca/Swupd_Root.pem"
+
+	// MirrorIndex lists the sha256 of every file of a mirror in the sha256sum
+	// format, the copies of a mirror can be checked with: sha256sum -c SHA256SUMS
+	MirrorIndex = "SHA256SUMS"
+
+	// partialSuffix marks the downloads in progress, resumed by the next run
+	partialSuffix = ".part"
+
+	// maxMirrorDownloads limits the concurrent downloads of the mirror content
+	maxMirrorDownloads = 4
+
+	// mirrorFetchTimeout and mirrorFetchMaxSize allow the download of the
+	// largest packs, the defaults of the fetcher only suit the manifests
+	mirrorFetchTimeout = time.Hour
+	mirrorFetchMaxSize = 16 * 1024 * 1024 * 1024
+)
+
+var (
+	// zeroHash is the hash of the deleted files, which have no content
+	zeroHash = strings.Repeat("0", 64)
+)
+
+// mirror is a swupd content directory in the layout of the content URL
+type mirror struct {
+	dir       string
+	url       string
+	index     map[string]string
+	indexFile *os.File
+	mutex     sync.Mutex
+}
+
+// verifyFunc checks the integrity of a downloaded file
+type verifyFunc func(path string) error
+
+// BuildMirror downloads from contentURL to dir the swupd content of version
+// needed to install bundles and the bundles they include: the manifests, the
+// zero packs and the fullfiles. The dir can be served over HTTP or used with
+// --swupd-url=file://<dir>; the manifest of manifests is verified with its
+// signature against certPath, or DefaultCertPath if empty, the manifests with
+// their hash in the manifest of manifests and the files with their hash in the
+// manifests. Every file, including the ones kept from a previous run, is
+// verified before being added to the mirror and an interrupted build is resumed
+// by running it again with the same dir. The version built is returned, which
+// is the latest when version is latest.
+func BuildMirror(dir string, contentURL string, version string, bundles []string, skipOptional bool,
+	certPath string) (string, error) {
+	var err error
+
+	contentURL = strings.TrimSuffix(contentURL, "/")
+
+	if utils.IsLatestVersion(version) {
+		if version, err = (&urlSource{url: contentURL}).latestVersion(); err != nil {
+			return "", err
+		}
+	}
+
+	m, err := openMirror(dir, contentURL)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := m.close(); cerr != nil {
+			log.Warning("Could not write the mirror index: %v", cerr)
+		}
+	}()
+
+	momRel := filepath.Join("update", version, "Manifest."+momName)
+	momPath := filepath.Join(dir, momRel)
+
+	// the signature is always downloaded again so a replaced manifest of
+	// manifests can't be verified against a signature left in the mirror
+	if err = m.remove(momRel + ".sig"); err != nil {
+		return "", err
+	}
+
+	if err = m.fetch(momRel+".sig", true, verifyNotEmpty); err != nil {
+		return "", err
+	}
+
+	if err = m.fetch(momRel, true, verifyMoM(momPath+".sig", certPath)); err != nil {
+		return "", err
+	}
+
+	if err = m.fetch(momRel+".tar", false, verifyManifestTar("Manifest."+momName, momPath)); err != nil {
+		return "", err
+	}
+
+	format, entries, err := readMoM(momPath)
+	if err != nil {
+		return "", err
+	}
+
+	fullfiles, err := m.fetchBundles(version, entries, bundles, skipOptional)
+	if err != nil {
+		return "", err
+	}
+
+	if err = m.fetchFullfiles(fullfiles); err != nil {
+		return "", err
+	}
+
+	// the version files tell swupd the most recent version of the mirror
+	for _, curr := range []string{"latest", filepath.Join("version", "format"+format, "latest")} {
+		if err = writeMirrorVersion(filepath.Join(dir, curr), version); err != nil {
+			return "", err
+		}
+	}
+
+	return version, nil
+}
+
+// VerifyMirror checks every file listed in the index of the mirror in dir still
+// matches its checksum and the content of the mirror with the signatures of its
+// manifests of manifests against certPath, or DefaultCertPath if empty, as
+// BuildMirror does; the missing, modified and partially downloaded files are returned
+func VerifyMirror(dir string, certPath string) ([]string, error) {
+	index, err := readMirrorIndex(filepath.Join(dir, MirrorIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(index) == 0 {
+		return nil, errors.Errorf("%s has no %s index, it is not a mirror", dir, MirrorIndex)
+	}
+
+	problems := []string{}
+	reported := map[string]bool{}
+
+	for rel, sum := range index {
+		path := filepath.Join(dir, rel)
+
+		curr, err := fileSHA256(path)
+		if os.IsNotExist(err) {
+			problems = append(problems, utils.Locale.Get("%s is missing", rel))
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if curr != sum {
+			problems = append(problems, utils.Locale.Get("%s checksum mismatch", rel))
+			reported[rel] = true
+		}
+	}
+
+	failed, err := verifyMirrorContent(dir, certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range failed {
+		if !reported[rel] {
+			problems = append(problems, utils.Locale.Get("%s failed the verification", rel))
+		}
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && strings.HasSuffix(path, partialSuffix) {
+			rel, _ := filepath.Rel(dir, path)
+			problems = append(problems, utils.Locale.Get("%s is an incomplete download", rel))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	sort.Strings(problems)
+
+	return problems, nil
+}
+
+// openMirror creates the mirror dir if needed and loads the index of the files
+// downloaded by the previous runs
+func openMirror(dir string, url string) (*mirror, error) {
+	if err := utils.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	indexPath := filepath.Join(dir, MirrorIndex)
+
+	index, err := readMirrorIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fp, err := os.OpenFile(indexPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &mirror{dir: dir, url: url, index: index, indexFile: fp}, nil
+}
+
+// close rewrites the index sorted and without the entries replaced by later downloads
+func (m *mirror) close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.indexFile.Close(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	names := []string{}
+	for rel := range m.index {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, rel := range names {
+		buf.WriteString(fmt.Sprintf("%s  %s\n", m.index[rel], rel))
+	}
+
+	indexPath := filepath.Join(m.dir, MirrorIndex)
+	if err := ioutil.WriteFile(indexPath+partialSuffix, buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := os.Rename(indexPath+partialSuffix, indexPath); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// readMirrorIndex reads the sha256 of the mirror files by relative path, a
+// missing index is empty
+func readMirrorIndex(path string) (map[string]string, error) {
+	index := map[string]string{}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		index[fields[1]] = fields[0]
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return index, nil
+}
+
+// fetchBundles downloads the manifests and packs of bundles and the bundles
+// they include, the fullfiles listed by the manifests are returned
+func (m *mirror) fetchBundles(version string, entries []*momEntry, bundles []string,
+	skipOptional bool) (map[string]string, error) {
+	byName := map[string]*momEntry{}
+	for _, curr := range entries {
+		byName[curr.name] = curr
+	}
+
+	fullfiles := map[string]string{}
+	visited := map[string]bool{}
+	pending := append([]string{}, bundles...)
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		entry, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("Bundle %s not found in the Manifest.MoM of version %s", name, version)
+		}
+
+		log.Info("Mirroring bundle %s (version %s)", name, entry.version)
+		fmt.Printf("Mirroring bundle %s\n", name)
+
+		updateDir := filepath.Join("update", entry.version)
+		manifestRel := filepath.Join(updateDir, "Manifest."+name)
+
+		manifestPath := filepath.Join(m.dir, manifestRel)
+
+		if err := m.fetch(manifestRel, true, verifyBundleManifest(entry.hash)); err != nil {
+			return nil, err
+		}
+
+		if err := m.fetch(manifestRel+".tar", false, verifyManifestTar("Manifest."+name, manifestPath)); err != nil {
+			return nil, err
+		}
+
+		// swupd falls back to the fullfiles if the pack is not published
+		if err := m.fetch(packRel(entry), false, verifyPack); err != nil {
+			return nil, err
+		}
+
+		header, files, err := readManifestFiles(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for hash, fileVersion := range files {
+			fullfiles[filepath.Join("update", fileVersion, "files", hash+".tar")] = hash
+		}
+
+		pending = append(pending, header.includes...)
+		if !skipOptional {
+			pending = append(pending, header.optional...)
+		}
+	}
+
+	return fullfiles, nil
+}
+
+// fetchFullfiles downloads the fullfiles concurrently
+func (m *mirror) fetchFullfiles(fullfiles map[string]string) error {
+	names := []string{}
+	for rel := range fullfiles {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+
+	log.Info("Mirroring %d fullfiles", len(names))
+	fmt.Printf("Mirroring %d files\n", len(names))
+
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxMirrorDownloads)
+
+	for i, curr := range names {
+		wg.Add(1)
+		sem <- true
+
+		go func(i int, rel string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			errs[i] = m.fetch(rel, true, verifyFullfile(fullfiles[rel]))
+		}(i, curr)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isMirrored returns true if rel was already downloaded and is intact, the
+// files are verified again as the index only records what was downloaded and
+// the files copied to the mirror by other means are added to the index
+func (m *mirror) isMirrored(rel string, verify verifyFunc) (bool, error) {
+	path := filepath.Join(m.dir, rel)
+
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err)
+	}
+
+	if err := verify(path); err != nil {
+		log.Warning("Replacing %s: %v", rel, err)
+		return false, nil
+	}
+
+	m.mutex.Lock()
+	sum, indexed := m.index[rel]
+	m.mutex.Unlock()
+
+	curr, err := fileSHA256(path)
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	if indexed && curr == sum {
+		return true, nil
+	}
+
+	return true, m.addToIndex(rel, path)
+}
+
+// remove deletes rel from the mirror and its index
+func (m *mirror) remove(rel string) error {
+	if err := os.Remove(filepath.Join(m.dir, rel)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.index, rel)
+
+	return nil
+}
+
+// fetch downloads rel from the content URL to the mirror, unless it is already
+// mirrored; the missing files which are not required are ignored, the downloads
+// completed but not added to the mirror by the previous run are kept and the
+// interrupted ones are resumed
+func (m *mirror) fetch(rel string, required bool, verify verifyFunc) error {
+	ok, err := m.isMirrored(rel, verify)
+	if err != nil || ok {
+		return err
+	}
+
+	url := m.url + "/" + filepath.ToSlash(rel)
+	path := filepath.Join(m.dir, rel)
+	part := path + partialSuffix
+
+	if err = utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// the previous run may have stopped after the download completed, or in
+	// the middle of it and the download is resumed
+	resumed := false
+	if info, serr := os.Stat(part); serr == nil {
+		if verify(part) == nil {
+			log.Debug("Keeping the download of %s from the previous run", rel)
+			return m.commit(rel, part)
+		}
+
+		resumed = info.Size() > 0
+	}
+
+	fetcher := &network.Fetcher{Timeout: mirrorFetchTimeout, MaxSize: mirrorFetchMaxSize}
+	if err = fetcher.Resume(url, part); err != nil {
+		if !required && network.IsNotFound(err) {
+			log.Debug("Optional %s not found, skipping", url)
+			return nil
+		}
+
+		return errors.Errorf("Could not download %s: %v", url, err)
+	}
+
+	// the content kept from the previous run may not be a prefix of the file
+	if err = verify(part); err != nil && resumed {
+		log.Warning("Downloading %s again: %v", rel, err)
+
+		if err = fetcher.Fetch(url, part); err != nil {
+			return errors.Errorf("Could not download %s: %v", url, err)
+		}
+
+		err = verify(part)
+	}
+
+	if err != nil {
+		_ = os.Remove(part)
+		return errors.Errorf("%s failed the verification: %v", url, err)
+	}
+
+	return m.commit(rel, part)
+}
+
+// commit moves a verified download into place and adds it to the index
+func (m *mirror) commit(rel string, part string) error {
+	path := filepath.Join(m.dir, rel)
+
+	// the downloads are private until verified, the mirror is served to others
+	if err := os.Chmod(part, 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return m.addToIndex(rel, path)
+}
+
+func (m *mirror) addToIndex(rel string, path string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.index[rel] = sum
+
+	// the entries are appended as the files are completed so an interrupted
+	// build keeps track of the files already downloaded
+	if _, err = fmt.Fprintf(m.indexFile, "%s  %s\n", sum, rel); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, fp); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyManifest checks the file is a complete manifest: a valid header
+// followed by well formed entries, the last one ending with a new line
+func verifyManifest(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		return errors.Errorf("Truncated manifest")
+	}
+
+	_, scanner, err := parseManifestHeader(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && len(strings.Split(line, "\t")) != 4 {
+			return errors.Errorf("Invalid manifest entry %q", line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func verifyNotEmpty(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if info.Size() == 0 {
+		return errors.Errorf("Empty file")
+	}
+
+	return nil
+}
+
+// verifyMoM returns a check that the file is a manifest of manifests signed by
+// the signature in sigPath with the certificate in certPath, or DefaultCertPath
+func verifyMoM(sigPath string, certPath string) verifyFunc {
+	return func(path string) error {
+		if err := verifyManifest(path); err != nil {
+			return err
+		}
+
+		return verifySignature(path, sigPath, certPath)
+	}
+}
+
+// verifySignature checks the detached PKCS7 signature swupd publishes for the
+// manifest of manifests, as swupd does
+func verifySignature(path string, sigPath string, certPath string) error {
+	if certPath == "" {
+		certPath = DefaultCertPath
+	}
+
+	var out bytes.Buffer
+
+	args := []string{
+		"openssl",
+		"smime",
+		"-verify",
+		"-binary",
+		"-purpose",
+		"any",
+		"-inform",
+		"der",
+		"-in",
+		sigPath,
+		"-content",
+		path,
+		"-CAfile",
+		certPath,
+		"-out",
+		os.DevNull,
+	}
+
+	if err := cmd.Run(&out, args...); err != nil {
+		return errors.Errorf("Signature check against %s failed: %s", certPath, strings.TrimSpace(out.String()))
+	}
+
+	return nil
+}
+
+// verifyBundleManifest returns a check that the file is a manifest with the hash
+// listed in the manifest of manifests
+func verifyBundleManifest(hash string) verifyFunc {
+	return func(path string) error {
+		if err := verifyManifest(path); err != nil {
+			return err
+		}
+
+		curr, err := manifestHash(path)
+		if err != nil {
+			return err
+		}
+
+		if curr != hash {
+			return errors.Errorf("Manifest hash %s does not match %s", curr, hash)
+		}
+
+		return nil
+	}
+}
+
+// verifyManifestTar returns a check that the file is an archive of the verified
+// manifest in manifestPath
+func verifyManifestTar(name string, manifestPath string) verifyFunc {
+	return func(path string) error {
+		manifest, err := ioutil.ReadFile(manifestPath)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		found := false
+
+		err = walkArchive(path, func(hdr *tar.Header, r io.Reader) error {
+			if archiveName(hdr) != name {
+				return nil
+			}
+
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return errors.Errorf("Invalid archive: %v", err)
+			}
+
+			if !bytes.Equal(content, manifest) {
+				return errors.Errorf("Archived %s differs from the manifest", name)
+			}
+
+			found = true
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return errors.Errorf("Archive does not contain %s", name)
+		}
+
+		return nil
+	}
+}
+
+// verifyFullfile returns a check that the file is an archive of the file with
+// the given hash in the manifests
+func verifyFullfile(hash string) verifyFunc {
+	return func(path string) error {
+		found := false
+
+		err := walkArchive(path, func(hdr *tar.Header, r io.Reader) error {
+			if archiveName(hdr) != hash {
+				return nil
+			}
+
+			curr, err := entryHash(hdr, r)
+			if err != nil {
+				return err
+			}
+
+			if curr != hash {
+				return errors.Errorf("File hash %s does not match %s", curr, hash)
+			}
+
+			found = true
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return errors.Errorf("Archive does not contain %s", hash)
+		}
+
+		return nil
+	}
+}
+
+// verifyPack checks the files staged by a pack match the hash they are named after
+func verifyPack(path string) error {
+	return walkArchive(path, func(hdr *tar.Header, r io.Reader) error {
+		name := archiveName(hdr)
+		if !strings.HasPrefix(name, "staged/") {
+			return nil
+		}
+
+		hash := strings.TrimPrefix(name, "staged/")
+
+		curr, err := entryHash(hdr, r)
+		if err != nil {
+			return err
+		}
+
+		if curr != hash {
+			return errors.Errorf("Staged file hash %s does not match %s", curr, hash)
+		}
+
+		return nil
+	})
+}
+
+// packRel returns the path of the zero pack of a bundle in the content
+func packRel(entry *momEntry) string {
+	return filepath.Join("update", entry.version, fmt.Sprintf("pack-%s-from-0.tar", entry.name))
+}
+
+// verifyMirrorContent checks the content of every manifest of manifests of the
+// mirror in dir and returns the files failing the verification; the bundles and
+// files which are not part of the mirror are skipped
+func verifyMirrorContent(dir string, certPath string) ([]string, error) {
+	moms, err := filepath.Glob(filepath.Join(dir, "update", "*", "Manifest."+momName))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	failed := []string{}
+	checked := map[string]bool{}
+
+	// check verifies rel if it is part of the mirror and wasn't verified already
+	check := func(rel string, verify verifyFunc) bool {
+		path := filepath.Join(dir, rel)
+		if _, err := os.Stat(path); checked[rel] || err != nil {
+			return false
+		}
+		checked[rel] = true
+
+		if err := verify(path); err != nil {
+			log.Warning("%s failed the verification: %v", rel, err)
+			failed = append(failed, rel)
+			return false
+		}
+
+		return true
+	}
+
+	for _, curr := range moms {
+		momRel, _ := filepath.Rel(dir, curr)
+		if !check(momRel, verifyMoM(curr+".sig", certPath)) {
+			continue
+		}
+
+		_, entries, err := readMoM(curr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			manifestRel := filepath.Join("update", entry.version, "Manifest."+entry.name)
+			if !check(manifestRel, verifyBundleManifest(entry.hash)) {
+				continue
+			}
+
+			_ = check(manifestRel+".tar", verifyManifestTar("Manifest."+entry.name, filepath.Join(dir, manifestRel)))
+			_ = check(packRel(entry), verifyPack)
+
+			_, files, err := readManifestFiles(filepath.Join(dir, manifestRel))
+			if err != nil {
+				return nil, err
+			}
+
+			for hash, fileVersion := range files {
+				_ = check(filepath.Join("update", fileVersion, "files", hash+".tar"), verifyFullfile(hash))
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+// readMoM returns the format and the bundles of a manifest of manifests
+func readMoM(path string) (string, []*momEntry, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	header, _, err := parseManifestHeader(fp)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err = fp.Seek(0, io.SeekStart); err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+
+	entries, err := parseMoM(fp)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return header.format, entries, nil
+}
+
+// readManifestFiles returns the header of a bundle manifest and the version of
+// its files by hash, the deleted files are skipped
+func readManifestFiles(path string) (*manifestHeader, map[string]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+	defer func() {
+		_ = fp.Close()
+	}()
+
+	header, entries, err := parseManifestFiles(fp)
+	if err != nil {
+		return nil, nil, errors.Errorf("%s: %v", path, err)
+	}
+
+	files := map[string]string{}
+	for _, curr := range entries {
+		files[curr.hash] = curr.version
+	}
+
+	return header, files, nil
+}
+
+// writeMirrorVersion writes version to path unless it holds a more recent version
+func writeMirrorVersion(path string, version string) error {
+	if content, err := ioutil.ReadFile(path); err == nil {
+		curr, cerr := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 32)
+		next, nerr := strconv.ParseUint(version, 10, 32)
+
+		if cerr == nil && nerr == nil && curr > next {
+			return nil
+		}
+	}
+
+	if err := utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(version+"\n"), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// Resolver answers bundle questions from the bundle catalog: whether a
+// bundle exists and which bundles it installs through its includes
+type Resolver struct {
+	catalog      *Catalog
+	skipOptional bool
+}
+
+// NewResolver creates a resolver for the catalog bundles, the optional includes
+// are not followed if skipOptional is set
+func NewResolver(catalog *Catalog, skipOptional bool) *Resolver {
+	return &Resolver{catalog: catalog, skipOptional: skipOptional}
+}
+
+// LoadResolver creates a resolver for the bundle catalog of the selected version
+func LoadResolver(md *model.SystemInstall, options args.Args) (*Resolver, error) {
+	catalog, err := LoadCatalog(md, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewResolver(catalog, md.SwupdSkipOptional), nil
+}
+
+// Contains returns true if the bundle exists in the catalog
+func (r *Resolver) Contains(name string) bool {
+	return r.catalog.Contains(name)
+}
+
+// closure returns every bundle installed along with the given ones, including
+// them; the manifests of these bundles are read the first time they are needed
+func (r *Resolver) closure(names ...string) map[string]bool {
+	found, _, err := r.catalog.closure(names, r.skipOptional)
+	if err != nil {
+		log.Warning("Could not resolve the includes of %s: %v", strings.Join(names, ", "), err)
+		found = map[string]bool{}
+	}
+
+	for _, curr := range names {
+		found[curr] = true
+	}
+
+	return found
+}
+
+// AlsoInstalls returns the bundles installed as a consequence of installing
+// name, sorted and excluding name itself
+func (r *Resolver) AlsoInstalls(name string) []string {
+	result := []string{}
+
+	for curr := range r.closure(name) {
+		if curr != name {
+			result = append(result, curr)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// IncludedBy returns the selected bundles which still install name through
+// their includes, name itself is ignored
+func (r *Resolver) IncludedBy(name string, selected []string) []string {
+	result := []string{}
+
+	for _, curr := range selected {
+		if curr == name {
+			continue
+		}
+
+		if r.closure(curr)[name] {
+			result = append(result, curr)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Suggest returns the catalog bundle with the closest name to a nonexistent
+// bundle, an empty string is returned if no name is close enough
+func (r *Resolver) Suggest(name string) string {
+	best := ""
+	bestDistance := len(name)/3 + 1
+
+	for _, bundle := range r.catalog.Bundles() {
+		curr := bundle.Name
+		distance := editDistance(name, curr)
+		if distance < bestDistance || (distance == bestDistance && best != "" && curr < best) {
+			best = curr
+			bestDistance = distance
+		}
+	}
+
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// ImplicitBundles returns the bundles the installer adds and why, including
+// the ones depending on the running system
+func ImplicitBundles(md *model.SystemInstall) []*model.ImplicitBundle {
+	return append(hostBundles(md), md.ImplicitBundles()...)
+}
+
+// hostBundles returns the bundles added for the running system, or the ones
+// pinned by the lock file of a reproducible installation
+func hostBundles(md *model.SystemInstall) []*model.ImplicitBundle {
+	result := []*model.ImplicitBundle{}
+
+	if md.HostBundles != nil {
+		for _, curr := range md.HostBundles {
+			result = append(result, &model.ImplicitBundle{Name: curr, Reason: "pinned by the lock file"})
+		}
+
+		return result
+	}
+
+	if network.IsNetworkManagerActive() {
+		result = append(result, &model.ImplicitBundle{Name: network.RequiredBundle,
+			Reason: "NetworkManager is used by the installer"})
+	}
+
+	return result
+}
+
+// SelectionNote describes the effect of checking or unchecking a bundle in the
+// bundle pickers given the bundles checked; an empty string means nothing to note
+func (r *Resolver) SelectionNote(md *model.SystemInstall, name string, checked bool, selected []string) string {
+	if checked {
+		also := r.AlsoInstalls(name)
+		if len(also) == 0 {
+			return ""
+		}
+
+		return utils.Locale.Get("%s also installs: %s", name, strings.Join(also, ", "))
+	}
+
+	// the bundles installed regardless of the user selection
+	installed := append([]string{}, selected...)
+	installed = append(installed, CoreBundles...)
+	installed = append(installed, md.Bundles...)
+
+	for _, curr := range ImplicitBundles(md) {
+		if !md.ContainsUserBundle(curr.Name) {
+			installed = append(installed, curr.Name)
+		}
+	}
+
+	by := r.IncludedBy(name, installed)
+	if len(by) == 0 {
+		return ""
+	}
+
+	return utils.Locale.Get("WARNING: %s is still installed, it is included by %s", name, strings.Join(by, ", "))
+}
+
+// ImplicitBundlesNote explains the bundles the installer adds besides the
+// user selection for the bundle pickers
+func ImplicitBundlesNote(md *model.SystemInstall) string {
+	notes := []string{}
+
+	for _, curr := range ImplicitBundles(md) {
+		if !md.ContainsUserBundle(curr.Name) {
+			notes = append(notes, fmt.Sprintf("%s (%s)", curr.Name, utils.Locale.Get(curr.Reason)))
+		}
+	}
+
+	if len(notes) == 0 {
+		return ""
+	}
+
+	return utils.Locale.Get("Also installed: %s", strings.Join(notes, ", "))
+}
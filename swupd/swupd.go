@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/clearlinux/clr-installer/args"
 	"github.com/clearlinux/clr-installer/cmd"
@@ -82,6 +83,8 @@ const (
 	OfflinePrefix = "Offline Content: "
 
 	IsoPrefix = "ISO Initrd: "
+
+	ThirdPartyPrefix = "Third-party: "
 )
 
 var (
@@ -113,8 +116,13 @@ type SoftwareUpdater struct {
 
 // Bundle maps a map name and description with the actual checkbox
 type Bundle struct {
-	Name string // Name the bundle name or id
-	Desc string // Desc is the bundle long description
+	Name     string   // Name the bundle name or id
+	Desc     string   // Desc is the bundle long description
+	Category string   // Category groups related bundles in the bundle pickers
+	Status   string   // Status is empty for active bundles, Experimental or Deprecated otherwise
+	Size     uint64   // Size is the content size in bytes of the bundle alone
+	Includes []string // Includes are the bundles always installed with this one
+	Optional []string // Optional are the bundles installed with this one unless optional bundles are skipped
 }
 
 // Message represents data parsed from a JSON message sent by a swupd command
@@ -128,11 +136,16 @@ type Message struct {
 	StepDescription string `json:"stepDescription"`
 }
 
-// Process parses the output received from swupd and process it according to its type
-func (m Message) Process(printPrefix, line string) {
-	var description string
-	const total = 100
+// output processes the JSON output of a swupd command: the progress messages
+// drive the progress bars and the errors are kept to classify a failure
+type output struct {
+	errors []string
+	status int
+}
 
+// decode parses a line of the JSON output of a swupd command, false is
+// returned for the lines which are not a message
+func (m *Message) decode(line string) bool {
 	log.Debug(line)
 
 	// the JSON output of a swupd command, is a big array of JSON objects, like this:
@@ -143,75 +156,152 @@ func (m Message) Process(printPrefix, line string) {
 	// ]
 	// since we are going to be reading line by line, we can ignore the '[' or ']'
 	if line == "[" || line == "]" {
-		return
+		return false
 	}
 
 	// also remove the "," at the end of the string if exist
 	trimmedMsg := strings.TrimSuffix(line, ",")
 
 	// decode the message assuming it is a JSON stream and ignore those that are not
-	if err := json.Unmarshal([]byte(trimmedMsg), &m); err != nil {
+	if err := json.Unmarshal([]byte(trimmedMsg), m); err != nil {
 		log.Error("error decoding JSON: %s", err)
+		return false
+	}
+
+	return true
+}
+
+// Process keeps the errors and the exit status of the command and handles the progress
+func (o *output) Process(printPrefix, line string) {
+	var m Message
+
+	if !m.decode(line) {
 		return
 	}
 
-	if m.Type == "progress" {
-		// "pretty" descriptions for steps
-		switch m.StepDescription {
-		case "get_versions":
-			description = utils.Locale.Get("Resolving OS versions")
-		case "cleanup_download_dir":
-			description = utils.Locale.Get("Cleaning up download directory")
-		case "load_manifests":
-			description = utils.Locale.Get("Downloading required manifests")
-		case "consolidate_files":
-			description = utils.Locale.Get("Resolving files that need to be installed")
-		case "download_packs":
-			description = utils.Locale.Get("Downloading required packs")
-		case "extract_packs":
-			description = utils.Locale.Get("Extracting required packs")
-		case "check_files_hash":
-			description = utils.Locale.Get("Verifying installed files")
-		case "validate_fullfiles":
-			description = utils.Locale.Get("Verifying staged files")
-		case "download_fullfiles":
-			description = utils.Locale.Get("Downloading missing files")
-		case "extract_fullfiles":
-			description = utils.Locale.Get("Extracting missing files")
-		case "add_missing_files":
-			description = utils.Locale.Get("Installing base OS and configured bundles")
-		case "run_postupdate_scripts":
-			description = utils.Locale.Get("Running post-update scripts")
-		}
+	switch m.Type {
+	case "error":
+		msg := strings.TrimSpace(m.Msg)
+		log.Warning("swupd: %s", msg)
+		o.errors = append(o.errors, msg)
+	case "warning":
+		log.Debug("swupd warning: %s", strings.TrimSpace(m.Msg))
+	case "end":
+		o.status = m.Status
+	case "progress":
+		m.progress(printPrefix)
+	}
+}
 
-		// The printPrefix string is used to separate target, offline content,
-		// and ISO installations.
-		description = printPrefix + description
+// stepPhase places a swupd step in the overall installation progress as the
+// range of its installation phase it covers
+type stepPhase struct {
+	phase progress.Phase
+	from  float64
+	to    float64
+}
 
-		if m.StepCompletion == -1 {
-			if prgDesc != m.StepDescription {
-				// create a new instance of the indeterminate progress bar with the correct description
-				log.Debug("%s: Setting indeterminate progress for task %s", printPrefix, m.StepDescription)
-				prg = progress.NewLoop(description)
-				prgDesc = m.StepDescription
-			}
-			return
-		}
+// stepPhases maps the swupd steps of the target installation to the overall progress
+var stepPhases = map[string]stepPhase{
+	"get_versions":           {progress.PhaseDownload, 0, 0.05},
+	"cleanup_download_dir":   {progress.PhaseDownload, 0.05, 0.1},
+	"load_manifests":         {progress.PhaseDownload, 0.1, 0.15},
+	"consolidate_files":      {progress.PhaseDownload, 0.15, 0.2},
+	"download_packs":         {progress.PhaseDownload, 0.2, 0.7},
+	"download_fullfiles":     {progress.PhaseDownload, 0.7, 1},
+	"extract_packs":          {progress.PhaseExtract, 0, 0.4},
+	"check_files_hash":       {progress.PhaseExtract, 0.4, 0.5},
+	"validate_fullfiles":     {progress.PhaseExtract, 0.5, 0.55},
+	"extract_fullfiles":      {progress.PhaseExtract, 0.55, 0.7},
+	"add_missing_files":      {progress.PhaseExtract, 0.7, 0.95},
+	"run_postupdate_scripts": {progress.PhaseExtract, 0.95, 1},
+}
+
+// overallProgress reports the step completion of the target installation to
+// the overall installation progress
+func (m Message) overallProgress(printPrefix string) {
+	step, ok := stepPhases[m.StepDescription]
+	if !ok || printPrefix != TargetPrefix {
+		return
+	}
+
+	fraction := step.from
+	if m.StepCompletion > 0 {
+		fraction += (step.to - step.from) * float64(m.StepCompletion) / 100
+	}
+
+	progress.PhaseProgress(step.phase, fraction)
+}
+
+// Process parses the output received from swupd and process it according to its type
+func (m Message) Process(printPrefix, line string) {
+	if m.decode(line) && m.Type == "progress" {
+		m.progress(printPrefix)
+	}
+}
+
+// progress reports the progress message to the progress bars
+func (m Message) progress(printPrefix string) {
+	var description string
+	const total = 100
 
+	// "pretty" descriptions for steps
+	switch m.StepDescription {
+	case "get_versions":
+		description = utils.Locale.Get("Resolving OS versions")
+	case "cleanup_download_dir":
+		description = utils.Locale.Get("Cleaning up download directory")
+	case "load_manifests":
+		description = utils.Locale.Get("Downloading required manifests")
+	case "consolidate_files":
+		description = utils.Locale.Get("Resolving files that need to be installed")
+	case "download_packs":
+		description = utils.Locale.Get("Downloading required packs")
+	case "extract_packs":
+		description = utils.Locale.Get("Extracting required packs")
+	case "check_files_hash":
+		description = utils.Locale.Get("Verifying installed files")
+	case "validate_fullfiles":
+		description = utils.Locale.Get("Verifying staged files")
+	case "download_fullfiles":
+		description = utils.Locale.Get("Downloading missing files")
+	case "extract_fullfiles":
+		description = utils.Locale.Get("Extracting missing files")
+	case "add_missing_files":
+		description = utils.Locale.Get("Installing base OS and configured bundles")
+	case "run_postupdate_scripts":
+		description = utils.Locale.Get("Running post-update scripts")
+	}
+
+	// The printPrefix string is used to separate target, offline content,
+	// and ISO installations.
+	description = printPrefix + description
+
+	m.overallProgress(printPrefix)
+
+	if m.StepCompletion == -1 {
 		if prgDesc != m.StepDescription {
-			// create a new instance of the step progress bar with the correct description
-			log.Debug("%s: Setting progress for task %s", printPrefix, m.StepDescription)
-			prg = progress.MultiStep(total, description)
+			// create a new instance of the indeterminate progress bar with the correct description
+			log.Debug("%s: Setting indeterminate progress for task %s", printPrefix, m.StepDescription)
+			prg = progress.NewLoop(description)
 			prgDesc = m.StepDescription
 		}
+		return
+	}
 
-		// report current % of completion
-		prg.Partial(m.StepCompletion)
-		if m.StepCompletion == total {
-			log.Debug("%s: Task %s completed", printPrefix, m.StepDescription)
-			prg.Success()
-			prgDesc = ""
-		}
+	if prgDesc != m.StepDescription {
+		// create a new instance of the step progress bar with the correct description
+		log.Debug("%s: Setting progress for task %s", printPrefix, m.StepDescription)
+		prg = progress.MultiStep(total, description)
+		prgDesc = m.StepDescription
+	}
+
+	// report current % of completion
+	prg.Partial(m.StepCompletion)
+	if m.StepCompletion == total {
+		log.Debug("%s: Task %s completed", printPrefix, m.StepDescription)
+		prg.Success()
+		prgDesc = ""
 	}
 }
 
@@ -311,6 +401,14 @@ func (s *SoftwareUpdater) GetStateDir() string {
 	return s.stateDir
 }
 
+// UseStateDirCache sets the --statedir-cache of the swupd operations unless the
+// offline content is already used
+func (s *SoftwareUpdater) UseStateDirCache(dir string) {
+	if s.stateDirCache == "" {
+		s.stateDirCache = dir
+	}
+}
+
 // OSInstall runs "swupd os-install" operation with a bundle list
 func (s *SoftwareUpdater) OSInstall(version, printPrefix string, bundles []string) error {
 	args := []string{
@@ -355,11 +453,8 @@ func (s *SoftwareUpdater) OSInstall(version, printPrefix string, bundles []strin
 		args = append(args, "-B", strings.Join(allBundles, ","))
 	}
 
-	m := Message{}
-	err := cmd.RunAndProcessOutput(printPrefix, m, args...)
-	if err != nil {
-		err = fmt.Errorf("The swupd command \"%s\" failed with %s", strings.Join(args, " "), err)
-		return errors.Wrap(err)
+	if err := runWithRetries(printPrefix, version, args); err != nil {
+		return err
 	}
 
 	if s.mirrorURL != "" {
@@ -375,8 +470,7 @@ func (s *SoftwareUpdater) OSInstall(version, printPrefix string, bundles []strin
 			args = append(args, "--allow-insecure-http")
 		}
 
-		err = cmd.RunAndLog(args...)
-		if err != nil {
+		if err := cmd.RunAndLog(args...); err != nil {
 			return errors.Wrap(err)
 		}
 	}
@@ -384,6 +478,33 @@ func (s *SoftwareUpdater) OSInstall(version, printPrefix string, bundles []strin
 	return nil
 }
 
+// runWithRetries runs a swupd command with JSON output reporting its progress,
+// the command is retried after a delay while it fails due to a transient failure
+// i.e.: a network hiccup; the failure is returned as a classified Error
+func runWithRetries(printPrefix string, version string, args []string) error {
+	for attempt := 0; ; attempt++ {
+		out := &output{}
+
+		err := runSwupd(printPrefix, out, args...)
+		if err == nil {
+			return nil
+		}
+
+		serr := classifyFailure(args, version, out, err)
+		if !serr.Transient() || attempt >= len(retryDelays) {
+			return serr
+		}
+
+		delay := retryDelays[attempt]
+		log.Warning("Retrying in %v (%d of %d): %v", delay, attempt+1, len(retryDelays), serr)
+
+		retry := progress.NewLoop(printPrefix+utils.Locale.Get("Could not reach the content server, retrying in %d seconds"),
+			int(delay.Seconds()))
+		time.Sleep(delay)
+		retry.Success()
+	}
+}
+
 // DownloadBundles downloads the bundle list to the OfflineContentDir within the installer image
 func (s SoftwareUpdater) DownloadBundles(version string, bundles []string) error {
 	var err error
@@ -488,7 +609,7 @@ func setMirror(swupdArgs []string, t string) (string, error) {
 // SetHostMirror executes the "swupd mirror" to set the Host's mirror
 func SetHostMirror(url string, allowInsecureHTTP bool) (string, error) {
 	if urlErr := network.CheckURL(url); urlErr != nil {
-		if strings.Contains(urlErr.Error(), "60") {
+		if strings.Contains(urlErr.Error(), "x509:") {
 			return "", fmt.Errorf(utils.Locale.Get("SSL certificate problem"))
 		}
 		return "", fmt.Errorf(utils.Locale.Get("Server not responding"))
@@ -614,8 +735,8 @@ func parseSwupdMirror(data []byte) (string, error) {
 	return string(match[1]), nil
 }
 
-// LoadBundleList loads the bundle definitions
-func LoadBundleList(model *model.SystemInstall) ([]*Bundle, error) {
+// loadBundleListFile loads the bundle definitions of the bundle list file
+func loadBundleListFile() ([]*Bundle, error) {
 	path, err := conf.LookupBundleListFile()
 	if err != nil {
 		return nil, err
@@ -635,13 +756,61 @@ func LoadBundleList(model *model.SystemInstall) ([]*Bundle, error) {
 		return nil, errors.Wrap(err)
 	}
 
-	// Filter out the bundles which will always be installed
+	for _, bundle := range root.Bundles {
+		if bundle.Category == "" {
+			bundle.Category = bundleCategory(bundle.Name)
+		}
+	}
+
+	return root.Bundles, nil
+}
+
+// LoadBundleList loads the bundle catalog of the selected version with the
+// descriptions of the version, the bundle list file descriptions are preferred
+// since they are translated and the file alone is used when the catalog can not
+// be built. The catalog is downloaded unless it is cached, the bundle pages call
+// it in the background
+func LoadBundleList(model *model.SystemInstall, options args.Args) ([]*Bundle, error) {
+	listed, err := loadBundleListFile()
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := listed
+
+	if catalog, err := LoadCatalog(model, options); err != nil {
+		log.Warning("Could not load the bundle catalog, using %s: %v", conf.BundleListFile, err)
+	} else {
+		if err = catalog.loadDescriptions(); err != nil {
+			log.Warning("Could not read the bundle descriptions of the selected version: %v", err)
+		}
+		bundles = catalog.Bundles()
+	}
+
+	descs := map[string]*Bundle{}
+	for _, bundle := range listed {
+		descs[bundle.Name] = bundle
+	}
+
+	// Filter out the bundles which will always be installed and the
+	// deprecated ones not already selected
 	filteredBundles := []*Bundle{}
 
-	for _, bundle := range root.Bundles {
-		if !model.ContainsBundle(bundle.Name) {
-			filteredBundles = append(filteredBundles, bundle)
+	for _, bundle := range bundles {
+		if model.ContainsBundle(bundle.Name) {
+			continue
+		}
+
+		if bundle.Status == BundleStatusDeprecated && !model.ContainsUserBundle(bundle.Name) {
+			continue
 		}
+
+		if curr, ok := descs[bundle.Name]; ok {
+			bundle.Desc = curr.Desc
+			bundle.Category = curr.Category
+		}
+
+		filteredBundles = append(filteredBundles, bundle)
 	}
 
 	return filteredBundles, nil
@@ -725,3 +894,35 @@ func OfflineIsUsable(version string, options args.Args) bool {
 
 	return false
 }
+
+// isLocalURL returns true if the URL refers to local content
+func isLocalURL(url string) bool {
+	return strings.HasPrefix(url, "file://")
+}
+
+// ContentIsLocal returns true if all the content of the installation is available
+// without network, either as offline content or from local mirrors, so the
+// network connectivity is not required.
+func ContentIsLocal(version string, options args.Args, md *model.SystemInstall) bool {
+	for _, curr := range md.ThirdParty {
+		if !isLocalURL(curr.URL) {
+			return false
+		}
+	}
+
+	if OfflineIsUsable(version, options) {
+		return true
+	}
+
+	contentURL := options.SwupdContentURL
+	if contentURL == "" {
+		contentURL = md.SwupdMirror
+	}
+
+	versionURL := options.SwupdVersionURL
+	if versionURL == "" {
+		versionURL = md.SwupdMirror
+	}
+
+	return isLocalURL(contentURL) && isLocalURL(versionURL)
+}
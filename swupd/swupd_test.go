@@ -5,13 +5,27 @@
 package swupd
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/clearlinux/clr-installer/args"
+	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/errors"
 	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/network"
 	"github.com/clearlinux/clr-installer/progress"
 	"github.com/clearlinux/clr-installer/utils"
 )
@@ -143,6 +157,8 @@ func (p *MockProgress) LoopWaitDuration() time.Duration {
 	return time.Second
 }
 
+func (p *MockProgress) Overall(percent int, eta time.Duration) {}
+
 func TestProcess(t *testing.T) {
 	var msg Message
 	var mp MockProgress
@@ -239,3 +255,1074 @@ func TestOffline(t *testing.T) {
 		t.Fatalf("Offline Content should be usable")
 	}
 }
+
+func TestContentIsLocal(t *testing.T) {
+	options := args.Args{}
+	md := &model.SystemInstall{SwupdMirror: "file:///srv/clear-mirror"}
+
+	if IsOfflineContent() {
+		t.Skip("Offline content is present, skipping test")
+	}
+
+	if !ContentIsLocal("latest", options, md) {
+		t.Fatalf("A local mirror should not require the network")
+	}
+
+	options.SwupdContentURL = "https://cdn.download.clearlinux.org/update/"
+	if ContentIsLocal("latest", options, md) {
+		t.Fatalf("A remote content url should require the network")
+	}
+
+	options.SwupdContentURL = ""
+	md.ThirdParty = []*model.ThirdPartyRepo{{Name: "extra", URL: "https://repo.example.com/update"}}
+	if ContentIsLocal("latest", options, md) {
+		t.Fatalf("A remote third-party repository should require the network")
+	}
+
+	md.ThirdParty[0].URL = "file:///srv/extra"
+	if !ContentIsLocal("latest", options, md) {
+		t.Fatalf("A local third-party repository should not require the network")
+	}
+
+	md.SwupdMirror = ""
+	if ContentIsLocal("latest", options, md) {
+		t.Fatalf("The default content url should require the network")
+	}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	testsDir := os.Getenv("TESTS_DIR")
+	catalogDir := filepath.Join(testsDir, "swupd-catalog")
+
+	bundleHeaderDir = filepath.Join(catalogDir, "allbundles")
+	defer func() { bundleHeaderDir = "/usr/share/clear/allbundles" }()
+
+	catalog, err := loadCatalog(&dirSource{dir: catalogDir}, "33000")
+	if err != nil {
+		t.Fatalf("Could not load the bundle catalog: %v", err)
+	}
+
+	bundles := catalog.Bundles()
+
+	names := []string{}
+	for _, curr := range bundles {
+		names = append(names, curr.Name)
+	}
+
+	if bundles[2].Size != 0 {
+		t.Fatalf("The manifests should only be read when needed")
+	}
+
+	if err = catalog.loadDescriptions(); err != nil {
+		t.Fatalf("Could not read the bundle descriptions: %v", err)
+	}
+
+	if err = catalog.load(names); err != nil {
+		t.Fatalf("Could not read the bundle manifests: %v", err)
+	}
+
+	// the bundles handed out before are copies, not updated by the catalog
+	if bundles[2].Size != 0 {
+		t.Fatalf("The catalog should not change the bundles it handed out")
+	}
+	bundles = catalog.Bundles()
+
+	expected := "editors kata-containers os-core python3-basic"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("Expected bundles %q, got %q", expected, strings.Join(names, " "))
+	}
+
+	editors, kata, core, python := bundles[0], bundles[1], bundles[2], bundles[3]
+
+	if editors.Desc != "Popular text editors (terminal-based)" || editors.Status != "" {
+		t.Fatalf("Wrong editors description or status: %+v", *editors)
+	}
+
+	if kata.Status != BundleStatusExperimental || kata.Category != "Containers and Cloud" {
+		t.Fatalf("Wrong kata-containers status or category: %+v", *kata)
+	}
+
+	if strings.Join(kata.Includes, " ") != "os-core python3-basic" || strings.Join(kata.Optional, " ") != "editors" {
+		t.Fatalf("Wrong kata-containers includes: %+v", *kata)
+	}
+
+	if core.Size != 104857600 {
+		t.Fatalf("Expected os-core size 104857600, got %d", core.Size)
+	}
+
+	if python.Status != BundleStatusDeprecated || python.Category != "Languages" {
+		t.Fatalf("Wrong python3-basic status or category: %+v", *python)
+	}
+
+	if _, err = loadCatalog(&dirSource{dir: catalogDir}, "1000"); err == nil {
+		t.Fatalf("Loading a missing version should fail")
+	}
+}
+
+func TestLoadCatalogURL(t *testing.T) {
+	testsDir := os.Getenv("TESTS_DIR")
+	url := "https://example.com"
+
+	fetchURL = func(u string) (string, error) {
+		src := strings.Replace(u, url+"/update", filepath.Join(testsDir, "swupd-catalog", "manifest"), 1)
+		if u == url+"/latest" {
+			src = filepath.Join(testsDir, "swupd-catalog", "latest")
+		}
+
+		out, err := ioutil.TempFile("", "catalog-test-")
+		if err != nil {
+			return "", err
+		}
+		_ = out.Close()
+
+		return out.Name(), utils.CopyFile(src, out.Name())
+	}
+	defer func() { fetchURL = network.FetchRemoteConfigFile }()
+
+	cacheDir, err := ioutil.TempDir("", "catalog-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(cacheDir) }()
+
+	source := &urlSource{url: url, cacheDir: cacheDir}
+
+	version, err := source.latestVersion()
+	if err != nil || version != "33000" {
+		t.Fatalf("Expected latest version 33000, got %q: %v", version, err)
+	}
+
+	catalog, err := loadCatalog(source, version)
+	if err != nil {
+		t.Fatalf("Could not load the bundle catalog: %v", err)
+	}
+
+	if len(catalog.Bundles()) != 4 {
+		t.Fatalf("Expected 4 bundles, got %d", len(catalog.Bundles()))
+	}
+
+	// the manifests are cached by content URL
+	cached := filepath.Join(cacheDir, sourceKey(url))
+
+	if _, err = os.Stat(filepath.Join(cached, "33000", "Manifest.editors")); err == nil {
+		t.Fatalf("Only the manifest of manifests should be downloaded to list the bundles")
+	}
+
+	closure, unknown, err := catalog.closure([]string{"editors", "vim"}, false)
+	if err != nil {
+		t.Fatalf("Could not resolve the editors includes: %v", err)
+	}
+
+	if len(closure) != 2 || !closure["os-core"] || strings.Join(unknown, " ") != "vim" {
+		t.Fatalf("Expected the editors closure with os-core and vim unknown, got %v %v", closure, unknown)
+	}
+
+	if _, err = os.Stat(filepath.Join(cached, "32990", "Manifest.os-core")); err != nil {
+		t.Fatalf("Manifest was not cached: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(cached, "33000", "Manifest.kata-containers")); err == nil {
+		t.Fatalf("Only the manifests of the closure should be downloaded")
+	}
+
+	entries := catalog.entries
+
+	// a cached manifest not matching the manifest of manifests is downloaded again
+	editorsPath := filepath.Join(cached, "33000", "Manifest.editors")
+	if err = ioutil.WriteFile(editorsPath, []byte("MANIFEST\t30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := source.open("33000", "editors", entries["editors"].hash)
+	if err != nil {
+		t.Fatalf("The corrupted cached manifest should be downloaded again: %v", err)
+	}
+	_ = fp.Close()
+
+	if err = checkManifest(editorsPath, entries["editors"].hash); err != nil {
+		t.Fatalf("The cached manifest was not replaced: %v", err)
+	}
+
+	// a downloaded manifest not matching the manifest of manifests is refused
+	if _, err = source.open("33000", "python3-basic", entries["editors"].hash); err == nil {
+		t.Fatalf("A manifest not matching its hash should be refused")
+	}
+
+	if _, err = os.Stat(filepath.Join(cached, "33000", "Manifest.python3-basic")); err == nil {
+		t.Fatalf("A manifest not matching its hash should not be cached")
+	}
+
+	// another content URL does not share the cache
+	other := &urlSource{url: "https://mirror.example.com", cacheDir: cacheDir}
+	if _, err = other.open("33000", "editors", entries["editors"].hash); err == nil {
+		t.Fatalf("The manifests of another content URL should not be read from the cache")
+	}
+
+	// the descriptions come from the bundle definitions published in os-core
+	if err = catalog.loadDescriptions(); err != nil {
+		t.Fatalf("Could not read the bundle descriptions: %v", err)
+	}
+
+	if editors := catalog.Bundles()[0]; editors.Desc != "Text editors of version 32990" {
+		t.Fatalf("Expected the description of the selected version, got %q", editors.Desc)
+	}
+}
+
+func TestBundleMatches(t *testing.T) {
+	bundle := &Bundle{Name: "editors", Desc: "Popular text editors (terminal-based)", Category: "Other"}
+
+	tests := []struct {
+		query    string
+		category string
+		matches  bool
+	}{
+		{"", "", true},
+		{"", BundleCategoryAll, true},
+		{"EDIT", "Other", true},
+		{"text terminal", BundleCategoryAll, true},
+		{"text desktop", BundleCategoryAll, false},
+		{"", "Desktop", false},
+	}
+
+	for _, curr := range tests {
+		if bundle.Matches(curr.query, curr.category) != curr.matches {
+			t.Fatalf("Matching %q in %q should be %v", curr.query, curr.category, curr.matches)
+		}
+	}
+
+	if bundleCategory("kde-desktop") != "Desktop" || bundleCategory("zsh") != "Other" {
+		t.Fatalf("Wrong bundle category")
+	}
+}
+
+func TestEstimateFromCatalog(t *testing.T) {
+	catalog := newCatalog([]*Bundle{
+		{Name: "os-core", Size: 100},
+		{Name: "editors", Size: 50, Includes: []string{"os-core"}},
+		{Name: "python3-basic", Size: 150, Includes: []string{"os-core"}},
+		{Name: "kata-containers", Size: 200, Includes: []string{"os-core", "python3-basic"},
+			Optional: []string{"editors"}},
+	})
+
+	estimate, err := estimateFromCatalog(catalog, []string{"os-core", "kata-containers", "missing"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(estimate.Bundles, " ") != "editors kata-containers os-core python3-basic" {
+		t.Fatalf("Wrong bundle closure: %v", estimate.Bundles)
+	}
+
+	if strings.Join(estimate.Unknown, " ") != "missing" {
+		t.Fatalf("Expected the missing bundle to be unknown, got %v", estimate.Unknown)
+	}
+
+	if estimate.InstalledSize != 500 || estimate.StateSize != 200 || estimate.DownloadSize != 200 {
+		t.Fatalf("Wrong estimate sizes: %+v", *estimate)
+	}
+
+	if estimate.RequiredSize() != 770 {
+		t.Fatalf("Expected a required size of 770, got %d", estimate.RequiredSize())
+	}
+
+	if estimate, err = estimateFromCatalog(catalog, []string{"kata-containers"}, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.InstalledSize != 450 || estimate.DownloadSize != 0 {
+		t.Fatalf("Wrong offline estimate without optional bundles: %+v", *estimate)
+	}
+}
+
+func TestEstimateInBackground(t *testing.T) {
+	md := &model.SystemInstall{Bundles: []string{"editors"}}
+
+	// the estimate reads the catalog of the request, built here beforehand
+	request := newEstimateRequest(md, args.Args{})
+	key := fmt.Sprintf("%s@%s", request.source, request.version)
+
+	loadedCatalogsMutex.Lock()
+	loadedCatalogs[key] = newCatalog([]*Bundle{
+		{Name: "os-core", Size: 100},
+		{Name: "editors", Size: 50, Includes: []string{"os-core"}},
+	})
+	loadedCatalogsMutex.Unlock()
+
+	defer func() {
+		loadedCatalogsMutex.Lock()
+		delete(loadedCatalogs, key)
+		loadedCatalogsMutex.Unlock()
+	}()
+
+	results := make(chan *InstallEstimate, 1)
+	EstimateInBackground(md, args.Args{}, func(estimate *InstallEstimate) {
+		results <- estimate
+	})
+
+	// the model changes after the start are not part of the estimate
+	md.Bundles = nil
+
+	select {
+	case estimate := <-results:
+		if estimate == nil || estimate.InstalledSize != 150 {
+			t.Fatalf("Expected the estimate of editors and os-core, got %+v", estimate)
+		}
+
+		SetEstimatedSize(md, estimate)
+		if md.MediaOpts.EstimatedSize != estimate.RequiredSize() {
+			t.Fatalf("Expected the estimated size %d, got %d", estimate.RequiredSize(),
+				md.MediaOpts.EstimatedSize)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The background estimate did not complete")
+	}
+
+	SetEstimatedSize(md, nil)
+	if md.MediaOpts.EstimatedSize != 0 {
+		t.Fatalf("Expected the estimated size to be cleared, got %d", md.MediaOpts.EstimatedSize)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	catalog := newCatalog([]*Bundle{
+		{Name: "os-core"},
+		{Name: "editors", Includes: []string{"os-core"}},
+		{Name: "python3-basic", Includes: []string{"os-core"}},
+		{Name: "kata-containers", Includes: []string{"os-core", "python3-basic"}, Optional: []string{"editors"}},
+	})
+
+	resolver := NewResolver(catalog, false)
+
+	if !resolver.Contains("editors") || resolver.Contains("editor") {
+		t.Fatalf("Wrong bundle lookup")
+	}
+
+	if also := strings.Join(resolver.AlsoInstalls("kata-containers"), " "); also != "editors os-core python3-basic" {
+		t.Fatalf("Wrong kata-containers includes: %q", also)
+	}
+
+	by := resolver.IncludedBy("python3-basic", []string{"python3-basic", "kata-containers", "editors"})
+	if strings.Join(by, " ") != "kata-containers" {
+		t.Fatalf("Expected python3-basic to be included by kata-containers, got %v", by)
+	}
+
+	if suggestion := resolver.Suggest("editor"); suggestion != "editors" {
+		t.Fatalf("Expected the suggestion editors, got %q", suggestion)
+	}
+
+	if suggestion := resolver.Suggest("vim"); suggestion != "" {
+		t.Fatalf("Expected no suggestion, got %q", suggestion)
+	}
+
+	md := &model.SystemInstall{}
+	note := resolver.SelectionNote(md, "editors", false, []string{"kata-containers"})
+	if !strings.Contains(note, "included by kata-containers") {
+		t.Fatalf("Expected a warning about kata-containers, got %q", note)
+	}
+
+	if note = resolver.SelectionNote(md, "editors", false, []string{}); note != "" {
+		t.Fatalf("Expected no note, got %q", note)
+	}
+
+	resolver = NewResolver(catalog, true)
+	if also := strings.Join(resolver.AlsoInstalls("kata-containers"), " "); also != "os-core python3-basic" {
+		t.Fatalf("Wrong kata-containers includes without optional bundles: %q", also)
+	}
+}
+
+func writeMirrorTestFile(t *testing.T, path string, content string) {
+	if err := utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeMirrorTestTar archives content as name, gzip compressed if compress is set
+func writeMirrorTestTar(t *testing.T, path string, name string, content string, compress bool) {
+	var buf bytes.Buffer
+
+	var w io.Writer = &buf
+	zw := gzip.NewWriter(&buf)
+	if compress {
+		w = zw
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeMirrorTestFile(t, path, buf.String())
+}
+
+// writeMirrorTestFullfile publishes a file of version in the content dir and returns its hash
+func writeMirrorTestFullfile(t *testing.T, dir string, version string, content string, compress bool) string {
+	hash, err := entryHash(&tar.Header{Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg},
+		strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeMirrorTestTar(t, filepath.Join(dir, "update", version, "files", hash+".tar"), hash, content, compress)
+
+	return hash
+}
+
+// writeMirrorTestManifest publishes a manifest and returns its hash
+func writeMirrorTestManifest(t *testing.T, path string, content string) string {
+	writeMirrorTestFile(t, path, content)
+
+	hash, err := manifestHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+// writeMirrorTestCert creates a self signed certificate and its key
+func writeMirrorTestCert(t *testing.T, dir string, name string) (string, string) {
+	cert := filepath.Join(dir, name+".pem")
+	key := filepath.Join(dir, name+".key")
+
+	var out bytes.Buffer
+	if err := cmd.Run(&out, "openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-keyout", key,
+		"-out", cert, "-subj", "/CN="+name, "-days", "1"); err != nil {
+		t.Fatalf("Could not create a certificate: %v %s", err, out.String())
+	}
+
+	return cert, key
+}
+
+func TestBuildMirror(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not available, skipping test")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "clr-installer-mirror-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	content := filepath.Join(tmpDir, "content")
+	dir := filepath.Join(tmpDir, "mirror")
+
+	cert, key := writeMirrorTestCert(t, tmpDir, "swupd")
+	otherCert, _ := writeMirrorTestCert(t, tmpDir, "other")
+
+	coreHash := writeMirrorTestFullfile(t, content, "90", strings.Repeat("a", 1024), false)
+	editorsContent := strings.Repeat("b", 1024)
+	editorsHash := writeMirrorTestFullfile(t, content, "100", editorsContent, true)
+	writeMirrorTestTar(t, filepath.Join(content, "update/100/pack-editors-from-0.tar"), "staged/"+editorsHash,
+		editorsContent, false)
+
+	coreManifest := writeMirrorTestManifest(t, filepath.Join(content, "update/90/Manifest.os-core"),
+		"MANIFEST\t30\nversion:\t90\nfilecount:\t2\n\n"+
+			"F...\t"+coreHash+"\t90\t/usr/bin/sh\n"+
+			"Fd..\t"+zeroHash+"\t80\t/usr/bin/removed\n")
+	editorsManifest := writeMirrorTestManifest(t, filepath.Join(content, "update/100/Manifest.editors"),
+		"MANIFEST\t30\nversion:\t100\nfilecount:\t1\nincludes:\tos-core\n\n"+
+			"F...\t"+editorsHash+"\t100\t/usr/bin/vim\n")
+
+	writeMirrorTestFile(t, filepath.Join(content, "latest"), "100\n")
+	mom := filepath.Join(content, "update/100/Manifest.MoM")
+	writeMirrorTestFile(t, mom,
+		"MANIFEST\t30\nversion:\t100\nfilecount:\t2\n\n"+
+			"M...\t"+coreManifest+"\t90\tos-core\n"+
+			"M...\t"+editorsManifest+"\t100\teditors\n")
+
+	var out bytes.Buffer
+	if err = cmd.Run(&out, "openssl", "smime", "-sign", "-binary", "-noattr", "-in", mom, "-signer", cert,
+		"-inkey", key, "-outform", "der", "-out", mom+".sig"); err != nil {
+		t.Fatalf("Could not sign the MoM: %v %s", err, out.String())
+	}
+
+	url := "file://" + content
+
+	if _, err = BuildMirror(dir, url, "latest", []string{"vim"}, false, cert); err == nil {
+		t.Fatal("Mirroring an unknown bundle should fail")
+	}
+
+	if _, err = BuildMirror(dir, url, "latest", []string{"editors"}, false, otherCert); err == nil {
+		t.Fatal("Mirroring content signed with another certificate should fail")
+	}
+
+	version, err := BuildMirror(dir, url, "latest", []string{"editors"}, false, cert)
+	if err != nil {
+		t.Fatalf("Could not build the mirror: %v", err)
+	}
+
+	if version != "100" {
+		t.Fatalf("Expected the mirror of the latest version 100, got %s", version)
+	}
+
+	for _, curr := range []string{
+		"update/100/Manifest.MoM.sig",
+		"update/90/Manifest.os-core",
+		"update/100/pack-editors-from-0.tar",
+		"update/90/files/" + coreHash + ".tar",
+		"update/100/files/" + editorsHash + ".tar",
+		"version/format30/latest",
+	} {
+		if _, err = os.Stat(filepath.Join(dir, curr)); err != nil {
+			t.Fatalf("%s should be part of the mirror: %v", curr, err)
+		}
+	}
+
+	if problems, err := VerifyMirror(dir, cert); err != nil || len(problems) > 0 {
+		t.Fatalf("The mirror should be complete: %v %v", problems, err)
+	}
+
+	if problems, err := VerifyMirror(dir, otherCert); err != nil || len(problems) != 1 {
+		t.Fatalf("The signature check against another certificate should fail: %v %v", problems, err)
+	}
+
+	// emulate an incomplete copy and an interrupted download
+	editorsFile := filepath.Join(dir, "update/100/files", editorsHash+".tar")
+	if err = os.Remove(filepath.Join(dir, "update/90/files", coreHash+".tar")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(editorsFile, editorsFile+partialSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(editorsFile+partialSuffix, 512); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := VerifyMirror(dir, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(problems) != 3 {
+		t.Fatalf("Expected 2 missing files and an incomplete download, got: %v", problems)
+	}
+
+	if _, err = BuildMirror(dir, url, "100", []string{"editors"}, false, cert); err != nil {
+		t.Fatalf("Could not resume the mirror: %v", err)
+	}
+
+	if problems, err := VerifyMirror(dir, cert); err != nil || len(problems) > 0 {
+		t.Fatalf("The resumed mirror should be complete: %v %v", problems, err)
+	}
+
+	// an interrupted download which is not a prefix of the file is started over
+	if err = os.Remove(editorsFile); err != nil {
+		t.Fatal(err)
+	}
+	writeMirrorTestFile(t, editorsFile+partialSuffix, strings.Repeat("x", 512))
+
+	if _, err = BuildMirror(dir, url, "100", []string{"editors"}, false, cert); err != nil {
+		t.Fatalf("Could not download the corrupted file again: %v", err)
+	}
+
+	if problems, err := VerifyMirror(dir, cert); err != nil || len(problems) > 0 {
+		t.Fatalf("The mirror should be complete after the new download: %v %v", problems, err)
+	}
+
+	// a file replaced along with its checksum in the index is not trusted
+	coreFile := filepath.Join(dir, "update/90/files", coreHash+".tar")
+	writeMirrorTestTar(t, coreFile, coreHash, strings.Repeat("c", 1024), false)
+
+	sum, err := fileSHA256(coreFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := readMirrorIndex(filepath.Join(dir, MirrorIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexContent := ""
+	for rel, curr := range index {
+		if strings.HasSuffix(rel, coreHash+".tar") {
+			curr = sum
+		}
+		indexContent += curr + "  " + rel + "\n"
+	}
+	writeMirrorTestFile(t, filepath.Join(dir, MirrorIndex), indexContent)
+
+	problems, err = VerifyMirror(dir, cert)
+	if err != nil || len(problems) != 1 || !strings.Contains(problems[0], coreHash) {
+		t.Fatalf("The replaced file should fail the verification: %v %v", problems, err)
+	}
+
+	if _, err = BuildMirror(dir, url, "100", []string{"editors"}, false, cert); err != nil {
+		t.Fatalf("Could not repair the mirror: %v", err)
+	}
+
+	if problems, err := VerifyMirror(dir, cert); err != nil || len(problems) > 0 {
+		t.Fatalf("The repaired mirror should be complete: %v %v", problems, err)
+	}
+
+	// the content server publishing a file not matching its manifest
+	if err = os.Remove(coreFile); err != nil {
+		t.Fatal(err)
+	}
+	writeMirrorTestTar(t, filepath.Join(content, "update/90/files", coreHash+".tar"), coreHash,
+		strings.Repeat("c", 1024), false)
+
+	if _, err = BuildMirror(dir, url, "100", []string{"editors"}, false, cert); err == nil {
+		t.Fatal("A file not matching its hash should not be mirrored")
+	}
+}
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		status int
+		errors []string
+		kind   FailureKind
+		bundle string
+	}{
+		{19, nil, FailureNetwork, ""},
+		{6, []string{"Failed to retrieve 99999 MoM manifest"}, FailureVersionNotFound, ""},
+		{6, []string{"Curl: Couldn't resolve host name", "Failed to retrieve 33000 MoM manifest"}, FailureNetwork, ""},
+		{3, []string{"Bundle \"vim2\" is invalid, skipping it..."}, FailureBundleNotFound, "vim2"},
+		{8, nil, FailureSignature, ""},
+		{14, []string{"Signature check failed"}, FailureSignature, ""},
+		{14, []string{"There is not enough disk space to complete the install"}, FailureDiskFull, ""},
+		{14, []string{"Unexpected condition"}, FailureUnknown, ""},
+	}
+
+	for _, curr := range tests {
+		out := &output{errors: curr.errors, status: curr.status}
+		serr := classifyFailure([]string{"swupd", "os-install"}, "33000", out, nil)
+
+		if serr.Kind != curr.kind {
+			t.Fatalf("Status %d %v: expected failure kind %d, got %d", curr.status, curr.errors, curr.kind, serr.Kind)
+		}
+
+		if serr.Bundle != curr.bundle {
+			t.Fatalf("Expected missing bundle %q, got %q", curr.bundle, serr.Bundle)
+		}
+
+		if (curr.kind == FailureUnknown) != (serr.Hint() == "") {
+			t.Fatalf("Unexpected hint for failure kind %d: %q", serr.Kind, serr.Hint())
+		}
+
+		if serr.Transient() != (curr.kind == FailureNetwork) {
+			t.Fatalf("Only network failures are transient, got %v for %d", serr.Transient(), serr.Kind)
+		}
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	var mp MockProgress
+	progress.Set(&mp)
+
+	savedRun := runSwupd
+	savedDelays := retryDelays
+	defer func() {
+		runSwupd = savedRun
+		retryDelays = savedDelays
+	}()
+
+	retryDelays = []time.Duration{0, 0}
+
+	runs := 0
+	statuses := []int{}
+
+	runSwupd = func(printPrefix string, out cmd.Output, args ...string) error {
+		status := statuses[runs]
+		runs++
+
+		out.Process(printPrefix, "[")
+		out.Process(printPrefix, `{ "type" : "start", "section" : "os-install" },`)
+		if status == 0 {
+			out.Process(printPrefix, `{ "type" : "end", "section" : "os-install", "status" : 0 }`)
+			out.Process(printPrefix, "]")
+			return nil
+		}
+
+		out.Process(printPrefix, `{ "type" : "error", "msg" : "Error: Network issue, unable to download manifest" },`)
+		out.Process(printPrefix, fmt.Sprintf(`{ "type" : "end", "section" : "os-install", "status" : %d }`, status))
+		out.Process(printPrefix, "]")
+
+		return errors.Errorf("exit status %d", status)
+	}
+
+	statuses = []int{19, 19, 0}
+	if err := runWithRetries("", "33000", []string{"swupd", "os-install"}); err != nil || runs != 3 {
+		t.Fatalf("The transient failures should be retried: %d runs, %v", runs, err)
+	}
+
+	runs = 0
+	statuses = []int{19, 19, 19}
+	err := runWithRetries("", "33000", []string{"swupd", "os-install"})
+	if !IsTransientError(err) || runs != 3 {
+		t.Fatalf("Expected a network failure after 3 runs, got %d runs: %v", runs, err)
+	}
+
+	if !strings.Contains(err.Error(), "Network issue") || FailureHint(err) == "" {
+		t.Fatalf("The failure should report the swupd error and the action to take: %v", err)
+	}
+
+	runSwupd = func(printPrefix string, out cmd.Output, args ...string) error {
+		runs++
+		out.Process(printPrefix, `{ "type" : "error", "msg" : "Bundle \"vim2\" is invalid, skipping it..." },`)
+		out.Process(printPrefix, `{ "type" : "end", "section" : "os-install", "status" : 3 }`)
+		return errors.Errorf("exit status 3")
+	}
+
+	runs = 0
+	err = runWithRetries("", "33000", []string{"swupd", "os-install"})
+	if serr, ok := err.(*Error); !ok || serr.Kind != FailureBundleNotFound || runs != 1 {
+		t.Fatalf("A missing bundle should fail without retries, got %d runs: %v", runs, err)
+	}
+}
+
+func TestInstallThirdParty(t *testing.T) {
+	var mp MockProgress
+	progress.Set(&mp)
+
+	savedRun := runSwupd
+	savedAdd := runThirdPartyAdd
+	defer func() {
+		runSwupd = savedRun
+		runThirdPartyAdd = savedAdd
+	}()
+
+	commands := []string{}
+
+	runThirdPartyAdd = func(args ...string) error {
+		commands = append(commands, strings.Join(args, " "))
+		return nil
+	}
+
+	runSwupd = func(printPrefix string, out cmd.Output, args ...string) error {
+		commands = append(commands, strings.Join(args, " "))
+		return nil
+	}
+
+	md := &model.SystemInstall{AllowInsecureHTTP: true}
+	sw := New("/target", args.Args{SwupdStateDir: "/state"}, md)
+
+	repos := []*model.ThirdPartyRepo{
+		{Name: "tools", URL: "https://example.com/tools", Bundles: []string{"tools-cli", "tools-agent"}},
+		{Name: "empty", URL: "file:///srv/empty"},
+	}
+
+	if err := sw.InstallThirdParty(repos); err != nil {
+		t.Fatalf("InstallThirdParty shouldn't fail: %v", err)
+	}
+
+	expected := []string{
+		"swupd 3rd-party add --path=/target --statedir=/state --assume=yes --allow-insecure-http " +
+			"tools https://example.com/tools",
+		"swupd 3rd-party bundle-add --path=/target --statedir=/state --assume=yes --allow-insecure-http " +
+			"--repo=tools --json-output tools-cli tools-agent",
+		"swupd 3rd-party add --path=/target --statedir=/state --assume=yes --allow-insecure-http " +
+			"empty file:///srv/empty",
+	}
+
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected the commands:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(commands, "\n"))
+	}
+
+	// the pinned certificate is installed in the repository root of the target
+	rootDir, err := ioutil.TempDir("", "clr-installer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	cert := filepath.Join(rootDir, "pinned.pem")
+	if err = ioutil.WriteFile(cert, []byte("pinned"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := New(rootDir, args.Args{SwupdStateDir: "/state"}, md)
+	repo := &model.ThirdPartyRepo{Name: "tools", URL: "https://example.com/tools", Certificate: cert}
+	if err = pinned.ThirdPartyAdd(repo); err != nil {
+		t.Fatalf("ThirdPartyAdd shouldn't fail: %v", err)
+	}
+
+	installed := filepath.Join(rootDir, "opt/3rd-party/bundles/tools", DefaultCertPath)
+	if content, err := ioutil.ReadFile(installed); err != nil || string(content) != "pinned" {
+		t.Fatalf("The pinned certificate should be installed in the target: %v", err)
+	}
+
+	if info, err := os.Stat(installed); err != nil || info.Mode().Perm() != 0644 {
+		t.Fatalf("The installed certificate should be world readable: %v", err)
+	}
+
+	repos[0].Certificate = "/nonexistent/Swupd_Root.pem"
+	if err := sw.InstallThirdParty(repos); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("A missing certificate should fail, got: %v", err)
+	}
+}
+
+// countingSource records the manifests read from a source
+type countingSource struct {
+	manifestSource
+	opened map[string]bool
+	mutex  sync.Mutex
+}
+
+func (s *countingSource) open(version string, name string, hash string) (io.ReadCloser, error) {
+	s.mutex.Lock()
+	s.opened[name] = true
+	s.mutex.Unlock()
+
+	return s.manifestSource.open(version, name, hash)
+}
+
+func TestBuildLock(t *testing.T) {
+	testsDir := os.Getenv("TESTS_DIR")
+	source := &dirSource{dir: filepath.Join(testsDir, "swupd-catalog")}
+
+	counting := &countingSource{manifestSource: source, opened: map[string]bool{}}
+
+	lock, err := buildLock(counting, "33000", []string{"os-core", "editors"}, false)
+	if err != nil {
+		t.Fatalf("Could not build the lock: %v", err)
+	}
+
+	if counting.opened["kata-containers"] || counting.opened["python3-basic"] {
+		t.Fatalf("Only the manifests of the pinned bundles should be read, got %v", counting.opened)
+	}
+
+	names := []string{}
+	for curr := range lock.Manifests {
+		names = append(names, curr)
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, " ") != "MoM editors os-core" {
+		t.Fatalf("Expected the MoM, editors and os-core manifests, got %v", names)
+	}
+
+	if lock.Version != "33000" || lock.SourceDateEpoch != 1588000000 || len(lock.Seed) != 32 {
+		t.Fatalf("Wrong lock version, timestamp or seed: %+v", *lock)
+	}
+
+	again, err := buildLock(source, "33000", []string{"editors", "os-core"}, false)
+	if err != nil || again.Seed != lock.Seed {
+		t.Fatalf("The lock of the same content should have the same seed: %v", err)
+	}
+
+	if err = verifyLock(lock, again); err != nil {
+		t.Fatalf("The same content should match the lock: %v", err)
+	}
+
+	// kata-containers includes python3-basic
+	more, err := buildLock(source, "33000", []string{"os-core", "editors", "kata-containers"}, true)
+	if err != nil {
+		t.Fatalf("Could not build the lock: %v", err)
+	}
+
+	if more.Seed == lock.Seed {
+		t.Fatalf("A different content should have a different seed")
+	}
+
+	err = verifyLock(lock, more)
+	if err == nil || !strings.Contains(err.Error(), "bundle python3-basic is not pinned") {
+		t.Fatalf("The new bundles should not match the lock, got: %v", err)
+	}
+
+	changed := &Lock{Version: lock.Version, Manifests: map[string]string{}}
+	for name, hash := range lock.Manifests {
+		changed.Manifests[name] = hash
+	}
+	changed.Manifests["editors"] = "0000"
+
+	err = verifyLock(lock, changed)
+	if err == nil || !strings.Contains(err.Error(), "Manifest.editors changed") {
+		t.Fatalf("A changed manifest should not match the lock, got: %v", err)
+	}
+
+	if _, err = buildLock(source, "33000", []string{"vim"}, false); err == nil {
+		t.Fatalf("Pinning a missing bundle should fail")
+	}
+
+	dir, err := ioutil.TempDir("", "lock-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the bundles pinned for the build host replace the ones of the running system
+	md := &model.SystemInstall{UserBundles: []string{"editors"}, HostBundles: []string{}}
+	for _, curr := range ImplicitBundles(md) {
+		if curr.Name == network.RequiredBundle {
+			t.Fatalf("The host bundles pinned by the lock should replace %s", curr.Name)
+		}
+	}
+
+	md.HostBundles = []string{network.RequiredBundle}
+	if implicit := ImplicitBundles(md); len(implicit) != 2 || implicit[0].Name != network.RequiredBundle {
+		t.Fatalf("Expected the pinned host bundle and the user bundle, got %v", implicit)
+	}
+
+	lock.HostBundles = md.HostBundles
+
+	file := LockFile(filepath.Join(dir, "image.yaml"))
+	if file != filepath.Join(dir, "image.lock") {
+		t.Fatalf("Unexpected lock file name %s", file)
+	}
+
+	if missing, err := readLock(file); missing != nil || err != nil {
+		t.Fatalf("A missing lock file should not fail: %v", err)
+	}
+
+	if err = writeLock(file, lock); err != nil {
+		t.Fatalf("Could not write the lock file: %v", err)
+	}
+
+	read, err := readLock(file)
+	if err != nil {
+		t.Fatalf("Could not read the lock file: %v", err)
+	}
+
+	if err = verifyLock(read, lock); err != nil || read.Seed != lock.Seed || read.SourceDateEpoch != lock.SourceDateEpoch {
+		t.Fatalf("The lock file should keep the lock: %+v, %v", *read, err)
+	}
+
+	if strings.Join(read.HostBundles, " ") != network.RequiredBundle {
+		t.Fatalf("The lock file should keep the host bundles, got %v", read.HostBundles)
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	stateDir := filepath.Join(dir, "state")
+	staged := filepath.Join(stateDir, "staged")
+	if err = utils.MkdirAll(filepath.Join(staged, "0002"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(staged, "0001"), make([]byte, 600), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Symlink("0001", filepath.Join(staged, "0003")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the telemetry and other state files are not cached
+	if err = ioutil.WriteFile(filepath.Join(stateDir, "version"), []byte("33000"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewContentCache(filepath.Join(dir, "cache"), 1000)
+	if err != nil {
+		t.Fatalf("Could not create the content cache: %v", err)
+	}
+
+	source := "https://cdn.download.clearlinux.org/update"
+
+	if _, err = cache.Acquire(source, "../33000"); err == nil {
+		t.Fatalf("Acquiring an invalid version should fail")
+	}
+
+	first, err := cache.Acquire(source, "33000")
+	if err != nil {
+		t.Fatalf("Could not acquire version 33000: %v", err)
+	}
+
+	if err = first.Store(stateDir); err != nil {
+		t.Fatalf("Could not store version 33000: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(first.Dir(), "staged", "0001"))
+	if err != nil || info.Mode().Perm() != 0750 || info.Size() != 600 {
+		t.Fatalf("The staged file should be cached with its mode: %v", err)
+	}
+
+	if link, err := os.Readlink(filepath.Join(first.Dir(), "staged", "0003")); err != nil || link != "0001" {
+		t.Fatalf("The staged symlink should be cached: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(first.Dir(), "version")); !os.IsNotExist(err) {
+		t.Fatalf("Only the manifests and staged files should be cached")
+	}
+
+	// the same version of another content source, such as a mix, is cached apart
+	mix, err := cache.Acquire("https://mixer.example.com/update", "33000")
+	if err != nil {
+		t.Fatalf("Could not acquire version 33000 of the mix: %v", err)
+	}
+
+	if mix.Dir() == first.Dir() {
+		t.Fatalf("The versions of different content sources should not share the cache")
+	}
+	mix.Release()
+
+	// concurrent stores of the same version use their own temporary files
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- copyToCache(staged, filepath.Join(dir, "concurrent"))
+		}()
+	}
+
+	for i := 0; i < cap(errs); i++ {
+		if err = <-errs; err != nil {
+			t.Fatalf("Concurrent stores should not fail: %v", err)
+		}
+	}
+
+	if info, err = os.Stat(filepath.Join(dir, "concurrent", "0001")); err != nil || info.Size() != 600 {
+		t.Fatalf("The concurrently stored file should be complete: %v", err)
+	}
+
+	first.Release()
+
+	// storing a second version goes past the limit, evicting the first one
+	second, err := cache.Acquire(source, "33010")
+	if err != nil {
+		t.Fatalf("Could not acquire version 33010: %v", err)
+	}
+
+	if err = second.Store(stateDir); err != nil {
+		t.Fatalf("Could not store version 33010: %v", err)
+	}
+
+	if _, err = os.Stat(first.Dir()); !os.IsNotExist(err) {
+		t.Fatalf("The least recently used version should be evicted")
+	}
+
+	if _, err = os.Stat(first.Dir() + ".lock"); err != nil {
+		t.Fatalf("The lock file of an evicted version should be kept: %v", err)
+	}
+
+	// the versions in use are never evicted
+	third, err := cache.Acquire(source, "33020")
+	if err != nil {
+		t.Fatalf("Could not acquire version 33020: %v", err)
+	}
+	defer third.Release()
+
+	if err = third.Store(stateDir); err != nil {
+		t.Fatalf("Could not store version 33020: %v", err)
+	}
+
+	if _, err = os.Stat(second.Dir()); err != nil {
+		t.Fatalf("A version in use should not be evicted: %v", err)
+	}
+
+	second.Release()
+}
@@ -0,0 +1,182 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package swupd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/conf"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/model"
+	"github.com/clearlinux/clr-installer/progress"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// thirdPartyVersion is the version reported for the failures of the third-party
+	// repositories which are always installed at their latest version
+	thirdPartyVersion = "latest"
+
+	// thirdPartyRoot is the root of each third-party repository in the target,
+	// swupd verifies the repository content with the certificate within it
+	thirdPartyRoot = "/opt/3rd-party/bundles"
+)
+
+var (
+	// runThirdPartyAdd runs the command registering a repository, replaced by tests
+	runThirdPartyAdd = cmd.RunAndLog
+)
+
+// thirdPartyArgs builds the swupd 3rd-party command op for repo in the target
+func (s *SoftwareUpdater) thirdPartyArgs(op string, repo *model.ThirdPartyRepo) []string {
+	args := []string{
+		"swupd",
+		"3rd-party",
+		op,
+		fmt.Sprintf("--path=%s", s.rootDir),
+		fmt.Sprintf("--statedir=%s", s.stateDir),
+		"--assume=yes",
+	}
+
+	if s.allowInsecureHTTP {
+		args = append(args, "--allow-insecure-http")
+	}
+
+	// the signatures are verified with the pinned certificate instead of the
+	// certificate published by the repository
+	if repo.Certificate != "" {
+		args = append(args, fmt.Sprintf("--certpath=%s", repo.Certificate))
+	}
+
+	return args
+}
+
+// ThirdPartyAdd registers the third-party repository in the target
+func (s *SoftwareUpdater) ThirdPartyAdd(repo *model.ThirdPartyRepo) error {
+	if repo.Certificate != "" {
+		if ok, err := utils.FileExists(repo.Certificate); err != nil || !ok {
+			return errors.Errorf("Certificate %s of third-party repository %s not found",
+				repo.Certificate, repo.Name)
+		}
+	}
+
+	args := s.thirdPartyArgs("add", repo)
+	args = append(args, repo.Name, repo.URL)
+
+	if err := runThirdPartyAdd(args...); err != nil {
+		return errors.Errorf("Could not add third-party repository %s (%s): %v", repo.Name, repo.URL, err)
+	}
+
+	return s.installThirdPartyCert(repo)
+}
+
+// installThirdPartyCert replaces the certificate of the repository in the target
+// with the pinned one so the later updates of the repository are verified with it
+func (s *SoftwareUpdater) installThirdPartyCert(repo *model.ThirdPartyRepo) error {
+	if repo.Certificate == "" {
+		return nil
+	}
+
+	certPath := filepath.Join(s.rootDir, thirdPartyRoot, repo.Name, DefaultCertPath)
+
+	if err := utils.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+
+	if err := utils.CopyFile(repo.Certificate, certPath); err != nil {
+		return errors.Errorf("Could not install the certificate of third-party repository %s: %v",
+			repo.Name, err)
+	}
+
+	if err := os.Chmod(certPath, 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// ThirdPartyInstall installs the bundles of the third-party repository in the
+// target, the repository must be registered with ThirdPartyAdd first
+func (s *SoftwareUpdater) ThirdPartyInstall(repo *model.ThirdPartyRepo) error {
+	if len(repo.Bundles) == 0 {
+		return nil
+	}
+
+	args := s.thirdPartyArgs("bundle-add", repo)
+	args = append(args, fmt.Sprintf("--repo=%s", repo.Name), "--json-output")
+
+	if s.skipOptional {
+		args = append(args, "--skip-optional")
+	}
+
+	if s.skipDiskSpaceCheck {
+		args = append(args, "--skip-diskspace-check")
+	}
+
+	if s.stateDirCache != "" {
+		args = append(args, fmt.Sprintf("--statedir-cache=%s", s.stateDirCache))
+	}
+
+	args = append(args, repo.Bundles...)
+
+	return runWithRetries(ThirdPartyPrefix, thirdPartyVersion, args)
+}
+
+// InstallThirdParty registers every third-party repository in the target and
+// installs their bundles; the repositories are always fetched from their URL,
+// the network is required unless it is a file:// URL
+func (s *SoftwareUpdater) InstallThirdParty(repos []*model.ThirdPartyRepo) error {
+	for _, curr := range repos {
+		msg := utils.Locale.Get("Adding third-party repository %s", curr.Name)
+		prg := progress.NewLoop(msg)
+		log.Info(msg)
+
+		if err := s.ThirdPartyAdd(curr); err != nil {
+			prg.Failure()
+			return err
+		}
+		prg.Success()
+
+		log.Debug("Installing third-party bundles from %s: %v", curr.Name, curr.Bundles)
+		if err := s.ThirdPartyInstall(curr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DownloadThirdParty downloads the third-party bundles to the OfflineContentDir
+// within the installer image; the repositories are registered in a scratch
+// root since swupd 3rd-party has no download only mode, the content is kept
+// in the offline state directory for the installations from the image
+func (s SoftwareUpdater) DownloadThirdParty(repos []*model.ThirdPartyRepo) error {
+	var err error
+
+	s.stateDirCache = ""
+	s.stateDir = filepath.Join(s.rootDir, conf.OfflineContentDir)
+
+	if s.rootDir, err = ioutil.TempDir("", "installerTmp-"); err != nil {
+		return errors.Wrap(err)
+	}
+	defer func() { _ = os.RemoveAll(s.rootDir) }()
+
+	for _, curr := range repos {
+		if err = s.ThirdPartyAdd(curr); err != nil {
+			return err
+		}
+
+		if err = s.ThirdPartyInstall(curr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
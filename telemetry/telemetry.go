@@ -18,6 +18,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/clearlinux/clr-installer/cmd"
 	"github.com/clearlinux/clr-installer/errors"
@@ -69,6 +70,9 @@ is collected.
 	// recordGenFullPath command
 	recordGenCmdPath = "/usr/bin/" + recordGenCmd
 
+	// serverCheckTimeout is the time allowed to reach the telemetry server
+	serverCheckTimeout = 10 * time.Second
+
 	// Configuration template
 	configTemplate = `[settings]
 server=%s
@@ -176,7 +180,9 @@ func (tl *Telemetry) SetTelemetryServer(telmURL string, telmID string, telmPolic
 		return fmt.Errorf("Could not determine provided telemetry server name from URL (%s): %v", telmURL, err)
 	}
 
-	if urlErr := network.CheckURL(telmURL); urlErr != nil {
+	// The server is checked once, with the proxies and certificates of the downloads
+	fetcher := &network.Fetcher{Timeout: serverCheckTimeout, Attempts: 1}
+	if urlErr := fetcher.Check(telmURL); urlErr != nil {
 		return fmt.Errorf("Server not responding")
 	}
 
@@ -11,12 +11,23 @@ import (
 
 	"github.com/VladimirMarkelov/clui"
 	"github.com/clearlinux/clr-installer/controller"
+	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/swupd"
 )
 
 // BundlePage is the Page implementation for the proxy configuration page
 type BundlePage struct {
 	BasePage
+	searchEdit   *clui.EditField
+	categoryList *clui.ListBox
+	bundlesFrame *clui.Frame
+	checksFrame  *clui.Frame
+	noteLabel    *clui.Label
+	resolver     *swupd.Resolver
+	lastFilter   string
+	loading      bool
+	loaded       bool
+	noteSeq      int
 }
 
 // BundleCheck maps a map name and description with the actual checkbox
@@ -44,9 +55,23 @@ func (bp *BundlePage) updateNetworkStatus() {
 	bp.confirmBtn.SetEnabled(controller.NetworkPassing)
 }
 
-// Activate marks the checkbox selections based on the data model
+// Activate marks the checkbox selections based on the data model, the bundle
+// catalog is loaded in the background the first time the page is shown
 func (bp *BundlePage) Activate() {
 	bp.GetWindow().SetVisible(true)
+
+	bp.updateNetworkStatus()
+
+	if !bp.loaded {
+		bp.loadBundles()
+		return
+	}
+
+	bp.applyModel()
+}
+
+// applyModel checks the bundles selected in the data model
+func (bp *BundlePage) applyModel() {
 	model := bp.getModel()
 
 	for _, curr := range bundles {
@@ -59,7 +84,129 @@ func (bp *BundlePage) Activate() {
 		curr.check.SetState(state)
 	}
 
-	bp.updateNetworkStatus()
+	bp.updateNote(nil, false)
+}
+
+// loadBundles loads the bundle catalog in the background and creates the
+// bundle check boxes once it is available
+func (bp *BundlePage) loadBundles() {
+	if bp.loading {
+		return
+	}
+
+	bp.loading = true
+	bp.noteLabel.SetTitle("Loading the bundle catalog...")
+
+	go func() {
+		defer func() {
+			bp.loading = false
+		}()
+
+		bdls, err := swupd.LoadBundleList(bp.getModel(), bp.tui.options)
+		if err != nil {
+			log.Warning("Could not load the bundle list: %v", err)
+			bp.noteLabel.SetTitle(fmt.Sprintf("Could not load the bundle list: %v", err))
+			clui.RefreshScreen()
+			return
+		}
+
+		if bp.resolver, err = swupd.LoadResolver(bp.getModel(), bp.tui.options); err != nil {
+			log.Warning("Could not resolve the bundle includes: %v", err)
+		}
+
+		for _, curr := range bdls {
+			check := &BundleCheck{curr, nil}
+			bp.createCheck(check)
+			bundles = append(bundles, check)
+		}
+
+		bp.loaded = true
+		bp.applyModel()
+
+		bp.lastFilter = ""
+		bp.applyFilter()
+	}()
+}
+
+// createCheck creates the check box of a bundle
+func (bp *BundlePage) createCheck(curr *BundleCheck) {
+	curr.check = clui.CreateCheckBox(bp.checksFrame, AutoSize, bundleLabel(curr.bundle), AutoSize)
+	curr.check.SetPack(clui.Horizontal)
+
+	bundle := curr.bundle
+	curr.check.OnChange(func(ev int) {
+		bp.updateNote(bundle, ev == 1)
+
+		if ev == 1 && !controller.NetworkPassing {
+			bundleCheck(bp)
+		}
+	})
+}
+
+// bundleLabel returns the check box label of a bundle with its status and size
+func bundleLabel(bundle *swupd.Bundle) string {
+	lbl := fmt.Sprintf("%s: %s", bundle.Name, bundle.Desc)
+
+	if summary := bundle.Summary(); summary != "" {
+		lbl = fmt.Sprintf("%s (%s)", lbl, summary)
+	}
+
+	return lbl
+}
+
+// applyFilter shows only the bundles matching the search text and category
+func (bp *BundlePage) applyFilter() {
+	category := bp.categoryList.SelectedItemText()
+	query := bp.searchEdit.Title()
+
+	if bp.lastFilter == category+"\n"+query {
+		return
+	}
+	bp.lastFilter = category + "\n" + query
+
+	for _, curr := range bundles {
+		curr.check.SetVisible(curr.bundle.Matches(query, category))
+	}
+
+	bp.bundlesFrame.ScrollTo(0, 0)
+	bp.GetWindow().ResizeChildren()
+	bp.GetWindow().PlaceChildren()
+	clui.RefreshScreen()
+}
+
+// updateNote explains the effect of the last checked or unchecked bundle, or
+// the bundles always installed if there is nothing to note; the includes may
+// have to be downloaded so the note is resolved in the background
+func (bp *BundlePage) updateNote(bundle *swupd.Bundle, checked bool) {
+	bp.noteSeq++
+	seq := bp.noteSeq
+
+	if bp.resolver == nil || bundle == nil {
+		bp.noteLabel.SetTitle(swupd.ImplicitBundlesNote(bp.getModel()))
+		return
+	}
+
+	selected := []string{}
+	for _, curr := range bundles {
+		if curr.check.State() == 1 {
+			selected = append(selected, curr.bundle.Name)
+		}
+	}
+
+	go func() {
+		note := bp.resolver.SelectionNote(bp.getModel(), bundle.Name, checked, selected)
+		if note == "" {
+			note = swupd.ImplicitBundlesNote(bp.getModel())
+		}
+
+		// a later selection replaced this note
+		if seq != bp.noteSeq {
+			return
+		}
+
+		bp.noteLabel.SetTitle(note)
+		clui.RefreshScreen()
+	}()
 }
 
 func bundleCheck(bp *BundlePage) {
@@ -100,39 +247,60 @@ func newBundlePage(tui *Tui) (Page, error) {
 	page := &BundlePage{}
 	page.setupMenu(tui, TuiPageBundle, "Select Additional Bundles", NoButtons, TuiPageMenu)
 
-	bdls, err := swupd.LoadBundleList(page.getModel())
-	if err != nil {
-		return nil, err
-	}
+	clui.CreateLabel(page.content, 2, 2, "Select Additional Bundles", Fixed)
 
-	for _, curr := range bdls {
-		bundles = append(bundles, &BundleCheck{curr, nil})
+	filterFrm := clui.CreateFrame(page.content, AutoSize, 3, BorderNone, Fixed)
+	filterFrm.SetPack(clui.Horizontal)
+	filterFrm.SetGaps(2, 0)
+
+	searchFrm := clui.CreateFrame(filterFrm, 40, AutoSize, BorderNone, Fixed)
+	searchFrm.SetPack(clui.Vertical)
+	clui.CreateLabel(searchFrm, AutoSize, 1, "Search:", Fixed)
+
+	page.searchEdit = clui.CreateEditField(searchFrm, 1, "", Fixed)
+	page.searchEdit.OnChange(func(ev clui.Event) {
+		page.applyFilter()
+	})
+
+	categoryFrm := clui.CreateFrame(filterFrm, 30, AutoSize, BorderNone, Fixed)
+	categoryFrm.SetPack(clui.Vertical)
+	clui.CreateLabel(categoryFrm, AutoSize, 1, "Category:", Fixed)
+
+	page.categoryList = clui.CreateListBox(categoryFrm, AutoSize, 2, Fixed)
+	page.categoryList.SetStyle("List")
+	for _, curr := range swupd.BundleCategories {
+		page.categoryList.AddItem(curr)
 	}
+	page.categoryList.SelectItem(0)
+	page.categoryList.OnSelectItem(func(ev clui.Event) {
+		page.applyFilter()
+	})
+	page.categoryList.OnActive(func(active bool) {
+		if active {
+			page.categoryList.SetStyle("ListActive")
+			return
+		}
 
-	clui.CreateLabel(page.content, 2, 2, "Select Additional Bundles", Fixed)
+		page.categoryList.SetStyle("List")
+	})
 
-	frm := clui.CreateFrame(page.content, AutoSize, 14, BorderNone, Fixed)
+	frm := clui.CreateFrame(page.content, AutoSize, 8, BorderNone, Fixed)
 	frm.SetPack(clui.Vertical)
 	frm.SetScrollable(true)
+	page.bundlesFrame = frm
 
 	lblFrm := clui.CreateFrame(frm, AutoSize, AutoSize, BorderNone, Fixed)
 	lblFrm.SetPack(clui.Vertical)
 	lblFrm.SetPaddings(2, 0)
 
-	for _, curr := range bundles {
-		lbl := fmt.Sprintf("%s: %s", curr.bundle.Name, curr.bundle.Desc)
-		curr.check = clui.CreateCheckBox(lblFrm, AutoSize, lbl, AutoSize)
-		curr.check.SetPack(clui.Horizontal)
-		curr.check.OnChange(func(ev int) {
-			if ev == 1 && !controller.NetworkPassing {
-				bundleCheck(page)
-			}
-		})
-	}
+	page.checksFrame = lblFrm
 
 	fldFrm := clui.CreateFrame(frm, 30, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
 
+	page.noteLabel = clui.CreateLabel(page.content, AutoSize, 2, "", Fixed)
+	page.noteLabel.SetMultiline(true)
+
 	cancelBtn := CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Cancel", Fixed)
 	cancelBtn.OnClick(func(ev clui.Event) {
 		page.GotoPage(TuiPageMenu)
@@ -140,6 +308,12 @@ func newBundlePage(tui *Tui) (Page, error) {
 
 	page.confirmBtn = CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Confirm", Fixed)
 	page.confirmBtn.OnClick(func(ev clui.Event) {
+		// nothing could be selected before the catalog is loaded
+		if !page.loaded {
+			page.GotoPage(TuiPageMenu)
+			return
+		}
+
 		anySelected := false
 		for _, curr := range bundles {
 			if curr.check.State() == 1 {
@@ -150,6 +324,12 @@ func newBundlePage(tui *Tui) (Page, error) {
 			}
 		}
 
+		// the bundle manifests may have to be downloaded for the estimate
+		model := page.getModel()
+		swupd.EstimateInBackground(model, page.tui.options, func(estimate *swupd.InstallEstimate) {
+			swupd.SetEstimatedSize(model, estimate)
+		})
+
 		page.SetDone(anySelected)
 		page.GotoPage(TuiPageMenu)
 	})
@@ -151,6 +151,9 @@ const (
 	// TuiPageSaveConfig is the id for the save YAML configuration file page
 	TuiPageSaveConfig
 
+	// TuiPageWireless is the id for the Wi-Fi network configuration page
+	TuiPageWireless
+
 	// ConfigDefinedByUser is used to determine a configuration was interactively
 	// defined by the user
 	ConfigDefinedByUser = iota
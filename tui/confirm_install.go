@@ -169,6 +169,9 @@ func initConfirmDiaglogWindow(dialog *ConfirmInstallDialog) error {
 			"Offline Install: Removing additional bundles")
 	}
 
+	*dryRunResults.TargetResults = append(*dryRunResults.TargetResults,
+		swupd.EstimateSummary(dialog.modelSI, dialog.options)...)
+
 	writeToConfirmInstallDialog(dialog, dryRunResults)
 
 	buttonFrame := clui.CreateFrame(borderFrame, AutoSize, 1, clui.BorderNone, clui.Fixed)
@@ -203,6 +206,7 @@ func CreateConfirmInstallDialogBox(modelSI *model.SystemInstall, options args.Ar
 		return nil, fmt.Errorf("Missing model for Confirmation of Installation Dialog")
 	}
 	dialog.modelSI = modelSI
+	dialog.options = options
 
 	if err := initConfirmDiaglogWindow(dialog); err != nil {
 		return nil, fmt.Errorf("Failed to create Confirmation of Installation Dialog: %v", err)
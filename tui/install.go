@@ -11,6 +11,7 @@ import (
 	"github.com/VladimirMarkelov/clui"
 
 	"github.com/clearlinux/clr-installer/controller"
+	"github.com/clearlinux/clr-installer/log"
 	"github.com/clearlinux/clr-installer/network"
 	"github.com/clearlinux/clr-installer/progress"
 	"github.com/clearlinux/clr-installer/swupd"
@@ -25,6 +26,7 @@ type InstallPage struct {
 	prgBar    *clui.ProgressBar
 	prgLabel  *clui.Label
 	prgMax    int
+	overall   *clui.Label
 }
 
 var (
@@ -92,6 +94,13 @@ func (page *InstallPage) LoopWaitDuration() time.Duration {
 	return loopWaitDuration
 }
 
+// Overall is part of the progress.Client implementation and shows the overall
+// installation progress and the remaining time
+func (page *InstallPage) Overall(percent int, eta time.Duration) {
+	page.overall.SetTitle("Overall progress: " + progress.OverallString(percent, eta))
+	clui.RefreshScreen()
+}
+
 // Activate is called when the page is "shown"
 func (page *InstallPage) Activate() {
 	go func() {
@@ -99,6 +108,19 @@ func (page *InstallPage) Activate() {
 
 		err := controller.Install(page.tui.rootDir, page.getModel(), page.tui.options)
 		if err != nil {
+			// show what to do about the swupd failures before exiting
+			if hint := swupd.FailureHint(err); hint != "" {
+				dialog, derr := CreateWarningDialogBox("Installation failed.\n" + hint)
+				if derr == nil {
+					dialog.OnClose(func() {
+						page.Panic(err)
+					})
+					clui.RefreshScreen()
+					return
+				}
+				log.Warning("Could not show the installation failure: %v", derr)
+			}
+
 			page.Panic(err)
 			return // In a panic state, do not continue
 		}
@@ -134,6 +156,9 @@ func newInstallPage(tui *Tui) (Page, error) {
 	page.prgLabel = clui.CreateLabel(progressFrame, 1, 1, "Installing", Fixed)
 	page.prgLabel.SetPaddings(0, 3)
 
+	page.overall = clui.CreateLabel(page.content, 1, 1, "", Fixed)
+	page.overall.SetPaddings(0, 1)
+
 	page.rebootBtn = CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Reboot", Fixed)
 	page.rebootBtn.OnClick(func(ev clui.Event) {
 		go clui.Stop()
@@ -38,7 +38,10 @@ func (page *NetworkPage) GetConfiguredValue() string {
 
 	for _, curr := range ifaces {
 		for _, addr := range curr.Addrs {
-			if addr.Version != network.IPv4 {
+			if addr.Version == network.IPv6 {
+				if cidr, err := addr.CIDR(); err == nil && curr.IPv6 != "" && !addr.IsLinkLocal() {
+					res = append(res, cidr)
+				}
 				continue
 			}
 
@@ -82,6 +85,13 @@ func (page *NetworkPage) showInterface(frm *clui.Frame, iface *network.Interface
 	btn.OnClick(func(ev clui.Event) {
 		iface.UserDefined = true
 		page.data = iface
+
+		// Wi-Fi devices first select the network to connect to
+		if iface.IsWireless() || network.IsWireless(iface.Name) {
+			page.GotoPage(TuiPageWireless)
+			return
+		}
+
 		page.GotoPage(TuiPageInterface)
 	})
 
@@ -98,6 +108,14 @@ func (page *NetworkPage) showInterface(frm *clui.Frame, iface *network.Interface
 		page.showLabel(frm, fmt.Sprintf("  ipv4:    0.0.0.0"))
 		page.showLabel(frm, fmt.Sprintf("  netmask: 0.0.0.0"))
 	}
+
+	if iface.IsWireless() {
+		page.showLabel(frm, fmt.Sprintf("  wifi:    %s", iface.Wireless.SSID))
+	}
+
+	if iface.Match != nil {
+		page.showLabel(frm, fmt.Sprintf("  match:   %s", iface.Match))
+	}
 }
 
 // Activate will recreate the network listing elements
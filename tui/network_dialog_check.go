@@ -87,6 +87,10 @@ func (dialog *NetworkTestDialog) Desc(desc string) {
 func (dialog *NetworkTestDialog) Partial(total int, step int) {
 }
 
+// Overall is part of the progress.Client implementation, the network check is
+// not part of the installation progress
+func (dialog *NetworkTestDialog) Overall(percent int, eta time.Duration) {}
+
 // LoopWaitDuration is part of the progress.Client implementation and returns the time duration
 // each step should wait until calling Step again
 func (dialog *NetworkTestDialog) LoopWaitDuration() time.Duration {
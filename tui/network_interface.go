@@ -5,6 +5,8 @@
 package tui
 
 import (
+	"net"
+	"strings"
 	"time"
 
 	"github.com/clearlinux/clr-installer/network"
@@ -22,10 +24,18 @@ type NetworkInterfacePage struct {
 	NetMaskWarning   *clui.Label
 	GatewayEdit      *clui.EditField
 	GatewayWarning   *clui.Label
+	IPv6ModeEdit     *clui.EditField
+	IPv6ModeWarning  *clui.Label
+	IPv6Edit         *clui.EditField
+	IPv6Warning      *clui.Label
+	Gateway6Edit     *clui.EditField
+	Gateway6Warning  *clui.Label
 	DNSServerEdit    *clui.EditField
 	DNSServerWarning *clui.Label
 	DNSDomainEdit    *clui.EditField
 	DNSDomainWarning *clui.Label
+	RoutesEdit       *clui.EditField
+	RoutesWarning    *clui.Label
 	ifaceLbl         *clui.Label
 	DHCPCheck        *clui.CheckBox
 	confirmBtn       *SimpleButton
@@ -34,8 +44,12 @@ type NetworkInterfacePage struct {
 		IP        string
 		NetMask   string
 		Gateway   string
+		IPv6Mode  string
+		IPv6      string
+		Gateway6  string
 		DNSServer string
 		DNSDomain string
+		Routes    string
 		DHCP      bool
 	}
 }
@@ -56,12 +70,58 @@ func (page *NetworkInterfacePage) clearAllWarnings() {
 	page.IPWarning.SetTitle("")
 	page.NetMaskWarning.SetTitle("")
 	page.GatewayWarning.SetTitle("")
+	page.IPv6ModeWarning.SetTitle("")
+	page.IPv6Warning.SetTitle("")
+	page.Gateway6Warning.SetTitle("")
 	page.DNSServerWarning.SetTitle("")
 	page.DNSDomainWarning.SetTitle("")
+	page.RoutesWarning.SetTitle("")
 
 	page.setConfirmButton()
 }
 
+// firstIPv6Addr returns the first non link-local ipv6 addr in CIDR notation, the
+// addresses are only configured with an ipv6 mode
+func firstIPv6Addr(iface *network.Interface) string {
+	if iface.IPv6 == "" || iface.IPv6 == network.IPv6Disabled {
+		return ""
+	}
+
+	for _, addr := range iface.Addrs {
+		if addr.Version != network.IPv6 || addr.IsLinkLocal() {
+			continue
+		}
+
+		if cidr, err := addr.CIDR(); err == nil {
+			return cidr
+		}
+	}
+
+	return ""
+}
+
+// setInterfaceAddr replaces the first addr of a given version, an empty IP removes it
+func setInterfaceAddr(iface *network.Interface, IP string, NetMask string, version int) {
+	for idx, addr := range iface.Addrs {
+		if addr.Version != version || addr.IsLinkLocal() {
+			continue
+		}
+
+		if IP == "" {
+			iface.Addrs = append(iface.Addrs[:idx], iface.Addrs[idx+1:]...)
+			return
+		}
+
+		addr.IP = IP
+		addr.NetMask = NetMask
+		return
+	}
+
+	if IP != "" {
+		iface.AddAddr(IP, NetMask, version)
+	}
+}
+
 // Activate will set the fields with the selected interface info
 func (page *NetworkInterfacePage) Activate() {
 	sel := page.getSelectedInterface()
@@ -70,18 +130,25 @@ func (page *NetworkInterfacePage) Activate() {
 	page.IPEdit.SetTitle("")
 	page.NetMaskEdit.SetTitle("")
 	page.GatewayEdit.SetTitle(sel.Gateway)
-	page.DNSServerEdit.SetTitle(sel.DNSServer)
-	page.DNSDomainEdit.SetTitle(sel.DNSDomain)
+	page.IPv6ModeEdit.SetTitle(sel.IPv6)
+	page.IPv6Edit.SetTitle(firstIPv6Addr(sel))
+	page.Gateway6Edit.SetTitle(sel.Gateway6)
+	page.DNSServerEdit.SetTitle(strings.Join(sel.DNSServers, ", "))
+	page.DNSDomainEdit.SetTitle(strings.Join(sel.DNSDomains, ", "))
+	page.RoutesEdit.SetTitle(network.FormatRoutes(sel.Routes))
 	page.clearAllWarnings()
 
 	page.defaultValues.Gateway = sel.Gateway
-	page.defaultValues.DNSServer = sel.DNSServer
-	page.defaultValues.DNSDomain = sel.DNSDomain
+	page.defaultValues.IPv6Mode = sel.IPv6
+	page.defaultValues.IPv6 = firstIPv6Addr(sel)
+	page.defaultValues.Gateway6 = sel.Gateway6
+	page.defaultValues.DNSServer = page.DNSServerEdit.Title()
+	page.defaultValues.DNSDomain = page.DNSDomainEdit.Title()
+	page.defaultValues.Routes = page.RoutesEdit.Title()
 	page.defaultValues.DHCP = sel.DHCP
 
-	showIPv4 := sel.HasIPv4Addr()
 	for _, addr := range sel.Addrs {
-		if showIPv4 && addr.Version != network.IPv4 {
+		if addr.Version != network.IPv4 {
 			continue
 		}
 
@@ -98,8 +165,10 @@ func (page *NetworkInterfacePage) Activate() {
 
 func (page *NetworkInterfacePage) setConfirmButton() {
 	if page.IPWarning.Title() == "" && page.NetMaskWarning.Title() == "" &&
-		page.GatewayWarning.Title() == "" &&
-		page.DNSServerWarning.Title() == "" && page.DNSDomainWarning.Title() == "" {
+		page.GatewayWarning.Title() == "" && page.IPv6ModeWarning.Title() == "" &&
+		page.IPv6Warning.Title() == "" && page.Gateway6Warning.Title() == "" &&
+		page.DNSServerWarning.Title() == "" && page.DNSDomainWarning.Title() == "" &&
+		page.RoutesWarning.Title() == "" {
 		page.confirmBtn.SetEnabled(true)
 	} else {
 		page.confirmBtn.SetEnabled(false)
@@ -112,6 +181,83 @@ func (page *NetworkInterfacePage) validateIPField(editField *clui.EditField, war
 	page.setConfirmButton()
 }
 
+// validateIPv4Field validates the ipv4 address, which may be left empty
+// for ipv6 only interfaces
+func (page *NetworkInterfacePage) validateIPv4Field(editField *clui.EditField, warnLabel *clui.Label) {
+	warning := ""
+
+	if editField.Title() != "" || page.IPv6ModeEdit.Title() != network.IPv6Static {
+		if ip := net.ParseIP(editField.Title()); ip == nil || ip.To4() == nil {
+			warning = "Invalid IP Addr"
+		}
+	}
+
+	warnLabel.SetTitle(warning)
+	page.setConfirmButton()
+}
+
+func (page *NetworkInterfacePage) validateNetMaskField(editField *clui.EditField, warnLabel *clui.Label) {
+	warning := ""
+
+	if page.IPEdit.Title() != "" {
+		warning = network.IsValidNetMask(editField.Title())
+	}
+
+	warnLabel.SetTitle(warning)
+	page.setConfirmButton()
+}
+
+func (page *NetworkInterfacePage) validateIPv6ModeField(editField *clui.EditField, warnLabel *clui.Label) {
+	warnLabel.SetTitle(network.IsValidIPv6Mode(editField.Title()))
+
+	page.setConfirmButton()
+}
+
+// validateIPv6Field validates the ipv6 address in CIDR notation, required
+// for static ipv6 only
+func (page *NetworkInterfacePage) validateIPv6Field(editField *clui.EditField, warnLabel *clui.Label) {
+	warning := ""
+	mode := page.IPv6ModeEdit.Title()
+
+	if editField.Title() != "" || mode == network.IPv6Static {
+		warning = network.IsValidCIDR(editField.Title())
+		if warning == "" && !strings.Contains(editField.Title(), ":") {
+			warning = "Invalid ipv6 address"
+		}
+	}
+
+	if warning == "" && editField.Title() != "" && (mode == "" || mode == network.IPv6Disabled) {
+		warning = "Requires an ipv6 mode other than disabled"
+	}
+
+	warnLabel.SetTitle(warning)
+	page.setConfirmButton()
+}
+
+func (page *NetworkInterfacePage) validateGateway6Field(editField *clui.EditField, warnLabel *clui.Label) {
+	warning := ""
+
+	if editField.Title() != "" {
+		if ip := net.ParseIP(editField.Title()); ip == nil || ip.To4() != nil {
+			warning = "Invalid ipv6 gateway"
+		}
+	}
+
+	warnLabel.SetTitle(warning)
+	page.setConfirmButton()
+}
+
+// validateIPv6Fields revalidates all the fields depending on the ipv6 mode
+func (page *NetworkInterfacePage) validateIPv6Fields() {
+	page.validateIPv6ModeField(page.IPv6ModeEdit, page.IPv6ModeWarning)
+	page.validateIPv6Field(page.IPv6Edit, page.IPv6Warning)
+	page.validateGateway6Field(page.Gateway6Edit, page.Gateway6Warning)
+
+	if !page.getDHCP() {
+		page.validateIPv4Field(page.IPEdit, page.IPWarning)
+	}
+}
+
 func (page *NetworkInterfacePage) validateIPOrHostField(editField *clui.EditField, warnLabel *clui.Label) {
 	warning := network.IsValidIP(editField.Title())
 
@@ -128,9 +274,43 @@ func (page *NetworkInterfacePage) validateIPOrHostField(editField *clui.EditFiel
 	page.setConfirmButton()
 }
 
-func (page *NetworkInterfacePage) validateDomainField(editField *clui.EditField, warnLabel *clui.Label) {
-	warnLabel.SetTitle(network.IsValidDomainName(editField.Title()))
+// splitList splits a list of values separated by commas or spaces
+func splitList(str string) []string {
+	return strings.Fields(strings.Replace(str, ",", " ", -1))
+}
+
+// validateListField validates each value of a list field with the given validation
+// function, an empty list is valid
+func (page *NetworkInterfacePage) validateListField(editField *clui.EditField, warnLabel *clui.Label,
+	validate func(string) string) {
+	warning := ""
+
+	for _, curr := range splitList(editField.Title()) {
+		if warning = validate(curr); warning != "" {
+			break
+		}
+	}
 
+	warnLabel.SetTitle(warning)
+	page.setConfirmButton()
+}
+
+func (page *NetworkInterfacePage) validateDNSServersField(editField *clui.EditField, warnLabel *clui.Label) {
+	page.validateListField(editField, warnLabel, network.IsValidIP)
+}
+
+func (page *NetworkInterfacePage) validateDomainsField(editField *clui.EditField, warnLabel *clui.Label) {
+	page.validateListField(editField, warnLabel, network.IsValidDomainName)
+}
+
+func (page *NetworkInterfacePage) validateRoutesField(editField *clui.EditField, warnLabel *clui.Label) {
+	warning := ""
+
+	if _, err := network.ParseRoutes(editField.Title()); err != nil {
+		warning = err.Error()
+	}
+
+	warnLabel.SetTitle(warning)
 	page.setConfirmButton()
 }
 
@@ -180,6 +360,19 @@ func validateIPEdit(k term.Key, ch rune) bool {
 	return true
 }
 
+func validateIPv6Edit(k term.Key, ch rune) bool {
+	if k == term.KeyBackspace || k == term.KeyBackspace2 {
+		return false
+	}
+
+	if k == term.KeyArrowUp || k == term.KeyArrowDown ||
+		k == term.KeyArrowLeft || k == term.KeyArrowRight {
+		return false
+	}
+
+	return !strings.ContainsRune("0123456789abcdefABCDEF:./", ch)
+}
+
 func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	page := &NetworkInterfacePage{}
 	page.setup(tui, TuiPageInterface, NoButtons, TuiPageMenu)
@@ -195,8 +388,12 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	newFieldLabel(lblFrm, "Ip address:")
 	newFieldLabel(lblFrm, "Subnet mask:")
 	newFieldLabel(lblFrm, "Gateway:")
+	newFieldLabel(lblFrm, "IPv6 mode:")
+	newFieldLabel(lblFrm, "IPv6 address:")
+	newFieldLabel(lblFrm, "IPv6 gateway:")
 	newFieldLabel(lblFrm, "DNS Server:")
 	newFieldLabel(lblFrm, "DNS Domain:")
+	newFieldLabel(lblFrm, "Routes:")
 
 	fldFrm := clui.CreateFrame(frm, 50, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
@@ -210,24 +407,28 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 	page.IPEdit, page.IPWarning = newEditField(fldFrm, true, validateIPEdit, 0)
 	page.NetMaskEdit, page.NetMaskWarning = newEditField(fldFrm, true, validateIPEdit, 0)
 	page.GatewayEdit, page.GatewayWarning = newEditField(fldFrm, true, nil, 0)
+	page.IPv6ModeEdit, page.IPv6ModeWarning = newEditField(fldFrm, true, nil, 0)
+	page.IPv6Edit, page.IPv6Warning = newEditField(fldFrm, true, validateIPv6Edit, 0)
+	page.Gateway6Edit, page.Gateway6Warning = newEditField(fldFrm, true, validateIPv6Edit, 0)
 	page.DNSServerEdit, page.DNSServerWarning = newEditField(fldFrm, true, nil, 0)
 	page.DNSDomainEdit, page.DNSDomainWarning = newEditField(fldFrm, true, nil, 0)
+	page.RoutesEdit, page.RoutesWarning = newEditField(fldFrm, true, nil, 0)
 
 	page.IPEdit.OnChange(func(ev clui.Event) {
-		page.validateIPField(page.IPEdit, page.IPWarning)
+		page.validateIPv4Field(page.IPEdit, page.IPWarning)
 	})
 	page.IPEdit.OnActive(func(active bool) {
 		if page.IPEdit.Active() {
-			page.validateIPField(page.IPEdit, page.IPWarning)
+			page.validateIPv4Field(page.IPEdit, page.IPWarning)
 		}
 	})
 	page.IPWarning.SetVisible(true)
 	page.NetMaskEdit.OnChange(func(ev clui.Event) {
-		page.validateIPField(page.NetMaskEdit, page.NetMaskWarning)
+		page.validateNetMaskField(page.NetMaskEdit, page.NetMaskWarning)
 	})
 	page.NetMaskEdit.OnActive(func(active bool) {
 		if page.NetMaskEdit.Active() {
-			page.validateIPField(page.NetMaskEdit, page.NetMaskWarning)
+			page.validateNetMaskField(page.NetMaskEdit, page.NetMaskWarning)
 		}
 	})
 	page.NetMaskWarning.SetVisible(true)
@@ -240,24 +441,60 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 		}
 	})
 	page.GatewayWarning.SetVisible(true)
+	page.IPv6ModeEdit.OnChange(func(ev clui.Event) {
+		page.validateIPv6Fields()
+	})
+	page.IPv6ModeEdit.OnActive(func(active bool) {
+		if page.IPv6ModeEdit.Active() {
+			page.validateIPv6Fields()
+		}
+	})
+	page.IPv6ModeWarning.SetVisible(true)
+	page.IPv6Edit.OnChange(func(ev clui.Event) {
+		page.validateIPv6Field(page.IPv6Edit, page.IPv6Warning)
+	})
+	page.IPv6Edit.OnActive(func(active bool) {
+		if page.IPv6Edit.Active() {
+			page.validateIPv6Field(page.IPv6Edit, page.IPv6Warning)
+		}
+	})
+	page.IPv6Warning.SetVisible(true)
+	page.Gateway6Edit.OnChange(func(ev clui.Event) {
+		page.validateGateway6Field(page.Gateway6Edit, page.Gateway6Warning)
+	})
+	page.Gateway6Edit.OnActive(func(active bool) {
+		if page.Gateway6Edit.Active() {
+			page.validateGateway6Field(page.Gateway6Edit, page.Gateway6Warning)
+		}
+	})
+	page.Gateway6Warning.SetVisible(true)
 	page.DNSServerEdit.OnChange(func(ev clui.Event) {
-		page.validateIPOrHostField(page.DNSServerEdit, page.DNSServerWarning)
+		page.validateDNSServersField(page.DNSServerEdit, page.DNSServerWarning)
 	})
 	page.DNSServerEdit.OnActive(func(active bool) {
 		if page.DNSServerEdit.Active() {
-			page.validateIPOrHostField(page.DNSServerEdit, page.DNSServerWarning)
+			page.validateDNSServersField(page.DNSServerEdit, page.DNSServerWarning)
 		}
 	})
 	page.DNSServerWarning.SetVisible(true)
 	page.DNSDomainEdit.OnChange(func(ev clui.Event) {
-		page.validateDomainField(page.DNSDomainEdit, page.DNSDomainWarning)
+		page.validateDomainsField(page.DNSDomainEdit, page.DNSDomainWarning)
 	})
 	page.DNSDomainEdit.OnActive(func(active bool) {
 		if page.DNSDomainEdit.Active() {
-			page.validateDomainField(page.DNSDomainEdit, page.DNSDomainWarning)
+			page.validateDomainsField(page.DNSDomainEdit, page.DNSDomainWarning)
 		}
 	})
 	page.DNSDomainWarning.SetVisible(true)
+	page.RoutesEdit.OnChange(func(ev clui.Event) {
+		page.validateRoutesField(page.RoutesEdit, page.RoutesWarning)
+	})
+	page.RoutesEdit.OnActive(func(active bool) {
+		if page.RoutesEdit.Active() {
+			page.validateRoutesField(page.RoutesEdit, page.RoutesWarning)
+		}
+	})
+	page.RoutesWarning.SetVisible(true)
 
 	dhcpFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
 	dhcpFrm.SetPack(clui.Vertical)
@@ -270,13 +507,16 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 		if ev == 1 {
 			enable = false
 			page.clearAllWarnings()
+			page.validateIPv6Fields()
 		} else {
-			page.validateIPField(page.IPEdit, page.IPWarning)
-			page.validateIPField(page.NetMaskEdit, page.NetMaskWarning)
+			page.validateIPv4Field(page.IPEdit, page.IPWarning)
+			page.validateNetMaskField(page.NetMaskEdit, page.NetMaskWarning)
+			page.validateIPv6Fields()
 			page.validateIPOrHostField(page.GatewayEdit, page.GatewayWarning)
-			page.validateIPOrHostField(page.DNSServerEdit, page.DNSServerWarning)
-			page.validateDomainField(page.DNSDomainEdit, page.DNSDomainWarning)
+			page.validateDNSServersField(page.DNSServerEdit, page.DNSServerWarning)
+			page.validateDomainsField(page.DNSDomainEdit, page.DNSDomainWarning)
 		}
+		page.validateRoutesField(page.RoutesEdit, page.RoutesWarning)
 
 		page.IPEdit.SetEnabled(enable)
 		page.NetMaskEdit.SetEnabled(enable)
@@ -301,8 +541,12 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 		NetMask := page.NetMaskEdit.Title()
 		DHCP := page.getDHCP()
 		Gateway := page.GatewayEdit.Title()
+		IPv6Mode := page.IPv6ModeEdit.Title()
+		IPv6 := page.IPv6Edit.Title()
+		Gateway6 := page.Gateway6Edit.Title()
 		DNSServer := page.DNSServerEdit.Title()
 		DNSDomain := page.DNSDomainEdit.Title()
+		Routes := page.RoutesEdit.Title()
 		changed := false
 
 		if IP != page.defaultValues.IP {
@@ -321,6 +565,11 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 			changed = true
 		}
 
+		if IPv6Mode != page.defaultValues.IPv6Mode || IPv6 != page.defaultValues.IPv6 ||
+			Gateway6 != page.defaultValues.Gateway6 {
+			changed = true
+		}
+
 		if DNSServer != page.defaultValues.DNSServer {
 			changed = true
 		}
@@ -329,26 +578,27 @@ func newNetworkInterfacePage(tui *Tui) (Page, error) {
 			changed = true
 		}
 
+		if Routes != page.defaultValues.Routes {
+			changed = true
+		}
+
 		if changed {
 			sel := page.getSelectedInterface()
-			if !sel.HasIPv4Addr() {
-				sel.AddAddr(IP, NetMask, network.IPv4)
-			} else {
-				for _, addr := range sel.Addrs {
-					if addr.Version != network.IPv4 {
-						continue
-					}
-
-					addr.IP = IP
-					addr.NetMask = NetMask
-					break
-				}
+			if !DHCP {
+				setInterfaceAddr(sel, IP, NetMask, network.IPv4)
+			}
+			if IPv6 != page.defaultValues.IPv6 {
+				setInterfaceAddr(sel, IPv6, "", network.IPv6)
 			}
 
 			sel.DHCP = DHCP
 			sel.Gateway = Gateway
-			sel.DNSServer = DNSServer
-			sel.DNSDomain = DNSDomain
+			sel.IPv6 = IPv6Mode
+			sel.Gateway6 = Gateway6
+			sel.DNSServers = splitList(DNSServer)
+			sel.DNSDomains = splitList(DNSDomain)
+			// The routes field is validated on change
+			sel.Routes, _ = network.ParseRoutes(Routes)
 			page.getModel().AddNetworkInterface(sel)
 		}
 
@@ -0,0 +1,221 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package tui
+
+import (
+	"github.com/clearlinux/clr-installer/network"
+
+	"github.com/VladimirMarkelov/clui"
+)
+
+// NetworkWirelessPage is the Page implementation for the Wi-Fi network configuration page
+type NetworkWirelessPage struct {
+	BasePage
+	SSIDEdit          *clui.EditField
+	SecurityEdit      *clui.EditField
+	PSKEdit           *clui.EditField
+	EAPMethodEdit     *clui.EditField
+	IdentityEdit      *clui.EditField
+	PasswordEdit      *clui.EditField
+	CACertEdit        *clui.EditField
+	ifaceLbl          *clui.Label
+	HiddenCheck       *clui.CheckBox
+	confirmBtn        *SimpleButton
+	eap               *network.EAP
+	validationWarning *clui.Label
+}
+
+// Activate will set the fields with the selected interface Wi-Fi network
+func (page *NetworkWirelessPage) Activate() {
+	sel := page.getSelectedInterface()
+
+	w := sel.Wireless
+	if w == nil {
+		w = &network.Wireless{Security: network.WirelessWPA2PSK}
+	}
+
+	page.eap = w.EAP
+	eap := w.EAP
+	if eap == nil {
+		eap = &network.EAP{}
+	}
+
+	page.ifaceLbl.SetTitle(sel.Name)
+	page.SSIDEdit.SetTitle(w.SSID)
+	page.SecurityEdit.SetTitle(w.Security)
+	page.PSKEdit.SetTitle(w.PSK)
+	page.EAPMethodEdit.SetTitle(eap.Method)
+	page.IdentityEdit.SetTitle(eap.Identity)
+	page.PasswordEdit.SetTitle(eap.Password)
+	page.CACertEdit.SetTitle(eap.CACert)
+
+	state := 0
+	if w.Hidden {
+		state = 1
+	}
+	page.HiddenCheck.SetState(state)
+
+	page.validate()
+}
+
+func (page *NetworkWirelessPage) getSelectedInterface() *network.Interface {
+	var iface *network.Interface
+	var ok bool
+
+	prevPage := page.tui.getPage(TuiPageNetwork)
+	if iface, ok = prevPage.GetData().(*network.Interface); !ok {
+		return nil
+	}
+
+	return iface
+}
+
+// getWireless returns the Wi-Fi network settings as entered, the eap settings
+// not shown in the page are kept
+func (page *NetworkWirelessPage) getWireless() *network.Wireless {
+	w := &network.Wireless{
+		SSID:     page.SSIDEdit.Title(),
+		Hidden:   page.HiddenCheck.State() == 1,
+		Security: page.SecurityEdit.Title(),
+	}
+
+	if w.Security != network.WirelessEAP {
+		w.PSK = page.PSKEdit.Title()
+		return w
+	}
+
+	eap := network.EAP{}
+	if page.eap != nil {
+		eap = *page.eap
+	}
+
+	eap.Method = page.EAPMethodEdit.Title()
+	eap.Identity = page.IdentityEdit.Title()
+	eap.Password = page.PasswordEdit.Title()
+	eap.CACert = page.CACertEdit.Title()
+	w.EAP = &eap
+
+	return w
+}
+
+// validate validates the Wi-Fi network settings as a whole and enables the
+// fields relevant to the selected security
+func (page *NetworkWirelessPage) validate() {
+	security := page.SecurityEdit.Title()
+	isEAP := security == network.WirelessEAP
+
+	page.PSKEdit.SetEnabled(security == network.WirelessWPA2PSK || security == network.WirelessWPA3PSK)
+	page.EAPMethodEdit.SetEnabled(isEAP)
+	page.IdentityEdit.SetEnabled(isEAP)
+	page.PasswordEdit.SetEnabled(isEAP)
+	page.CACertEdit.SetEnabled(isEAP)
+
+	warning := ""
+	if err := page.getWireless().Validate(); err != nil {
+		warning = err.Error()
+	}
+
+	page.validationWarning.SetTitle(warning)
+	page.confirmBtn.SetEnabled(warning == "")
+}
+
+// newField creates an edit field validating the whole Wi-Fi network on change, the
+// warning is shown once below the fields
+func (page *NetworkWirelessPage) newField(frm *clui.Frame, password bool) *clui.EditField {
+	edit, _ := newEditField(frm, false, nil, 0)
+	edit.SetPasswordMode(password)
+
+	edit.OnChange(func(ev clui.Event) {
+		page.validate()
+	})
+	edit.OnActive(func(active bool) {
+		if edit.Active() {
+			page.validate()
+		}
+	})
+
+	return edit
+}
+
+func newNetworkWirelessPage(tui *Tui) (Page, error) {
+	page := &NetworkWirelessPage{}
+	page.setup(tui, TuiPageWireless, NoButtons, TuiPageMenu)
+
+	frm := clui.CreateFrame(page.content, AutoSize, AutoSize, BorderNone, Fixed)
+	frm.SetPack(clui.Horizontal)
+
+	lblFrm := clui.CreateFrame(frm, 10, AutoSize, BorderNone, Fixed)
+	lblFrm.SetPack(clui.Vertical)
+	lblFrm.SetPaddings(1, 0)
+
+	newFieldLabel(lblFrm, "Interface:")
+	newFieldLabel(lblFrm, "SSID:")
+	newFieldLabel(lblFrm, "Security:")
+	newFieldLabel(lblFrm, "Passphrase:")
+	newFieldLabel(lblFrm, "EAP method:")
+	newFieldLabel(lblFrm, "Identity:")
+	newFieldLabel(lblFrm, "Password:")
+	newFieldLabel(lblFrm, "CA cert:")
+
+	fldFrm := clui.CreateFrame(frm, 50, AutoSize, BorderNone, Fixed)
+	fldFrm.SetPack(clui.Vertical)
+
+	ifaceFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
+	ifaceFrm.SetPack(clui.Vertical)
+
+	page.ifaceLbl = clui.CreateLabel(ifaceFrm, AutoSize, 2, "", Fixed)
+	page.ifaceLbl.SetAlign(AlignLeft)
+
+	page.SSIDEdit = page.newField(fldFrm, false)
+	page.SecurityEdit = page.newField(fldFrm, false)
+	page.PSKEdit = page.newField(fldFrm, true)
+	page.EAPMethodEdit = page.newField(fldFrm, false)
+	page.IdentityEdit = page.newField(fldFrm, false)
+	page.PasswordEdit = page.newField(fldFrm, true)
+	page.CACertEdit = page.newField(fldFrm, false)
+
+	hiddenFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
+	hiddenFrm.SetPack(clui.Vertical)
+
+	page.HiddenCheck = clui.CreateCheckBox(hiddenFrm, 1, "Hidden network", Fixed)
+	page.HiddenCheck.OnChange(func(ev int) {
+		page.validate()
+	})
+
+	page.validationWarning = clui.CreateLabel(fldFrm, AutoSize, 2, "", Fixed)
+	page.validationWarning.SetMultiline(true)
+	page.validationWarning.SetBackColor(errorLabelBg)
+	page.validationWarning.SetTextColor(errorLabelFg)
+
+	btnFrm := clui.CreateFrame(fldFrm, 30, 1, BorderNone, Fixed)
+	btnFrm.SetPack(clui.Horizontal)
+	btnFrm.SetGaps(1, 1)
+	btnFrm.SetPaddings(2, 0)
+
+	cancelBtn := CreateSimpleButton(btnFrm, AutoSize, AutoSize, "Cancel", Fixed)
+	cancelBtn.OnClick(func(ev clui.Event) {
+		page.GotoPage(TuiPageNetwork)
+	})
+
+	page.confirmBtn = CreateSimpleButton(btnFrm, AutoSize, AutoSize, "Confirm", Fixed)
+	page.confirmBtn.OnClick(func(ev clui.Event) {
+		sel := page.getSelectedInterface()
+		sel.Wireless = page.getWireless()
+
+		found := false
+		for _, curr := range page.getModel().NetworkInterfaces {
+			found = found || curr == sel
+		}
+
+		if !found {
+			page.getModel().AddNetworkInterface(sel)
+		}
+
+		// The addressing of the Wi-Fi network is configured as for any interface
+		page.GotoPage(TuiPageInterface)
+	})
+
+	return page, nil
+}
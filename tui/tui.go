@@ -139,6 +139,7 @@ func (tui *Tui) Run(md *model.SystemInstall, rootDir string, options args.Args)
 		{"proxy", newProxyPage},
 		{"network validate", newNetworkValidatePage},
 		{"network interface", newNetworkInterfacePage},
+		{"network wireless", newNetworkWirelessPage},
 		{"main menu", newMenuPage},
 		{"bundle selection", newBundlePage},
 		{"add manager", newUserManagerPage},
@@ -476,14 +476,10 @@ func (users ByUserName) Less(a, b int) bool { return users.Users[a].UserName < u
 func (page *UserManagerPage) addUser(addUser *user.User) {
 	page.usersChanged = true
 
-	newUser := &user.User{
-		Login:    addUser.Login,
-		UserName: addUser.UserName,
-		Password: addUser.Password,
-		Admin:    addUser.Admin,
-	}
+	// copy every attribute, the account details are not shown in the list
+	newUser := *addUser
 
-	page.users = append(page.users, newUser)
+	page.users = append(page.users, &newUser)
 
 	// Now sort by Login
 	sort.Sort(ByLogin{page.users})
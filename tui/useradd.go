@@ -5,6 +5,7 @@
 package tui
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/clearlinux/clr-installer/log"
@@ -26,12 +27,20 @@ type UseraddPage struct {
 	passwordEdit    *clui.EditField
 	pwConfirmEdit   *clui.EditField
 	adminCheck      *clui.CheckBox
+	uidEdit         *clui.EditField
+	gidEdit         *clui.EditField
+	groupsEdit      *clui.EditField
+	shellEdit       *clui.EditField
+	homeEdit        *clui.EditField
+	expireEdit      *clui.EditField
+	forceCheck      *clui.CheckBox
 	deleteBtn       *SimpleButton
 	changedPwd      bool
 	changedLogin    bool
 	loginWarning    *clui.Label
 	usernameWarning *clui.Label
 	passwordWarning *clui.Label
+	accountWarnings []*clui.Label
 	confirmBtn      *SimpleButton
 }
 
@@ -102,12 +111,29 @@ func (page *UseraddPage) SetDone(done bool) bool {
 		page.user.Admin = false
 	}
 
+	uid, _ := strconv.ParseUint(page.uidEdit.Title(), 10, 32)
+	page.user.UID = uint(uid)
+	gid, _ := strconv.ParseUint(page.gidEdit.Title(), 10, 32)
+	page.user.GID = uint(gid)
+	page.user.Groups = user.ParseGroups(page.groupsEdit.Title())
+	page.user.Shell = page.shellEdit.Title()
+	page.user.Home = page.homeEdit.Title()
+	page.user.Expire = page.expireEdit.Title()
+	page.user.ForcePasswordChange = page.forceCheck.State() != 0
+
 	page.GotoPage(TuiPageUserManager)
 
 	return false
 }
 
 func (page *UseraddPage) setConfirmButton() {
+	for _, curr := range page.accountWarnings {
+		if curr.Title() != "" {
+			page.confirmBtn.SetEnabled(false)
+			return
+		}
+	}
+
 	if page.usernameWarning.Title() == "" &&
 		page.loginWarning.Title() == "" &&
 		page.passwordWarning.Title() == "" &&
@@ -172,6 +198,37 @@ func (page *UseraddPage) validatePassword() {
 	page.setConfirmButton()
 }
 
+// newAccountField creates an optional account attribute field checked by validate
+func (page *UseraddPage) newAccountField(frame *clui.Frame, cb func(k term.Key, ch rune) bool,
+	validate func(string) (bool, string)) *clui.EditField {
+	edit, warning := newEditField(frame, true, cb, 0)
+	warning.SetVisible(true)
+	page.accountWarnings = append(page.accountWarnings, warning)
+
+	edit.OnChange(func(ev clui.Event) {
+		if ok, msg := validate(edit.Title()); !ok {
+			warning.SetTitle(msg)
+		} else {
+			warning.SetTitle("")
+		}
+
+		page.setConfirmButton()
+	})
+
+	return edit
+}
+
+// validateIDEdit only accepts the digits of a uid or gid
+func validateIDEdit(k term.Key, ch rune) bool {
+	if k == term.KeyBackspace || k == term.KeyBackspace2 ||
+		k == term.KeyArrowUp || k == term.KeyArrowDown ||
+		k == term.KeyArrowLeft || k == term.KeyArrowRight {
+		return false
+	}
+
+	return ch < '0' || ch > '9'
+}
+
 func newUseraddPage(tui *Tui) (Page, error) {
 	page := &UseraddPage{}
 	page.setup(tui, TuiPageUseradd, NoButtons, TuiPageUserManager)
@@ -188,6 +245,13 @@ func newUseraddPage(tui *Tui) (Page, error) {
 	newFieldLabel(lblFrm, "Login:")
 	newFieldLabel(lblFrm, "Password:")
 	newFieldLabel(lblFrm, "Confirm:")
+	newFieldLabel(lblFrm, "")
+	newFieldLabel(lblFrm, "UID:")
+	newFieldLabel(lblFrm, "GID:")
+	newFieldLabel(lblFrm, "Groups:")
+	newFieldLabel(lblFrm, "Shell:")
+	newFieldLabel(lblFrm, "Home:")
+	newFieldLabel(lblFrm, "Expires:")
 
 	fldFrm := clui.CreateFrame(frm, 50, AutoSize, BorderNone, Fixed)
 	fldFrm.SetPack(clui.Vertical)
@@ -300,6 +364,18 @@ func newUseraddPage(tui *Tui) (Page, error) {
 
 	page.adminCheck = clui.CreateCheckBox(adminFrm, 1, "Administrator", Fixed)
 
+	page.uidEdit = page.newAccountField(fldFrm, validateIDEdit, user.IsValidID)
+	page.gidEdit = page.newAccountField(fldFrm, validateIDEdit, user.IsValidID)
+	page.groupsEdit = page.newAccountField(fldFrm, nil, user.IsValidGroups)
+	page.shellEdit = page.newAccountField(fldFrm, nil, user.IsValidPath)
+	page.homeEdit = page.newAccountField(fldFrm, nil, user.IsValidPath)
+	page.expireEdit = page.newAccountField(fldFrm, nil, user.IsValidExpire)
+
+	forceFrm := clui.CreateFrame(fldFrm, 5, 2, BorderNone, Fixed)
+	forceFrm.SetPack(clui.Vertical)
+
+	page.forceCheck = clui.CreateCheckBox(forceFrm, 1, "Change password at first login", Fixed)
+
 	cancelBtn := CreateSimpleButton(page.cFrame, AutoSize, AutoSize, "Cancel", Fixed)
 	cancelBtn.OnClick(func(ev clui.Event) {
 		page.clearForm()
@@ -368,6 +444,18 @@ func (page *UseraddPage) resetForm() {
 		page.adminCheck.SetState(1)
 	}
 
+	page.uidEdit.SetTitle(formatID(page.user.UID))
+	page.gidEdit.SetTitle(formatID(page.user.GID))
+	page.groupsEdit.SetTitle(strings.Join(page.user.Groups, ","))
+	page.shellEdit.SetTitle(page.user.Shell)
+	page.homeEdit.SetTitle(page.user.Home)
+	page.expireEdit.SetTitle(page.user.Expire)
+	if page.user.ForcePasswordChange {
+		page.forceCheck.SetState(1)
+	} else {
+		page.forceCheck.SetState(0)
+	}
+
 	page.deleteBtn.SetEnabled(true)
 
 	clui.ActivateControl(page.tui.currPage.GetWindow(), page.usernameEdit)
@@ -390,7 +478,21 @@ func (page *UseraddPage) clearForm() {
 	page.passwordEdit.SetPasswordMode(true)
 	page.pwConfirmEdit.SetPasswordMode(true)
 	page.adminCheck.SetState(1)
+	for _, curr := range []*clui.EditField{page.uidEdit, page.gidEdit, page.groupsEdit, page.shellEdit,
+		page.homeEdit, page.expireEdit} {
+		curr.SetTitle("")
+	}
+	page.forceCheck.SetState(0)
 	page.deleteBtn.SetEnabled(false)
 	page.confirmBtn.SetEnabled(false)
 	clui.ActivateControl(page.tui.currPage.GetWindow(), page.usernameEdit)
 }
+
+// formatID returns the text of a uid or gid, empty when unset
+func formatID(id uint) string {
+	if id == 0 {
+		return ""
+	}
+
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,487 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package updates
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+const (
+	// swupdConfigFile is the swupd configuration of the target
+	swupdConfigFile = "/etc/swupd/config"
+
+	// timerDropIn overrides the schedule of the swupd update timer
+	timerDropIn = "/etc/systemd/system/swupd-update.timer.d/50-clr-installer.conf"
+
+	// serviceDropIn overrides the swupd update command to honor the max version
+	serviceDropIn = "/etc/systemd/system/swupd-update.service.d/50-clr-installer.conf"
+
+	// GlobalSection is the swupd configuration section shared by every command
+	GlobalSection = "GLOBAL"
+
+	// UpdateSection is the swupd configuration section of the update command
+	UpdateSection = "update"
+)
+
+// keyType is the type of the value of a swupd configuration key
+type keyType int
+
+const (
+	stringKey keyType = iota
+	boolKey
+	uintKey
+)
+
+var (
+	// configKeys are the keys of each section of the swupd configuration file,
+	// they are the long options of the swupd commands with '_' instead of '-'
+	configKeys = map[string]map[string]keyType{
+		GlobalSection: {
+			"allow_insecure_http": boolKey, "assume": stringKey, "certpath": stringKey,
+			"contenturl": stringKey, "debug": boolKey, "format": stringKey, "ignore_time": boolKey,
+			"json_output": boolKey, "max_parallel_downloads": uintKey, "max_retries": uintKey,
+			"no_boot_update": boolKey, "no_progress": boolKey, "no_scripts": boolKey, "nosigcheck": boolKey,
+			"nosigcheck_latest": boolKey, "path": stringKey, "port": uintKey, "quiet": boolKey,
+			"retry_delay": uintKey, "statedir": stringKey, "statedir_cache": stringKey, "time": boolKey,
+			"url": stringKey, "verbose": boolKey, "versionurl": stringKey, "wait_for_scripts": boolKey,
+		},
+		UpdateSection: {
+			"3rd_party": boolKey, "allow_mix_collisions": boolKey, "download": boolKey, "keepcache": boolKey,
+			"migrate": boolKey, "status": boolKey, "update_search_file_index": boolKey, "version": uintKey,
+		},
+		"bundle-add": {
+			"skip_diskspace_check": boolKey, "skip_optional": boolKey,
+		},
+		"bundle-remove": {
+			"force": boolKey, "orphans": boolKey, "recursive": boolKey,
+		},
+		"check-update": {},
+		"diagnose": {
+			"bundles": stringKey, "extra_files_only": boolKey, "file": stringKey, "picky": boolKey,
+			"picky_tree": stringKey, "picky_whitelist": stringKey, "quick": boolKey, "version": uintKey,
+		},
+		"repair": {
+			"bundles": stringKey, "extra_files_only": boolKey, "file": stringKey, "picky": boolKey,
+			"picky_tree": stringKey, "picky_whitelist": stringKey, "quick": boolKey, "version": uintKey,
+		},
+		"search-file": {
+			"binary": boolKey, "library": boolKey, "order": stringKey, "top": uintKey,
+		},
+	}
+
+	uintExp   = regexp.MustCompile(`^[0-9]+$`)
+	formatExp = regexp.MustCompile(`^([0-9]+|staging)$`)
+)
+
+// Policy controls how the target system keeps itself up to date: when the
+// updates run, up to which version and from where; Config holds any other
+// key of the swupd configuration file by section, i.e.:
+// updates: {schedule: "Sun *-*-* 02:00:00", window: 2h, config: {GLOBAL: {max_retries: "5"}}}
+type Policy struct {
+	Schedule   string                       `yaml:"schedule,omitempty,flow"`
+	Window     string                       `yaml:"window,omitempty,flow"`
+	Version    uint                         `yaml:"version,omitempty,flow"`
+	MaxVersion uint                         `yaml:"maxVersion,omitempty,flow"`
+	URL        string                       `yaml:"url,omitempty,flow"`
+	ContentURL string                       `yaml:"contentURL,omitempty,flow"`
+	VersionURL string                       `yaml:"versionURL,omitempty,flow"`
+	CertPath   string                       `yaml:"certPath,omitempty,flow"`
+	Format     string                       `yaml:"format,omitempty,flow"`
+	Config     map[string]map[string]string `yaml:"config,omitempty"`
+}
+
+func (p *Policy) String() string {
+	if p == nil {
+		return ""
+	}
+
+	values := []string{}
+	for _, curr := range p.fields() {
+		values = append(values, fmt.Sprintf("%s.%s=%s", curr.section, curr.key, curr.value))
+	}
+
+	if p.Schedule != "" {
+		values = append(values, "schedule="+p.Schedule)
+	}
+
+	if p.Window != "" {
+		values = append(values, "window="+p.Window)
+	}
+
+	if p.MaxVersion != 0 {
+		values = append(values, fmt.Sprintf("maxVersion=%d", p.MaxVersion))
+	}
+
+	sections := sortedKeys(p.Config)
+	for _, section := range sections {
+		for _, key := range sortedKeys(p.Config[section]) {
+			values = append(values, fmt.Sprintf("%s.%s=%s", section, key, p.Config[section][key]))
+		}
+	}
+
+	return strings.Join(values, " ")
+}
+
+// configField is a swupd configuration key set by a policy field
+type configField struct {
+	name    string
+	section string
+	key     string
+	value   string
+}
+
+// fields returns the swupd configuration keys set by the policy fields
+func (p *Policy) fields() []configField {
+	result := []configField{}
+
+	add := func(name string, section string, key string, value string) {
+		if value != "" && value != "0" {
+			result = append(result, configField{name, section, key, value})
+		}
+	}
+
+	add("url", GlobalSection, "url", p.URL)
+	add("contentURL", GlobalSection, "contenturl", p.ContentURL)
+	add("versionURL", GlobalSection, "versionurl", p.VersionURL)
+	add("certPath", GlobalSection, "certpath", p.CertPath)
+	add("format", GlobalSection, "format", p.Format)
+	add("version", UpdateSection, "version", fmt.Sprintf("%d", p.Version))
+
+	return result
+}
+
+// windowSeconds returns the length of the maintenance window in seconds
+func (p *Policy) windowSeconds() (int64, error) {
+	window, err := time.ParseDuration(p.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	if window <= 0 {
+		return 0, errors.Errorf("the window must be positive")
+	}
+
+	return int64(window / time.Second), nil
+}
+
+func validateURL(name string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "file") ||
+		(u.Path == "" && u.Host == "") {
+		return errors.ValidationErrorf("Invalid updates %s '%s'", name, value)
+	}
+
+	return nil
+}
+
+// Validate checks the policy, autoUpdate is false when the automatic updates
+// are disabled which makes a schedule meaningless
+func (p *Policy) Validate(autoUpdate bool) error {
+	if strings.ContainsAny(p.Schedule, "\n\r") {
+		return errors.ValidationErrorf("Invalid updates schedule '%s'", p.Schedule)
+	}
+
+	if p.Schedule != "" && !autoUpdate {
+		return errors.ValidationErrorf("An updates schedule requires autoUpdate to be enabled")
+	}
+
+	if p.Window != "" {
+		if p.Schedule == "" {
+			return errors.ValidationErrorf("An updates window requires a schedule")
+		}
+
+		if _, err := p.windowSeconds(); err != nil {
+			return errors.ValidationErrorf("Invalid updates window '%s': %v", p.Window, err)
+		}
+	}
+
+	if p.Version != 0 && p.MaxVersion != 0 {
+		return errors.ValidationErrorf("The updates version and maxVersion are exclusive")
+	}
+
+	for name, value := range map[string]string{"url": p.URL, "contentURL": p.ContentURL, "versionURL": p.VersionURL} {
+		if err := validateURL(name, value); err != nil {
+			return err
+		}
+	}
+
+	if p.CertPath != "" && !filepath.IsAbs(p.CertPath) {
+		return errors.ValidationErrorf("The updates certPath '%s' must be an absolute path", p.CertPath)
+	}
+
+	if p.Format != "" && !formatExp.MatchString(p.Format) {
+		return errors.ValidationErrorf("Invalid updates format '%s'", p.Format)
+	}
+
+	for section, keys := range p.Config {
+		known, ok := configKeys[section]
+		if !ok {
+			return errors.ValidationErrorf("Unknown swupd configuration section '%s'", section)
+		}
+
+		for key, value := range keys {
+			kind, ok := known[key]
+			if !ok {
+				return errors.ValidationErrorf("Unknown swupd configuration key '%s' in section '%s'", key, section)
+			}
+
+			if err := validateValue(section, key, value, kind); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, curr := range p.fields() {
+		if _, ok := p.Config[curr.section][curr.key]; ok {
+			return errors.ValidationErrorf("The swupd configuration key '%s' in section '%s' is already set by updates %s",
+				curr.key, curr.section, curr.name)
+		}
+	}
+
+	return nil
+}
+
+func validateValue(section string, key string, value string, kind keyType) error {
+	valid := true
+
+	switch kind {
+	case boolKey:
+		valid = value == "true" || value == "false"
+	case uintKey:
+		valid = uintExp.MatchString(value)
+	case stringKey:
+		valid = !strings.ContainsAny(value, "\n\r")
+	}
+
+	if !valid {
+		return errors.ValidationErrorf("Invalid value '%s' for the swupd configuration key '%s' in section '%s'",
+			value, key, section)
+	}
+
+	return nil
+}
+
+func sortedKeys(m interface{}) []string {
+	result := []string{}
+
+	switch v := m.(type) {
+	case map[string]map[string]string:
+		for k := range v {
+			result = append(result, k)
+		}
+	case map[string]string:
+		for k := range v {
+			result = append(result, k)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// readSwupdConfig returns the keys by section of an existing swupd
+// configuration file, none if the file does not exist
+func readSwupdConfig(path string) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sections, nil
+		}
+		return nil, errors.Wrap(err)
+	}
+
+	section := GlobalSection
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("Invalid line in the swupd configuration %s: %s", path, line)
+		}
+
+		if sections[section] == nil {
+			sections[section] = map[string]string{}
+		}
+		sections[section][strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return sections, nil
+}
+
+// swupdConfig renders the swupd configuration file, the GLOBAL section first;
+// the keys of the policy are merged into the ones of base
+func (p *Policy) swupdConfig(allowInsecureHTTP bool, base map[string]map[string]string) string {
+	sections := map[string]map[string]string{}
+
+	for section, keys := range base {
+		sections[section] = map[string]string{}
+		for key, value := range keys {
+			sections[section][key] = value
+		}
+	}
+
+	set := func(section string, key string, value string) {
+		if sections[section] == nil {
+			sections[section] = map[string]string{}
+		}
+
+		if prev, ok := sections[section][key]; ok && prev != value {
+			log.Warning("The updates replace the swupd configuration key %s in section %s: %s -> %s",
+				key, section, prev, value)
+		}
+		sections[section][key] = value
+	}
+
+	if allowInsecureHTTP {
+		set(GlobalSection, "allow_insecure_http", "true")
+	}
+
+	for _, curr := range p.fields() {
+		set(curr.section, curr.key, curr.value)
+	}
+
+	for section, keys := range p.Config {
+		for key, value := range keys {
+			set(section, key, value)
+		}
+	}
+
+	names := sortedKeys(sections)
+	sort.SliceStable(names, func(i, j int) bool {
+		return names[i] == GlobalSection && names[j] != GlobalSection
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by clr-installer from the updates configuration\n")
+
+	for _, section := range names {
+		buf.WriteString(fmt.Sprintf("\n[%s]\n", section))
+
+		for _, key := range sortedKeys(sections[section]) {
+			buf.WriteString(fmt.Sprintf("%s=%s\n", key, sections[section][key]))
+		}
+	}
+
+	return buf.String()
+}
+
+// timerConfig renders the swupd update timer drop-in running the updates in
+// the maintenance window only, the start is spread over the window
+func (p *Policy) timerConfig() string {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Generated by clr-installer from the updates configuration\n")
+	buf.WriteString("[Timer]\n")
+	buf.WriteString("OnBootSec=\n")
+	buf.WriteString("OnUnitActiveSec=\n")
+	buf.WriteString("OnCalendar=\n")
+	buf.WriteString(fmt.Sprintf("OnCalendar=%s\n", p.Schedule))
+
+	// a missed window is not caught up on the next boot
+	buf.WriteString("Persistent=false\n")
+
+	if seconds, err := p.windowSeconds(); err == nil {
+		buf.WriteString(fmt.Sprintf("RandomizedDelaySec=%d\n", seconds))
+	}
+
+	return buf.String()
+}
+
+// serviceConfig renders the swupd update service drop-in updating up to the
+// max version: the latest version when it does not exceed the max version,
+// the max version itself otherwise
+func (p *Policy) serviceConfig() string {
+	var buf bytes.Buffer
+
+	script := fmt.Sprintf(`. /usr/lib/os-release; `+
+		`latest=$$(/usr/bin/swupd check-update 2>/dev/null | sed -n "s/^Latest server version: *//p"); `+
+		`if [ -z "$$latest" ] || [ "$$latest" -le %[1]d ]; then exec /usr/bin/swupd update; `+
+		`elif [ "$$VERSION_ID" -lt %[1]d ]; then exec /usr/bin/swupd update --version=%[1]d; fi`, p.MaxVersion)
+
+	buf.WriteString("# Generated by clr-installer from the updates configuration\n")
+	buf.WriteString("[Service]\n")
+	buf.WriteString("ExecStart=\n")
+	buf.WriteString(fmt.Sprintf("ExecStart=/bin/sh -c '%s'\n", script))
+
+	return buf.String()
+}
+
+func writeTargetFile(rootDir string, file string, content string) error {
+	path := filepath.Join(rootDir, file)
+
+	if err := utils.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return errors.Wrap(err)
+	}
+
+	log.Debug("Created the update configuration file %s", path)
+
+	return nil
+}
+
+// Apply writes the swupd configuration and the swupd update timer and service
+// overrides of the policy to the target, the keys of the swupd configuration
+// already in the target, i.e. copied from the host with copySwupd, are kept
+// unless the policy sets them
+func (p *Policy) Apply(rootDir string, allowInsecureHTTP bool) error {
+	base, err := readSwupdConfig(filepath.Join(rootDir, swupdConfigFile))
+	if err != nil {
+		return err
+	}
+
+	if len(base) > 0 {
+		log.Info("Merging the updates into the swupd configuration of the target")
+	}
+
+	if err = writeTargetFile(rootDir, swupdConfigFile, p.swupdConfig(allowInsecureHTTP, base)); err != nil {
+		return err
+	}
+
+	if p.Schedule != "" {
+		if err := writeTargetFile(rootDir, timerDropIn, p.timerConfig()); err != nil {
+			return err
+		}
+	}
+
+	if p.MaxVersion != 0 {
+		if err := writeTargetFile(rootDir, serviceDropIn, p.serviceConfig()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
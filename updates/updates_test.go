@@ -0,0 +1,140 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package updates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &Policy{
+		Schedule:   "Sun *-*-* 02:00:00",
+		Window:     "2h",
+		MaxVersion: 33500,
+		URL:        "https://mirror.example.com/update",
+		CertPath:   "/etc/swupd/mirror.pem",
+		Format:     "30",
+		Config: map[string]map[string]string{
+			GlobalSection: {"max_retries": "5"},
+			UpdateSection: {"keepcache": "true"},
+		},
+	}
+
+	if err := valid.Validate(true); err != nil {
+		t.Fatalf("The policy should be valid: %v", err)
+	}
+
+	tests := []struct {
+		policy     *Policy
+		autoUpdate bool
+		msg        string
+	}{
+		{&Policy{Schedule: "daily"}, false, "requires autoUpdate"},
+		{&Policy{Window: "2h"}, true, "requires a schedule"},
+		{&Policy{Schedule: "daily", Window: "two hours"}, true, "Invalid updates window"},
+		{&Policy{Version: 33000, MaxVersion: 33500}, true, "exclusive"},
+		{&Policy{URL: "ftp://mirror"}, true, "Invalid updates url"},
+		{&Policy{CertPath: "mirror.pem"}, true, "absolute path"},
+		{&Policy{Format: "thirty"}, true, "Invalid updates format"},
+		{&Policy{Config: map[string]map[string]string{"upgrade": {}}}, true, "Unknown swupd configuration section"},
+		{&Policy{Config: map[string]map[string]string{GlobalSection: {"retries": "5"}}}, true, "Unknown swupd configuration key"},
+		{&Policy{Config: map[string]map[string]string{GlobalSection: {"max_retries": "five"}}}, true, "Invalid value"},
+		{&Policy{Config: map[string]map[string]string{UpdateSection: {"keepcache": "yes"}}}, true, "Invalid value"},
+		{&Policy{URL: "https://mirror", Config: map[string]map[string]string{GlobalSection: {"url": "https://other"}}},
+			true, "already set by updates url"},
+	}
+
+	for _, curr := range tests {
+		if err := curr.policy.Validate(curr.autoUpdate); err == nil || !strings.Contains(err.Error(), curr.msg) {
+			t.Fatalf("Validate of %+v should fail with %q, got: %v", *curr.policy, curr.msg, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	var policy Policy
+
+	config := `
+schedule: "Sun *-*-* 02:00:00"
+window: 90m
+maxVersion: 33500
+url: https://mirror.example.com/update
+config:
+  update: {keepcache: "true"}
+  GLOBAL: {max_retries: "5"}
+`
+	if err := yaml.UnmarshalStrict([]byte(config), &policy); err != nil {
+		t.Fatalf("Could not parse the updates configuration: %v", err)
+	}
+
+	rootDir, err := ioutil.TempDir("", "updates-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(rootDir) }()
+
+	if err = policy.Apply(rootDir, true); err != nil {
+		t.Fatalf("Could not apply the policy: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(rootDir, swupdConfigFile))
+	if err != nil {
+		t.Fatalf("The swupd configuration was not written: %v", err)
+	}
+
+	expected := "\n[GLOBAL]\nallow_insecure_http=true\nmax_retries=5\nurl=https://mirror.example.com/update\n" +
+		"\n[update]\nkeepcache=true\n"
+	if !strings.HasSuffix(string(content), expected) {
+		t.Fatalf("Expected the swupd configuration:\n%s\ngot:\n%s", expected, content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(rootDir, timerDropIn))
+	if err != nil {
+		t.Fatalf("The timer override was not written: %v", err)
+	}
+
+	for _, curr := range []string{"OnCalendar=Sun *-*-* 02:00:00\n", "RandomizedDelaySec=5400\n", "OnUnitActiveSec=\n"} {
+		if !strings.Contains(string(content), curr) {
+			t.Fatalf("The timer override should contain %q:\n%s", curr, content)
+		}
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(rootDir, serviceDropIn))
+	if err != nil || !strings.Contains(string(content), "--version=33500") {
+		t.Fatalf("The service override should update up to the max version: %v\n%s", err, content)
+	}
+
+	if !strings.Contains(policy.String(), "maxVersion=33500") {
+		t.Fatalf("The policy description should include the max version: %s", policy.String())
+	}
+
+	// the configuration copied from the host is merged, the policy wins
+	copied := "# host configuration\n[GLOBAL]\nmax_retries=3\ncontenturl=https://host.example.com/update\n" +
+		"\n[bundle-add]\nskip_optional=true\n"
+	if err = ioutil.WriteFile(filepath.Join(rootDir, swupdConfigFile), []byte(copied), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = policy.Apply(rootDir, false); err != nil {
+		t.Fatalf("Could not apply the policy: %v", err)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(rootDir, swupdConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "\n[GLOBAL]\ncontenturl=https://host.example.com/update\nmax_retries=5\n" +
+		"url=https://mirror.example.com/update\n\n[bundle-add]\nskip_optional=true\n\n[update]\nkeepcache=true\n"
+	if !strings.HasSuffix(string(content), expected) {
+		t.Fatalf("Expected the merged swupd configuration:\n%s\ngot:\n%s", expected, content)
+	}
+}
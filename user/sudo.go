@@ -0,0 +1,205 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clearlinux/clr-installer/cmd"
+	"github.com/clearlinux/clr-installer/errors"
+	"github.com/clearlinux/clr-installer/log"
+	"github.com/clearlinux/clr-installer/utils"
+)
+
+// SudoPolicy grants a user or the members of a group the right to run
+// commands with sudo, it is written to a drop-in of /etc/sudoers.d
+type SudoPolicy struct {
+	User     string   `yaml:"user,omitempty"`
+	Group    string   `yaml:"group,omitempty"`
+	NoPasswd bool     `yaml:"nopasswd,omitempty"`
+	Commands []string `yaml:"commands,omitempty"`
+	RunAs    string   `yaml:"runas,omitempty"`
+	Defaults []string `yaml:"defaults,omitempty,flow"`
+}
+
+const (
+	// sudoersDir holds the drop-ins of the sudo policies in the target
+	sudoersDir = "/etc/sudoers.d"
+
+	// sudoAll allows every command or target user
+	sudoAll = "ALL"
+)
+
+var (
+	runAsExp    = regexp.MustCompile(`^(ALL|[a-zA-Z_][0-9a-zA-Z-_.]*)(:(ALL|[a-zA-Z_][0-9a-zA-Z-_.]*))?$`)
+	sudoDefExp  = regexp.MustCompile(`^!?[a-z_]+([+-]?=[^,\n]+)?$`)
+	sudoFileExp = regexp.MustCompile(`[^0-9a-zA-Z-_]`)
+)
+
+// Validate checks the policy names a single user or group and only holds
+// values sudo accepts
+func (sp *SudoPolicy) Validate() error {
+	if (sp.User == "") == (sp.Group == "") {
+		return errors.ValidationErrorf("A sudo policy requires either a user or a group")
+	}
+
+	if name := sp.User + sp.Group; !loginExp.MatchString(name) || len(name) > MaxLoginLength {
+		return errors.ValidationErrorf("Invalid sudo policy user or group %q", name)
+	}
+
+	for _, curr := range sp.Commands {
+		if strings.ContainsAny(curr, "\n\r") {
+			return errors.ValidationErrorf("Invalid sudo command %q for %s: multiple lines", curr, sp.subject())
+		}
+
+		if fields := strings.Fields(curr); curr != sudoAll && (len(fields) == 0 || !filepath.IsAbs(fields[0])) {
+			return errors.ValidationErrorf("Invalid sudo command %q for %s: an absolute path is required",
+				curr, sp.subject())
+		}
+	}
+
+	if sp.RunAs != "" && !runAsExp.MatchString(sp.RunAs) {
+		return errors.ValidationErrorf("Invalid sudo runas %q for %s, expected user[:group]", sp.RunAs,
+			sp.subject())
+	}
+
+	for _, curr := range sp.Defaults {
+		if !sudoDefExp.MatchString(curr) {
+			return errors.ValidationErrorf("Invalid sudo default %q for %s", curr, sp.subject())
+		}
+	}
+
+	return nil
+}
+
+// subject returns the sudoers name of the user or group
+func (sp *SudoPolicy) subject() string {
+	if sp.Group != "" {
+		return "%" + sp.Group
+	}
+
+	return sp.User
+}
+
+// String returns the policy as a single line
+func (sp *SudoPolicy) String() string {
+	tks := []string{sp.subject()}
+
+	if sp.NoPasswd {
+		tks = append(tks, "nopasswd")
+	}
+
+	if sp.RunAs != "" {
+		tks = append(tks, "runas="+sp.RunAs)
+	}
+
+	if len(sp.Commands) > 0 {
+		tks = append(tks, "commands=["+strings.Join(sp.Commands, ",")+"]")
+	}
+
+	if len(sp.Defaults) > 0 {
+		tks = append(tks, "defaults=["+strings.Join(sp.Defaults, ",")+"]")
+	}
+
+	return strings.Join(tks, " ")
+}
+
+// fileName returns the drop-in name, sudo skips the names with a dot
+func (sp *SudoPolicy) fileName() string {
+	kind := "user"
+	if sp.Group != "" {
+		kind = "group"
+	}
+
+	return fmt.Sprintf("50-clr-installer-%s-%s", kind, sudoFileExp.ReplaceAllString(sp.User+sp.Group, "_"))
+}
+
+// escapeCommand escapes the sudoers special characters of the command arguments
+func escapeCommand(command string) string {
+	tks := strings.SplitN(command, " ", 2)
+	if len(tks) == 1 {
+		return command
+	}
+
+	args := strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`, `=`, `\=`).Replace(tks[1])
+
+	return tks[0] + " " + args
+}
+
+// content returns the sudoers rules of the policy
+func (sp *SudoPolicy) content() string {
+	lines := []string{"# Generated by clr-installer from the sudo configuration"}
+
+	if len(sp.Defaults) > 0 {
+		lines = append(lines, fmt.Sprintf("Defaults:%s %s", sp.subject(), strings.Join(sp.Defaults, ", ")))
+	}
+
+	runAs := sp.RunAs
+	if runAs == "" {
+		runAs = sudoAll
+	}
+
+	commands := []string{}
+	for _, curr := range sp.Commands {
+		commands = append(commands, escapeCommand(curr))
+	}
+
+	if len(commands) == 0 {
+		commands = []string{sudoAll}
+	}
+
+	tag := ""
+	if sp.NoPasswd {
+		tag = "NOPASSWD: "
+	}
+
+	lines = append(lines, fmt.Sprintf("%s ALL=(%s) %s%s", sp.subject(), runAs, tag, strings.Join(commands, ", ")))
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ApplySudo writes the sudo policies to the target, each drop-in is checked
+// with visudo and removed if sudo would reject it
+func ApplySudo(rootDir string, policies []*SudoPolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(rootDir, sudoersDir)
+	if err := utils.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	for _, curr := range policies {
+		path := filepath.Join(dir, curr.fileName())
+
+		if err := ioutil.WriteFile(path, []byte(curr.content()), 0440); err != nil {
+			return errors.Wrap(err)
+		}
+
+		args := []string{
+			"chroot",
+			rootDir,
+			"visudo",
+			"-c",
+			"-f",
+			filepath.Join(sudoersDir, curr.fileName()),
+		}
+
+		if err := cmd.RunAndLog(args...); err != nil {
+			_ = os.Remove(path)
+			return errors.Errorf("The sudo policy of %s was rejected by visudo: %v", curr.subject(), err)
+		}
+
+		log.Info("Added the sudo policy of %s", curr.subject())
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSudoPolicyValidate(t *testing.T) {
+	valid := &SudoPolicy{
+		User:     "ops",
+		NoPasswd: true,
+		Commands: []string{"/usr/bin/systemctl restart nginx", "/usr/bin/journalctl"},
+		RunAs:    "root",
+		Defaults: []string{"timestamp_timeout=5", "requiretty", "!lecture"},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		policy *SudoPolicy
+		msg    string
+	}{
+		{&SudoPolicy{}, "either a user or a group"},
+		{&SudoPolicy{User: "ops", Group: "ops"}, "either a user or a group"},
+		{&SudoPolicy{Group: "ops team"}, "Invalid sudo policy user or group"},
+		{&SudoPolicy{User: "ops", Commands: []string{"systemctl"}}, "absolute path"},
+		{&SudoPolicy{User: "ops", Commands: []string{" "}}, "absolute path"},
+		{&SudoPolicy{User: "ops", Commands: []string{"/bin/true\nops ALL=(ALL) ALL"}}, "multiple lines"},
+		{&SudoPolicy{User: "ops", RunAs: "root postgres"}, "Invalid sudo runas"},
+		{&SudoPolicy{User: "ops", Defaults: []string{"requiretty, lecture"}}, "Invalid sudo default"},
+	}
+
+	for _, curr := range tests {
+		if err := curr.policy.Validate(); err == nil || !strings.Contains(err.Error(), curr.msg) {
+			t.Fatalf("Validate of %+v should fail with %q, got: %v", *curr.policy, curr.msg, err)
+		}
+	}
+}
+
+func TestSudoPolicyContent(t *testing.T) {
+	sp := &SudoPolicy{
+		User:     "ops.admin",
+		NoPasswd: true,
+		Commands: []string{"/usr/bin/systemctl restart nginx", "/usr/bin/chown root:root /srv/a,b"},
+		RunAs:    "root",
+		Defaults: []string{"timestamp_timeout=5", "requiretty"},
+	}
+
+	if name := sp.fileName(); name != "50-clr-installer-user-ops_admin" {
+		t.Fatalf("Unexpected drop-in name: %s", name)
+	}
+
+	expected := "# Generated by clr-installer from the sudo configuration\n" +
+		"Defaults:ops.admin timestamp_timeout=5, requiretty\n" +
+		`ops.admin ALL=(root) NOPASSWD: /usr/bin/systemctl restart nginx, /usr/bin/chown root\:root /srv/a\,b` +
+		"\n"
+	if content := sp.content(); content != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, content)
+	}
+
+	sp = &SudoPolicy{Group: "admins"}
+
+	if name := sp.fileName(); name != "50-clr-installer-group-admins" {
+		t.Fatalf("Unexpected drop-in name: %s", name)
+	}
+
+	expected = "# Generated by clr-installer from the sudo configuration\n%admins ALL=(ALL) ALL\n"
+	if content := sp.content(); content != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, content)
+	}
+}
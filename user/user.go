@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,11 +25,21 @@ import (
 
 // User abstracts a target system definition
 type User struct {
-	Login    string   `yaml:"login,omitempty"`
-	UserName string   `yaml:"username,omitempty,flow"`
-	Password string   `yaml:"password,omitempty,flow"`
-	Admin    bool     `yaml:"admin,omitempty,flow"`
-	SSHKeys  []string `yaml:"ssh-keys,omitempty,flow"`
+	Login               string   `yaml:"login,omitempty"`
+	UserName            string   `yaml:"username,omitempty,flow"`
+	Password            string   `yaml:"password,omitempty,flow"`
+	Admin               bool     `yaml:"admin,omitempty,flow"`
+	SSHKeys             []string `yaml:"ssh-keys,omitempty,flow"`
+	UID                 uint     `yaml:"uid,omitempty"`
+	GID                 uint     `yaml:"gid,omitempty"`
+	Groups              []string `yaml:"groups,omitempty,flow"`
+	Shell               string   `yaml:"shell,omitempty"`
+	Home                string   `yaml:"home,omitempty"`
+	Skeleton            string   `yaml:"skel,omitempty"`
+	System              bool     `yaml:"system,omitempty"`
+	Expire              string   `yaml:"expire,omitempty"`
+	PasswordMaxDays     uint     `yaml:"password-max-days,omitempty"`
+	ForcePasswordChange bool     `yaml:"force-password-change,omitempty"`
 }
 
 const (
@@ -36,6 +47,15 @@ const (
 
 	// RequiredBundle the bundle needed to enable non-root user accounts
 	RequiredBundle = "sysadmin-basic"
+
+	// ExpireLayout is the format of the account expiry date
+	ExpireLayout = "2006-01-02"
+
+	// adminGroup is the supplementary group of the administrators
+	adminGroup = "wheel"
+
+	// maxID is the largest uid or gid, (uint32)-1 is reserved
+	maxID = 4294967294
 )
 
 var (
@@ -116,6 +136,199 @@ func (u *User) Equals(usr *User) bool {
 	return u == usr || u.Login == usr.Login
 }
 
+// ParseGroups returns the groups of a comma separated list
+func ParseGroups(text string) []string {
+	groups := []string{}
+
+	for _, curr := range strings.Split(text, ",") {
+		if curr = strings.TrimSpace(curr); curr != "" {
+			groups = append(groups, curr)
+		}
+	}
+
+	return groups
+}
+
+// Validate checks the account attributes, the login and the password are
+// checked by IsValidLogin and IsValidPassword
+func (u *User) Validate() error {
+	if u.UID > maxID {
+		return errors.ValidationErrorf("Invalid uid %d for user %s", u.UID, u.Login)
+	}
+
+	if u.GID > maxID {
+		return errors.ValidationErrorf("Invalid gid %d for user %s", u.GID, u.Login)
+	}
+
+	seen := map[string]bool{}
+	for _, curr := range u.Groups {
+		if !loginExp.MatchString(curr) || len(curr) > MaxLoginLength {
+			return errors.ValidationErrorf("Invalid group %q for user %s", curr, u.Login)
+		}
+
+		if seen[curr] {
+			return errors.ValidationErrorf("Group %s is listed twice for user %s", curr, u.Login)
+		}
+		seen[curr] = true
+	}
+
+	for _, curr := range []struct{ name, value string }{
+		{"shell", u.Shell},
+		{"home", u.Home},
+		{"skel", u.Skeleton},
+	} {
+		if curr.value != "" && !filepath.IsAbs(curr.value) {
+			return errors.ValidationErrorf("The %s of user %s must be an absolute path: %s",
+				curr.name, u.Login, curr.value)
+		}
+	}
+
+	if u.Expire != "" {
+		if _, err := time.Parse(ExpireLayout, u.Expire); err != nil {
+			return errors.ValidationErrorf("Invalid expire date %q for user %s, expected YYYY-MM-DD",
+				u.Expire, u.Login)
+		}
+	}
+
+	return nil
+}
+
+// groups returns the supplementary groups, including the admin group
+func (u *User) groups() []string {
+	groups := append([]string{}, u.Groups...)
+
+	if u.Admin {
+		for _, curr := range groups {
+			if curr == adminGroup {
+				return groups
+			}
+		}
+
+		groups = append(groups, adminGroup)
+	}
+
+	return groups
+}
+
+// useraddArgs returns the command creating the account
+func (u *User) useraddArgs(rootDir string) []string {
+	args := []string{
+		"chroot",
+		rootDir,
+		"useradd",
+		"--comment",
+		u.UserName,
+	}
+
+	if u.UID != 0 {
+		args = append(args, "--uid", strconv.FormatUint(uint64(u.UID), 10))
+	}
+
+	if u.GID != 0 {
+		args = append(args, "--gid", strconv.FormatUint(uint64(u.GID), 10))
+	}
+
+	if groups := u.groups(); len(groups) > 0 {
+		args = append(args, "--groups", strings.Join(groups, ","))
+	}
+
+	if u.Shell != "" {
+		args = append(args, "--shell", u.Shell)
+	}
+
+	if u.Home != "" {
+		args = append(args, "--home-dir", u.Home)
+	}
+
+	// the skeleton is only copied when the home directory is created
+	if u.Skeleton != "" {
+		args = append(args, "--create-home", "--skel", u.Skeleton)
+	}
+
+	if u.System {
+		args = append(args, "--system")
+	}
+
+	return append(args, u.Login)
+}
+
+// chageArgs returns the command setting the account and password expiry,
+// nil if there is nothing to set
+func (u *User) chageArgs(rootDir string) []string {
+	args := []string{
+		"chroot",
+		rootDir,
+		"chage",
+	}
+
+	if u.Expire != "" {
+		args = append(args, "--expiredate", u.Expire)
+	}
+
+	if u.PasswordMaxDays != 0 {
+		args = append(args, "--maxdays", strconv.FormatUint(uint64(u.PasswordMaxDays), 10))
+	}
+
+	// a last change on day 0 forces a password change at the first login
+	if u.ForcePasswordChange {
+		args = append(args, "--lastday", "0")
+	}
+
+	if len(args) == 3 {
+		return nil
+	}
+
+	return append(args, u.Login)
+}
+
+// groupExist returns true if the group name or id is defined in the target
+func groupExist(rootDir string, group string) bool {
+	args := []string{
+		"chroot",
+		rootDir,
+		"getent",
+		"group",
+		group,
+	}
+
+	return cmd.RunAndLog(args...) == nil
+}
+
+// createGroups creates the primary group, named after the login, and the
+// supplementary groups missing in the target
+func (u *User) createGroups(rootDir string) error {
+	if u.GID != 0 {
+		gid := strconv.FormatUint(uint64(u.GID), 10)
+
+		if !groupExist(rootDir, gid) {
+			log.Info("Adding the primary group '%s' with gid %s", u.Login, gid)
+
+			args := []string{"chroot", rootDir, "groupadd", "--gid", gid}
+			if u.System {
+				args = append(args, "--system")
+			}
+
+			if err := cmd.RunAndLog(append(args, u.Login)...); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+	}
+
+	for _, curr := range u.groups() {
+		if groupExist(rootDir, curr) {
+			continue
+		}
+
+		log.Info("Adding the missing group '%s'", curr)
+
+		if err := cmd.RunAndLog("chroot", rootDir, "groupadd", curr); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
 // setTempTargetPAMConfig copy the temporary chpasswd PAM config to target system
 // this is required for changing user's password into target system.
 func setTempTargetPAMConfig(rootDir string) error {
@@ -293,26 +506,30 @@ func (u *User) getUserHome(rootDir string) string {
 func (u *User) apply(rootDir string) error {
 	accountAdded := false
 
+	if err := u.createGroups(rootDir); err != nil {
+		return err
+	}
+
 	if u.userExist(rootDir) {
 		log.Info("Account '%s' already a defined system account, skipping add.", u.Login)
-	} else {
-		args := []string{
-			"chroot",
-			rootDir,
-			"useradd",
-			"--comment",
-			u.UserName,
-			u.Login,
-		}
 
-		if u.Admin {
-			args = append(args, []string{
-				"-G",
-				"wheel",
-			}...)
-		}
+		if groups := u.groups(); len(groups) > 0 {
+			args := []string{
+				"chroot",
+				rootDir,
+				"usermod",
+				"--append",
+				"--groups",
+				strings.Join(groups, ","),
+				u.Login,
+			}
 
-		if err := cmd.RunAndLog(args...); err != nil {
+			if err := cmd.RunAndLog(args...); err != nil {
+				return errors.Wrap(err)
+			}
+		}
+	} else {
+		if err := cmd.RunAndLog(u.useraddArgs(rootDir)...); err != nil {
 			return errors.Wrap(err)
 		}
 
@@ -352,6 +569,13 @@ func (u *User) apply(rootDir string) error {
 		}
 	}
 
+	// chpasswd sets the last password change, so the expiry comes after it
+	if args := u.chageArgs(rootDir); args != nil {
+		if err := cmd.RunAndLog(args...); err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
 	if len(u.SSHKeys) > 0 {
 		if err := writeSSHKey(rootDir, u); err != nil {
 			return err
@@ -395,7 +619,8 @@ func writeSSHKey(rootDir string, u *User) error {
 		rootDir,
 		"/usr/bin/chown",
 		"-R",
-		fmt.Sprintf("%s:%s", u.Login, u.Login),
+		// an empty group is the primary group of the account
+		u.Login + ":",
 		sshDir,
 	}
 
@@ -459,3 +684,49 @@ func IsValidPassword(pwd string) (bool, string) {
 
 	return true, ""
 }
+
+// IsValidID checks an optional uid or gid
+func IsValidID(id string) (bool, string) {
+	if id == "" {
+		return true, ""
+	}
+
+	if value, err := strconv.ParseUint(id, 10, 32); err != nil || value > maxID {
+		return false, utils.Locale.Get("Must be a number up to %d", maxID)
+	}
+
+	return true, ""
+}
+
+// IsValidGroups checks a comma separated list of supplementary groups
+func IsValidGroups(groups string) (bool, string) {
+	for _, curr := range ParseGroups(groups) {
+		if !loginExp.MatchString(curr) || len(curr) > MaxLoginLength {
+			return false, utils.Locale.Get("Invalid group %s", curr)
+		}
+	}
+
+	return true, ""
+}
+
+// IsValidPath checks an optional shell or home directory
+func IsValidPath(path string) (bool, string) {
+	if path != "" && !filepath.IsAbs(path) {
+		return false, utils.Locale.Get("Must be an absolute path")
+	}
+
+	return true, ""
+}
+
+// IsValidExpire checks an optional account expiry date
+func IsValidExpire(date string) (bool, string) {
+	if date == "" {
+		return true, ""
+	}
+
+	if _, err := time.Parse(ExpireLayout, date); err != nil {
+		return false, utils.Locale.Get("Must be a date as YYYY-MM-DD")
+	}
+
+	return true, ""
+}
@@ -0,0 +1,132 @@
+// Copyright © 2020 Intel Corporation
+//
+// SPDX-License-Identifier: GPL-3.0-only
+
+package user
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserValidate(t *testing.T) {
+	valid := &User{
+		Login:   "jdoe",
+		UID:     41001,
+		GID:     41001,
+		Groups:  []string{"docker", "projects"},
+		Shell:   "/bin/zsh",
+		Home:    "/nfs/home/jdoe",
+		Expire:  "2027-12-31",
+		System:  true,
+		SSHKeys: []string{"ssh-rsa xxxx"},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate shouldn't return an error: %v", err)
+	}
+
+	tests := []struct {
+		user *User
+		msg  string
+	}{
+		{&User{Login: "a", UID: 4294967295}, "Invalid uid"},
+		{&User{Login: "a", GID: 4294967295}, "Invalid gid"},
+		{&User{Login: "a", Groups: []string{"my group"}}, "Invalid group"},
+		{&User{Login: "a", Groups: []string{"docker", "docker"}}, "listed twice"},
+		{&User{Login: "a", Shell: "bash"}, "absolute path"},
+		{&User{Login: "a", Skeleton: "skel"}, "absolute path"},
+		{&User{Login: "a", Expire: "31/12/2027"}, "Invalid expire date"},
+	}
+
+	for _, curr := range tests {
+		if err := curr.user.Validate(); err == nil || !strings.Contains(err.Error(), curr.msg) {
+			t.Fatalf("Validate of %+v should fail with %q, got: %v", *curr.user, curr.msg, err)
+		}
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	groups := ParseGroups(" docker, ,projects ")
+	if !reflect.DeepEqual(groups, []string{"docker", "projects"}) {
+		t.Fatalf("Unexpected groups: %v", groups)
+	}
+
+	if groups = ParseGroups(""); len(groups) != 0 {
+		t.Fatalf("An empty list should have no groups: %v", groups)
+	}
+}
+
+func TestUseraddArgs(t *testing.T) {
+	u := &User{Login: "clear", UserName: "Clear User"}
+
+	expected := []string{"chroot", "/target", "useradd", "--comment", "Clear User", "clear"}
+	if args := u.useraddArgs("/target"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+
+	u.UID = 41001
+	u.GID = 41000
+	u.Admin = true
+	u.Groups = []string{"docker"}
+	u.Shell = "/bin/zsh"
+	u.Home = "/nfs/home/clear"
+	u.Skeleton = "/etc/skel-nfs"
+	u.System = true
+
+	expected = []string{"chroot", "/target", "useradd", "--comment", "Clear User", "--uid", "41001",
+		"--gid", "41000", "--groups", "docker,wheel", "--shell", "/bin/zsh", "--home-dir", "/nfs/home/clear",
+		"--create-home", "--skel", "/etc/skel-nfs", "--system", "clear"}
+	if args := u.useraddArgs("/target"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+
+	u.Groups = []string{"wheel", "docker"}
+	if groups := u.groups(); !reflect.DeepEqual(groups, u.Groups) {
+		t.Fatalf("wheel should not be added twice: %v", groups)
+	}
+}
+
+func TestChageArgs(t *testing.T) {
+	u := &User{Login: "clear"}
+
+	if args := u.chageArgs("/target"); args != nil {
+		t.Fatalf("chage shouldn't run without expiry settings: %v", args)
+	}
+
+	u.Expire = "2027-12-31"
+	u.PasswordMaxDays = 90
+	u.ForcePasswordChange = true
+
+	expected := []string{"chroot", "/target", "chage", "--expiredate", "2027-12-31", "--maxdays", "90",
+		"--lastday", "0", "clear"}
+	if args := u.chageArgs("/target"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+}
+
+func TestAccountFieldValidation(t *testing.T) {
+	tests := []struct {
+		check func(string) (bool, string)
+		value string
+		valid bool
+	}{
+		{IsValidID, "", true},
+		{IsValidID, "41001", true},
+		{IsValidID, "-1", false},
+		{IsValidID, "4294967295", false},
+		{IsValidGroups, "docker, projects", true},
+		{IsValidGroups, "docker,my group", false},
+		{IsValidPath, "/bin/zsh", true},
+		{IsValidPath, "zsh", false},
+		{IsValidExpire, "2027-12-31", true},
+		{IsValidExpire, "2027-13-01", false},
+	}
+
+	for _, curr := range tests {
+		if ok, msg := curr.check(curr.value); ok != curr.valid {
+			t.Fatalf("Validation of %q should return %v, got %v: %s", curr.value, curr.valid, ok, msg)
+		}
+	}
+}